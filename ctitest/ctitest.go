@@ -0,0 +1,657 @@
+// Package ctitest provides an in-memory ledger and transaction context for exercising the CTI
+// chaincode outside a Fabric network.
+//
+// Transactions follow Fabric's execute-order-validate model: a Transaction reads only committed
+// state (writes are not visible to its own reads), records the version of every key and range it
+// reads, and applies its writes only when committed. Commit rejects a transaction with
+// ErrMVCCConflict when anything it read has changed since, the same way a peer invalidates it.
+// Simulating several transactions before committing any of them reproduces concurrent
+// endorsement, such as two reviews racing to update the same CTI item's review aggregates:
+//
+//	ledger := ctitest.NewLedger()
+//	bob := &ctitest.Identity{ID: "bob", MSPID: "Org2MSP"}
+//	carol := &ctitest.Identity{ID: "carol", MSPID: "Org3MSP"}
+//	errs := ledger.Concurrent(
+//		ctitest.Call(bob, func(ctx contractapi.TransactionContextInterface) error {
+//			return contract.AddReviewData(ctx, id, 4, 4, 4, 4, "accurate")
+//		}),
+//		ctitest.Call(carol, func(ctx contractapi.TransactionContextInterface) error {
+//			return contract.AddReviewData(ctx, id, 2, 2, 2, 2, "outdated")
+//		}),
+//	)
+//	// errs[0] is nil; errs[1] wraps ErrMVCCConflict because both read the item at the same version
+//
+// Only the stub methods the chaincode uses are implemented. Calling any other method panics.
+package ctitest
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric-chaincode-go/pkg/cid"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+	pb "github.com/hyperledger/fabric-protos-go/peer"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// ErrMVCCConflict is returned by Commit when a transaction read state that another transaction
+// changed before it was committed
+var ErrMVCCConflict = errors.New("MVCC read conflict")
+
+// Composite key delimiters, as used by Fabric
+const (
+	compositeKeyNamespace = "\x00"
+	minUnicodeRuneValue   = 0
+	maxUnicodeRuneValue   = utf8.MaxRune
+)
+
+// versionedValue is a committed value and the version it was written at
+type versionedValue struct {
+	value   []byte
+	version uint64
+}
+
+// Ledger is an in-memory world state shared by the transactions created from it
+type Ledger struct {
+	mu      sync.Mutex
+	state   map[string]versionedValue
+	private map[string]map[string][]byte
+	history map[string][]*queryresult.KeyModification
+	version uint64
+	txCount int
+	clock   time.Time
+}
+
+// NewLedger returns an empty ledger whose clock starts at the Unix epoch plus one day
+func NewLedger() *Ledger {
+	return &Ledger{
+		state:   make(map[string]versionedValue),
+		private: make(map[string]map[string][]byte),
+		history: make(map[string][]*queryresult.KeyModification),
+		clock:   time.Unix(24*60*60, 0).UTC(),
+	}
+}
+
+// SetTime sets the timestamp given to the next transactions created from the ledger
+func (l *Ledger) SetTime(t time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.clock = t
+}
+
+// Advance moves the ledger clock forward
+func (l *Ledger) Advance(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.clock = l.clock.Add(d)
+}
+
+// Get returns the committed value of a key, or nil if it is absent
+func (l *Ledger) Get(key string) []byte {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.state[key].value
+}
+
+// Put writes a value directly to committed state, bypassing the chaincode. It is intended for
+// setting up fixtures and for simulating out-of-band tampering.
+func (l *Ledger) Put(key string, value []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.version++
+	l.state[key] = versionedValue{value: value, version: l.version}
+}
+
+// Delete removes a key directly from committed state, bypassing the chaincode
+func (l *Ledger) Delete(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.version++
+	delete(l.state, key)
+}
+
+// NewTransaction starts a transaction submitted by identity
+func (l *Ledger) NewTransaction(identity *Identity) *Transaction {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.txCount++
+	return &Transaction{
+		ledger:    l,
+		identity:  identity,
+		txID:      fmt.Sprintf("tx%06d", l.txCount),
+		timestamp: l.clock,
+		transient: make(map[string][]byte),
+		reads:     make(map[string]uint64),
+		writes:    make(map[string][]byte),
+		deletes:   make(map[string]bool),
+		private:   make(map[string]map[string][]byte),
+	}
+}
+
+// Invoke runs fn in a new transaction submitted by identity and commits it if fn succeeds
+func (l *Ledger) Invoke(identity *Identity, fn func(ctx contractapi.TransactionContextInterface) error) error {
+	tx := l.NewTransaction(identity)
+	if err := fn(tx.Context()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Invocation is a chaincode call submitted by an identity, for use with Concurrent
+type Invocation struct {
+	Identity *Identity
+	Fn       func(ctx contractapi.TransactionContextInterface) error
+}
+
+// Call builds an Invocation
+func Call(identity *Identity, fn func(ctx contractapi.TransactionContextInterface) error) Invocation {
+	return Invocation{Identity: identity, Fn: fn}
+}
+
+// Concurrent simulates every invocation against the same committed state and then commits them in
+// order, as if they had been endorsed concurrently and ordered into one block. The error for each
+// invocation is its simulation error or, if it was invalidated at commit, one wrapping ErrMVCCConflict.
+func (l *Ledger) Concurrent(invocations ...Invocation) []error {
+	txs := make([]*Transaction, len(invocations))
+	errs := make([]error, len(invocations))
+	for i, invocation := range invocations {
+		txs[i] = l.NewTransaction(invocation.Identity)
+		errs[i] = invocation.Fn(txs[i].Context())
+	}
+	for i, tx := range txs {
+		if errs[i] == nil {
+			errs[i] = tx.Commit()
+		}
+	}
+	return errs
+}
+
+// Identity is a client identity with a fixed ID, MSP and set of certificate attributes
+type Identity struct {
+	ID          string
+	MSPID       string
+	Attributes  map[string]string
+	Certificate *x509.Certificate
+}
+
+var _ cid.ClientIdentity = (*Identity)(nil)
+
+// GetID returns the identity's ID
+func (i *Identity) GetID() (string, error) {
+	return i.ID, nil
+}
+
+// GetMSPID returns the identity's MSP ID
+func (i *Identity) GetMSPID() (string, error) {
+	return i.MSPID, nil
+}
+
+// GetAttributeValue returns the value of a certificate attribute
+func (i *Identity) GetAttributeValue(attrName string) (string, bool, error) {
+	value, found := i.Attributes[attrName]
+	return value, found, nil
+}
+
+// AssertAttributeValue returns an error unless the attribute has the given value
+func (i *Identity) AssertAttributeValue(attrName, attrValue string) error {
+	value, found := i.Attributes[attrName]
+	if !found {
+		return fmt.Errorf("attribute '%s' was not found", attrName)
+	}
+	if value != attrValue {
+		return fmt.Errorf("attribute '%s' equals '%s', not '%s'", attrName, value, attrValue)
+	}
+	return nil
+}
+
+// GetX509Certificate returns the identity's certificate, which may be nil
+func (i *Identity) GetX509Certificate() (*x509.Certificate, error) {
+	return i.Certificate, nil
+}
+
+// Context is a transaction context binding a Transaction to its submitting identity
+type Context struct {
+	tx *Transaction
+}
+
+var _ contractapi.TransactionContextInterface = (*Context)(nil)
+
+// GetStub returns the transaction's stub
+func (c *Context) GetStub() shim.ChaincodeStubInterface {
+	return c.tx
+}
+
+// GetClientIdentity returns the identity that submitted the transaction
+func (c *Context) GetClientIdentity() cid.ClientIdentity {
+	return c.tx.identity
+}
+
+// rangeRead records the keys and versions a range query returned, for phantom read detection
+type rangeRead struct {
+	startKey string
+	endKey   string
+	versions map[string]uint64
+}
+
+// Event is a chaincode event set by a transaction
+type Event struct {
+	Name    string
+	Payload []byte
+}
+
+// Transaction is a chaincode stub simulating one transaction against a Ledger
+type Transaction struct {
+	// Methods the chaincode does not use are left unimplemented and panic when called
+	shim.ChaincodeStubInterface
+
+	ledger    *Ledger
+	identity  *Identity
+	txID      string
+	timestamp time.Time
+	transient map[string][]byte
+	event     *Event
+
+	reads      map[string]uint64
+	rangeReads []*rangeRead
+	writes     map[string][]byte
+	deletes    map[string]bool
+	private    map[string]map[string][]byte
+	paginated  bool
+	committed  bool
+}
+
+var _ shim.ChaincodeStubInterface = (*Transaction)(nil)
+
+// Context returns a transaction context for passing to contract methods
+func (t *Transaction) Context() contractapi.TransactionContextInterface {
+	return &Context{tx: t}
+}
+
+// SetTransient sets the transient data passed with the transaction proposal
+func (t *Transaction) SetTransient(transient map[string][]byte) {
+	t.transient = transient
+}
+
+// Event returns the event set by the transaction, or nil if none was set
+func (t *Transaction) Event() *Event {
+	return t.event
+}
+
+// Commit validates the transaction's reads against the ledger and applies its writes. Nothing is
+// applied if a read key or range has changed since it was read.
+func (t *Transaction) Commit() error {
+	l := t.ledger
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if t.committed {
+		return fmt.Errorf("transaction %s has already been committed", t.txID)
+	}
+	t.committed = true
+
+	for key, version := range t.reads {
+		if l.state[key].version != version {
+			return fmt.Errorf("transaction %s read key %q at version %d but it is now at version %d: %w", t.txID, key, version, l.state[key].version, ErrMVCCConflict)
+		}
+	}
+	for _, read := range t.rangeReads {
+		current := l.rangeVersions(read.startKey, read.endKey)
+		if len(current) != len(read.versions) {
+			return fmt.Errorf("transaction %s range [%q, %q) changed: %w", t.txID, read.startKey, read.endKey, ErrMVCCConflict)
+		}
+		for key, version := range read.versions {
+			if current[key] != version {
+				return fmt.Errorf("transaction %s range [%q, %q) changed at key %q: %w", t.txID, read.startKey, read.endKey, key, ErrMVCCConflict)
+			}
+		}
+	}
+
+	timestamp := timestamppb.New(t.timestamp)
+	keys := make([]string, 0, len(t.writes)+len(t.deletes))
+	for key := range t.writes {
+		keys = append(keys, key)
+	}
+	for key := range t.deletes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	l.version++
+	for _, key := range keys {
+		if t.deletes[key] {
+			delete(l.state, key)
+			l.history[key] = append(l.history[key], &queryresult.KeyModification{TxId: t.txID, Timestamp: timestamp, IsDelete: true})
+			continue
+		}
+		l.state[key] = versionedValue{value: t.writes[key], version: l.version}
+		l.history[key] = append(l.history[key], &queryresult.KeyModification{TxId: t.txID, Value: t.writes[key], Timestamp: timestamp})
+	}
+
+	for collection, values := range t.private {
+		if l.private[collection] == nil {
+			l.private[collection] = make(map[string][]byte)
+		}
+		for key, value := range values {
+			if value == nil {
+				delete(l.private[collection], key)
+			} else {
+				l.private[collection][key] = value
+			}
+		}
+	}
+
+	return nil
+}
+
+// rangeVersions returns the committed non-composite keys in [startKey, endKey) and their versions.
+// The caller must hold the ledger lock.
+func (l *Ledger) rangeVersions(startKey, endKey string) map[string]uint64 {
+	versions := make(map[string]uint64)
+	for key, value := range l.state {
+		if inRange(key, startKey, endKey) {
+			versions[key] = value.version
+		}
+	}
+	return versions
+}
+
+// inRange reports whether key lies in [startKey, endKey), where an empty endKey is unbounded. Keys
+// in the composite key namespace only match ranges that start inside it.
+func inRange(key, startKey, endKey string) bool {
+	if strings.HasPrefix(key, compositeKeyNamespace) != strings.HasPrefix(startKey, compositeKeyNamespace) {
+		return false
+	}
+	return key >= startKey && (endKey == "" || key < endKey)
+}
+
+// GetTxID returns the transaction ID
+func (t *Transaction) GetTxID() string {
+	return t.txID
+}
+
+// GetChannelID returns the channel the transaction runs on
+func (t *Transaction) GetChannelID() string {
+	return "mychannel"
+}
+
+// GetTxTimestamp returns the transaction timestamp
+func (t *Transaction) GetTxTimestamp() (*timestamppb.Timestamp, error) {
+	return timestamppb.New(t.timestamp), nil
+}
+
+// GetTransient returns the transient data passed with the proposal
+func (t *Transaction) GetTransient() (map[string][]byte, error) {
+	return t.transient, nil
+}
+
+// SetEvent sets the transaction's event. As in Fabric, only the last event set is kept.
+func (t *Transaction) SetEvent(name string, payload []byte) error {
+	if name == "" {
+		return errors.New("event name can not be empty string")
+	}
+	t.event = &Event{Name: name, Payload: payload}
+	return nil
+}
+
+// GetState returns the committed value of a key. Writes made earlier in the same transaction are
+// not visible, as in Fabric.
+func (t *Transaction) GetState(key string) ([]byte, error) {
+	l := t.ledger
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	committed := l.state[key]
+	if _, read := t.reads[key]; !read {
+		t.reads[key] = committed.version
+	}
+	return committed.value, nil
+}
+
+// PutState records a write, applied when the transaction commits
+func (t *Transaction) PutState(key string, value []byte) error {
+	if key == "" {
+		return errors.New("key must not be an empty string")
+	}
+	if t.paginated {
+		return fmt.Errorf("txid [%s]: transaction has already performed a paginated query. Writes are not allowed", t.txID)
+	}
+	delete(t.deletes, key)
+	t.writes[key] = value
+	return nil
+}
+
+// DelState records a deletion, applied when the transaction commits
+func (t *Transaction) DelState(key string) error {
+	if t.paginated {
+		return fmt.Errorf("txid [%s]: transaction has already performed a paginated query. Writes are not allowed", t.txID)
+	}
+	delete(t.writes, key)
+	t.deletes[key] = true
+	return nil
+}
+
+// rangeQuery returns the committed entries in [startKey, endKey), sorted by key, and records the
+// range for phantom read detection
+func (t *Transaction) rangeQuery(startKey, endKey string) []*queryresult.KV {
+	l := t.ledger
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	versions := l.rangeVersions(startKey, endKey)
+	t.rangeReads = append(t.rangeReads, &rangeRead{startKey: startKey, endKey: endKey, versions: versions})
+
+	keys := make([]string, 0, len(versions))
+	for key := range versions {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	results := make([]*queryresult.KV, 0, len(keys))
+	for _, key := range keys {
+		results = append(results, &queryresult.KV{Key: key, Value: l.state[key].value})
+	}
+	return results
+}
+
+// paginate returns one page of results starting at the bookmark key, and the metadata for it
+func (t *Transaction) paginate(results []*queryresult.KV, pageSize int32, bookmark string) ([]*queryresult.KV, *pb.QueryResponseMetadata) {
+	t.paginated = true
+
+	start := 0
+	if bookmark != "" {
+		start = sort.Search(len(results), func(i int) bool { return results[i].Key >= bookmark })
+	}
+	end := len(results)
+	metadata := &pb.QueryResponseMetadata{}
+	if pageSize > 0 && start+int(pageSize) < end {
+		end = start + int(pageSize)
+		metadata.Bookmark = results[end].Key
+	}
+	metadata.FetchedRecordsCount = int32(end - start)
+	return results[start:end], metadata
+}
+
+// GetStateByRange returns the committed non-composite keys in [startKey, endKey)
+func (t *Transaction) GetStateByRange(startKey, endKey string) (shim.StateQueryIteratorInterface, error) {
+	if strings.HasPrefix(startKey, compositeKeyNamespace) || strings.HasPrefix(endKey, compositeKeyNamespace) {
+		return nil, errors.New("range query keys must not be composite keys")
+	}
+	return &stateIterator{results: t.rangeQuery(startKey, endKey)}, nil
+}
+
+// GetStateByRangeWithPagination returns one page of the committed non-composite keys in [startKey, endKey)
+func (t *Transaction) GetStateByRangeWithPagination(startKey, endKey string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	if len(t.writes) > 0 || len(t.deletes) > 0 {
+		return nil, nil, errors.New("paginated queries are only valid in read-only transactions")
+	}
+	iterator, err := t.GetStateByRange(startKey, endKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	page, metadata := t.paginate(iterator.(*stateIterator).results, pageSize, bookmark)
+	return &stateIterator{results: page}, metadata, nil
+}
+
+// GetStateByPartialCompositeKey returns the committed composite keys starting with the given prefix
+func (t *Transaction) GetStateByPartialCompositeKey(objectType string, keys []string) (shim.StateQueryIteratorInterface, error) {
+	prefix, err := t.CreateCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, err
+	}
+	return &stateIterator{results: t.rangeQuery(prefix, prefix+string(rune(maxUnicodeRuneValue)))}, nil
+}
+
+// GetStateByPartialCompositeKeyWithPagination returns one page of the committed composite keys
+// starting with the given prefix
+func (t *Transaction) GetStateByPartialCompositeKeyWithPagination(objectType string, keys []string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	if len(t.writes) > 0 || len(t.deletes) > 0 {
+		return nil, nil, errors.New("paginated queries are only valid in read-only transactions")
+	}
+	iterator, err := t.GetStateByPartialCompositeKey(objectType, keys)
+	if err != nil {
+		return nil, nil, err
+	}
+	page, metadata := t.paginate(iterator.(*stateIterator).results, pageSize, bookmark)
+	return &stateIterator{results: page}, metadata, nil
+}
+
+// GetQueryResult is not supported: rich queries need CouchDB, and the mock behaves like LevelDB
+func (t *Transaction) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	return nil, errors.New("rich queries are not supported by the in-memory ledger")
+}
+
+// GetQueryResultWithPagination is not supported: rich queries need CouchDB, and the mock behaves like LevelDB
+func (t *Transaction) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *pb.QueryResponseMetadata, error) {
+	return nil, nil, errors.New("rich queries are not supported by the in-memory ledger")
+}
+
+// CreateCompositeKey joins an object type and attributes into a composite key, as Fabric does
+func (t *Transaction) CreateCompositeKey(objectType string, attributes []string) (string, error) {
+	if err := validateCompositeKeyAttribute(objectType); err != nil {
+		return "", err
+	}
+	key := compositeKeyNamespace + objectType + string(rune(minUnicodeRuneValue))
+	for _, attribute := range attributes {
+		if err := validateCompositeKeyAttribute(attribute); err != nil {
+			return "", err
+		}
+		key += attribute + string(rune(minUnicodeRuneValue))
+	}
+	return key, nil
+}
+
+// SplitCompositeKey splits a composite key into its object type and attributes
+func (t *Transaction) SplitCompositeKey(compositeKey string) (string, []string, error) {
+	if !strings.HasPrefix(compositeKey, compositeKeyNamespace) {
+		return "", nil, fmt.Errorf("%q is not a composite key", compositeKey)
+	}
+	parts := strings.Split(strings.TrimSuffix(compositeKey[1:], string(rune(minUnicodeRuneValue))), string(rune(minUnicodeRuneValue)))
+	return parts[0], parts[1:], nil
+}
+
+// validateCompositeKeyAttribute rejects attributes Fabric does not allow in composite keys
+func validateCompositeKeyAttribute(attribute string) error {
+	if !utf8.ValidString(attribute) {
+		return fmt.Errorf("not a valid utf8 string: [%x]", attribute)
+	}
+	for _, r := range attribute {
+		if r == minUnicodeRuneValue || r == maxUnicodeRuneValue {
+			return fmt.Errorf("input contains unicode %#U starting at position [%d]. %#U and %#U are not allowed in the input attribute of a composite key", r, strings.IndexRune(attribute, r), minUnicodeRuneValue, maxUnicodeRuneValue)
+		}
+	}
+	return nil
+}
+
+// GetHistoryForKey returns the committed modifications of a key, oldest first
+func (t *Transaction) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	l := t.ledger
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	history := make([]*queryresult.KeyModification, len(l.history[key]))
+	copy(history, l.history[key])
+	return &historyIterator{results: history}, nil
+}
+
+// GetPrivateData returns the committed value of a key in a private data collection
+func (t *Transaction) GetPrivateData(collection, key string) ([]byte, error) {
+	l := t.ledger
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.private[collection][key], nil
+}
+
+// PutPrivateData records a private data write, applied when the transaction commits
+func (t *Transaction) PutPrivateData(collection string, key string, value []byte) error {
+	if t.private[collection] == nil {
+		t.private[collection] = make(map[string][]byte)
+	}
+	t.private[collection][key] = value
+	return nil
+}
+
+// DelPrivateData records a private data deletion, applied when the transaction commits
+func (t *Transaction) DelPrivateData(collection, key string) error {
+	if t.private[collection] == nil {
+		t.private[collection] = make(map[string][]byte)
+	}
+	t.private[collection][key] = nil
+	return nil
+}
+
+// stateIterator iterates over a fixed set of query results
+type stateIterator struct {
+	results []*queryresult.KV
+	next    int
+}
+
+// HasNext reports whether another result remains
+func (i *stateIterator) HasNext() bool {
+	return i.next < len(i.results)
+}
+
+// Next returns the next result
+func (i *stateIterator) Next() (*queryresult.KV, error) {
+	if !i.HasNext() {
+		return nil, errors.New("no more results")
+	}
+	i.next++
+	return i.results[i.next-1], nil
+}
+
+// Close releases the iterator
+func (i *stateIterator) Close() error {
+	return nil
+}
+
+// historyIterator iterates over a fixed set of key modifications
+type historyIterator struct {
+	results []*queryresult.KeyModification
+	next    int
+}
+
+// HasNext reports whether another modification remains
+func (i *historyIterator) HasNext() bool {
+	return i.next < len(i.results)
+}
+
+// Next returns the next modification
+func (i *historyIterator) Next() (*queryresult.KeyModification, error) {
+	if !i.HasNext() {
+		return nil, errors.New("no more results")
+	}
+	i.next++
+	return i.results[i.next-1], nil
+}
+
+// Close releases the iterator
+func (i *historyIterator) Close() error {
+	return nil
+}
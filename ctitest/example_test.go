@@ -0,0 +1,96 @@
+package ctitest_test
+
+import (
+	"errors"
+	"fmt"
+
+	cti "github.com/dstrukturos/cti"
+	"github.com/dstrukturos/cti/ctitest"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+)
+
+const cid = "QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG"
+
+// quietLogger discards the contract's operation log so it does not mix with example output
+type quietLogger struct{}
+
+func (quietLogger) Debugf(format string, args ...interface{}) {}
+
+func (quietLogger) Infof(format string, args ...interface{}) {}
+
+// Two uploads endorsed against the same state are given IDs derived from their transaction IDs,
+// so they never collide and both commit.
+func ExampleLedger_Concurrent_addCTIItem() {
+	contract := &cti.SmartContract{Logger: quietLogger{}}
+	ledger := ctitest.NewLedger()
+	alice := &ctitest.Identity{ID: "alice", MSPID: "Org1MSP"}
+	bob := &ctitest.Identity{ID: "bob", MSPID: "Org2MSP"}
+
+	ids := make([]string, 2)
+	upload := func(i int) func(ctx contractapi.TransactionContextInterface) error {
+		return func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			ids[i], err = contract.AddCTIItem(ctx, "phishing kit", 1, cid, "key", 1, 1, "low", "", nil, "", "GREEN", nil, 50)
+			return err
+		}
+	}
+	errs := ledger.Concurrent(ctitest.Call(alice, upload(0)), ctitest.Call(bob, upload(1)))
+
+	fmt.Println(ids, errs)
+	// Output: [tx000001 tx000002] [<nil> <nil>]
+}
+
+// Batch uploads number their items after the transaction ID, so they cannot collide with a single
+// upload committed later.
+func ExampleLedger_Invoke_addCTIItemsBatch() {
+	contract := &cti.SmartContract{Logger: quietLogger{}}
+	ledger := ctitest.NewLedger()
+	alice := &ctitest.Identity{ID: "alice", MSPID: "Org1MSP"}
+
+	var batch []string
+	ledger.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		batch, err = contract.AddCTIItemsBatch(ctx, `[
+			{"Name": "first", "Timestamp": 1, "CID": "`+cid+`", "Level": 1, "TLP": "GREEN", "Confidence": 50},
+			{"Name": "second", "Timestamp": 1, "CID": "`+cid+`", "Level": 1, "TLP": "GREEN", "Confidence": 50}
+		]`)
+		return err
+	})
+	var single string
+	ledger.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		single, err = contract.AddCTIItem(ctx, "third", 1, cid, "key", 1, 1, "low", "", nil, "", "GREEN", nil, 50)
+		return err
+	})
+
+	fmt.Println(batch, single)
+	// Output: [tx000001-000 tx000001-001] tx000002
+}
+
+// Reviews racing to update the same item's aggregates conflict at commit, so the item never loses
+// a review; the invalidated one can simply be resubmitted.
+func ExampleLedger_Concurrent_reviews() {
+	contract := &cti.SmartContract{Logger: quietLogger{}}
+	ledger := ctitest.NewLedger()
+	alice := &ctitest.Identity{ID: "alice", MSPID: "Org1MSP"}
+	bob := &ctitest.Identity{ID: "bob", MSPID: "Org2MSP"}
+	carol := &ctitest.Identity{ID: "carol", MSPID: "Org3MSP"}
+
+	var id string
+	ledger.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		id, err = contract.AddCTIItem(ctx, "phishing kit", 1, cid, "key", 1, 1, "low", "", nil, "", "GREEN", nil, 50)
+		return err
+	})
+	errs := ledger.Concurrent(
+		ctitest.Call(bob, func(ctx contractapi.TransactionContextInterface) error {
+			return contract.AddReviewData(ctx, id, 4, 4, 4, 4, "accurate")
+		}),
+		ctitest.Call(carol, func(ctx contractapi.TransactionContextInterface) error {
+			return contract.AddReviewData(ctx, id, 2, 2, 2, 2, "outdated")
+		}),
+	)
+
+	fmt.Println(errs[0], errors.Is(errs[1], ctitest.ErrMVCCConflict))
+	// Output: <nil> true
+}
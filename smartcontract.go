@@ -1,9 +1,21 @@
 package chaincode
 
 import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/hyperledger/fabric-contract-api-go/contractapi"
 )
@@ -11,169 +23,7892 @@ import (
 // SmartContract provides functions
 type SmartContract struct {
 	contractapi.Contract
+
+	// AllowSelfReview lets uploaders review their own CTI items. Self-reviews earn no rewards. It is
+	// meant for test networks only and must be set the same way on every peer.
+	AllowSelfReview bool
+
+	// Logger receives the operation log. When nil, operations are logged to standard error.
+	Logger Logger
+}
+
+// Logger is a leveled logger for the chaincode's operation log. Its methods match those of the Fabric
+// shim's ChaincodeLogger, so a shim logger can be used directly.
+type Logger interface {
+	Debugf(format string, args ...interface{})
+	Infof(format string, args ...interface{})
+}
+
+// logLevelEnv names the environment variable selecting the chaincode log level. Operation start lines
+// are logged at debug level and only written when it is set to "debug"; outcomes are logged at info level.
+const logLevelEnv = "CTI_LOG_LEVEL"
+
+// stderrLogger writes the operation log to the container's standard error, which the peer collects
+type stderrLogger struct {
+	out   *log.Logger
+	debug bool
+}
+
+// Debugf writes a debug-level line if debug logging is enabled
+func (l *stderrLogger) Debugf(format string, args ...interface{}) {
+	if l.debug {
+		l.out.Printf("level=debug "+format, args...)
+	}
+}
+
+// Infof writes an info-level line
+func (l *stderrLogger) Infof(format string, args ...interface{}) {
+	l.out.Printf("level=info "+format, args...)
+}
+
+// defaultLogger is the logger used when SmartContract.Logger is not set
+var defaultLogger Logger = &stderrLogger{
+	out:   log.New(os.Stderr, "", log.LstdFlags|log.LUTC),
+	debug: strings.EqualFold(os.Getenv(logLevelEnv), "debug"),
+}
+
+// tagPattern is the format a normalized tag must have
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+// maxBulkTagItems bounds the number of CTI items tagged in one transaction
+const maxBulkTagItems = 100
+
+// maxBatchCTIItems bounds the number of CTI items added in one AddCTIItemsBatch transaction
+const maxBatchCTIItems = 500
+
+// regionCodes holds the ISO 3166-1 alpha-2 country codes accepted as CTI item regions
+var regionCodes = func() map[string]bool {
+	codes := make(map[string]bool)
+	for _, code := range strings.Fields(iso3166Alpha2) {
+		codes[code] = true
+	}
+	return codes
+}()
+
+// iso3166Alpha2 lists every officially assigned ISO 3166-1 alpha-2 code
+const iso3166Alpha2 = "AD AE AF AG AI AL AM AO AQ AR AS AT AU AW AX AZ BA BB BD BE " +
+	"BF BG BH BI BJ BL BM BN BO BQ BR BS BT BV BW BY BZ CA CC CD " +
+	"CF CG CH CI CK CL CM CN CO CR CU CV CW CX CY CZ DE DJ DK DM " +
+	"DO DZ EC EE EG EH ER ES ET FI FJ FK FM FO FR GA GB GD GE GF " +
+	"GG GH GI GL GM GN GP GQ GR GS GT GU GW GY HK HM HN HR HT HU " +
+	"ID IE IL IM IN IO IQ IR IS IT JE JM JO JP KE KG KH KI KM KN " +
+	"KP KR KW KY KZ LA LB LC LI LK LR LS LT LU LV LY MA MC MD ME " +
+	"MF MG MH MK ML MM MN MO MP MQ MR MS MT MU MV MW MX MY MZ NA " +
+	"NC NE NF NG NI NL NO NP NR NU NZ OM PA PE PF PG PH PK PL PM " +
+	"PN PR PS PT PW PY QA RE RO RS RU RW SA SB SC SD SE SG SH SI " +
+	"SJ SK SL SM SN SO SR SS ST SV SX SY SZ TC TD TF TG TH TJ TK " +
+	"TL TM TN TO TR TT TV TW TZ UA UG UM US UY UZ VA VC VE VG VI " +
+	"VN VU WF WS YE YT ZA ZM ZW "
+
+// accessLogPrefix starts the keys of the CTI access log. Keys continue with the zero-padded access
+// time so a time window can be read with one range query.
+const accessLogPrefix = "Access_"
+
+// maxTrendingWindow bounds the window, in seconds, over which trending items are ranked
+const maxTrendingWindow = 30 * 24 * 60 * 60
+
+// maxHashReportSize bounds the number of entries in one content hash report
+const maxHashReportSize = 100
+
+// IPFS content identifier formats: base58btc CIDv0 and base32 CIDv1
+var (
+	cidV0Pattern = regexp.MustCompile(`^Qm[1-9A-HJ-NP-Za-km-z]{44}$`)
+	cidV1Pattern = regexp.MustCompile(`^b[a-z2-7]{58,}$`)
+)
+
+// techniquePattern is the format of a MITRE ATT&CK technique or sub-technique ID
+var techniquePattern = regexp.MustCompile(`^T[0-9]{4}(\.[0-9]{3})?$`)
+
+// sha256HexPattern is the format of a hex-encoded SHA-256 digest
+var sha256HexPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// ContentHashReport is one entry of an oracle's content hash report: the hash the oracle computed
+// over the content it retrieved for a CTI item
+type ContentHashReport struct {
+	ID           string `json:"id"`
+	ComputedHash string `json:"computedHash"`
+}
+
+// maxUploaderListSize bounds the number of uploaders queried in one call
+const maxUploaderListSize = 50
+
+// ContractVersion is the version of this chaincode
+const ContractVersion = "1.0.0"
+
+// SchemaVersion is the version of the CTI, user and review record layouts written by this chaincode.
+// Records carrying an older version are brought up to date by MigrateSchema.
+//
+// Version 2 added the cached review aggregates (ReviewCount, CompositeScore) to CTI items.
+// Version 3 added RewardPaid to reviews. Older reviews are assumed to have been paid under the
+// first-review rule, since rewards were always credited in the same transaction as the review.
+// Version 4 added Confidence to CTI items. Older items read as unsetConfidence.
+// Version 5 added RewardAmount to reviews. Older paid reviews were paid defaultReviewerReward.
+const SchemaVersion = 5
+
+// unsetConfidence is the confidence of CTI items recorded before uploaders could assert one
+const unsetConfidence = -1
+
+// VersionInfo reports the contract version and the record schema version it writes
+type VersionInfo struct {
+	ContractVersion string `json:"ContractVersion"`
+	SchemaVersion   int    `json:"SchemaVersion"`
+}
+
+// Key range covering every CTI item on the ledger: the zero-padded numeric keys of items uploaded
+// before IDs were derived from transaction IDs, un-padded keys awaiting MigrateCTIKeys, and the
+// transaction ID keys of newer items. '~' sorts after every character a key can continue with.
+const (
+	ctiRangeStart = "CTI_"
+	ctiRangeEnd   = "CTI_~"
+)
+
+// ctiIDWidth is the width numeric CTI IDs are zero-padded to in ledger keys, so that the keys of
+// up to ten-digit IDs sort in numeric order
+const ctiIDWidth = 10
+
+// ctiKey returns the ledger key of a CTI item. Numeric IDs are zero-padded; any other ID, such as the
+// transaction ID a newer item is identified by, is used as is. Only canonical numerals count as numeric,
+// so "007" does not alias item 7.
+func ctiKey(id string) string {
+	numericID, err := strconv.ParseUint(id, 10, 64)
+	if err != nil || strconv.FormatUint(numericID, 10) != id {
+		return "CTI_" + id
+	}
+	return fmt.Sprintf("CTI_%0*d", ctiIDWidth, numericID)
+}
+
+// Composite key indexes maintained for CTI items
+const (
+	uploaderIndex  = "uploader~id"
+	levelIndex     = "level~id"
+	cidIndex       = "cid~id"
+	tagIndex       = "tag~id"
+	orgIndex       = "org~id"
+	regionIndex    = "region~id"
+	tlpIndex       = "tlp~id"
+	techniqueIndex = "technique~id"
+	indicatorIndex = "indicator~id"
+)
+
+// reviewerIndex indexes reviews by reviewer and reviewed CTI item
+const reviewerIndex = "reviewer~cti"
+
+// voteIndex records helpfulness votes by review and voter
+const voteIndex = "vote~review"
+
+// grantIndex holds the identities a CTI item's owner has granted access to, keyed by item and grantee
+const grantIndex = "grant~cti"
+
+// granteeIndex mirrors grantIndex keyed by grantee and item, so a caller's grants can be loaded at once.
+// Grants made before it existed are added to it by RebuildIndexes.
+const granteeIndex = "grantee~cti"
+
+// linkIndex holds the links from CTI items to the items they supersede or relate to, keyed by item,
+// relationship and target
+const linkIndex = "link~cti"
+
+// linkedIndex mirrors linkIndex keyed by target, relationship and item, so links can be followed backwards
+const linkedIndex = "linked~cti"
+
+// linkRelationships lists the relationships a CTI item can have to another
+var linkRelationships = []string{"supersedes", "related-to"}
+
+// GetCTILineage follows at most maxLineageDepth links and returns at most maxLineageNodes items
+const (
+	maxLineageDepth = 10
+	maxLineageNodes = 100
+)
+
+// reviewerRewardKey is the ledger key holding the number of points credited to a reviewer for
+// reviewing a CTI item
+const reviewerRewardKey = "ReviewerReward"
+
+// defaultReviewerReward is the reviewer reward until an admin sets another
+const defaultReviewerReward = 1
+
+// rewardMintCapKey is the ledger key holding the most reviewer reward points one reviewer may be
+// credited per day. Until an admin sets a cap, rewards are not capped.
+const rewardMintCapKey = "RewardMintCap"
+
+// rewardMintPeriod is the length in seconds of the period the reward mint cap applies to
+const rewardMintPeriod = 24 * 60 * 60
+
+// changeLogIndex holds each CTI item's changelog, keyed by item, transaction timestamp and ID
+const changeLogIndex = "changelog~cti"
+
+// ChangeLogEntry is a human-readable record of a significant change to a CTI item
+type ChangeLogEntry struct {
+	Action    string `json:"Action"`
+	Actor     string `json:"Actor"`
+	Timestamp int64  `json:"Timestamp"`
+	TxID      string `json:"TxID"`
+	Summary   string `json:"Summary"`
+}
+
+// notificationIndex holds each user's notification inbox, keyed by recipient and notification ID
+const notificationIndex = "notification~user"
+
+// maxNotificationsPerUser caps a user's inbox; the oldest notifications are evicted beyond it
+const maxNotificationsPerUser = 50
+
+// Notification is a platform event addressed to one user
+type Notification struct {
+	ID        string `json:"ID"`
+	Recipient string `json:"Recipient"`
+	Type      string `json:"Type"`
+	RefID     string `json:"RefID"`
+	Message   string `json:"Message"`
+	Read      bool   `json:"Read"`
+	Timestamp int64  `json:"Timestamp"`
+}
+
+// auditIndex holds the admin audit log, keyed by transaction timestamp and ID
+const auditIndex = "audit~ts"
+
+// AuditEntry records one administrative action
+type AuditEntry struct {
+	TxID      string `json:"TxID"`
+	Timestamp int64  `json:"Timestamp"`
+	Actor     string `json:"Actor"`
+	Action    string `json:"Action"`
+	Target    string `json:"Target"`
+	Details   string `json:"Details"`
+}
+
+// Composite key indexes recording who follows which CTI item, in both directions
+const (
+	followerIndex  = "cti~follower"
+	followingIndex = "follower~cti"
+)
+
+// CTIItemEvent is the payload of the events emitted when a CTI item is added or deleted
+type CTIItemEvent struct {
+	ID        string `json:"ID"`
+	Uploader  string `json:"Uploader"`
+	Level     int    `json:"Level"`
+	Timestamp int64  `json:"Timestamp"`
+}
+
+// CTIItemUpdatedEvent is the payload of the event emitted when a CTI item is updated. Followers lists
+// the identities following the item so an off-chain notifier can alert them.
+type CTIItemUpdatedEvent struct {
+	CTIItemEvent
+	UpdatedBy string   `json:"UpdatedBy"`
+	Followers []string `json:"Followers"`
+}
+
+// newCTIItemEvent returns the event payload describing a CTI item
+func newCTIItemEvent(ctiItem *CTIData) CTIItemEvent {
+	return CTIItemEvent{ID: ctiItem.ID, Uploader: ctiItem.Uploader, Level: ctiItem.Level, Timestamp: ctiItem.Timestamp}
+}
+
+// reviewDimensionsKey is the ledger key holding the admin-configured review dimensions
+const reviewDimensionsKey = "ReviewDimensions"
+
+// defaultReviewDimensions are the review dimensions used until an admin configures others. They
+// match the fixed score fields of ReviewData, which are still filled for these names.
+var defaultReviewDimensions = []string{"Accuracy", "Timeliness", "Completeness", "Consistency"}
+
+// uploadStakeKey is the ledger key holding the balance an uploader must stake on each new CTI item
+const uploadStakeKey = "UploadStake"
+
+// A staked CTI item returns its stake to the uploader once it has at least stakeReturnReviews reviews
+// with a composite score of at least stakeReturnScore
+const (
+	stakeReturnReviews = 2
+	stakeReturnScore   = 3.0
+)
+
+// reviewDimensionWeightsKey is the ledger key holding the admin-configured weights of the review
+// dimensions in a CTI item's overall score
+const reviewDimensionWeightsKey = "ReviewDimensionWeights"
+
+// defaultReviewDimensionWeights are the weights of the default review dimensions until an admin
+// configures others. Any other dimension without a configured weight has weight 1. Weights need not
+// sum to one; the overall score is normalised by their total.
+var defaultReviewDimensionWeights = map[string]float64{
+	"Accuracy":     0.4,
+	"Timeliness":   0.2,
+	"Completeness": 0.2,
+	"Consistency":  0.2,
+}
+
+// UploaderReward is the number of points credited to an uploader, and to their CTI item, for each review
+// of the item whose composite score exceeds uploaderRewardScore
+const (
+	UploaderReward      = 1
+	uploaderRewardScore = 3.5
+)
+
+// Every review dimension is scored on a scale from MinReviewScore to MaxReviewScore inclusive
+const (
+	MinReviewScore = 1
+	MaxReviewScore = 5
+)
+
+// CTICIDUpdatedEvent is the payload of the event emitted when a CTI item's content is re-pinned
+type CTICIDUpdatedEvent struct {
+	ID          string `json:"ID"`
+	ContentHash string `json:"ContentHash"`
+	UpdatedBy   string `json:"UpdatedBy"`
+}
+
+// ExpiryResult reports the CTI items archived by one ExpireStaleCTIItems call and where to resume
+type ExpiryResult struct {
+	Expired  int    `json:"Expired"`
+	Bookmark string `json:"Bookmark"`
+}
+
+// ReconcileResult reports one page of a user statistics reconciliation
+type ReconcileResult struct {
+	Scanned   int    `json:"Scanned"`
+	Corrected int    `json:"Corrected"`
+	Bookmark  string `json:"Bookmark"`
+}
+
+// CTIItemsAddedEvent is the payload of the event emitted when a batch of CTI items is added
+type CTIItemsAddedEvent struct {
+	IDs []string `json:"IDs"`
+}
+
+// CTIItemsExpiredEvent is the payload of the event emitted when stale CTI items are archived
+type CTIItemsExpiredEvent struct {
+	IDs []string `json:"IDs"`
+}
+
+// ReviewAddedEvent is the payload of the event emitted when a review is added
+type ReviewAddedEvent struct {
+	ID        string `json:"ID"`
+	CTIDataID string `json:"CTIDataID"`
+	Reviewer  string `json:"Reviewer"`
+	Reward    int    `json:"Reward"`
+}
+
+// ctiIndexes lists every composite index maintained for CTI items
+var ctiIndexes = []string{uploaderIndex, levelIndex, cidIndex, tagIndex, orgIndex, regionIndex, tlpIndex, techniqueIndex, indicatorIndex}
+
+// reviewTextCollection is the private data collection holding the text of private reviews, keyed by
+// review ID. It must be declared in the chaincode's collection configuration (see collections_config.json)
+// with memberOnlyRead enabled so only member organisations' peers store the text.
+const reviewTextCollection = "reviewTextCollection"
+
+// ctiKeyCollection is the private data collection holding the encryption keys of CTI items added with
+// AddCTIItemPrivate, keyed by CTI item ID. Like reviewTextCollection it must be declared in the
+// collection configuration with memberOnlyRead enabled.
+const ctiKeyCollection = "ctiKeyCollection"
+
+// Client certificate attribute carrying the caller's role, and the recognised roles
+const (
+	roleAttribute = "cti.role"
+	adminRole     = "admin"
+	oracleRole    = "oracle"
+	orgLeadRole   = "orglead"
+	moderatorRole = "moderator"
+)
+
+// Permissions describes what the caller may do, derived from their identity's role attribute and MSP
+type Permissions struct {
+	ID                       string `json:"ID"`
+	MSPID                    string `json:"MSPID"`
+	Role                     string `json:"Role"`
+	IsAdmin                  bool   `json:"IsAdmin"`
+	IsOracle                 bool   `json:"IsOracle"`
+	IsModerator              bool   `json:"IsModerator"`
+	IsOrgLead                bool   `json:"IsOrgLead"`
+	CanMint                  bool   `json:"CanMint"`
+	CanConfigure             bool   `json:"CanConfigure"`
+	CanRecordCIDAvailability bool   `json:"CanRecordCIDAvailability"`
+	CanAdoptItems            bool   `json:"CanAdoptItems"`
+	CanModerate              bool   `json:"CanModerate"`
+	CanExpireItems           bool   `json:"CanExpireItems"`
+}
+
+// AnonymizedUploader is the uploader recorded on CTI items whose uploader account was deleted
+const AnonymizedUploader = "anonymized"
+
+// Indicator is an indicator of compromise referenced by a CTI item
+type Indicator struct {
+	Type  string `json:"Type"`
+	Value string `json:"Value"`
+}
+
+// indicatorTypes are the indicator types a CTI item can reference
+var indicatorTypes = []string{"ipv4", "domain", "md5", "sha256", "url"}
+
+// Value formats of the hash and domain indicator types
+var (
+	md5HexPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+	domainPattern = regexp.MustCompile(`^([a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?\.)+[a-z]{2,63}$`)
+)
+
+// Severity levels a CTI item can be marked with. Items may also leave the severity unset.
+var severityLevels = []string{"low", "medium", "high", "critical"}
+
+// tlpMarkings are the Traffic Light Protocol markings a CTI item can carry
+var tlpMarkings = []string{"WHITE", "GREEN", "AMBER", "RED"}
+
+// partnerTLPMarkings are the TLP markings under which a CTI item may be redistributed to partners
+var partnerTLPMarkings = []string{"WHITE", "GREEN"}
+
+// CTIData represents the data structure for CTI data entries
+type CTIData struct {
+	ID              string      `json:"ID"`
+	Name            string      `json:"Name"`
+	Uploader        string      `json:"Uploader"`
+	UploaderMSP     string      `json:"UploaderMSP"`
+	Timestamp       int64       `json:"Timestamp"`
+	CID             string      `json:"CID"`
+	EncryptKey      string      `json:"encryptKey"`
+	Points          int         `json:"Points"`
+	Level           int         `json:"Level"`
+	CIDAvailable    bool        `json:"CIDAvailable"`
+	LastCheckedAt   int64       `json:"LastCheckedAt"`
+	UpdatedAt       int64       `json:"UpdatedAt"`
+	Severity        string      `json:"Severity"`
+	TLP             string      `json:"TLP"`
+	Confidence      int         `json:"Confidence"`
+	Description     string      `json:"Description"`
+	Tags            []string    `json:"Tags"`
+	Techniques      []string    `json:"Techniques"`
+	Indicators      []Indicator `json:"Indicators"`
+	ReviewCount     int         `json:"ReviewCount"`
+	CompositeScore  float64     `json:"CompositeScore"`
+	NeedsReReview   bool        `json:"NeedsReReview"`
+	StakeEscrow     int         `json:"StakeEscrow"`
+	LastReviewedAt  int64       `json:"LastReviewedAt"`
+	ExpiresAt       int64       `json:"ExpiresAt"`
+	CreatedAt       int64       `json:"CreatedAt"`
+	Region          string      `json:"Region"`
+	ContentHash     string      `json:"ContentHash"`
+	TamperSuspected bool        `json:"TamperSuspected"`
+	Archived        bool        `json:"Archived"`
+	ArchivedAt      int64       `json:"ArchivedAt"`
+	Deleted         bool        `json:"Deleted"`
+	DeletedBy       string      `json:"DeletedBy"`
+	DeletedAt       int64       `json:"DeletedAt"`
+	PrivateKey      bool        `json:"PrivateKey"`
+	LastModifiedBy  string      `json:"LastModifiedBy"`
+	SchemaVersion   int         `json:"SchemaVersion"`
+}
+
+// UnmarshalJSON decodes a CTI item, defaulting Confidence to unsetConfidence for records written
+// before the field existed so they are not mistaken for a confidence of zero
+func (c *CTIData) UnmarshalJSON(data []byte) error {
+	type ctiData CTIData
+	decoded := ctiData{Confidence: unsetConfidence}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	*c = CTIData(decoded)
+	return nil
+}
+
+// UserData represents the data structure for user entries.
+// Reputation is a cached mean composite score of the reviews received on the user's CTI items.
+// It is updated incrementally whenever a review is added, so it can be stale after items or
+// reviews are removed until ForceRecomputeReputation rescans the ledger.
+type UserData struct {
+	ID              string  `json:"ID"`
+	UserLevel       int     `json:"UserLevel"`
+	UploadCount     int     `json:"UploadCount"`
+	Points          int     `json:"Points"`
+	Subscribed      int     `json:"Subscribed"`
+	Balance         int     `json:"Balance"`
+	Reputation      float64 `json:"Reputation"`
+	ReviewsReceived int     `json:"ReviewsReceived"`
+	RewardPeriod    int64   `json:"RewardPeriod"`
+	RewardsMinted   int     `json:"RewardsMinted"`
+	ReviewsAuthored int     `json:"ReviewsAuthored"`
+	ReviewPoints    int     `json:"ReviewPoints"`
+	LastSeenAt      int64   `json:"LastSeenAt"`
+	LastSeenPoints  int     `json:"LastSeenPoints"`
+	SchemaVersion   int     `json:"SchemaVersion"`
+}
+
+// ReviewData represents the data structure for review entries
+type ReviewData struct {
+	ID               string         `json:"ID"`
+	UserDataID       string         `json:"UserDataID"`
+	ReviewerMSP      string         `json:"ReviewerMSP"`
+	CTIDataID        string         `json:"CTIDataID"`
+	Accuracy         int            `json:"Accuracy"`
+	Timeliness       int            `json:"Timeliness"`
+	Completeness     int            `json:"Completeness"`
+	Consistency      int            `json:"Consistency"`
+	Scores           map[string]int `json:"Scores"`
+	ReviewText       string         `json:"ReviewText"`
+	Timestamp        int64          `json:"Timestamp"`
+	Invalidated      bool           `json:"Invalidated"`
+	PrivateText      bool           `json:"PrivateText"`
+	HelpfulCount     int            `json:"HelpfulCount"`
+	UnhelpfulCount   int            `json:"UnhelpfulCount"`
+	ContentHash      string         `json:"ContentHash"`
+	RewardPaid       bool           `json:"RewardPaid"`
+	RewardAmount     int            `json:"RewardAmount"`
+	UploaderRewarded bool           `json:"UploaderRewarded"`
+	SchemaVersion    int            `json:"SchemaVersion"`
+}
+
+// StaleIndexEntries is one page of index entries that no longer match the record they refer to, and the
+// bookmark from which the next page starts
+type StaleIndexEntries struct {
+	Keys     []string `json:"Keys"`
+	Bookmark string   `json:"Bookmark"`
+}
+
+// RewardShortfall lists the reviews of a reviewer that earned a reward which was never credited
+type RewardShortfall struct {
+	UserID      string   `json:"UserID"`
+	UnpaidIDs   []string `json:"UnpaidIDs"`
+	Owed        int      `json:"Owed"`
+	PaidRewards int      `json:"PaidRewards"`
+}
+
+// reviewContent holds the fields of a review that never change after it is written. Its canonical
+// hash is stored on the review as ContentHash.
+type reviewContent struct {
+	ID           string         `json:"ID"`
+	UserDataID   string         `json:"UserDataID"`
+	ReviewerMSP  string         `json:"ReviewerMSP"`
+	CTIDataID    string         `json:"CTIDataID"`
+	Accuracy     int            `json:"Accuracy"`
+	Timeliness   int            `json:"Timeliness"`
+	Completeness int            `json:"Completeness"`
+	Consistency  int            `json:"Consistency"`
+	Scores       map[string]int `json:"Scores"`
+	ReviewText   string         `json:"ReviewText"`
+	Timestamp    int64          `json:"Timestamp"`
+	PrivateText  bool           `json:"PrivateText"`
+}
+
+// ReviewIntegrity is the result of checking a stored review against its content hash. Hashed is false
+// for reviews written before content hashes were recorded, which cannot be verified.
+type ReviewIntegrity struct {
+	ReviewID     string `json:"ReviewID"`
+	Hashed       bool   `json:"Hashed"`
+	Verified     bool   `json:"Verified"`
+	StoredHash   string `json:"StoredHash"`
+	ComputedHash string `json:"ComputedHash"`
+}
+
+// ScoreTrend describes how the perceived quality of a CTI item moved between its earlier and later reviews
+type ScoreTrend struct {
+	CTIDataID      string  `json:"CTIDataID"`
+	Direction      string  `json:"Direction"`
+	Delta          float64 `json:"Delta"`
+	Magnitude      float64 `json:"Magnitude"`
+	EarlierAverage float64 `json:"EarlierAverage"`
+	LaterAverage   float64 `json:"LaterAverage"`
+	ReviewCount    int     `json:"ReviewCount"`
+}
+
+// CTIItemScore is the aggregate quality of a CTI item: the mean of each configured review dimension over
+// its valid reviews and their weighted overall score. Dimensions holds every mean; the fixed fields
+// repeat the means of the default dimensions and stay zero when those are not configured. All scores
+// are zero for an item without reviews.
+type CTIItemScore struct {
+	CTIDataID    string             `json:"CTIDataID"`
+	Dimensions   map[string]float64 `json:"Dimensions"`
+	Accuracy     float64            `json:"Accuracy"`
+	Timeliness   float64            `json:"Timeliness"`
+	Completeness float64            `json:"Completeness"`
+	Consistency  float64            `json:"Consistency"`
+	Overall      float64            `json:"Overall"`
+	ReviewCount  int                `json:"ReviewCount"`
+}
+
+// CTIItemsPage is one page of CTI items and the bookmark from which the next page starts
+type CTIItemsPage struct {
+	Items        []*CTIData `json:"Items"`
+	Bookmark     string     `json:"Bookmark"`
+	FetchedCount int32      `json:"FetchedCount"`
+}
+
+// CTILink is a directed relationship from one CTI item to another
+type CTILink struct {
+	From         string `json:"From"`
+	To           string `json:"To"`
+	Relationship string `json:"Relationship"`
+}
+
+// CTILineage is the subgraph of CTI items linked to an item
+type CTILineage struct {
+	ID        string     `json:"ID"`
+	Nodes     []*CTIData `json:"Nodes"`
+	Edges     []*CTILink `json:"Edges"`
+	Truncated bool       `json:"Truncated"`
+}
+
+// ReviewsPage is one page of reviews and the bookmark from which the next page starts
+type ReviewsPage struct {
+	Reviews      []*ReviewData `json:"Reviews"`
+	Bookmark     string        `json:"Bookmark"`
+	FetchedCount int32         `json:"FetchedCount"`
+}
+
+// BrokenReference is a record or index entry that refers to a record which no longer exists
+type BrokenReference struct {
+	Key        string `json:"Key"`
+	MissingKey string `json:"MissingKey"`
+}
+
+// BrokenReferenceReport groups the dangling references found by ScanBrokenReferences
+type BrokenReferenceReport struct {
+	DanglingReviews      []*BrokenReference `json:"DanglingReviews"`
+	DanglingIndexEntries []*BrokenReference `json:"DanglingIndexEntries"`
+	DanglingLinks        []*BrokenReference `json:"DanglingLinks"`
+	Bookmark             string             `json:"Bookmark"`
+}
+
+// SignedCTIItem is a CTI item packaged for verification outside Fabric. CanonicalHash is the hex SHA-256
+// of the item's canonical JSON encoding, i.e. the encoding in the Item field of this response.
+//
+// To verify an item off-chain, a consumer keeps the endorsed proposal response returned for the
+// GetCTIItemSigned call: its payload holds this response and is signed by the endorsing peer. The
+// consumer checks the peer's signature against the peer certificate of an MSP it trusts, re-encodes
+// Item as JSON, and confirms its SHA-256 matches CanonicalHash.
+type SignedCTIItem struct {
+	Item          *CTIData `json:"Item"`
+	CanonicalHash string   `json:"CanonicalHash"`
+	HashAlgorithm string   `json:"HashAlgorithm"`
+	TxID          string   `json:"TxID"`
+}
+
+// CTIHistoryRecord is one version of a CTI item from the ledger history
+type CTIHistoryRecord struct {
+	TxID      string   `json:"TxID"`
+	Timestamp int64    `json:"Timestamp"`
+	Value     *CTIData `json:"Value"`
+	IsDelete  bool     `json:"IsDelete"`
+	ChangedBy string   `json:"ChangedBy,omitempty"`
+}
+
+// FieldChange is a change to one field of a CTI item. Before and After hold the JSON encoding of
+// the field's value and are empty when the field was absent.
+type FieldChange struct {
+	Field  string `json:"Field"`
+	Before string `json:"Before"`
+	After  string `json:"After"`
+}
+
+// CTIItemDiff lists the fields of a CTI item that changed between two points in time
+type CTIItemDiff struct {
+	ID            string         `json:"ID"`
+	FromTimestamp int64          `json:"FromTimestamp"`
+	ToTimestamp   int64          `json:"ToTimestamp"`
+	ExistedAtFrom bool           `json:"ExistedAtFrom"`
+	ExistedAtTo   bool           `json:"ExistedAtTo"`
+	Changes       []*FieldChange `json:"Changes"`
+}
+
+// TagUpdateResult reports which CTI items were tagged and why any were skipped
+type TagUpdateResult struct {
+	Tagged  []string          `json:"Tagged"`
+	Skipped map[string]string `json:"Skipped"`
+}
+
+// AccessibleCount reports how many CTI items the caller can access out of all items on the ledger
+type AccessibleCount struct {
+	Accessible int `json:"Accessible"`
+	Total      int `json:"Total"`
+}
+
+// OrgQuotaUsage reports an organisation's upload quota and how much of it is used.
+// MaxItems is -1 when the organisation has no quota.
+type OrgQuotaUsage struct {
+	MSPID    string `json:"MSPID"`
+	MaxItems int    `json:"MaxItems"`
+	Used     int    `json:"Used"`
+}
+
+// ExportPage is one page of a ledger export: newline-delimited, type-tagged JSON records
+// and the bookmark from which the next page starts
+type ExportPage struct {
+	Records      string `json:"Records"`
+	Bookmark     string `json:"Bookmark"`
+	FetchedCount int32  `json:"FetchedCount"`
+}
+
+// ExportRecord is a single line of a ledger export
+type ExportRecord struct {
+	Type   string          `json:"Type"`
+	Key    string          `json:"Key"`
+	Record json.RawMessage `json:"Record"`
+}
+
+// ImportResult summarises the outcome of importing a ledger export
+type ImportResult struct {
+	Imported int `json:"Imported"`
+	Skipped  int `json:"Skipped"`
+}
+
+// Record types tagged on exported lines
+const (
+	RecordTypeCTI    = "CTIData"
+	RecordTypeUser   = "UserData"
+	RecordTypeReview = "ReviewData"
+)
+
+// recordKeyPrefixes maps each exported record type to the key prefix it is stored under
+var recordKeyPrefixes = map[string]string{
+	RecordTypeCTI:    "CTI_",
+	RecordTypeUser:   "UserData_",
+	RecordTypeReview: "Review_",
+}
+
+// ReviewerAgreement compares the scores two reviewers gave the same CTI item
+type ReviewerAgreement struct {
+	ReviewerA              string  `json:"ReviewerA"`
+	ReviewerB              string  `json:"ReviewerB"`
+	ReviewIDA              string  `json:"ReviewIDA"`
+	ReviewIDB              string  `json:"ReviewIDB"`
+	MeanAbsoluteDifference float64 `json:"MeanAbsoluteDifference"`
+	CompositeDifference    float64 `json:"CompositeDifference"`
+}
+
+// OrgReviewStats summarises the reviews one organisation left on a CTI item
+type OrgReviewStats struct {
+	MSPID               string  `json:"MSPID"`
+	ReviewCount         int     `json:"ReviewCount"`
+	AverageAccuracy     float64 `json:"AverageAccuracy"`
+	AverageTimeliness   float64 `json:"AverageTimeliness"`
+	AverageCompleteness float64 `json:"AverageCompleteness"`
+	AverageConsistency  float64 `json:"AverageConsistency"`
+	AverageComposite    float64 `json:"AverageComposite"`
+}
+
+// minAgreementReviews is the number of reviews an item needs before reviewer agreement is computed
+const minAgreementReviews = 3
+
+// maxRankedItems bounds the number of items a ranking query returns
+const maxRankedItems = 100
+
+// Freshness scoring parameters, in seconds
+const (
+	freshnessAgeHalfLife    = 30 * 24 * 60 * 60
+	freshnessReviewHalfLife = 14 * 24 * 60 * 60
+)
+
+// RankedCTIItem is a CTI item with the score it was ranked by
+type RankedCTIItem struct {
+	Item  *CTIData `json:"Item"`
+	Score float64  `json:"Score"`
+}
+
+// minCorrelationReviews is the number of reviews an item needs before score and helpfulness are correlated
+const minCorrelationReviews = 3
+
+// Reviewer impact parameters. A review is early if it is among the first earlyReviewCount valid reviews
+// of an item, and only items with at least minConsensusReviews valid reviews have a consensus to compare
+// against. An early review called the consensus if its composite score is within impactTolerance of it.
+const (
+	earlyReviewCount    = 3
+	minConsensusReviews = 5
+	impactTolerance     = 0.5
+)
+
+// ReviewerImpact measures how often a reviewer's early reviews predicted an item's eventual consensus.
+// ImpactScore is the share of samples that did, and 0 when there are no samples.
+type ReviewerImpact struct {
+	UserDataID  string  `json:"UserDataID"`
+	Samples     int     `json:"Samples"`
+	Matches     int     `json:"Matches"`
+	ImpactScore float64 `json:"ImpactScore"`
+}
+
+// ActivitySummary describes what happened since a user last acknowledged their activity
+type ActivitySummary struct {
+	Since             int64 `json:"Since"`
+	NewCTIItems       int   `json:"NewCTIItems"`
+	NewReviewsOnItems int   `json:"NewReviewsOnItems"`
+	PointsChange      int   `json:"PointsChange"`
+}
+
+// ReviewParticipation compares the number of distinct reviewers of a CTI item with the number of users
+// whose subscription entitles them to it. Rate is 0 when no user is entitled.
+type ReviewParticipation struct {
+	CTIDataID     string  `json:"CTIDataID"`
+	ReviewCount   int     `json:"ReviewCount"`
+	Reviewers     int     `json:"Reviewers"`
+	EntitledUsers int     `json:"EntitledUsers"`
+	Rate          float64 `json:"Rate"`
+}
+
+// UploaderReviewSummary aggregates the reviews received across all of an uploader's CTI items. The
+// best and worst items are ranked by their mean composite score and are empty until a review arrives.
+type UploaderReviewSummary struct {
+	UploaderID        string             `json:"UploaderID"`
+	ItemCount         int                `json:"ItemCount"`
+	ReviewsReceived   int                `json:"ReviewsReceived"`
+	DimensionAverages map[string]float64 `json:"DimensionAverages"`
+	AverageComposite  float64            `json:"AverageComposite"`
+	BestItemID        string             `json:"BestItemID"`
+	BestItemScore     float64            `json:"BestItemScore"`
+	WorstItemID       string             `json:"WorstItemID"`
+	WorstItemScore    float64            `json:"WorstItemScore"`
+}
+
+// ScoreHelpfulnessCorrelation is the Pearson correlation between the composite scores of an item's
+// reviews and the number of helpful votes they received
+type ScoreHelpfulnessCorrelation struct {
+	CTIDataID   string  `json:"CTIDataID"`
+	ReviewCount int     `json:"ReviewCount"`
+	Correlation float64 `json:"Correlation"`
+}
+
+// Score trend directions
+const (
+	TrendImproving        = "improving"
+	TrendDeclining        = "declining"
+	TrendStable           = "stable"
+	TrendInsufficientData = "insufficient data"
+)
+
+// Minimum number of timestamped reviews needed for a score trend, and the smallest
+// change in average composite score that counts as a movement rather than stable
+const (
+	minTrendReviews      = 4
+	trendStableThreshold = 0.25
+)
+
+// CTIItemInput holds the fields of one CTI item to add in AddCTIItemsBatch. Indicators takes the same
+// JSON array as AddCTIItem's indicatorsJSON argument.
+type CTIItemInput struct {
+	Name        string          `json:"Name"`
+	Timestamp   int64           `json:"Timestamp"`
+	CID         string          `json:"CID"`
+	EncryptKey  string          `json:"encryptKey"`
+	Points      int             `json:"Points"`
+	Level       int             `json:"Level"`
+	Severity    string          `json:"Severity"`
+	Description string          `json:"Description"`
+	Tags        []string        `json:"Tags"`
+	Indicators  json.RawMessage `json:"Indicators"`
+	TLP         string          `json:"TLP"`
+	Techniques  []string        `json:"Techniques"`
+	Confidence  int             `json:"Confidence"`
+}
+
+// AddCTIItem adds a new CTI item to the ledger and returns the ID assigned to it
+func (cc *SmartContract) AddCTIItem(ctx contractapi.TransactionContextInterface, name string, timestamp int64, cid string, encryptKey string, points int, level int, severity string, description string, tags []string, indicatorsJSON string, tlp string, techniques []string, confidence int) (id string, err error) {
+	cc.logOperationStart(ctx, "AddCTIItem")
+	defer func() { cc.logOperationEnd(ctx, "AddCTIItem", err) }()
+
+	input := CTIItemInput{
+		Name:        name,
+		Timestamp:   timestamp,
+		CID:         cid,
+		EncryptKey:  encryptKey,
+		Points:      points,
+		Level:       level,
+		Severity:    severity,
+		Description: description,
+		Tags:        tags,
+		Indicators:  json.RawMessage(indicatorsJSON),
+		TLP:         tlp,
+		Techniques:  techniques,
+		Confidence:  confidence,
+	}
+
+	return addCTIItem(ctx, input, false)
+}
+
+// AddCTIItemPrivate adds a new CTI item like AddCTIItem, but stores its encryption key in the CTI key
+// private data collection instead of the public state. GetCTIItemKey reads it back.
+func (cc *SmartContract) AddCTIItemPrivate(ctx contractapi.TransactionContextInterface, name string, timestamp int64, cid string, encryptKey string, points int, level int, severity string, description string, tags []string, indicatorsJSON string, tlp string, techniques []string, confidence int) (id string, err error) {
+	cc.logOperationStart(ctx, "AddCTIItemPrivate")
+	defer func() { cc.logOperationEnd(ctx, "AddCTIItemPrivate", err) }()
+
+	input := CTIItemInput{
+		Name:        name,
+		Timestamp:   timestamp,
+		CID:         cid,
+		EncryptKey:  encryptKey,
+		Points:      points,
+		Level:       level,
+		Severity:    severity,
+		Description: description,
+		Tags:        tags,
+		Indicators:  json.RawMessage(indicatorsJSON),
+		TLP:         tlp,
+		Techniques:  techniques,
+		Confidence:  confidence,
+	}
+
+	return addCTIItem(ctx, input, true)
+}
+
+// AddCTIItemWithTransientKey adds a new CTI item like AddCTIItemPrivate, but reads the encryption key
+// from the "encryptKey" transient field so it never appears in the transaction proposal or the block
+func (cc *SmartContract) AddCTIItemWithTransientKey(ctx contractapi.TransactionContextInterface, name string, timestamp int64, cid string, points int, level int, severity string, description string, tags []string, indicatorsJSON string, tlp string, techniques []string, confidence int) (id string, err error) {
+	cc.logOperationStart(ctx, "AddCTIItemWithTransientKey")
+	defer func() { cc.logOperationEnd(ctx, "AddCTIItemWithTransientKey", err) }()
+
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return "", fmt.Errorf("failed to read transient data: %v", err)
+	}
+	encryptKey, ok := transientMap["encryptKey"]
+	if !ok || len(encryptKey) == 0 {
+		return "", fmt.Errorf("encryption key must be passed in the encryptKey transient field")
+	}
+
+	input := CTIItemInput{
+		Name:        name,
+		Timestamp:   timestamp,
+		CID:         cid,
+		EncryptKey:  string(encryptKey),
+		Points:      points,
+		Level:       level,
+		Severity:    severity,
+		Description: description,
+		Tags:        tags,
+		Indicators:  json.RawMessage(indicatorsJSON),
+		TLP:         tlp,
+		Techniques:  techniques,
+		Confidence:  confidence,
+	}
+
+	return addCTIItem(ctx, input, true)
+}
+
+// addCTIItem adds a single CTI item, identified by the transaction that uploads it, and emits its
+// added event
+func addCTIItem(ctx contractapi.TransactionContextInterface, input CTIItemInput, privateKey bool) (string, error) {
+	ctiItems, err := addCTIItems(ctx, []CTIItemInput{input}, []string{ctx.GetStub().GetTxID()}, privateKey)
+	if err != nil {
+		return "", err
+	}
+	ctiItem := ctiItems[0]
+
+	eventJSON, err := json.Marshal(newCTIItemEvent(ctiItem))
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal CTI item added event: %v", err)
+	}
+	if err := ctx.GetStub().SetEvent("CTIItemAdded", eventJSON); err != nil {
+		return "", fmt.Errorf("failed to set CTI item added event: %v", err)
+	}
+
+	return ctiItem.ID, nil
+}
+
+// AddCTIItemsBatch adds every CTI item in a JSON array of CTIItemInput in one transaction and returns
+// their IDs in input order. Rather than taking a contiguous block from a shared counter, which every
+// concurrent upload would conflict on, the items are numbered within the transaction as "<txID>-<nnn>";
+// the zero-padded n keeps a batch's IDs contiguous and in input order on the ledger. If any item fails
+// validation, or the batch would exceed the organisation's quota or the uploader's balance for stakes,
+// nothing is written.
+func (cc *SmartContract) AddCTIItemsBatch(ctx contractapi.TransactionContextInterface, itemsJSON string) (ids []string, err error) {
+	cc.logOperationStart(ctx, "AddCTIItemsBatch")
+	defer func() { cc.logOperationEnd(ctx, "AddCTIItemsBatch", err) }()
+
+	var inputs []CTIItemInput
+	if err := json.Unmarshal([]byte(itemsJSON), &inputs); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal CTI items: %v", err)
+	}
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("batch must contain at least one CTI item")
+	}
+	if len(inputs) > maxBatchCTIItems {
+		return nil, fmt.Errorf("batch of %d CTI items exceeds the limit of %d", len(inputs), maxBatchCTIItems)
+	}
+
+	txID := ctx.GetStub().GetTxID()
+	ids = make([]string, len(inputs))
+	for i := range inputs {
+		ids[i] = fmt.Sprintf("%s-%03d", txID, i)
+	}
+
+	if _, err := addCTIItems(ctx, inputs, ids, false); err != nil {
+		return nil, err
+	}
+
+	eventJSON, err := json.Marshal(CTIItemsAddedEvent{IDs: ids})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal CTI items added event: %v", err)
+	}
+	if err := ctx.GetStub().SetEvent("CTIItemsAdded", eventJSON); err != nil {
+		return nil, fmt.Errorf("failed to set CTI items added event: %v", err)
+	}
+
+	return ids, nil
+}
+
+// addCTIItems validates and writes new CTI items for the caller under the given IDs. Every item is
+// validated before anything is written, and the uploader's and organisation's counts are adjusted once
+// for the whole set, since reads in a transaction do not see its own writes. With privateKeys set the
+// encryption keys go to the CTI key collection rather than the public state.
+func addCTIItems(ctx contractapi.TransactionContextInterface, inputs []CTIItemInput, ids []string, privateKeys bool) ([]*CTIData, error) {
+	// Get the current peer ID
+	uploader, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get uploader ID: %v", err)
+	}
+	uploaderMSP, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get uploader MSP ID: %v", err)
+	}
+
+	// Record when the items were created, independently of the uploader-supplied timestamps
+	createdAt, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctiItems := make([]*CTIData, len(inputs))
+	for i, input := range inputs {
+		ctiItem, err := newCTIItem(input)
+		if err != nil {
+			if len(inputs) > 1 {
+				return nil, fmt.Errorf("CTI item %d: %v", i, err)
+			}
+			return nil, err
+		}
+		ctiItem.ID = ids[i]
+		ctiItem.Uploader = uploader
+		ctiItem.UploaderMSP = uploaderMSP
+		ctiItem.CreatedAt = createdAt
+		ctiItems[i] = ctiItem
+	}
+
+	// Enforce the uploader organisation's quota
+	if err := checkOrgQuota(ctx, uploaderMSP, len(ctiItems)); err != nil {
+		return nil, err
+	}
+
+	// Lock the configured stake from the uploader's balance and count the uploads
+	stake, err := readCounter(ctx, uploadStakeKey, 0)
+	if err != nil {
+		return nil, err
+	}
+	uploaderData, err := readUserData(ctx, uploader)
+	if err != nil {
+		return nil, err
+	}
+	if stake > 0 {
+		if uploaderData == nil {
+			return nil, fmt.Errorf("uploader %s has no user data to stake %d from", uploader, stake)
+		}
+		if err := adjustUserBalances(uploaderData, 0, -stake*len(ctiItems)); err != nil {
+			return nil, err
+		}
+	}
+	if uploaderData != nil {
+		uploaderData.UploadCount += len(ctiItems)
+		if err := putUserData(ctx, uploaderData); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, ctiItem := range ctiItems {
+		ctiItem.StakeEscrow = stake
+
+		// Move a private encryption key into the collection, leaving only the metadata public
+		if privateKeys {
+			if err := ctx.GetStub().PutPrivateData(ctiKeyCollection, ctiItem.ID, []byte(ctiItem.EncryptKey)); err != nil {
+				return nil, fmt.Errorf("failed to put encryption key in private data collection: %v", err)
+			}
+			ctiItem.EncryptKey = ""
+			ctiItem.PrivateKey = true
+		}
+
+		// Put the CTIData on the ledger
+		if err := putCTIItem(ctx, ctiItem); err != nil {
+			return nil, err
+		}
+
+		// Index the new CTI item
+		if err := putCTIIndexes(ctx, ctiItem); err != nil {
+			return nil, err
+		}
+
+		if err := appendChangeLog(ctx, ctiItem.ID, "created", fmt.Sprintf("uploaded at level %d", ctiItem.Level)); err != nil {
+			return nil, err
+		}
+	}
+
+	return ctiItems, nil
+}
+
+// newCTIItem validates and normalises the content of a new CTI item. The caller fills in its ID and
+// uploader.
+func newCTIItem(input CTIItemInput) (*CTIData, error) {
+	// Validate the content locator and the item's descriptive metadata
+	if err := validateCID(input.CID); err != nil {
+		return nil, err
+	}
+	tlp, err := normalizeTLP(input.TLP)
+	if err != nil {
+		return nil, err
+	}
+	techniques, err := validateTechniques(input.Techniques)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateConfidence(input.Confidence); err != nil {
+		return nil, err
+	}
+	severity := strings.ToLower(strings.TrimSpace(input.Severity))
+	tags, err := validateTags(input.Tags)
+	if err != nil {
+		return nil, err
+	}
+	indicators, err := parseIndicators(string(input.Indicators))
+	if err != nil {
+		return nil, err
+	}
+	if err := checkSeverityRequirements(severity, input.Description, tags, indicators); err != nil {
+		return nil, err
+	}
+
+	return &CTIData{
+		Name:          input.Name,
+		Timestamp:     input.Timestamp,
+		CID:           input.CID,
+		EncryptKey:    input.EncryptKey,
+		Points:        input.Points,
+		Level:         input.Level,
+		Severity:      severity,
+		TLP:           tlp,
+		Techniques:    techniques,
+		Confidence:    input.Confidence,
+		Description:   input.Description,
+		Tags:          tags,
+		Indicators:    indicators,
+		SchemaVersion: SchemaVersion,
+	}, nil
+}
+
+// UpdateCTIItem replaces the content of a CTI item: its name, timestamp, CID, encryption key, level, TLP
+// marking, techniques and confidence. Only the uploader or an admin may update an item. The points the
+// item earned from reviews are kept.
+func (cc *SmartContract) UpdateCTIItem(ctx contractapi.TransactionContextInterface, id string, name string, timestamp int64, cid string, encryptKey string, level int, tlp string, techniques []string, confidence int) error {
+	if err := validateCID(cid); err != nil {
+		return err
+	}
+	tlp, err := normalizeTLP(tlp)
+	if err != nil {
+		return err
+	}
+	techniques, err = validateTechniques(techniques)
+	if err != nil {
+		return err
+	}
+	if err := validateConfidence(confidence); err != nil {
+		return err
+	}
+
+	// Get the current peer ID
+	uploader, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get uploader ID: %v", err)
+	}
+
+	// Check if the CTI item exists
+	existingItem, err := readCTIItem(ctx, id)
+	if err != nil {
+		return err
+	}
+	if existingItem == nil {
+		return fmt.Errorf("CTI item with ID %s does not exist", id)
+	}
+
+	if existingItem.Deleted {
+		return fmt.Errorf("CTI item %s has been deleted", id)
+	}
+
+	// Only the uploader or an admin may update an item
+	admin, err := isAdmin(ctx)
+	if err != nil {
+		return err
+	}
+	if !admin && existingItem.Uploader != uploader {
+		return fmt.Errorf("caller is not authorized: only the uploader or an admin can update CTI item %s", id)
+	}
+
+	// Update the CTI item, leaving it owned by its uploader even when an admin updates it
+	ctiItem := CTIData{
+		ID:            id,
+		Name:          name,
+		Uploader:      existingItem.Uploader,
+		Timestamp:     timestamp,
+		CID:           cid,
+		EncryptKey:    encryptKey,
+		Points:        existingItem.Points,
+		Level:         level,
+		TLP:           tlp,
+		Techniques:    techniques,
+		Confidence:    confidence,
+		SchemaVersion: SchemaVersion,
+	}
+
+	// Descriptive metadata, tags, region, review aggregates, the stake and the expiry are managed separately
+	// from the item's content, and the item keeps its creation time and stays counted against its original
+	// organisation
+	ctiItem.Tags = existingItem.Tags
+	ctiItem.Severity = existingItem.Severity
+	ctiItem.Description = existingItem.Description
+	ctiItem.Indicators = existingItem.Indicators
+	ctiItem.Region = existingItem.Region
+	ctiItem.CreatedAt = existingItem.CreatedAt
+	ctiItem.LastReviewedAt = existingItem.LastReviewedAt
+	ctiItem.ExpiresAt = existingItem.ExpiresAt
+	ctiItem.Archived = existingItem.Archived
+	ctiItem.ArchivedAt = existingItem.ArchivedAt
+	ctiItem.StakeEscrow = existingItem.StakeEscrow
+	ctiItem.ReviewCount = existingItem.ReviewCount
+	ctiItem.CompositeScore = existingItem.CompositeScore
+	ctiItem.UploaderMSP = existingItem.UploaderMSP
+
+	// Record who made the edit and when
+	ctiItem.UpdatedAt, err = txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	ctiItem.LastModifiedBy = uploader
+
+	// Availability checks and the content hash only remain valid while the CID is unchanged
+	if existingItem.CID == cid {
+		ctiItem.CIDAvailable = existingItem.CIDAvailable
+		ctiItem.LastCheckedAt = existingItem.LastCheckedAt
+		ctiItem.ContentHash = existingItem.ContentHash
+		ctiItem.TamperSuspected = existingItem.TamperSuspected
+	}
+
+	// An item whose key is private keeps its new key in the collection too
+	previousKey := existingItem.EncryptKey
+	if existingItem.PrivateKey {
+		storedKey, err := ctx.GetStub().GetPrivateData(ctiKeyCollection, id)
+		if err != nil {
+			return fmt.Errorf("failed to read encryption key from private data collection: %v", err)
+		}
+		previousKey = string(storedKey)
+		if err := ctx.GetStub().PutPrivateData(ctiKeyCollection, id, []byte(encryptKey)); err != nil {
+			return fmt.Errorf("failed to put encryption key in private data collection: %v", err)
+		}
+		ctiItem.EncryptKey = ""
+		ctiItem.PrivateKey = true
+	}
+
+	// Reviews vetted the old content, so a changed content hash, CID or key calls for the item to be
+	// reviewed again
+	ctiItem.NeedsReReview = existingItem.NeedsReReview
+	contentChanged := ctiItem.ContentHash != existingItem.ContentHash || ctiItem.CID != existingItem.CID || encryptKey != previousKey
+	if existingItem.ReviewCount > 0 && contentChanged {
+		ctiItem.NeedsReReview = true
+	}
+
+	// Convert CTI data to JSON
+	ctiItemJSON, err := json.Marshal(ctiItem)
+	if err != nil {
+		return fmt.Errorf("failed to marshal CTI item to JSON: %v", err)
+	}
+
+	// Put the updated CTI item on the ledger
+	if err := ctx.GetStub().PutState(ctiKey(id), ctiItemJSON); err != nil {
+		return fmt.Errorf("failed to put updated CTI item on ledger: %v", err)
+	}
+
+	// Re-index the CTI item under its updated values
+	if err := deleteCTIIndexes(ctx, existingItem); err != nil {
+		return err
+	}
+	if err := putCTIIndexes(ctx, &ctiItem); err != nil {
+		return err
+	}
+
+	if err := appendChangeLog(ctx, id, "updated", describeCTIItemChanges(existingItem, &ctiItem)); err != nil {
+		return err
+	}
+
+	// Let the item's followers know it changed
+	followers, err := ctiItemFollowers(ctx, id)
+	if err != nil {
+		return err
+	}
+	eventJSON, err := json.Marshal(CTIItemUpdatedEvent{CTIItemEvent: newCTIItemEvent(&ctiItem), UpdatedBy: uploader, Followers: followers})
+	if err != nil {
+		return fmt.Errorf("failed to marshal CTI item update event: %v", err)
+	}
+	if err := ctx.GetStub().SetEvent("CTIItemUpdated", eventJSON); err != nil {
+		return fmt.Errorf("failed to set CTI item update event: %v", err)
+	}
+
+	return nil
+}
+
+// GetCTIItem retrieves a CTI item from the ledger by its ID. If the caller is not entitled to the item
+// only its metadata is returned, without the CID and encryption key.
+func (cc *SmartContract) GetCTIItem(ctx contractapi.TransactionContextInterface, id string) (*CTIData, error) {
+	return cc.GetCTIItemForCaller(ctx, id)
+}
+
+// ConditionalCTIItem is the result of a conditional CTI item fetch. When NotModified is set the
+// caller's copy is current and Item is omitted.
+type ConditionalCTIItem struct {
+	NotModified bool     `json:"NotModified"`
+	ETag        string   `json:"ETag"`
+	Item        *CTIData `json:"Item,omitempty"`
+}
+
+// GetCTIItemConditional retrieves a CTI item unless the caller already holds its current version.
+// The ETag is the canonical hash of the item as returned to the caller, redacted if the caller is not
+// entitled to it, so it changes whenever what the caller would see changes, including when the caller
+// gains or loses access.
+func (cc *SmartContract) GetCTIItemConditional(ctx contractapi.TransactionContextInterface, id string, knownETag string) (*ConditionalCTIItem, error) {
+	ctiItem, err := readCTIItem(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if ctiItem == nil {
+		return nil, fmt.Errorf("CTI item with ID %s does not exist", id)
+	}
+
+	entitled, err := canAccess(ctx, ctiItem)
+	if err != nil {
+		return nil, err
+	}
+	if !entitled {
+		ctiItem = redactCTIItem(ctiItem)
+	}
+
+	etag, err := canonicalHash(ctiItem)
+	if err != nil {
+		return nil, err
+	}
+	result := &ConditionalCTIItem{ETag: etag}
+	if knownETag != "" && strings.EqualFold(knownETag, etag) {
+		result.NotModified = true
+		return result, nil
+	}
+	result.Item = ctiItem
+
+	return result, nil
+}
+
+// GetCTIItemsPage returns one page of CTI items in key order. Pass an empty bookmark for the first
+// page and the returned bookmark for each following one; an empty bookmark marks the last page.
+// Soft-deleted items are skipped, so a page may hold fewer than pageSize items.
+func (cc *SmartContract) GetCTIItemsPage(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*CTIItemsPage, error) {
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("page size must be positive, got %d", pageSize)
+	}
+
+	iterator, metadata, err := ctx.GetStub().GetStateByRangeWithPagination(ctiRangeStart, ctiRangeEnd, pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get CTI data range: %v", err)
+	}
+	defer iterator.Close()
+
+	page := &CTIItemsPage{Items: []*CTIData{}}
+	for iterator.HasNext() {
+		item, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate over CTI data range: %v", err)
+		}
+
+		var ctiItem CTIData
+		if err := json.Unmarshal(item.Value, &ctiItem); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal CTI data: %v", err)
+		}
+		if ctiItem.Deleted {
+			continue
+		}
+		page.Items = append(page.Items, &ctiItem)
+	}
+	page.Items, err = redactForCaller(ctx, page.Items)
+	if err != nil {
+		return nil, err
+	}
+
+	if metadata != nil {
+		page.FetchedCount = metadata.FetchedRecordsCount
+		if metadata.FetchedRecordsCount == pageSize {
+			page.Bookmark = metadata.Bookmark
+		}
+	}
+
+	return page, nil
+}
+
+// GetAllCTIItems retrieves all CTI data entries from the ledger, leaving out soft-deleted ones. Items
+// the caller is not entitled to are returned without their CID and encryption key.
+func (cc *SmartContract) GetAllCTIItems(ctx contractapi.TransactionContextInterface) ([]*CTIData, error) {
+	ctiItems, err := cc.ctiData(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+	return redactForCaller(ctx, ctiItems)
+}
+
+// GetAllCTIItemsIncludingDeleted retrieves every CTI data entry from the ledger, including soft-deleted
+// ones, redacted like GetAllCTIItems
+func (cc *SmartContract) GetAllCTIItemsIncludingDeleted(ctx contractapi.TransactionContextInterface) ([]*CTIData, error) {
+	ctiItems, err := cc.ctiData(ctx, true)
+	if err != nil {
+		return nil, err
+	}
+	return redactForCaller(ctx, ctiItems)
+}
+
+// ctiData reads the CTI data entries from the ledger, optionally including soft-deleted ones
+func (cc *SmartContract) ctiData(ctx contractapi.TransactionContextInterface, includeDeleted bool) ([]*CTIData, error) {
+	resultsIterator, err := ctx.GetStub().GetStateByRange(ctiRangeStart, ctiRangeEnd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get CTI data range: %v", err)
+	}
+	defer resultsIterator.Close()
+
+	var ctiItems []*CTIData
+	for resultsIterator.HasNext() {
+		item, err := resultsIterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate over CTI data range: %v", err)
+		}
+
+		var ctiItem CTIData
+		if err := json.Unmarshal(item.Value, &ctiItem); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal CTI data: %v", err)
+		}
+		if ctiItem.Deleted && !includeDeleted {
+			continue
+		}
+		ctiItems = append(ctiItems, &ctiItem)
+	}
+
+	return ctiItems, nil
+}
+
+// AddUserData registers the caller with empty user statistics. Balance, subscription, points and upload
+// count are only changed by the contract's own flows afterwards.
+func (cc *SmartContract) AddUserData(ctx contractapi.TransactionContextInterface) error {
+	user, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+
+	existing, err := readUserData(ctx, user)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		return fmt.Errorf("User data for user %s already exists", user)
+	}
+
+	return putUserData(ctx, newUserData(user))
+}
+
+// GetUserData retrieves user statistics data from the ledger by user ID
+func (cc *SmartContract) GetUserDataOld(ctx contractapi.TransactionContextInterface, user string) (*UserData, error) {
+	userDataJSON, err := ctx.GetStub().GetState(fmt.Sprintf("UserData_%s", user))
+	if err != nil {
+		return nil, err
+	}
+	if userDataJSON == nil {
+		return nil, fmt.Errorf("User data for user %s does not exist", user)
+	}
+
+	var userData UserData
+	err = json.Unmarshal(userDataJSON, &userData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &userData, nil
+}
+
+/*
+// GetUserData retrieves user statistics data from the ledger by peer ID
+func (cc *SmartContract) GetUserData(ctx contractapi.TransactionContextInterface) (*UserData, error) {
+	// Retrieve the current peer ID
+	peerID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current peer ID: %v", err)
+	}
+
+	userDataJSON, err := ctx.GetStub().GetState(fmt.Sprintf("UserData_%s", peerID))
+	if err != nil {
+		return nil, err
+	}
+	if userDataJSON == nil {
+		return nil, fmt.Errorf("User data for peer %s does not exist", peerID)
+	}
+
+	var userData UserData
+	err = json.Unmarshal(userDataJSON, &userData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &userData, nil
+}
+*/
+
+// GetUserData retrieves the caller's user statistics data from the ledger. It never writes; callers
+// without a record get a not-found error and can register with EnsureUserData or AddUserData.
+func (cc *SmartContract) GetUserData(ctx contractapi.TransactionContextInterface) (*UserData, error) {
+	// Retrieve the current peer ID
+	peerID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current peer ID: %v", err)
+	}
+
+	return cc.GetUserDataByID(ctx, peerID)
+}
+
+// GetUserDataByID retrieves a user's statistics data from the ledger, returning a not-found error
+// without writing if the user has no record
+func (cc *SmartContract) GetUserDataByID(ctx contractapi.TransactionContextInterface, id string) (*UserData, error) {
+	userData, err := readUserData(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if userData == nil {
+		return nil, fmt.Errorf("User data for user %s does not exist", id)
+	}
+
+	return userData, nil
+}
+
+// EnsureUserData returns the caller's user statistics data, creating and storing an empty record first
+// if they have none
+func (cc *SmartContract) EnsureUserData(ctx contractapi.TransactionContextInterface) (*UserData, error) {
+	peerID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current peer ID: %v", err)
+	}
+
+	userData, err := readUserData(ctx, peerID)
+	if err != nil {
+		return nil, err
+	}
+	if userData == nil {
+		userData = newUserData(peerID)
+		if err := putUserData(ctx, userData); err != nil {
+			return nil, err
+		}
+	}
+
+	return userData, nil
+}
+
+// AddReviewDataByCTIDataID adds review data for a specific CTI data ID
+func (cc *SmartContract) AddReviewData(ctx contractapi.TransactionContextInterface, ctiDataID string, accuracy, timeliness, completeness, consistency int, reviewText string) error {
+	return cc.addReview(ctx, ctiDataID, legacyScores(accuracy, timeliness, completeness, consistency), reviewText, false)
+}
+
+// AddScoredReviewData adds review data for a specific CTI data ID with a score for each configured
+// review dimension
+func (cc *SmartContract) AddScoredReviewData(ctx contractapi.TransactionContextInterface, ctiDataID string, scores map[string]int, reviewText string) error {
+	return cc.addReview(ctx, ctiDataID, scores, reviewText, false)
+}
+
+// AddPrivateReviewData adds review data for a specific CTI data ID, keeping the review text out of the
+// public state. The text is read from the "reviewText" transient field so it never appears in the
+// transaction proposal, and is stored in the review text private data collection.
+func (cc *SmartContract) AddPrivateReviewData(ctx contractapi.TransactionContextInterface, ctiDataID string, accuracy, timeliness, completeness, consistency int) error {
+	transientMap, err := ctx.GetStub().GetTransient()
+	if err != nil {
+		return fmt.Errorf("failed to read transient data: %v", err)
+	}
+	reviewText, ok := transientMap["reviewText"]
+	if !ok {
+		return fmt.Errorf("review text must be passed in the reviewText transient field")
+	}
+
+	return cc.addReview(ctx, ctiDataID, legacyScores(accuracy, timeliness, completeness, consistency), string(reviewText), true)
+}
+
+// addReview stores a new review, placing its text in the review text collection when privateText is set
+func (cc *SmartContract) addReview(ctx contractapi.TransactionContextInterface, ctiDataID string, scores map[string]int, reviewText string, privateText bool) (err error) {
+	cc.logOperationStart(ctx, "AddReviewData")
+	defer func() { cc.logOperationEnd(ctx, "AddReviewData", err) }()
+
+	// Retrieve the current peer ID
+	peerID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get current peer ID: %v", err)
+	}
+	reviewerMSP, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return fmt.Errorf("failed to get reviewer MSP ID: %v", err)
+	}
+
+	// Check if the CTI item exists
+	ctiItem, err := readCTIItem(ctx, ctiDataID)
+	if err != nil {
+		return err
+	}
+	if ctiItem == nil {
+		return fmt.Errorf("CTI item with ID %s does not exist", ctiDataID)
+	}
+
+	if ctiItem.Deleted {
+		return fmt.Errorf("CTI item %s has been deleted", ctiDataID)
+	}
+
+	// Uploaders may not review their own CTI items unless the contract allows it
+	selfReview := ctiItem.Uploader == peerID
+	if selfReview && !cc.AllowSelfReview {
+		return fmt.Errorf("reviewer %s uploaded CTI item %s and cannot review it", peerID, ctiDataID)
+	}
+
+	// Scores must cover exactly the configured review dimensions
+	if err := validateReviewScores(ctx, scores); err != nil {
+		return err
+	}
+
+	// Record when the review was submitted
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Each reviewer may review an item only once; UpsertReviewData revises an existing review
+	reviewed, err := hasReviewed(ctx, peerID, ctiDataID)
+	if err != nil {
+		return err
+	}
+	if reviewed {
+		return fmt.Errorf("reviewer %s has already reviewed CTI item %s", peerID, ctiDataID)
+	}
+
+	// Generate a unique ID for the review data
+	reviewID, err := generateUniqueID(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to generate review ID: %v", err)
+	}
+
+	// Create the review data instance
+	review := ReviewData{
+		ID:            reviewID,
+		UserDataID:    peerID,
+		ReviewerMSP:   reviewerMSP,
+		CTIDataID:     ctiDataID,
+		Accuracy:      scores["Accuracy"],
+		Timeliness:    scores["Timeliness"],
+		Completeness:  scores["Completeness"],
+		Consistency:   scores["Consistency"],
+		Scores:        scores,
+		ReviewText:    reviewText,
+		Timestamp:     timestamp,
+		SchemaVersion: SchemaVersion,
+	}
+
+	// Move private review text into the collection, leaving only the scores public
+	if privateText {
+		if err := ctx.GetStub().PutPrivateData(reviewTextCollection, reviewID, []byte(reviewText)); err != nil {
+			return fmt.Errorf("failed to put review text in private data collection: %v", err)
+		}
+		review.ReviewText = ""
+		review.PrivateText = true
+	}
+
+	// Record a hash of the review's content so later tampering can be detected
+	review.ContentHash, err = reviewContentHash(&review)
+	if err != nil {
+		return err
+	}
+
+	// The reviewer is rewarded for the review below, and the uploader too if the review is positive.
+	// Self-reviews earn neither reward. A reward beyond the reviewer's mint cap is left owed.
+	reward, err := readCounter(ctx, reviewerRewardKey, defaultReviewerReward)
+	if err != nil {
+		return err
+	}
+	review.RewardPaid = true
+	var reviewerData *UserData
+	if selfReview {
+		reward = 0
+	} else {
+		reviewerData, err = readUserData(ctx, peerID)
+		if err != nil {
+			return err
+		}
+		if reviewerData == nil {
+			reviewerData = newUserData(peerID)
+		}
+		review.RewardPaid, err = mintReviewerReward(ctx, reviewerData, reward, timestamp)
+		if err != nil {
+			return err
+		}
+		if !review.RewardPaid {
+			reward = 0
+		}
+	}
+	review.RewardAmount = reward
+	review.UploaderRewarded = !selfReview && compositeScore(&review) > uploaderRewardScore
+
+	// Convert review data to JSON
+	reviewJSON, err := json.Marshal(review)
+	if err != nil {
+		return fmt.Errorf("failed to marshal review data to JSON: %v", err)
+	}
+
+	// Put the review data on the ledger
+	if err := ctx.GetStub().PutState(reviewKey(reviewID), reviewJSON); err != nil {
+		return fmt.Errorf("failed to put review data on ledger: %v", err)
+	}
+
+	// Index the review by reviewer and CTI item
+	if err := putReviewIndex(ctx, &review); err != nil {
+		return err
+	}
+
+	// Fold the review into the item's cached review aggregates
+	totalItemScore := ctiItem.CompositeScore*float64(ctiItem.ReviewCount) + compositeScore(&review)
+	ctiItem.ReviewCount++
+	ctiItem.CompositeScore = totalItemScore / float64(ctiItem.ReviewCount)
+	ctiItem.NeedsReReview = false
+	ctiItem.LastReviewedAt = timestamp
+
+	// Release the uploader's stake once the item is well reviewed
+	releasedStake := 0
+	if ctiItem.StakeEscrow > 0 && ctiItem.ReviewCount >= stakeReturnReviews && ctiItem.CompositeScore >= stakeReturnScore {
+		releasedStake = ctiItem.StakeEscrow
+		ctiItem.StakeEscrow = 0
+	}
+
+	// Fold the review into the uploader's cached reputation
+	uploaderData, err := readUserData(ctx, ctiItem.Uploader)
+	if err != nil {
+		return err
+	}
+	if uploaderData == nil {
+		uploaderData = newUserData(ctiItem.Uploader)
+	}
+	if review.UploaderRewarded {
+		ctiItem.Points += UploaderReward
+		uploaderData.Points += UploaderReward
+	}
+	if err := putCTIItem(ctx, ctiItem); err != nil {
+		return err
+	}
+	totalScore := uploaderData.Reputation*float64(uploaderData.ReviewsReceived) + compositeScore(&review)
+	uploaderData.ReviewsReceived++
+	uploaderData.Reputation = totalScore / float64(uploaderData.ReviewsReceived)
+	if err := adjustUserBalances(uploaderData, 0, releasedStake); err != nil {
+		return err
+	}
+
+	// Count the review and reward the reviewer. A self-reviewer's record is the uploader's, updated in
+	// place since reads do not see this transaction's own writes.
+	if selfReview {
+		reviewerData = uploaderData
+	}
+	reviewerData.ReviewsAuthored++
+	if err := adjustUserBalances(reviewerData, reward, 0); err != nil {
+		return err
+	}
+	reviewerData.ReviewPoints += reward
+	if err := putUserData(ctx, uploaderData); err != nil {
+		return err
+	}
+	if !selfReview {
+		if err := putUserData(ctx, reviewerData); err != nil {
+			return err
+		}
+	}
+
+	// Let the uploader know their item was reviewed and whether their stake came back
+	notifications := []*Notification{{Recipient: ctiItem.Uploader, Type: "review-received", RefID: ctiDataID, Message: fmt.Sprintf("CTI item %s received a review from %s", ctiDataID, peerID)}}
+	if releasedStake > 0 {
+		notifications = append(notifications, &Notification{Recipient: ctiItem.Uploader, Type: "stake-returned", RefID: ctiDataID, Message: fmt.Sprintf("stake of %d on CTI item %s was returned", releasedStake, ctiDataID)})
+	}
+	if err := notifyUsers(ctx, notifications); err != nil {
+		return err
+	}
+
+	eventJSON, err := json.Marshal(ReviewAddedEvent{ID: reviewID, CTIDataID: ctiDataID, Reviewer: peerID, Reward: reward})
+	if err != nil {
+		return fmt.Errorf("failed to marshal review event: %v", err)
+	}
+	if err := ctx.GetStub().SetEvent("ReviewAdded", eventJSON); err != nil {
+		return fmt.Errorf("failed to set review event: %v", err)
+	}
+
+	return nil
+}
+
+// putReviewIndex indexes a review by its reviewer and reviewed CTI item
+func putReviewIndex(ctx contractapi.TransactionContextInterface, review *ReviewData) error {
+	key, err := ctx.GetStub().CreateCompositeKey(reviewerIndex, []string{review.UserDataID, review.CTIDataID, review.ID})
+	if err != nil {
+		return fmt.Errorf("failed to create %s index key: %v", reviewerIndex, err)
+	}
+	if err := ctx.GetStub().PutState(key, []byte{0x00}); err != nil {
+		return fmt.Errorf("failed to put review index entry: %v", err)
+	}
+	return nil
+}
+
+// deleteReviewIndex removes a review's reviewer index entry
+func deleteReviewIndex(ctx contractapi.TransactionContextInterface, review *ReviewData) error {
+	key, err := ctx.GetStub().CreateCompositeKey(reviewerIndex, []string{review.UserDataID, review.CTIDataID, review.ID})
+	if err != nil {
+		return fmt.Errorf("failed to create %s index key: %v", reviewerIndex, err)
+	}
+	if err := ctx.GetStub().DelState(key); err != nil {
+		return fmt.Errorf("failed to delete review index entry: %v", err)
+	}
+	return nil
+}
+
+// hasReviewed reports whether a reviewer has already reviewed a CTI item
+func hasReviewed(ctx contractapi.TransactionContextInterface, reviewer string, ctiDataID string) (bool, error) {
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(reviewerIndex, []string{reviewer, ctiDataID})
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s index: %v", reviewerIndex, err)
+	}
+	defer iterator.Close()
+
+	return iterator.HasNext(), nil
+}
+
+// reviewByReviewerAndCTI returns a reviewer's review of a CTI item, or nil if they have not reviewed it
+func reviewByReviewerAndCTI(ctx contractapi.TransactionContextInterface, reviewer string, ctiDataID string) (*ReviewData, error) {
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(reviewerIndex, []string{reviewer, ctiDataID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s index: %v", reviewerIndex, err)
+	}
+	defer iterator.Close()
+
+	if !iterator.HasNext() {
+		return nil, nil
+	}
+	entry, err := iterator.Next()
+	if err != nil {
+		return nil, fmt.Errorf("failed to iterate over %s index: %v", reviewerIndex, err)
+	}
+	_, attributes, err := ctx.GetStub().SplitCompositeKey(entry.Key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to split %s index key: %v", reviewerIndex, err)
+	}
+	if len(attributes) != 3 {
+		return nil, fmt.Errorf("malformed %s index key %s", reviewerIndex, entry.Key)
+	}
+
+	return readReview(ctx, attributes[2])
+}
+
+// GetReviewByReviewerAndCTI returns a reviewer's review of a CTI item
+func (cc *SmartContract) GetReviewByReviewerAndCTI(ctx contractapi.TransactionContextInterface, reviewer string, ctiDataID string) (*ReviewData, error) {
+	review, err := reviewByReviewerAndCTI(ctx, reviewer, ctiDataID)
+	if err != nil {
+		return nil, err
+	}
+	if review == nil {
+		return nil, fmt.Errorf("reviewer %s has not reviewed CTI item %s", reviewer, ctiDataID)
+	}
+	return review, nil
+}
+
+// UpsertReviewData adds a review of a CTI item, or revises the caller's existing review of it in place,
+// scoring the four original review dimensions. See UpsertScoredReviewData.
+func (cc *SmartContract) UpsertReviewData(ctx contractapi.TransactionContextInterface, ctiDataID string, accuracy, timeliness, completeness, consistency int, reviewText string) error {
+	return cc.UpsertScoredReviewData(ctx, ctiDataID, legacyScores(accuracy, timeliness, completeness, consistency), reviewText)
+}
+
+// UpsertScoredReviewData adds a review of a CTI item with a score for each configured review dimension,
+// or revises the caller's existing review of it in place. A revised review keeps its ID and reward; its
+// scores, text and timestamp are replaced and the item's review aggregates and its uploader's
+// reputation are recomputed.
+func (cc *SmartContract) UpsertScoredReviewData(ctx contractapi.TransactionContextInterface, ctiDataID string, scores map[string]int, reviewText string) error {
+	peerID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get current peer ID: %v", err)
+	}
+
+	review, err := reviewByReviewerAndCTI(ctx, peerID, ctiDataID)
+	if err != nil {
+		return err
+	}
+	if review == nil {
+		return cc.AddScoredReviewData(ctx, ctiDataID, scores, reviewText)
+	}
+
+	ctiItem, err := readCTIItem(ctx, ctiDataID)
+	if err != nil {
+		return err
+	}
+	if ctiItem == nil {
+		return fmt.Errorf("CTI item with ID %s does not exist", ctiDataID)
+	}
+	if ctiItem.Deleted {
+		return fmt.Errorf("CTI item %s has been deleted", ctiDataID)
+	}
+
+	if err := validateReviewScores(ctx, scores); err != nil {
+		return err
+	}
+
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	review.Accuracy = scores["Accuracy"]
+	review.Timeliness = scores["Timeliness"]
+	review.Completeness = scores["Completeness"]
+	review.Consistency = scores["Consistency"]
+	review.Scores = scores
+	review.Timestamp = timestamp
+	review.RewardAmount = paidReviewerReward(review)
+	review.SchemaVersion = SchemaVersion
+
+	// A review with private text keeps its new text in the collection too
+	if review.PrivateText {
+		if err := ctx.GetStub().PutPrivateData(reviewTextCollection, review.ID, []byte(reviewText)); err != nil {
+			return fmt.Errorf("failed to put review text in private data collection: %v", err)
+		}
+		review.ReviewText = ""
+	} else {
+		review.ReviewText = reviewText
+	}
+
+	review.ContentHash, err = reviewContentHash(review)
+	if err != nil {
+		return err
+	}
+
+	// A revised review that becomes positive rewards the uploader, but never more than once and never
+	// for a self-review
+	rewardsUploader := !review.UploaderRewarded && review.UserDataID != ctiItem.Uploader && compositeScore(review) > uploaderRewardScore
+	if rewardsUploader {
+		review.UploaderRewarded = true
+		ctiItem.Points += UploaderReward
+	}
+
+	// Range reads do not see this transaction's writes, so read the other reviews first
+	allReviewData, err := cc.reviewData(ctx, true)
+	if err != nil {
+		return fmt.Errorf("failed to get all review data entries: %v", err)
+	}
+	for i, other := range allReviewData {
+		if other.ID == review.ID {
+			allReviewData[i] = review
+		}
+	}
+
+	if err := putReview(ctx, review); err != nil {
+		return err
+	}
+
+	recomputeCTIReviewAggregates(ctiItem, allReviewData)
+	ctiItem.NeedsReReview = false
+	ctiItem.LastReviewedAt = timestamp
+	if err := putCTIItem(ctx, ctiItem); err != nil {
+		return err
+	}
+
+	uploaderData, err := readUserData(ctx, ctiItem.Uploader)
+	if err != nil {
+		return err
+	}
+	if uploaderData == nil {
+		uploaderData = newUserData(ctiItem.Uploader)
+	}
+	if rewardsUploader {
+		uploaderData.Points += UploaderReward
+	}
+	return recomputeReputation(ctx, uploaderData, allReviewData, nil)
+}
+
+// SetReviewerReward sets the number of points credited to a reviewer for each new review. A reward of
+// 0 disables reviewer rewards. Rewards already paid are unaffected.
+func (cc *SmartContract) SetReviewerReward(ctx contractapi.TransactionContextInterface, reward int) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+	if reward < 0 {
+		return fmt.Errorf("reviewer reward must not be negative, got %d", reward)
+	}
+
+	if err := ctx.GetStub().PutState(reviewerRewardKey, []byte(strconv.Itoa(reward))); err != nil {
+		return fmt.Errorf("failed to put reviewer reward on ledger: %v", err)
+	}
+	return nil
+}
+
+// GetReviewerReward returns the number of points credited to a reviewer for each new review
+func (cc *SmartContract) GetReviewerReward(ctx contractapi.TransactionContextInterface) (int, error) {
+	return readCounter(ctx, reviewerRewardKey, defaultReviewerReward)
+}
+
+// SetRewardMintCap sets the most reviewer reward points one reviewer may be credited per day, across
+// new reviews and back-payments. A cap of 0 removes the limit.
+func (cc *SmartContract) SetRewardMintCap(ctx contractapi.TransactionContextInterface, limit int) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+	if limit < 0 {
+		return fmt.Errorf("reward mint cap must not be negative, got %d", limit)
+	}
+
+	if err := ctx.GetStub().PutState(rewardMintCapKey, []byte(strconv.Itoa(limit))); err != nil {
+		return fmt.Errorf("failed to put reward mint cap on ledger: %v", err)
+	}
+	return nil
+}
+
+// GetRewardMintCap returns the most reviewer reward points one reviewer may be credited per day, or 0
+// if rewards are not capped
+func (cc *SmartContract) GetRewardMintCap(ctx contractapi.TransactionContextInterface) (int, error) {
+	return readCounter(ctx, rewardMintCapKey, 0)
+}
+
+// mintReviewerReward reports whether a reward may be credited to a reviewer at timestamp without
+// exceeding the reward mint cap and, if so, counts it against the reviewer's allowance for the day.
+// The caller credits the reward and puts the reviewer's record.
+func mintReviewerReward(ctx contractapi.TransactionContextInterface, reviewerData *UserData, reward int, timestamp int64) (bool, error) {
+	limit, err := readCounter(ctx, rewardMintCapKey, 0)
+	if err != nil {
+		return false, err
+	}
+
+	period := timestamp / rewardMintPeriod
+	if reviewerData.RewardPeriod != period {
+		reviewerData.RewardPeriod = period
+		reviewerData.RewardsMinted = 0
+	}
+	if limit > 0 && reviewerData.RewardsMinted+reward > limit {
+		return false, nil
+	}
+	reviewerData.RewardsMinted += reward
+	return true, nil
+}
+
+// readCounter reads an integer stored under a ledger key, returning fallback if the key is unset
+func readCounter(ctx contractapi.TransactionContextInterface, key string, fallback int) (int, error) {
+	valueBytes, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s from ledger: %v", key, err)
+	}
+	if valueBytes == nil {
+		return fallback, nil
+	}
+
+	value, err := strconv.Atoi(string(valueBytes))
+	if err != nil {
+		return 0, fmt.Errorf("failed to convert %s to integer: %v", key, err)
+	}
+	return value, nil
+}
+
+// generateUniqueID generates a unique bare ID from the transaction ID; callers add their own key prefix.
+// No shared counter is read, so concurrent transactions never conflict over it, but a transaction
+// only gets one ID.
+func generateUniqueID(ctx contractapi.TransactionContextInterface) (string, error) {
+	txID := ctx.GetStub().GetTxID()
+	if txID == "" {
+		return "", fmt.Errorf("failed to generate ID: transaction has no ID")
+	}
+	return txID, nil
+}
+
+// Key range covering every review on the ledger. '~' sorts after every character a review ID can
+// start with, including the "Review_" that reviews from before bare IDs carry in their ID.
+const (
+	reviewRangeStart = "Review_"
+	reviewRangeEnd   = "Review_~"
+)
+
+// reviewKey returns the ledger key of a review
+func reviewKey(id string) string {
+	return "Review_" + id
+}
+
+// GetAllReviewData retrieves all review data entries from the ledger, leaving out invalidated reviews
+func (cc *SmartContract) GetAllReviewData(ctx contractapi.TransactionContextInterface) ([]*ReviewData, error) {
+	return cc.reviewData(ctx, false)
+}
+
+// GetAllReviewDataIncludingInvalidated retrieves every review data entry from the ledger, including
+// invalidated reviews, for moderators auditing the full review history
+func (cc *SmartContract) GetAllReviewDataIncludingInvalidated(ctx contractapi.TransactionContextInterface) ([]*ReviewData, error) {
+	permissions, err := callerPermissions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !permissions.CanModerate {
+		return nil, fmt.Errorf("caller is not authorized: admin or moderator role required")
+	}
+
+	return cc.reviewData(ctx, true)
+}
+
+// reviewData reads the review data entries from the ledger, optionally including invalidated ones
+func (cc *SmartContract) reviewData(ctx contractapi.TransactionContextInterface, includeInvalidated bool) ([]*ReviewData, error) {
+	// Get iterator for all review data entries
+	iterator, err := ctx.GetStub().GetStateByRange(reviewRangeStart, reviewRangeEnd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read all review data entries: %v", err)
+	}
+	defer iterator.Close()
+
+	// Iterate through the results and unmarshal review data entries
+	var reviews []*ReviewData
+	for iterator.HasNext() {
+		item, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get next item in iterator: %v", err)
+		}
+
+		// Unmarshal review data
+		var review ReviewData
+		err = json.Unmarshal(item.Value, &review)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal review data: %v", err)
+		}
+		if review.Invalidated && !includeInvalidated {
+			continue
+		}
+
+		// Append review data to result list
+		reviews = append(reviews, &review)
+	}
+
+	return reviews, nil
+}
+
+// GetAllReviewData retrieves all review data entries from the ledger and filters them by the specified CTI data ID
+func (cc *SmartContract) GetReviewDataByCTIDataID(ctx contractapi.TransactionContextInterface, ctiDataID string) ([]*ReviewData, error) {
+	// Get all review data entries from the ledger
+	allReviewData, err := cc.GetAllReviewData(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all review data entries: %v", err)
+	}
+
+	// Filter review data by the specified CTI data ID
+	var filteredReviews []*ReviewData
+	for _, review := range allReviewData {
+		if review.CTIDataID == ctiDataID {
+			filteredReviews = append(filteredReviews, review)
+		}
+	}
+
+	return filteredReviews, nil
+}
+
+// GetCTIItemsFilteredBySubscriptionLevel retrieves CTI data entries from the ledger filtered by subscription level
+func (cc *SmartContract) GetCTIItemsFilteredBySubscriptionLevel(ctx contractapi.TransactionContextInterface) ([]*CTIData, error) {
+	// Retrieve all CTI data entries from the ledger
+	allCTIItems, err := cc.GetAllCTIItems(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all CTI data entries: %v", err)
+	}
+
+	// Retrieve user data for the current peer; callers without a record have no subscription
+	peerID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current peer ID: %v", err)
+	}
+	userData, err := readUserData(ctx, peerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user data: %v", err)
+	}
+	if userData == nil {
+		userData = newUserData(peerID)
+	}
+
+	// Filter CTI data entries based on subscription level
+	var filteredCTIItems []*CTIData
+	for _, ctiItem := range allCTIItems {
+		if ctiItem.Level <= userData.Subscribed {
+			filteredCTIItems = append(filteredCTIItems, ctiItem)
+		}
+	}
+
+	return filteredCTIItems, nil
+}
+
+// subscriptionPricePerLevel is the balance charged for each subscription level. Upgrading charges the
+// difference between the new and the current level's price.
+const subscriptionPricePerLevel = 10
+
+// PurchaseSubscription raises the caller's subscription to level, paying for the upgrade from their
+// balance. Subscriptions can only be upgraded; a downgrade would need a refund and is rejected.
+func (cc *SmartContract) PurchaseSubscription(ctx contractapi.TransactionContextInterface, level int) (err error) {
+	cc.logOperationStart(ctx, "PurchaseSubscription")
+	defer func() { cc.logOperationEnd(ctx, "PurchaseSubscription", err) }()
+
+	userID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+
+	userData, err := readUserData(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if userData == nil {
+		return fmt.Errorf("user data with ID %s does not exist", userID)
+	}
+	if level <= userData.Subscribed {
+		return fmt.Errorf("subscription level %d is not above the current level %d", level, userData.Subscribed)
+	}
+
+	price := (level - userData.Subscribed) * subscriptionPricePerLevel
+	if err := adjustUserBalances(userData, 0, -price); err != nil {
+		return err
+	}
+	userData.Subscribed = level
+
+	return putUserData(ctx, userData)
+}
+
+// DeleteCTIItemByID deletes a CTI data entry from the ledger by its ID, refunding any stake still held
+// on it to the uploader
+func (cc *SmartContract) DeleteCTIItemByID(ctx contractapi.TransactionContextInterface, id string) (err error) {
+	cc.logOperationStart(ctx, "DeleteCTIItemByID")
+	defer func() { cc.logOperationEnd(ctx, "DeleteCTIItemByID", err) }()
+
+	// Check if the CTI data entry exists
+	existingItem, err := readCTIItem(ctx, id)
+	if err != nil {
+		return err
+	}
+	if existingItem == nil {
+		return fmt.Errorf("CTI data entry with ID %s does not exist", id)
+	}
+
+	// Hard deletion breaks the reviews referencing the item, so only admins may do it; uploaders
+	// use SoftDeleteCTIItem
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	// Delete the CTI data entry from the ledger
+	err = ctx.GetStub().DelState(ctiKey(id))
+	if err != nil {
+		return fmt.Errorf("failed to delete CTI data entry: %v", err)
+	}
+
+	// Remove a private encryption key along with the item
+	if existingItem.PrivateKey {
+		if err := ctx.GetStub().DelPrivateData(ctiKeyCollection, id); err != nil {
+			return fmt.Errorf("failed to delete encryption key from private data collection: %v", err)
+		}
+	}
+
+	// Drop any access grants to the item
+	grants, err := ctx.GetStub().GetStateByPartialCompositeKey(grantIndex, []string{id})
+	if err != nil {
+		return fmt.Errorf("failed to read %s index: %v", grantIndex, err)
+	}
+	defer grants.Close()
+	for grants.HasNext() {
+		grant, err := grants.Next()
+		if err != nil {
+			return fmt.Errorf("failed to iterate over %s index: %v", grantIndex, err)
+		}
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(grant.Key)
+		if err != nil {
+			return fmt.Errorf("failed to split %s index key: %v", grantIndex, err)
+		}
+		if err := deleteGrant(ctx, id, attributes[1]); err != nil {
+			return err
+		}
+	}
+
+	// Drop the item's links in both directions
+	links, err := readLinks(ctx, id)
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		if err := deleteLink(ctx, link); err != nil {
+			return err
+		}
+	}
+
+	// Remove the CTI data entry from the indexes
+	if err := deleteCTIIndexes(ctx, existingItem); err != nil {
+		return err
+	}
+
+	// Release the entry from its upload counts and refund its stake, unless a soft delete already did
+	if !existingItem.Deleted {
+		if err := releaseCTIUpload(ctx, existingItem, true); err != nil {
+			return err
+		}
+	}
+
+	if err := appendChangeLog(ctx, id, "deleted", "deleted from the ledger"); err != nil {
+		return err
+	}
+
+	eventJSON, err := json.Marshal(newCTIItemEvent(existingItem))
+	if err != nil {
+		return fmt.Errorf("failed to marshal CTI item deleted event: %v", err)
+	}
+	if err := ctx.GetStub().SetEvent("CTIItemDeleted", eventJSON); err != nil {
+		return fmt.Errorf("failed to set CTI item deleted event: %v", err)
+	}
+
+	return nil
+}
+
+// releaseCTIUpload stops counting a deleted CTI item against its uploader and settles the stake held on
+// it, refunding it to the uploader's balance or forfeiting it, and tells the uploader which. Deleting its
+// index entries already stops counting it against its organisation.
+func releaseCTIUpload(ctx contractapi.TransactionContextInterface, ctiItem *CTIData, refundStake bool) error {
+	uploaderData, err := readUserData(ctx, ctiItem.Uploader)
+	if err != nil {
+		return err
+	}
+	if uploaderData == nil {
+		return nil
+	}
+
+	// Settle the stake still held on the item
+	stake := ctiItem.StakeEscrow
+	ctiItem.StakeEscrow = 0
+	if stake > 0 && refundStake {
+		if err := adjustUserBalances(uploaderData, 0, stake); err != nil {
+			return err
+		}
+	}
+	if uploaderData.UploadCount > 0 {
+		uploaderData.UploadCount--
+	}
+	if err := putUserData(ctx, uploaderData); err != nil {
+		return err
+	}
+
+	if stake > 0 && refundStake {
+		return notifyUser(ctx, ctiItem.Uploader, "stake-returned", ctiItem.ID, fmt.Sprintf("stake of %d on deleted CTI item %s was returned", stake, ctiItem.ID))
+	}
+	if stake > 0 {
+		return notifyUser(ctx, ctiItem.Uploader, "stake-forfeited", ctiItem.ID, fmt.Sprintf("stake of %d on CTI item %s was forfeited when you deleted it", stake, ctiItem.ID))
+	}
+	return nil
+}
+
+// SoftDeleteCTIItem marks a CTI item deleted without removing it, so reviews referencing it still
+// resolve. The item drops out of every index and of GetAllCTIItems. Only the uploader or an admin may
+// delete an item. A stake still held on the item is forfeited when the uploader deletes it and refunded
+// when an admin deletes it.
+func (cc *SmartContract) SoftDeleteCTIItem(ctx contractapi.TransactionContextInterface, id string) (err error) {
+	cc.logOperationStart(ctx, "SoftDeleteCTIItem")
+	defer func() { cc.logOperationEnd(ctx, "SoftDeleteCTIItem", err) }()
+
+	permissions, err := callerPermissions(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctiItem, err := readCTIItem(ctx, id)
+	if err != nil {
+		return err
+	}
+	if ctiItem == nil {
+		return fmt.Errorf("CTI item with ID %s does not exist", id)
+	}
+	if !permissions.IsAdmin && ctiItem.Uploader != permissions.ID {
+		return fmt.Errorf("caller is not authorized: only the uploader or an admin can delete CTI item %s", id)
+	}
+	if ctiItem.Deleted {
+		return fmt.Errorf("CTI item %s is already deleted", id)
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	if err := deleteCTIIndexes(ctx, ctiItem); err != nil {
+		return err
+	}
+	// An uploader withdrawing their own item forfeits its stake, so deleting cannot dodge a forfeiture;
+	// an admin deleting someone else's item refunds it
+	if err := releaseCTIUpload(ctx, ctiItem, ctiItem.Uploader != permissions.ID); err != nil {
+		return err
+	}
+	ctiItem.Deleted = true
+	ctiItem.DeletedBy = permissions.ID
+	ctiItem.DeletedAt = now
+	if err := putCTIItem(ctx, ctiItem); err != nil {
+		return err
+	}
+
+	if err := appendChangeLog(ctx, id, "deleted", "marked deleted"); err != nil {
+		return err
+	}
+
+	eventJSON, err := json.Marshal(newCTIItemEvent(ctiItem))
+	if err != nil {
+		return fmt.Errorf("failed to marshal CTI item deleted event: %v", err)
+	}
+	if err := ctx.GetStub().SetEvent("CTIItemDeleted", eventJSON); err != nil {
+		return fmt.Errorf("failed to set CTI item deleted event: %v", err)
+	}
+
+	return nil
+}
+
+// callerPermissions derives the caller's permissions from their identity. Every role check in the
+// contract goes through here so GetMyPermissions always matches what the methods enforce.
+func callerPermissions(ctx contractapi.TransactionContextInterface) (*Permissions, error) {
+	callerID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current peer ID: %v", err)
+	}
+	mspID, err := ctx.GetClientIdentity().GetMSPID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller MSP ID: %v", err)
+	}
+	role, _, err := ctx.GetClientIdentity().GetAttributeValue(roleAttribute)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read caller role: %v", err)
+	}
+
+	permissions := &Permissions{
+		ID:          callerID,
+		MSPID:       mspID,
+		Role:        role,
+		IsAdmin:     role == adminRole,
+		IsOracle:    role == oracleRole,
+		IsModerator: role == moderatorRole,
+		IsOrgLead:   role == orgLeadRole,
+	}
+	permissions.CanMint = permissions.IsAdmin
+	permissions.CanConfigure = permissions.IsAdmin
+	permissions.CanRecordCIDAvailability = permissions.IsOracle
+	permissions.CanAdoptItems = permissions.IsAdmin || permissions.IsOrgLead
+	permissions.CanModerate = permissions.IsAdmin || permissions.IsModerator
+	permissions.CanExpireItems = permissions.IsAdmin || permissions.IsOracle
+
+	return permissions, nil
+}
+
+// isAdmin reports whether the caller carries the admin role attribute
+func isAdmin(ctx contractapi.TransactionContextInterface) (bool, error) {
+	permissions, err := callerPermissions(ctx)
+	if err != nil {
+		return false, err
+	}
+	return permissions.IsAdmin, nil
+}
+
+// GetMyPermissions returns what the caller is allowed to do, so clients can hide disallowed actions
+func (cc *SmartContract) GetMyPermissions(ctx contractapi.TransactionContextInterface) (*Permissions, error) {
+	return callerPermissions(ctx)
+}
+
+// requireAdmin returns an error unless the caller is an admin
+func requireAdmin(ctx contractapi.TransactionContextInterface) error {
+	admin, err := isAdmin(ctx)
+	if err != nil {
+		return err
+	}
+	if !admin {
+		return fmt.Errorf("caller is not authorized: admin role required")
+	}
+	return nil
+}
+
+// ctiIndexKeys returns the composite index keys under which a CTI item is indexed
+func ctiIndexKeys(ctx contractapi.TransactionContextInterface, ctiItem *CTIData) ([]string, error) {
+	// Soft-deleted items drop out of every index
+	if ctiItem.Deleted {
+		return nil, nil
+	}
+
+	values := map[string][]string{
+		uploaderIndex: {ctiItem.Uploader},
+		levelIndex:    {strconv.Itoa(ctiItem.Level)},
+		cidIndex:      {ctiItem.CID},
+		tagIndex:      normalizeTags(ctiItem.Tags),
+	}
+	// The organisation index holds one entry per item counted against the uploader's org quota.
+	// Items recorded before uploader organisations were tracked carry no MSP ID and are not counted.
+	if ctiItem.UploaderMSP != "" {
+		values[orgIndex] = []string{ctiItem.UploaderMSP}
+	}
+	if ctiItem.Region != "" {
+		values[regionIndex] = []string{ctiItem.Region}
+	}
+	if ctiItem.TLP != "" {
+		values[tlpIndex] = []string{ctiItem.TLP}
+	}
+	for _, indicator := range ctiItem.Indicators {
+		if !containsString(values[indicatorIndex], indicator.Value) {
+			values[indicatorIndex] = append(values[indicatorIndex], indicator.Value)
+		}
+	}
+	// Sub-techniques are indexed under their parent technique too, so a technique query finds them
+	for _, technique := range ctiItem.Techniques {
+		parent := strings.SplitN(technique, ".", 2)[0]
+		if !containsString(values[techniqueIndex], technique) {
+			values[techniqueIndex] = append(values[techniqueIndex], technique)
+		}
+		if !containsString(values[techniqueIndex], parent) {
+			values[techniqueIndex] = append(values[techniqueIndex], parent)
+		}
+	}
+
+	// Archived items are no longer current, so they drop out of tag queries and facets and stop
+	// counting against the org quota
+	if ctiItem.Archived {
+		delete(values, tagIndex)
+		delete(values, orgIndex)
+	}
+
+	var keys []string
+	for _, index := range ctiIndexes {
+		for _, value := range values[index] {
+			key, err := ctx.GetStub().CreateCompositeKey(index, []string{value, ctiItem.ID})
+			if err != nil {
+				return nil, fmt.Errorf("failed to create %s index key: %v", index, err)
+			}
+			keys = append(keys, key)
+		}
+	}
+
+	return keys, nil
+}
+
+// putCTIIndexes writes every composite index entry for a CTI item
+func putCTIIndexes(ctx contractapi.TransactionContextInterface, ctiItem *CTIData) error {
+	keys, err := ctiIndexKeys(ctx, ctiItem)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := ctx.GetStub().PutState(key, []byte{0x00}); err != nil {
+			return fmt.Errorf("failed to put CTI index entry: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// deleteCTIIndexes removes every composite index entry for a CTI item
+func deleteCTIIndexes(ctx contractapi.TransactionContextInterface, ctiItem *CTIData) error {
+	keys, err := ctiIndexKeys(ctx, ctiItem)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if err := ctx.GetStub().DelState(key); err != nil {
+			return fmt.Errorf("failed to delete CTI index entry: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// RebuildIndexes repairs the composite indexes in pages of up to pageSize records, starting at bookmark.
+// It first rewrites the index entries of every CTI item, adding its grants missing from the grantee
+// index on the way, then the reviewer entries of every review. Entries are only ever replaced one page at
+// a time, so queries keep working while a rebuild is in progress. Stale entries cannot be found without
+// paging through the indexes themselves, which Fabric only allows in read-only transactions, so they are
+// listed with FindStaleIndexEntries and removed with DeleteStaleIndexEntries. It returns the bookmark to
+// pass to the next call, or an empty string once the rebuild is complete.
+func (cc *SmartContract) RebuildIndexes(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (string, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return "", err
+	}
+	if pageSize <= 0 {
+		return "", fmt.Errorf("page size must be positive, got %d", pageSize)
+	}
+
+	if !strings.HasPrefix(bookmark, reviewRangeStart) {
+		next, err := scanPage(ctx, ctiRangeStart, ctiRangeEnd, bookmark, pageSize, func(key string, value []byte) error {
+			var ctiItem CTIData
+			if err := json.Unmarshal(value, &ctiItem); err != nil {
+				return fmt.Errorf("failed to unmarshal CTI data: %v", err)
+			}
+			if err := putCTIIndexes(ctx, &ctiItem); err != nil {
+				return err
+			}
+			return putGranteeEntries(ctx, ctiItem.ID)
+		})
+		if err != nil || next != "" {
+			return next, err
+		}
+		return reviewRangeStart, nil
+	}
+
+	return scanPage(ctx, reviewRangeStart, reviewRangeEnd, bookmark, pageSize, func(key string, value []byte) error {
+		var review ReviewData
+		if err := json.Unmarshal(value, &review); err != nil {
+			return fmt.Errorf("failed to unmarshal review data: %v", err)
+		}
+		return putReviewIndex(ctx, &review)
+	})
+}
+
+// putGranteeEntries adds a CTI item's grants made before the grantee index existed to it
+func putGranteeEntries(ctx contractapi.TransactionContextInterface, id string) error {
+	grants, err := ctx.GetStub().GetStateByPartialCompositeKey(grantIndex, []string{id})
+	if err != nil {
+		return fmt.Errorf("failed to read %s index: %v", grantIndex, err)
+	}
+	defer grants.Close()
+
+	for grants.HasNext() {
+		grant, err := grants.Next()
+		if err != nil {
+			return fmt.Errorf("failed to iterate over %s index: %v", grantIndex, err)
+		}
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(grant.Key)
+		if err != nil {
+			return fmt.Errorf("failed to split %s index key: %v", grantIndex, err)
+		}
+		reverseKey, err := granteeKey(ctx, id, attributes[1])
+		if err != nil {
+			return err
+		}
+		if err := ctx.GetStub().PutState(reverseKey, []byte{0x00}); err != nil {
+			return fmt.Errorf("failed to put access grant: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// scanPage calls fn for up to pageSize entries of the key range [startKey, endKey), starting at bookmark
+// when one is given. It returns the key to resume from, or an empty string once the range is exhausted.
+// Unlike GetStateByRangeWithPagination it may be used by transactions that write.
+func scanPage(ctx contractapi.TransactionContextInterface, startKey, endKey, bookmark string, pageSize int32, fn func(key string, value []byte) error) (string, error) {
+	if bookmark != "" {
+		if bookmark < startKey || bookmark >= endKey {
+			return "", fmt.Errorf("invalid bookmark %s", bookmark)
+		}
+		startKey = bookmark
+	}
+
+	iterator, err := ctx.GetStub().GetStateByRange(startKey, endKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to get range %s: %v", startKey, err)
+	}
+	defer iterator.Close()
+
+	var scanned int32
+	for iterator.HasNext() {
+		item, err := iterator.Next()
+		if err != nil {
+			return "", fmt.Errorf("failed to iterate over range %s: %v", startKey, err)
+		}
+
+		// Stop once the page is full and hand back the next key as the bookmark
+		if scanned == pageSize {
+			return item.Key, nil
+		}
+		scanned++
+
+		if err := fn(item.Key, item.Value); err != nil {
+			return "", err
+		}
+	}
+
+	return "", nil
+}
+
+// sweptIndexes lists the indexes FindStaleIndexEntries checks for stale entries, in scan order. Grant
+// entries are removed along with their item, so only the grantee index mirroring them is checked.
+var sweptIndexes = append(append([]string{}, ctiIndexes...), reviewerIndex, granteeIndex)
+
+// FindStaleIndexEntries lists the entries among up to pageSize index entries from bookmark on that no
+// longer match the record they refer to. A page covers a single index; its bookmark moves on to the start
+// of the next index once one is exhausted, and is empty once every index has been checked. The keys found
+// are passed to DeleteStaleIndexEntries.
+func (cc *SmartContract) FindStaleIndexEntries(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*StaleIndexEntries, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("page size must be positive, got %d", pageSize)
+	}
+
+	position := 0
+	if bookmark != "" {
+		bookmarkIndex, _, err := ctx.GetStub().SplitCompositeKey(bookmark)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bookmark %s", bookmark)
+		}
+		position = -1
+		for i, index := range sweptIndexes {
+			if index == bookmarkIndex {
+				position = i
+			}
+		}
+		if position < 0 {
+			return nil, fmt.Errorf("invalid bookmark %s", bookmark)
+		}
+	}
+	index := sweptIndexes[position]
+
+	iterator, metadata, err := ctx.GetStub().GetStateByPartialCompositeKeyWithPagination(index, []string{}, pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s index: %v", index, err)
+	}
+	defer iterator.Close()
+
+	page := &StaleIndexEntries{Keys: []string{}, Bookmark: metadata.Bookmark}
+	// Index keys of the CTI items met so far, so an item with many entries is only read once
+	itemKeys := make(map[string]map[string]bool)
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate over %s index: %v", index, err)
+		}
+		stale, err := staleIndexEntry(ctx, entry.Key, itemKeys)
+		if err != nil {
+			return nil, err
+		}
+		if stale {
+			page.Keys = append(page.Keys, entry.Key)
+		}
+	}
+
+	// The next index is checked from its first entry
+	if page.Bookmark == "" && position+1 < len(sweptIndexes) {
+		page.Bookmark, err = ctx.GetStub().CreateCompositeKey(sweptIndexes[position+1], []string{})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s index key: %v", sweptIndexes[position+1], err)
+		}
+	}
+
+	return page, nil
+}
+
+// DeleteStaleIndexEntries deletes the given index entries that are still stale, as found by
+// FindStaleIndexEntries, and returns how many it deleted. Entries that have become valid again since
+// they were found are kept.
+func (cc *SmartContract) DeleteStaleIndexEntries(ctx contractapi.TransactionContextInterface, keys []string) (int, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return 0, err
+	}
+
+	itemKeys := make(map[string]map[string]bool)
+	deleted := 0
+	for _, key := range keys {
+		stale, err := staleIndexEntry(ctx, key, itemKeys)
+		if err != nil {
+			return 0, err
+		}
+		if !stale {
+			continue
+		}
+		if err := ctx.GetStub().DelState(key); err != nil {
+			return 0, fmt.Errorf("failed to delete index entry %s: %v", key, err)
+		}
+		deleted++
+	}
+
+	return deleted, nil
+}
+
+// staleIndexEntry reports whether an entry of one of the swept indexes no longer matches the record it
+// refers to. itemKeys caches the current index keys of the CTI items met so far.
+func staleIndexEntry(ctx contractapi.TransactionContextInterface, key string, itemKeys map[string]map[string]bool) (bool, error) {
+	index, attributes, err := ctx.GetStub().SplitCompositeKey(key)
+	if err != nil {
+		return false, fmt.Errorf("invalid index key %s", key)
+	}
+	swept := false
+	for _, sweptIndex := range sweptIndexes {
+		swept = swept || sweptIndex == index
+	}
+	if !swept || len(attributes) == 0 {
+		return false, fmt.Errorf("invalid index key %s", key)
+	}
+
+	if index == reviewerIndex {
+		review, err := readReview(ctx, attributes[len(attributes)-1])
+		if err != nil {
+			return false, err
+		}
+		return review == nil || len(attributes) != 3 || review.UserDataID != attributes[0] || review.CTIDataID != attributes[1], nil
+	}
+	if index == granteeIndex {
+		if len(attributes) != 2 {
+			return true, nil
+		}
+		granted, err := isGranted(ctx, attributes[1], attributes[0])
+		if err != nil {
+			return false, err
+		}
+		return !granted, nil
+	}
+
+	id := attributes[len(attributes)-1]
+	keys, ok := itemKeys[id]
+	if !ok {
+		keys = make(map[string]bool)
+		ctiItem, err := readCTIItem(ctx, id)
+		if err != nil {
+			return false, err
+		}
+		if ctiItem != nil {
+			current, err := ctiIndexKeys(ctx, ctiItem)
+			if err != nil {
+				return false, err
+			}
+			for _, currentKey := range current {
+				keys[currentKey] = true
+			}
+		}
+		itemKeys[id] = keys
+	}
+	return !keys[key], nil
+}
+
+// readUserData loads the user data stored for a user ID, returning nil if none exists
+func readUserData(ctx contractapi.TransactionContextInterface, userID string) (*UserData, error) {
+	userDataJSON, err := ctx.GetStub().GetState(fmt.Sprintf("UserData_%s", userID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read user data: %v", err)
+	}
+	if userDataJSON == nil {
+		return nil, nil
+	}
+
+	var userData UserData
+	if err := json.Unmarshal(userDataJSON, &userData); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal user data: %v", err)
+	}
+
+	return &userData, nil
+}
+
+// userLevelThresholds lists the upload count and points needed for each user level above zero. A user
+// reaches a level by meeting either of its thresholds.
+var userLevelThresholds = []struct {
+	uploads int
+	points  int
+}{
+	{uploads: 5, points: 10},
+	{uploads: 20, points: 50},
+	{uploads: 50, points: 200},
+}
+
+// userLevel returns the level a user has reached through their upload count and points
+func userLevel(userData *UserData) int {
+	level := 0
+	for i, threshold := range userLevelThresholds {
+		if userData.UploadCount >= threshold.uploads || userData.Points >= threshold.points {
+			level = i + 1
+		}
+	}
+	return level
+}
+
+// RecalculateUserLevel brings the caller's stored level in line with their upload count and points and
+// returns it. Levels are kept current on every user data write, so this is only needed for records
+// written before levels were derived.
+func (cc *SmartContract) RecalculateUserLevel(ctx contractapi.TransactionContextInterface) (int, error) {
+	userID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get client identity: %v", err)
+	}
+
+	userData, err := readUserData(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+	if userData == nil {
+		return 0, fmt.Errorf("User data for user %s does not exist", userID)
+	}
+
+	if err := putUserData(ctx, userData); err != nil {
+		return 0, err
+	}
+	return userData.UserLevel, nil
+}
+
+// newUserData returns an empty user data entry for a user ID
+func newUserData(userID string) *UserData {
+	return &UserData{ID: userID, SchemaVersion: SchemaVersion}
+}
+
+// validateUserBalances rejects points or balance values below zero
+func validateUserBalances(points, balance int) error {
+	if points < 0 {
+		return fmt.Errorf("points must not be negative, got %d", points)
+	}
+	if balance < 0 {
+		return fmt.Errorf("balance must not be negative, got %d", balance)
+	}
+	return nil
+}
+
+// adjustUserBalances applies point and balance changes to a user. Every path that changes a user's
+// points or balance goes through here, so neither can ever be driven below zero.
+func adjustUserBalances(userData *UserData, pointsDelta, balanceDelta int) error {
+	if userData.Points+pointsDelta < 0 {
+		return fmt.Errorf("insufficient points: user %s has %d points, %d required", userData.ID, userData.Points, -pointsDelta)
+	}
+	if userData.Balance+balanceDelta < 0 {
+		return fmt.Errorf("insufficient funds: user %s has a balance of %d, %d required", userData.ID, userData.Balance, -balanceDelta)
+	}
+
+	userData.Points += pointsDelta
+	userData.Balance += balanceDelta
+	return nil
+}
+
+// putUserData writes user data to the ledger under its user ID
+func putUserData(ctx contractapi.TransactionContextInterface, userData *UserData) error {
+	// Keep the derived level in step with the counts it depends on
+	userData.UserLevel = userLevel(userData)
+
+	userDataJSON, err := json.Marshal(userData)
+	if err != nil {
+		return fmt.Errorf("failed to marshal user data: %v", err)
+	}
+
+	if err := ctx.GetStub().PutState(fmt.Sprintf("UserData_%s", userData.ID), userDataJSON); err != nil {
+		return fmt.Errorf("failed to put user data on ledger: %v", err)
+	}
+
+	return nil
+}
+
+// compositeScore returns the mean of a review's dimension scores. The scores were validated against
+// the configured dimensions when the review was written, so a review keeps its score if the
+// configuration later changes.
+func compositeScore(review *ReviewData) float64 {
+	scores := reviewScores(review)
+	if len(scores) == 0 {
+		return 0
+	}
+
+	total := 0
+	for _, score := range scores {
+		total += score
+	}
+	return float64(total) / float64(len(scores))
+}
+
+// reviewScores returns a review's score per dimension, falling back to the fixed score fields for
+// reviews written before dimensions were configurable
+func reviewScores(review *ReviewData) map[string]int {
+	if review.Scores != nil {
+		return review.Scores
+	}
+	return legacyScores(review.Accuracy, review.Timeliness, review.Completeness, review.Consistency)
+}
+
+// legacyScores maps the fixed score fields to their dimension names
+func legacyScores(accuracy, timeliness, completeness, consistency int) map[string]int {
+	return map[string]int{
+		"Accuracy":     accuracy,
+		"Timeliness":   timeliness,
+		"Completeness": completeness,
+		"Consistency":  consistency,
+	}
+}
+
+// readReviewDimensions returns the configured review dimensions
+func readReviewDimensions(ctx contractapi.TransactionContextInterface) ([]string, error) {
+	dimensionsJSON, err := ctx.GetStub().GetState(reviewDimensionsKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read review dimensions from ledger: %v", err)
+	}
+	if dimensionsJSON == nil {
+		return defaultReviewDimensions, nil
+	}
+
+	var dimensions []string
+	if err := json.Unmarshal(dimensionsJSON, &dimensions); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal review dimensions: %v", err)
+	}
+	return dimensions, nil
+}
+
+// validateReviewScores checks that scores hold a value for every configured dimension and no others
+func validateReviewScores(ctx contractapi.TransactionContextInterface, scores map[string]int) error {
+	dimensions, err := readReviewDimensions(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, dimension := range dimensions {
+		score, ok := scores[dimension]
+		if !ok {
+			return fmt.Errorf("missing score for review dimension %s", dimension)
+		}
+		if score < MinReviewScore || score > MaxReviewScore {
+			return fmt.Errorf("%s score %d is out of range: must be between %d and %d", dimension, score, MinReviewScore, MaxReviewScore)
+		}
+	}
+	if len(scores) != len(dimensions) {
+		for dimension := range scores {
+			if !containsString(dimensions, dimension) {
+				return fmt.Errorf("unknown review dimension %s", dimension)
+			}
+		}
+	}
+
+	return nil
+}
+
+// containsString reports whether values contains value
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// SetReviewDimensions replaces the set of dimensions new reviews are scored on
+func (cc *SmartContract) SetReviewDimensions(ctx contractapi.TransactionContextInterface, dimensions []string) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+	if len(dimensions) == 0 {
+		return fmt.Errorf("at least one review dimension is required")
+	}
+
+	seen := make(map[string]bool)
+	for _, dimension := range dimensions {
+		if strings.TrimSpace(dimension) == "" {
+			return fmt.Errorf("review dimension names must not be empty")
+		}
+		if seen[dimension] {
+			return fmt.Errorf("review dimension %s is listed more than once", dimension)
+		}
+		seen[dimension] = true
+	}
+
+	dimensionsJSON, err := json.Marshal(dimensions)
+	if err != nil {
+		return fmt.Errorf("failed to marshal review dimensions: %v", err)
+	}
+	if err := ctx.GetStub().PutState(reviewDimensionsKey, dimensionsJSON); err != nil {
+		return fmt.Errorf("failed to put review dimensions on ledger: %v", err)
+	}
+
+	return nil
+}
+
+// GetReviewDimensions returns the dimensions new reviews are scored on
+func (cc *SmartContract) GetReviewDimensions(ctx contractapi.TransactionContextInterface) ([]string, error) {
+	return readReviewDimensions(ctx)
+}
+
+// readReviewDimensionWeights returns the weight of each of the given dimensions in a CTI item's overall
+// score. Dimensions without a configured weight fall back to defaultReviewDimensionWeights, then to 1.
+func readReviewDimensionWeights(ctx contractapi.TransactionContextInterface, dimensions []string) (map[string]float64, error) {
+	weightsJSON, err := ctx.GetStub().GetState(reviewDimensionWeightsKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read review dimension weights from ledger: %v", err)
+	}
+	configured := map[string]float64{}
+	if weightsJSON != nil {
+		if err := json.Unmarshal(weightsJSON, &configured); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal review dimension weights: %v", err)
+		}
+	}
+
+	weights := make(map[string]float64)
+	for _, dimension := range dimensions {
+		weight, ok := configured[dimension]
+		if !ok {
+			weight, ok = defaultReviewDimensionWeights[dimension]
+		}
+		if !ok {
+			weight = 1
+		}
+		weights[dimension] = weight
+	}
+	return weights, nil
+}
+
+// SetReviewDimensionWeights replaces the weights of review dimensions in CTI items' overall scores.
+// Weights must not be negative. Dimensions left out take their default weight, and weights may be set
+// for dimensions that are not configured yet.
+func (cc *SmartContract) SetReviewDimensionWeights(ctx contractapi.TransactionContextInterface, weights map[string]float64) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	for dimension, weight := range weights {
+		if strings.TrimSpace(dimension) == "" {
+			return fmt.Errorf("review dimension names must not be empty")
+		}
+		if math.IsNaN(weight) || math.IsInf(weight, 0) || weight < 0 {
+			return fmt.Errorf("weight of review dimension %s must be a non-negative number, got %v", dimension, weight)
+		}
+	}
+
+	weightsJSON, err := json.Marshal(weights)
+	if err != nil {
+		return fmt.Errorf("failed to marshal review dimension weights: %v", err)
+	}
+	if err := ctx.GetStub().PutState(reviewDimensionWeightsKey, weightsJSON); err != nil {
+		return fmt.Errorf("failed to put review dimension weights on ledger: %v", err)
+	}
+
+	return nil
+}
+
+// GetReviewDimensionWeights returns the weight of each configured review dimension in a CTI item's
+// overall score
+func (cc *SmartContract) GetReviewDimensionWeights(ctx contractapi.TransactionContextInterface) (map[string]float64, error) {
+	dimensions, err := readReviewDimensions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return readReviewDimensionWeights(ctx, dimensions)
+}
+
+// ForceRecomputeReputation rebuilds a user's cached reputation by scanning every review on the ledger
+func (cc *SmartContract) ForceRecomputeReputation(ctx contractapi.TransactionContextInterface, userID string) (*UserData, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	userData, err := readUserData(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if userData == nil {
+		return nil, fmt.Errorf("User data for user %s does not exist", userID)
+	}
+
+	allReviewData, err := cc.GetAllReviewData(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all review data entries: %v", err)
+	}
+
+	if err := recomputeReputation(ctx, userData, allReviewData, nil); err != nil {
+		return nil, err
+	}
+
+	return userData, nil
+}
+
+// recomputeReputation recalculates and stores a user's reputation from the valid reviews
+// left on the user's CTI items. Items written earlier in the transaction, which reads do not see,
+// can be passed in ctiItems.
+func recomputeReputation(ctx contractapi.TransactionContextInterface, userData *UserData, allReviewData []*ReviewData, ctiItems map[string]*CTIData) error {
+	uploaders := make(map[string]string)
+	for id, ctiItem := range ctiItems {
+		uploaders[id] = ctiItem.Uploader
+	}
+	var totalScore float64
+	var reviewCount int
+	for _, review := range allReviewData {
+		if review.Invalidated {
+			continue
+		}
+
+		uploader, ok := uploaders[review.CTIDataID]
+		if !ok {
+			ctiItem, err := readCTIItem(ctx, review.CTIDataID)
+			if err != nil {
+				return err
+			}
+			if ctiItem != nil {
+				uploader = ctiItem.Uploader
+			}
+			uploaders[review.CTIDataID] = uploader
+		}
+
+		if uploader == userData.ID {
+			totalScore += compositeScore(review)
+			reviewCount++
+		}
+	}
+
+	userData.ReviewsReceived = reviewCount
+	userData.Reputation = 0
+	if reviewCount > 0 {
+		userData.Reputation = totalScore / float64(reviewCount)
+	}
+
+	return putUserData(ctx, userData)
+}
+
+// DeleteReviewData removes a review, its index and vote entries and any private text. Only the reviewer
+// or an admin may delete a review. The reviewed item's review aggregates and its uploader's reputation
+// are recomputed without it, and the rewards paid for the review are taken back from the reviewer, the
+// uploader and the item. The deletion fails if the reviewer or uploader has since spent those points.
+func (cc *SmartContract) DeleteReviewData(ctx contractapi.TransactionContextInterface, reviewID string) (err error) {
+	cc.logOperationStart(ctx, "DeleteReviewData")
+	defer func() { cc.logOperationEnd(ctx, "DeleteReviewData", err) }()
+
+	permissions, err := callerPermissions(ctx)
+	if err != nil {
+		return err
+	}
+
+	review, err := readReview(ctx, reviewID)
+	if err != nil {
+		return err
+	}
+	if review == nil {
+		return fmt.Errorf("review with ID %s does not exist", reviewID)
+	}
+	if !permissions.IsAdmin && review.UserDataID != permissions.ID {
+		return fmt.Errorf("caller is not authorized: only the reviewer or an admin can delete review %s", reviewID)
+	}
+
+	// Range reads do not see this transaction's deletions, so read the remaining reviews first
+	allReviewData, err := cc.reviewData(ctx, true)
+	if err != nil {
+		return fmt.Errorf("failed to get all review data entries: %v", err)
+	}
+	var remaining []*ReviewData
+	for _, other := range allReviewData {
+		if other.ID != reviewID {
+			remaining = append(remaining, other)
+		}
+	}
+
+	if err := ctx.GetStub().DelState(reviewKey(reviewID)); err != nil {
+		return fmt.Errorf("failed to delete review data: %v", err)
+	}
+	if review.PrivateText {
+		if err := ctx.GetStub().DelPrivateData(reviewTextCollection, reviewID); err != nil {
+			return fmt.Errorf("failed to delete private review text: %v", err)
+		}
+	}
+
+	if err := deleteReviewIndex(ctx, review); err != nil {
+		return err
+	}
+
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(voteIndex, []string{reviewID})
+	if err != nil {
+		return fmt.Errorf("failed to read %s index: %v", voteIndex, err)
+	}
+	defer iterator.Close()
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			return fmt.Errorf("failed to iterate over %s index: %v", voteIndex, err)
+		}
+		if err := ctx.GetStub().DelState(entry.Key); err != nil {
+			return fmt.Errorf("failed to delete vote: %v", err)
+		}
+	}
+
+	// Drop the review, and the uploader reward it earned, from the reviewed item
+	ctiItem, err := readCTIItem(ctx, review.CTIDataID)
+	if err != nil {
+		return err
+	}
+	var uploaderData *UserData
+	if ctiItem != nil {
+		recomputeCTIReviewAggregates(ctiItem, remaining)
+		if review.UploaderRewarded {
+			ctiItem.Points = maxInt(ctiItem.Points-UploaderReward, 0)
+		}
+		if err := putCTIItem(ctx, ctiItem); err != nil {
+			return err
+		}
+		uploaderData, err = readUserData(ctx, ctiItem.Uploader)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Take back the reviewer reward. A self-reviewer's record is the uploader's, updated in place since
+	// reads do not see this transaction's own writes.
+	var reviewerData *UserData
+	if uploaderData != nil && uploaderData.ID == review.UserDataID {
+		reviewerData = uploaderData
+	} else {
+		reviewerData, err = readUserData(ctx, review.UserDataID)
+		if err != nil {
+			return err
+		}
+	}
+	if reviewerData != nil {
+		reward := paidReviewerReward(review)
+		if err := adjustUserBalances(reviewerData, -reward, 0); err != nil {
+			return err
+		}
+		reviewerData.ReviewPoints = maxInt(reviewerData.ReviewPoints-reward, 0)
+		reviewerData.ReviewsAuthored = maxInt(reviewerData.ReviewsAuthored-1, 0)
+	}
+
+	// Take back the uploader reward and drop the review from the uploader's reputation
+	if uploaderData != nil {
+		if review.UploaderRewarded {
+			if err := adjustUserBalances(uploaderData, -UploaderReward, 0); err != nil {
+				return err
+			}
+		}
+		if err := recomputeReputation(ctx, uploaderData, remaining, nil); err != nil {
+			return err
+		}
+	}
+	if reviewerData != nil && reviewerData != uploaderData {
+		if err := putUserData(ctx, reviewerData); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// recomputeCTIReviewAggregates recalculates a CTI item's cached review count and composite score
+// from the valid reviews left on it
+func recomputeCTIReviewAggregates(ctiItem *CTIData, allReviewData []*ReviewData) {
+	var itemReviews []*ReviewData
+	for _, review := range allReviewData {
+		if review.CTIDataID == ctiItem.ID && !review.Invalidated {
+			itemReviews = append(itemReviews, review)
+		}
+	}
+
+	ctiItem.ReviewCount = len(itemReviews)
+	ctiItem.CompositeScore = averageCompositeScore(itemReviews)
+}
+
+// InvalidateSelfReviews marks every review left by an item's own uploader as invalidated and
+// recomputes the reputation of the affected uploaders. It returns the number of reviews invalidated.
+func (cc *SmartContract) InvalidateSelfReviews(ctx contractapi.TransactionContextInterface) (int, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return 0, err
+	}
+
+	allReviewData, err := cc.GetAllReviewData(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get all review data entries: %v", err)
+	}
+
+	affected := make(map[string]bool)
+	affectedItems := make(map[string]*CTIData)
+	invalidated := 0
+	for _, review := range allReviewData {
+		if review.Invalidated {
+			continue
+		}
+
+		ctiItem, err := readCTIItem(ctx, review.CTIDataID)
+		if err != nil {
+			return 0, err
+		}
+		if ctiItem == nil || ctiItem.Uploader != review.UserDataID {
+			continue
+		}
+
+		review.Invalidated = true
+		reviewJSON, err := json.Marshal(review)
+		if err != nil {
+			return 0, fmt.Errorf("failed to marshal review data to JSON: %v", err)
+		}
+		if err := ctx.GetStub().PutState(reviewKey(review.ID), reviewJSON); err != nil {
+			return 0, fmt.Errorf("failed to put review data on ledger: %v", err)
+		}
+
+		affected[ctiItem.Uploader] = true
+		affectedItems[ctiItem.ID] = ctiItem
+		invalidated++
+	}
+
+	// Drop the invalidated reviews from the items' cached review aggregates
+	for _, ctiItem := range affectedItems {
+		recomputeCTIReviewAggregates(ctiItem, allReviewData)
+		if err := putCTIItem(ctx, ctiItem); err != nil {
+			return 0, err
+		}
+	}
+
+	// Drop the invalidated reviews from the uploaders' cached reputation
+	for uploader := range affected {
+		userData, err := readUserData(ctx, uploader)
+		if err != nil {
+			return 0, err
+		}
+		if userData == nil {
+			continue
+		}
+		if err := recomputeReputation(ctx, userData, allReviewData, nil); err != nil {
+			return 0, err
+		}
+	}
+
+	return invalidated, nil
+}
+
+// CTIExists reports whether a CTI item is stored under an ID, without decoding it. Soft-deleted items
+// still exist.
+func (cc *SmartContract) CTIExists(ctx contractapi.TransactionContextInterface, id string) (bool, error) {
+	ctiItemJSON, err := ctx.GetStub().GetState(ctiKey(id))
+	if err != nil {
+		return false, fmt.Errorf("failed to read CTI item from ledger: %v", err)
+	}
+	return ctiItemJSON != nil, nil
+}
+
+// ReviewExists reports whether a review is stored under an ID, without decoding it
+func (cc *SmartContract) ReviewExists(ctx contractapi.TransactionContextInterface, reviewID string) (bool, error) {
+	reviewJSON, err := ctx.GetStub().GetState(reviewKey(reviewID))
+	if err != nil {
+		return false, fmt.Errorf("failed to read review data: %v", err)
+	}
+	return reviewJSON != nil, nil
+}
+
+// readCTIItem loads a CTI item from the ledger by its ID, returning nil if none exists
+func readCTIItem(ctx contractapi.TransactionContextInterface, id string) (*CTIData, error) {
+	ctiItemJSON, err := ctx.GetStub().GetState(ctiKey(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CTI item from ledger: %v", err)
+	}
+	if ctiItemJSON == nil {
+		return nil, nil
+	}
+
+	var ctiItem CTIData
+	if err := json.Unmarshal(ctiItemJSON, &ctiItem); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal CTI item: %v", err)
+	}
+
+	return &ctiItem, nil
+}
+
+// canAccess reports whether the caller is entitled to a CTI item's CID and encryption key, either
+// through their role and subscription or through an access grant from the item's owner
+func canAccess(ctx contractapi.TransactionContextInterface, ctiItem *CTIData) (bool, error) {
+	access, err := newAccessContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	if access.canAccess(ctiItem) {
+		return true, nil
+	}
+	// Grants missing from the grantee index are still honoured for a single item
+	return isGranted(ctx, ctiItem.ID, access.caller)
+}
+
+// grantKey returns the grantIndex key recording a grantee's access to a CTI item
+func grantKey(ctx contractapi.TransactionContextInterface, id string, granteeID string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(grantIndex, []string{id, granteeID})
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s index key: %v", grantIndex, err)
+	}
+	return key, nil
+}
+
+// granteeKey returns the granteeIndex key recording a grantee's access to a CTI item
+func granteeKey(ctx contractapi.TransactionContextInterface, id string, granteeID string) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(granteeIndex, []string{granteeID, id})
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s index key: %v", granteeIndex, err)
+	}
+	return key, nil
+}
+
+// putGrant records a grantee's access to a CTI item in both grant indexes
+func putGrant(ctx contractapi.TransactionContextInterface, id string, granteeID string) error {
+	key, err := grantKey(ctx, id, granteeID)
+	if err != nil {
+		return err
+	}
+	reverseKey, err := granteeKey(ctx, id, granteeID)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().PutState(key, []byte{0x00}); err != nil {
+		return fmt.Errorf("failed to put access grant: %v", err)
+	}
+	if err := ctx.GetStub().PutState(reverseKey, []byte{0x00}); err != nil {
+		return fmt.Errorf("failed to put access grant: %v", err)
+	}
+	return nil
+}
+
+// deleteGrant removes a grantee's access to a CTI item from both grant indexes
+func deleteGrant(ctx contractapi.TransactionContextInterface, id string, granteeID string) error {
+	key, err := grantKey(ctx, id, granteeID)
+	if err != nil {
+		return err
+	}
+	reverseKey, err := granteeKey(ctx, id, granteeID)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().DelState(key); err != nil {
+		return fmt.Errorf("failed to delete access grant: %v", err)
+	}
+	if err := ctx.GetStub().DelState(reverseKey); err != nil {
+		return fmt.Errorf("failed to delete access grant: %v", err)
+	}
+	return nil
+}
+
+// isGranted reports whether a CTI item's owner has granted an identity access to it
+func isGranted(ctx contractapi.TransactionContextInterface, id string, granteeID string) (bool, error) {
+	key, err := grantKey(ctx, id, granteeID)
+	if err != nil {
+		return false, err
+	}
+	grant, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return false, fmt.Errorf("failed to read access grant: %v", err)
+	}
+	return grant != nil, nil
+}
+
+// readOwnedCTIItem loads a CTI item that the caller must own or administer
+func readOwnedCTIItem(ctx contractapi.TransactionContextInterface, id string) (*CTIData, error) {
+	permissions, err := callerPermissions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctiItem, err := readCTIItem(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if ctiItem == nil || ctiItem.Deleted {
+		return nil, fmt.Errorf("CTI item with ID %s does not exist", id)
+	}
+	if !permissions.IsAdmin && ctiItem.Uploader != permissions.ID {
+		return nil, fmt.Errorf("caller is not authorized: only the uploader or an admin can manage CTI item %s", id)
+	}
+
+	return ctiItem, nil
+}
+
+// GrantCTIAccess lets a named identity retrieve a CTI item's CID and encryption key regardless of its
+// subscription level. Only the item's uploader or an admin may grant access.
+func (cc *SmartContract) GrantCTIAccess(ctx contractapi.TransactionContextInterface, id string, granteeID string) error {
+	if granteeID == "" {
+		return fmt.Errorf("grantee must not be empty")
+	}
+	if _, err := readOwnedCTIItem(ctx, id); err != nil {
+		return err
+	}
+
+	if err := putGrant(ctx, id, granteeID); err != nil {
+		return err
+	}
+
+	if err := appendChangeLog(ctx, id, "access-granted", fmt.Sprintf("access granted to %s", granteeID)); err != nil {
+		return err
+	}
+	return notifyUser(ctx, granteeID, "access-granted", id, fmt.Sprintf("you were granted access to CTI item %s", id))
+}
+
+// RevokeCTIAccess withdraws an access grant made with GrantCTIAccess. Only the item's uploader or an
+// admin may revoke access.
+func (cc *SmartContract) RevokeCTIAccess(ctx contractapi.TransactionContextInterface, id string, granteeID string) error {
+	if _, err := readOwnedCTIItem(ctx, id); err != nil {
+		return err
+	}
+
+	granted, err := isGranted(ctx, id, granteeID)
+	if err != nil {
+		return err
+	}
+	if !granted {
+		return fmt.Errorf("%s has not been granted access to CTI item %s", granteeID, id)
+	}
+
+	if err := deleteGrant(ctx, id, granteeID); err != nil {
+		return err
+	}
+
+	return appendChangeLog(ctx, id, "access-revoked", fmt.Sprintf("access revoked from %s", granteeID))
+}
+
+// IsGrantedCTIAccess reports whether an identity holds an access grant for a CTI item
+func (cc *SmartContract) IsGrantedCTIAccess(ctx contractapi.TransactionContextInterface, id string, granteeID string) (bool, error) {
+	return isGranted(ctx, id, granteeID)
+}
+
+// accessContext captures the caller details that decide entitlement to CTI items, so that
+// many items can be checked without re-reading the caller's user data or grants
+type accessContext struct {
+	caller     string
+	admin      bool
+	subscribed int
+	granted    map[string]bool
+}
+
+// newAccessContext loads the entitlement details of the caller
+func newAccessContext(ctx contractapi.TransactionContextInterface) (*accessContext, error) {
+	caller, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current peer ID: %v", err)
+	}
+
+	admin, err := isAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Users without a record have no subscription
+	access := &accessContext{caller: caller, admin: admin, granted: make(map[string]bool)}
+	userData, err := readUserData(ctx, caller)
+	if err != nil {
+		return nil, err
+	}
+	if userData != nil {
+		access.subscribed = userData.Subscribed
+	}
+
+	grants, err := ctx.GetStub().GetStateByPartialCompositeKey(granteeIndex, []string{caller})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s index: %v", granteeIndex, err)
+	}
+	defer grants.Close()
+	for grants.HasNext() {
+		grant, err := grants.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate over %s index: %v", granteeIndex, err)
+		}
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(grant.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to split %s index key: %v", granteeIndex, err)
+		}
+		access.granted[attributes[1]] = true
+	}
+
+	return access, nil
+}
+
+// canAccess reports whether the caller may see a CTI item's CID and encryption key.
+// Uploaders and admins always may; other users need a subscription at or above the item's level
+// or an access grant from the item's owner.
+func (a *accessContext) canAccess(ctiItem *CTIData) bool {
+	return a.admin || a.caller == ctiItem.Uploader || ctiItem.Level <= a.subscribed || a.granted[ctiItem.ID]
+}
+
+// redactCTIItem returns a copy of a CTI item with its CID and encryption key removed
+func redactCTIItem(ctiItem *CTIData) *CTIData {
+	redacted := *ctiItem
+	redacted.CID = ""
+	redacted.EncryptKey = ""
+	return &redacted
+}
+
+// redactForCaller replaces the CTI items the caller is not entitled to with copies lacking their CID
+// and encryption key. Admins, uploaders, sufficiently subscribed users and grantees see items in full.
+func redactForCaller(ctx contractapi.TransactionContextInterface, ctiItems []*CTIData) ([]*CTIData, error) {
+	access, err := newAccessContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, ctiItem := range ctiItems {
+		if !access.canAccess(ctiItem) {
+			ctiItems[i] = redactCTIItem(ctiItem)
+		}
+	}
+	return ctiItems, nil
+}
+
+// GetCTIItemKey returns the encryption key of a CTI item the caller is entitled to, reading it from the
+// CTI key collection for items added with AddCTIItemPrivate. Private keys can only be read on peers of
+// organisations that are members of the collection.
+func (cc *SmartContract) GetCTIItemKey(ctx contractapi.TransactionContextInterface, id string) (string, error) {
+	ctiItem, err := readCTIItem(ctx, id)
+	if err != nil {
+		return "", err
+	}
+	if ctiItem == nil {
+		return "", fmt.Errorf("CTI item with ID %s does not exist", id)
+	}
+
+	entitled, err := canAccess(ctx, ctiItem)
+	if err != nil {
+		return "", err
+	}
+	if !entitled {
+		return "", fmt.Errorf("caller is not authorized to read the encryption key of CTI item %s", id)
+	}
+	if !ctiItem.PrivateKey {
+		return ctiItem.EncryptKey, nil
+	}
+
+	encryptKey, err := ctx.GetStub().GetPrivateData(ctiKeyCollection, id)
+	if err != nil {
+		return "", fmt.Errorf("failed to read encryption key from private data collection: %v", err)
+	}
+	if encryptKey == nil {
+		return "", fmt.Errorf("encryption key of CTI item %s is not available on this peer", id)
+	}
+
+	return string(encryptKey), nil
+}
+
+// GetCTIItemForCaller retrieves a CTI item by its ID, returning the full record if the caller
+// is entitled to it and only its metadata otherwise
+func (cc *SmartContract) GetCTIItemForCaller(ctx contractapi.TransactionContextInterface, id string) (*CTIData, error) {
+	ctiItem, err := readCTIItem(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if ctiItem == nil {
+		return nil, fmt.Errorf("CTI item with ID %s does not exist", id)
+	}
+
+	entitled, err := canAccess(ctx, ctiItem)
+	if err != nil {
+		return nil, err
+	}
+	if !entitled {
+		return redactCTIItem(ctiItem), nil
+	}
+
+	return ctiItem, nil
+}
+
+// txTimestamp returns the transaction timestamp in Unix seconds
+func txTimestamp(ctx contractapi.TransactionContextInterface) (int64, error) {
+	ts, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get transaction timestamp: %v", err)
+	}
+	return ts.GetSeconds(), nil
+}
+
+// GetCTIScoreTrend compares the average composite score of a CTI item's earlier reviews with that
+// of its later reviews and reports whether perceived quality is improving, declining or stable
+func (cc *SmartContract) GetCTIScoreTrend(ctx contractapi.TransactionContextInterface, ctiDataID string) (*ScoreTrend, error) {
+	reviews, err := cc.GetReviewDataByCTIDataID(ctx, ctiDataID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Only valid reviews with a submission time can be placed on the timeline
+	var timedReviews []*ReviewData
+	for _, review := range reviews {
+		if !review.Invalidated && review.Timestamp > 0 {
+			timedReviews = append(timedReviews, review)
+		}
+	}
+
+	trend := &ScoreTrend{
+		CTIDataID:   ctiDataID,
+		ReviewCount: len(timedReviews),
+	}
+	if len(timedReviews) < minTrendReviews {
+		trend.Direction = TrendInsufficientData
+		return trend, nil
+	}
+
+	sort.SliceStable(timedReviews, func(i, j int) bool {
+		return timedReviews[i].Timestamp < timedReviews[j].Timestamp
+	})
+
+	// Compare the earlier half with the later half, leaving out the middle review of an odd count
+	half := len(timedReviews) / 2
+	trend.EarlierAverage = averageCompositeScore(timedReviews[:half])
+	trend.LaterAverage = averageCompositeScore(timedReviews[len(timedReviews)-half:])
+	trend.Delta = trend.LaterAverage - trend.EarlierAverage
+	trend.Magnitude = math.Abs(trend.Delta)
+
+	switch {
+	case trend.Magnitude < trendStableThreshold:
+		trend.Direction = TrendStable
+	case trend.Delta > 0:
+		trend.Direction = TrendImproving
+	default:
+		trend.Direction = TrendDeclining
+	}
+
+	return trend, nil
+}
+
+// GetCTIItemScore returns the mean of each configured review dimension over a CTI item's valid reviews
+// and the overall score weighted by the configured dimension weights. Each mean is taken over the
+// reviews scoring that dimension, so reviews written before a dimension was added do not drag it down;
+// a dimension no review scores is left out of the overall score.
+func (cc *SmartContract) GetCTIItemScore(ctx contractapi.TransactionContextInterface, ctiDataID string) (*CTIItemScore, error) {
+	reviews, err := cc.GetReviewDataByCTIDataID(ctx, ctiDataID)
+	if err != nil {
+		return nil, err
+	}
+	dimensions, err := readReviewDimensions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	weights, err := readReviewDimensionWeights(ctx, dimensions)
+	if err != nil {
+		return nil, err
+	}
+
+	score := &CTIItemScore{CTIDataID: ctiDataID, Dimensions: map[string]float64{}}
+	totals := make(map[string]int)
+	counts := make(map[string]int)
+	for _, review := range reviews {
+		if review.Invalidated {
+			continue
+		}
+		for dimension, value := range reviewScores(review) {
+			totals[dimension] += value
+			counts[dimension]++
+		}
+		score.ReviewCount++
+	}
+
+	var weightedTotal, totalWeight float64
+	for _, dimension := range dimensions {
+		// Leave the mean at zero rather than dividing by a zero review count
+		if counts[dimension] == 0 {
+			score.Dimensions[dimension] = 0
+			continue
+		}
+		mean := float64(totals[dimension]) / float64(counts[dimension])
+		score.Dimensions[dimension] = mean
+		weightedTotal += mean * weights[dimension]
+		totalWeight += weights[dimension]
+	}
+	if totalWeight > 0 {
+		score.Overall = weightedTotal / totalWeight
+	}
+
+	score.Accuracy = score.Dimensions["Accuracy"]
+	score.Timeliness = score.Dimensions["Timeliness"]
+	score.Completeness = score.Dimensions["Completeness"]
+	score.Consistency = score.Dimensions["Consistency"]
+
+	return score, nil
+}
+
+// averageCompositeScore returns the mean composite score of a set of reviews
+func averageCompositeScore(reviews []*ReviewData) float64 {
+	if len(reviews) == 0 {
+		return 0
+	}
+
+	var total float64
+	for _, review := range reviews {
+		total += compositeScore(review)
+	}
+	return total / float64(len(reviews))
+}
+
+// recordTypeForKey returns the record type stored under a ledger key, or an empty string
+// for keys that do not hold CTI, user or review records
+func recordTypeForKey(key string) string {
+	for recordType, prefix := range recordKeyPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return recordType
+		}
+	}
+	return ""
+}
+
+// ExportAll returns a page of every CTI, user and review record on the ledger as newline-delimited
+// JSON, each line tagged with its record type. Pass the returned bookmark to fetch the next page;
+// an empty bookmark marks the end of the export. Counter keys are skipped, so a page may hold fewer
+// than pageSize lines. Records are exported unredacted, so only admins may export.
+func (cc *SmartContract) ExportAll(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*ExportPage, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("page size must be positive, got %d", pageSize)
+	}
+
+	iterator, metadata, err := ctx.GetStub().GetStateByRangeWithPagination("", "", pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ledger state: %v", err)
+	}
+	defer iterator.Close()
+
+	var records strings.Builder
+	for iterator.HasNext() {
+		item, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate over ledger state: %v", err)
+		}
+
+		recordType := recordTypeForKey(item.Key)
+		if recordType == "" {
+			continue
+		}
+
+		line, err := json.Marshal(ExportRecord{Type: recordType, Key: item.Key, Record: item.Value})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal export record %s: %v", item.Key, err)
+		}
+		records.Write(line)
+		records.WriteByte('\n')
+	}
+
+	page := &ExportPage{Records: records.String()}
+	if metadata != nil {
+		page.FetchedCount = metadata.FetchedRecordsCount
+		// A short page means the state has been exhausted
+		if metadata.FetchedRecordsCount == pageSize {
+			page.Bookmark = metadata.Bookmark
+		}
+	}
+
+	return page, nil
+}
+
+// ImportRecords restores newline-delimited, type-tagged records as produced by ExportAll. Each record
+// is validated before it is written; existing records are overwritten when replaceExisting is set and
+// skipped otherwise. CTI indexes are brought up to date with the imported records, and the review
+// aggregates of the affected items and the upload counts, review counts and reputations of the affected
+// users are recomputed.
+func (cc *SmartContract) ImportRecords(ctx contractapi.TransactionContextInterface, ndjson string, replaceExisting bool) (*ImportResult, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	result := &ImportResult{}
+	imported := newImportedRecords()
+	for lineNumber, line := range strings.Split(ndjson, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		var record ExportRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			return nil, fmt.Errorf("line %d: failed to unmarshal export record: %v", lineNumber+1, err)
+		}
+
+		prefix, ok := recordKeyPrefixes[record.Type]
+		if !ok {
+			return nil, fmt.Errorf("line %d: unknown record type %q", lineNumber+1, record.Type)
+		}
+		if !strings.HasPrefix(record.Key, prefix) {
+			return nil, fmt.Errorf("line %d: key %s does not match record type %s", lineNumber+1, record.Key, record.Type)
+		}
+		// Exports taken before CTI keys were zero-padded use un-padded keys
+		if record.Type == RecordTypeCTI {
+			record.Key = ctiKey(strings.TrimPrefix(record.Key, prefix))
+		}
+
+		existingJSON, err := ctx.GetStub().GetState(record.Key)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: failed to read %s: %v", lineNumber+1, record.Key, err)
+		}
+		if existingJSON != nil && !replaceExisting {
+			result.Skipped++
+			continue
+		}
+
+		var recordJSON []byte
+		switch record.Type {
+		case RecordTypeCTI:
+			var ctiItem CTIData
+			if err := json.Unmarshal(record.Record, &ctiItem); err != nil {
+				return nil, fmt.Errorf("line %d: invalid CTI data: %v", lineNumber+1, err)
+			}
+			if record.Key != ctiKey(ctiItem.ID) {
+				return nil, fmt.Errorf("line %d: CTI data ID %s does not match key %s", lineNumber+1, ctiItem.ID, record.Key)
+			}
+			if ctiItem.ID == "" {
+				return nil, fmt.Errorf("line %d: CTI data has no ID", lineNumber+1)
+			}
+			if err := validateImportedCTIItem(&ctiItem); err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNumber+1, err)
+			}
+
+			// Replace the index entries of the record being overwritten
+			if existingJSON != nil {
+				var existingItem CTIData
+				if err := json.Unmarshal(existingJSON, &existingItem); err != nil {
+					return nil, fmt.Errorf("line %d: failed to unmarshal existing CTI data: %v", lineNumber+1, err)
+				}
+				if err := deleteCTIIndexes(ctx, &existingItem); err != nil {
+					return nil, err
+				}
+				imported.users[existingItem.Uploader] = true
+			}
+			if err := putCTIIndexes(ctx, &ctiItem); err != nil {
+				return nil, err
+			}
+			imported.ctiItems[ctiItem.ID] = &ctiItem
+			imported.items[ctiItem.ID] = true
+			imported.users[ctiItem.Uploader] = true
+			recordJSON, err = json.Marshal(ctiItem)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: failed to marshal CTI data: %v", lineNumber+1, err)
+			}
+
+		case RecordTypeUser:
+			var userData UserData
+			if err := json.Unmarshal(record.Record, &userData); err != nil {
+				return nil, fmt.Errorf("line %d: invalid user data: %v", lineNumber+1, err)
+			}
+			if record.Key != fmt.Sprintf("UserData_%s", userData.ID) {
+				return nil, fmt.Errorf("line %d: user data ID %s does not match key %s", lineNumber+1, userData.ID, record.Key)
+			}
+			if err := validateUserBalances(userData.Points, userData.Balance); err != nil {
+				return nil, fmt.Errorf("line %d: %v", lineNumber+1, err)
+			}
+			imported.userData[userData.ID] = &userData
+			imported.users[userData.ID] = true
+			recordJSON, err = json.Marshal(userData)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: failed to marshal user data: %v", lineNumber+1, err)
+			}
+
+		case RecordTypeReview:
+			var review ReviewData
+			if err := json.Unmarshal(record.Record, &review); err != nil {
+				return nil, fmt.Errorf("line %d: invalid review data: %v", lineNumber+1, err)
+			}
+			if record.Key != reviewKey(review.ID) {
+				return nil, fmt.Errorf("line %d: review data ID %s does not match key %s", lineNumber+1, review.ID, record.Key)
+			}
+			if review.ID == "" {
+				return nil, fmt.Errorf("line %d: review data has no ID", lineNumber+1)
+			}
+			// Dimensions may have been reconfigured since the export, so only the score range is checked
+			for dimension, score := range reviewScores(&review) {
+				if score < MinReviewScore || score > MaxReviewScore {
+					return nil, fmt.Errorf("line %d: %s score %d is out of range: must be between %d and %d", lineNumber+1, dimension, score, MinReviewScore, MaxReviewScore)
+				}
+			}
+
+			// Drop the reviewer index entry of the review being overwritten
+			if existingJSON != nil {
+				var existingReview ReviewData
+				if err := json.Unmarshal(existingJSON, &existingReview); err != nil {
+					return nil, fmt.Errorf("line %d: failed to unmarshal existing review data: %v", lineNumber+1, err)
+				}
+				if err := deleteReviewIndex(ctx, &existingReview); err != nil {
+					return nil, err
+				}
+				imported.items[existingReview.CTIDataID] = true
+				imported.users[existingReview.UserDataID] = true
+			}
+			if err := putReviewIndex(ctx, &review); err != nil {
+				return nil, err
+			}
+			imported.reviews[review.ID] = &review
+			imported.items[review.CTIDataID] = true
+			imported.users[review.UserDataID] = true
+			recordJSON, err = json.Marshal(review)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: failed to marshal review data: %v", lineNumber+1, err)
+			}
+		}
+
+		if err := ctx.GetStub().PutState(record.Key, recordJSON); err != nil {
+			return nil, fmt.Errorf("line %d: failed to put %s on ledger: %v", lineNumber+1, record.Key, err)
+		}
+		result.Imported++
+	}
+
+	if err := cc.recomputeImportedAggregates(ctx, imported); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// importedRecords tracks what an import has written, since its own ledger reads do not see it, and
+// which CTI items and users it affected
+type importedRecords struct {
+	ctiItems map[string]*CTIData
+	reviews  map[string]*ReviewData
+	userData map[string]*UserData
+	items    map[string]bool
+	users    map[string]bool
+}
+
+// newImportedRecords returns an empty import record
+func newImportedRecords() *importedRecords {
+	return &importedRecords{
+		ctiItems: make(map[string]*CTIData),
+		reviews:  make(map[string]*ReviewData),
+		userData: make(map[string]*UserData),
+		items:    make(map[string]bool),
+		users:    make(map[string]bool),
+	}
+}
+
+// validateImportedCTIItem applies the checks AddCTIItem makes to a CTI item being imported, normalizing
+// its TLP marking, tags and techniques. Items recorded without a confidence keep unsetConfidence.
+func validateImportedCTIItem(ctiItem *CTIData) error {
+	if err := validateCID(ctiItem.CID); err != nil {
+		return err
+	}
+	if ctiItem.TLP != "" {
+		tlp, err := normalizeTLP(ctiItem.TLP)
+		if err != nil {
+			return err
+		}
+		ctiItem.TLP = tlp
+	}
+	if ctiItem.Confidence != unsetConfidence {
+		if err := validateConfidence(ctiItem.Confidence); err != nil {
+			return err
+		}
+	}
+	techniques, err := validateTechniques(ctiItem.Techniques)
+	if err != nil {
+		return err
+	}
+	ctiItem.Techniques = techniques
+	tags, err := validateTags(ctiItem.Tags)
+	if err != nil {
+		return err
+	}
+	ctiItem.Tags = tags
+	return nil
+}
+
+// recomputeImportedAggregates recalculates the review count and composite score of the CTI items an
+// import affected, and the upload count, authored review count, review points and reputation of the
+// affected users, from the ledger overlaid with the imported records
+func (cc *SmartContract) recomputeImportedAggregates(ctx contractapi.TransactionContextInterface, imported *importedRecords) error {
+	if len(imported.items) == 0 && len(imported.users) == 0 {
+		return nil
+	}
+
+	allCTIItems, err := cc.ctiData(ctx, true)
+	if err != nil {
+		return err
+	}
+	ctiItems := make(map[string]*CTIData)
+	for _, ctiItem := range allCTIItems {
+		ctiItems[ctiItem.ID] = ctiItem
+	}
+	for id, ctiItem := range imported.ctiItems {
+		ctiItems[id] = ctiItem
+	}
+
+	allReviewData, err := cc.reviewData(ctx, true)
+	if err != nil {
+		return err
+	}
+	var reviews []*ReviewData
+	for _, review := range allReviewData {
+		if _, ok := imported.reviews[review.ID]; !ok {
+			reviews = append(reviews, review)
+		}
+	}
+	for _, review := range imported.reviews {
+		reviews = append(reviews, review)
+	}
+
+	// Visit items and users in a fixed order so every peer endorses the same writes. The uploaders of
+	// affected items have their reputations recomputed along with the imported users.
+	for _, id := range sortedKeys(imported.items) {
+		ctiItem, ok := ctiItems[id]
+		if !ok {
+			continue
+		}
+		// Only items whose aggregates moved are rewritten, so imported items keep their timestamps
+		reviewCount, compositeScore := ctiItem.ReviewCount, ctiItem.CompositeScore
+		recomputeCTIReviewAggregates(ctiItem, reviews)
+		if ctiItem.ReviewCount != reviewCount || ctiItem.CompositeScore != compositeScore {
+			if err := putCTIItem(ctx, ctiItem); err != nil {
+				return err
+			}
+		}
+		if ctiItem.Uploader != "" {
+			imported.users[ctiItem.Uploader] = true
+		}
+	}
+
+	for _, userID := range sortedKeys(imported.users) {
+		userData, ok := imported.userData[userID]
+		if !ok {
+			userData, err = readUserData(ctx, userID)
+			if err != nil {
+				return err
+			}
+			if userData == nil {
+				continue
+			}
+		}
+
+		userData.UploadCount = 0
+		for _, ctiItem := range ctiItems {
+			if ctiItem.Uploader == userID && !ctiItem.Deleted {
+				userData.UploadCount++
+			}
+		}
+		userData.ReviewsAuthored = 0
+		userData.ReviewPoints = 0
+		for _, review := range reviews {
+			if review.UserDataID == userID {
+				userData.ReviewsAuthored++
+				userData.ReviewPoints += paidReviewerReward(review)
+			}
+		}
+		if err := recomputeReputation(ctx, userData, reviews, ctiItems); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sortedKeys returns the keys of a set in ascending order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// putCTIItem writes a CTI item to the ledger under its ID, stamping it with the time of the write so
+// SyncAccessibleCTIItems sees every change
+func putCTIItem(ctx contractapi.TransactionContextInterface, ctiItem *CTIData) error {
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	ctiItem.UpdatedAt = now
+
+	ctiItemJSON, err := json.Marshal(ctiItem)
+	if err != nil {
+		return fmt.Errorf("failed to marshal CTI item to JSON: %v", err)
+	}
+
+	if err := ctx.GetStub().PutState(ctiKey(ctiItem.ID), ctiItemJSON); err != nil {
+		return fmt.Errorf("failed to put CTI item on ledger: %v", err)
+	}
+
+	return nil
+}
+
+// RecordCIDAvailability records whether an oracle could retrieve a CTI item's content from IPFS
+func (cc *SmartContract) RecordCIDAvailability(ctx contractapi.TransactionContextInterface, id string, available bool) error {
+	permissions, err := callerPermissions(ctx)
+	if err != nil {
+		return err
+	}
+	if !permissions.CanRecordCIDAvailability {
+		return fmt.Errorf("caller is not authorized: oracle role required")
+	}
+
+	ctiItem, err := readCTIItem(ctx, id)
+	if err != nil {
+		return err
+	}
+	if ctiItem == nil {
+		return fmt.Errorf("CTI item with ID %s does not exist", id)
+	}
+
+	checkedAt, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctiItem.CIDAvailable = available
+	ctiItem.LastCheckedAt = checkedAt
+
+	return putCTIItem(ctx, ctiItem)
+}
+
+// GetCTIItemsPendingCIDCheck retrieves the CTI items whose CID availability has never been recorded,
+// newest first, so the oracle can work through fresh uploads promptly
+func (cc *SmartContract) GetCTIItemsPendingCIDCheck(ctx contractapi.TransactionContextInterface) ([]*CTIData, error) {
+	permissions, err := callerPermissions(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	allCTIItems, err := cc.ctiData(ctx, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all CTI data entries: %v", err)
+	}
+
+	var pending []*CTIData
+	for _, ctiItem := range allCTIItems {
+		if ctiItem.LastCheckedAt == 0 {
+			pending = append(pending, ctiItem)
+		}
+	}
+
+	sort.SliceStable(pending, func(i, j int) bool {
+		return pending[i].Timestamp > pending[j].Timestamp
+	})
+
+	// Oracles need the CIDs to check, but never the encryption keys; everyone else is redacted as usual
+	if !permissions.IsOracle {
+		return redactForCaller(ctx, pending)
+	}
+	for i, ctiItem := range pending {
+		withoutKey := *ctiItem
+		withoutKey.EncryptKey = ""
+		pending[i] = &withoutKey
+	}
+	return pending, nil
+}
+
+// GetCTIItemsNeedingReReview returns the CTI items whose content changed after they were reviewed and
+// that have not been reviewed since, newest first
+func (cc *SmartContract) GetCTIItemsNeedingReReview(ctx contractapi.TransactionContextInterface) ([]*CTIData, error) {
+	allCTIItems, err := cc.GetAllCTIItems(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all CTI data entries: %v", err)
+	}
+
+	var stale []*CTIData
+	for _, ctiItem := range allCTIItems {
+		if ctiItem.NeedsReReview {
+			stale = append(stale, ctiItem)
+		}
+	}
+
+	sort.SliceStable(stale, func(i, j int) bool {
+		return stale[i].Timestamp > stale[j].Timestamp
+	})
+
+	return stale, nil
+}
+
+// GetReviewText retrieves the text of a review. The text of a private review can only be read by
+// its reviewer or by the uploader of the reviewed CTI item.
+func (cc *SmartContract) GetReviewText(ctx contractapi.TransactionContextInterface, reviewID string) (string, error) {
+	reviewJSON, err := ctx.GetStub().GetState(reviewKey(reviewID))
+	if err != nil {
+		return "", fmt.Errorf("failed to read review data: %v", err)
+	}
+	if reviewJSON == nil {
+		return "", fmt.Errorf("review with ID %s does not exist", reviewID)
+	}
+
+	var review ReviewData
+	if err := json.Unmarshal(reviewJSON, &review); err != nil {
+		return "", fmt.Errorf("failed to unmarshal review data: %v", err)
+	}
+	if !review.PrivateText {
+		return review.ReviewText, nil
+	}
+
+	caller, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return "", fmt.Errorf("failed to get current peer ID: %v", err)
+	}
+	if caller != review.UserDataID {
+		ctiItem, err := readCTIItem(ctx, review.CTIDataID)
+		if err != nil {
+			return "", err
+		}
+		if ctiItem == nil || caller != ctiItem.Uploader {
+			return "", fmt.Errorf("caller is not authorized to read the text of review %s", reviewID)
+		}
+	}
+
+	reviewText, err := ctx.GetStub().GetPrivateData(reviewTextCollection, reviewID)
+	if err != nil {
+		return "", fmt.Errorf("failed to read review text from private data collection: %v", err)
+	}
+	if reviewText == nil {
+		return "", fmt.Errorf("text of review %s is not available on this peer", reviewID)
+	}
+
+	return string(reviewText), nil
+}
+
+// SyncAccessibleCTIItems returns a page of the CTI items the caller is entitled to that were created or
+// modified at or after sinceTs, including their encryption keys, so a client cache can be kept current.
+// Deleted items are returned too, without their CID and key, so the cache can drop them. Keys held in
+// the CTI key collection are included when this peer holds them; otherwise they are left empty and must
+// be fetched with GetCTIItemKey on a member peer.
+// Entitlement and modification filters are applied after paging, so a page may hold fewer than pageSize
+// items; an empty bookmark marks the last page.
+func (cc *SmartContract) SyncAccessibleCTIItems(ctx contractapi.TransactionContextInterface, sinceTs int64, pageSize int32, bookmark string) (*CTIItemsPage, error) {
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("page size must be positive, got %d", pageSize)
+	}
+
+	access, err := newAccessContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	iterator, metadata, err := ctx.GetStub().GetStateByRangeWithPagination(ctiRangeStart, ctiRangeEnd, pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get CTI data range: %v", err)
+	}
+	defer iterator.Close()
+
+	page := &CTIItemsPage{Items: []*CTIData{}}
+	for iterator.HasNext() {
+		item, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate over CTI data range: %v", err)
+		}
+
+		var ctiItem CTIData
+		if err := json.Unmarshal(item.Value, &ctiItem); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal CTI data: %v", err)
+		}
+		modifiedAt := ctiCreatedAt(&ctiItem)
+		if ctiItem.UpdatedAt > modifiedAt {
+			modifiedAt = ctiItem.UpdatedAt
+		}
+		if modifiedAt < sinceTs || !access.canAccess(&ctiItem) {
+			continue
+		}
+
+		if ctiItem.Deleted {
+			page.Items = append(page.Items, redactCTIItem(&ctiItem))
+			continue
+		}
+		if ctiItem.PrivateKey {
+			// Peers outside the collection cannot read the key, which is not an error for the page
+			encryptKey, err := ctx.GetStub().GetPrivateData(ctiKeyCollection, ctiItem.ID)
+			if err == nil && encryptKey != nil {
+				ctiItem.EncryptKey = string(encryptKey)
+			}
+		}
+		page.Items = append(page.Items, &ctiItem)
+	}
+
+	if metadata != nil {
+		page.FetchedCount = metadata.FetchedRecordsCount
+		if metadata.FetchedRecordsCount == pageSize {
+			page.Bookmark = metadata.Bookmark
+		}
+	}
+
+	return page, nil
+}
+
+// AdoptCTIItem reassigns an anonymized CTI item to the caller, who must be an admin or an org lead
+func (cc *SmartContract) AdoptCTIItem(ctx contractapi.TransactionContextInterface, id string) error {
+	permissions, err := callerPermissions(ctx)
+	if err != nil {
+		return err
+	}
+	if !permissions.CanAdoptItems {
+		return fmt.Errorf("caller is not authorized: admin or org lead role required")
+	}
+
+	caller, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get current peer ID: %v", err)
+	}
+
+	ctiItem, err := readCTIItem(ctx, id)
+	if err != nil {
+		return err
+	}
+	if ctiItem == nil {
+		return fmt.Errorf("CTI item with ID %s does not exist", id)
+	}
+	if ctiItem.Uploader != AnonymizedUploader {
+		return fmt.Errorf("CTI item with ID %s is not anonymized and cannot be adopted", id)
+	}
+
+	// Move the item from the anonymized uploader's index entries to the caller's
+	if err := deleteCTIIndexes(ctx, ctiItem); err != nil {
+		return err
+	}
+	ctiItem.Uploader = caller
+	if err := putCTIIndexes(ctx, ctiItem); err != nil {
+		return err
+	}
+	if err := appendChangeLog(ctx, id, "adopted", "anonymized item adopted by a new uploader"); err != nil {
+		return err
+	}
+
+	return putCTIItem(ctx, ctiItem)
+}
+
+// GetReviewerAgreementMatrix compares every pair of valid reviews of a CTI item, reporting the mean
+// absolute difference across the quality dimensions and the difference in composite score
+func (cc *SmartContract) GetReviewerAgreementMatrix(ctx contractapi.TransactionContextInterface, ctiDataID string) ([]*ReviewerAgreement, error) {
+	reviews, err := cc.GetReviewDataByCTIDataID(ctx, ctiDataID)
+	if err != nil {
+		return nil, err
+	}
+
+	var validReviews []*ReviewData
+	for _, review := range reviews {
+		if !review.Invalidated {
+			validReviews = append(validReviews, review)
+		}
+	}
+	if len(validReviews) < minAgreementReviews {
+		return nil, fmt.Errorf("CTI item %s has %d reviews, at least %d are needed to compare reviewers", ctiDataID, len(validReviews), minAgreementReviews)
+	}
+
+	var matrix []*ReviewerAgreement
+	for i := 0; i < len(validReviews); i++ {
+		for j := i + 1; j < len(validReviews); j++ {
+			a, b := validReviews[i], validReviews[j]
+			// Compare the dimensions both reviews were scored on
+			scoresA, scoresB := reviewScores(a), reviewScores(b)
+			difference, shared := 0.0, 0
+			for dimension, scoreA := range scoresA {
+				if scoreB, ok := scoresB[dimension]; ok {
+					difference += math.Abs(float64(scoreA - scoreB))
+					shared++
+				}
+			}
+			if shared > 0 {
+				difference /= float64(shared)
+			}
+
+			matrix = append(matrix, &ReviewerAgreement{
+				ReviewerA:              a.UserDataID,
+				ReviewerB:              b.UserDataID,
+				ReviewIDA:              a.ID,
+				ReviewIDB:              b.ID,
+				MeanAbsoluteDifference: difference,
+				CompositeDifference:    math.Abs(compositeScore(a) - compositeScore(b)),
+			})
+		}
+	}
+
+	return matrix, nil
+}
+
+// Ping confirms the chaincode is installed and responsive, returning the contract version and the
+// transaction timestamp. It reads and writes no state.
+func (cc *SmartContract) Ping(ctx contractapi.TransactionContextInterface) (string, error) {
+	ts, err := ctx.GetStub().GetTxTimestamp()
+	if err != nil {
+		return "", fmt.Errorf("failed to get transaction timestamp: %v", err)
+	}
+	return fmt.Sprintf("cti %s %s", ContractVersion, ts.AsTime().UTC().Format(time.RFC3339Nano)), nil
+}
+
+// GetContractVersion returns the contract version and the record schema version it writes
+func (cc *SmartContract) GetContractVersion(ctx contractapi.TransactionContextInterface) (*VersionInfo, error) {
+	return &VersionInfo{ContractVersion: ContractVersion, SchemaVersion: SchemaVersion}, nil
+}
+
+// MigrateSchema upgrades every CTI, user and review record written under an older schema version to the
+// current one, filling fields introduced since with their defaults. It returns the number of records migrated.
+func (cc *SmartContract) MigrateSchema(ctx contractapi.TransactionContextInterface) (int, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return 0, err
+	}
+
+	// Reviews are needed to fill in the cached review aggregates of older CTI items and the reward
+	// status of older reviews
+	allReviewData, err := cc.reviewData(ctx, true)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get all review data entries: %v", err)
+	}
+	rewardable := rewardableReviews(allReviewData)
+
+	iterator, err := ctx.GetStub().GetStateByRange("", "")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read ledger state: %v", err)
+	}
+	defer iterator.Close()
+
+	migrated := 0
+	for iterator.HasNext() {
+		item, err := iterator.Next()
+		if err != nil {
+			return 0, fmt.Errorf("failed to iterate over ledger state: %v", err)
+		}
+
+		var record interface{}
+		switch recordTypeForKey(item.Key) {
+		case RecordTypeCTI:
+			var ctiItem CTIData
+			if err := json.Unmarshal(item.Value, &ctiItem); err != nil {
+				return 0, fmt.Errorf("failed to unmarshal CTI data %s: %v", item.Key, err)
+			}
+			if ctiItem.SchemaVersion >= SchemaVersion {
+				continue
+			}
+			if ctiItem.SchemaVersion < 2 {
+				recomputeCTIReviewAggregates(&ctiItem, allReviewData)
+			}
+			ctiItem.SchemaVersion = SchemaVersion
+			record = ctiItem
+		case RecordTypeUser:
+			var userData UserData
+			if err := json.Unmarshal(item.Value, &userData); err != nil {
+				return 0, fmt.Errorf("failed to unmarshal user data %s: %v", item.Key, err)
+			}
+			if userData.SchemaVersion >= SchemaVersion {
+				continue
+			}
+			userData.SchemaVersion = SchemaVersion
+			record = userData
+		case RecordTypeReview:
+			var review ReviewData
+			if err := json.Unmarshal(item.Value, &review); err != nil {
+				return 0, fmt.Errorf("failed to unmarshal review data %s: %v", item.Key, err)
+			}
+			if review.SchemaVersion >= SchemaVersion {
+				continue
+			}
+			if review.SchemaVersion < 3 {
+				review.RewardPaid = rewardable[review.ID]
+			}
+			if review.SchemaVersion < 5 && review.RewardPaid {
+				review.RewardAmount = defaultReviewerReward
+			}
+			review.SchemaVersion = SchemaVersion
+			record = review
+		default:
+			continue
+		}
+
+		recordJSON, err := json.Marshal(record)
+		if err != nil {
+			return 0, fmt.Errorf("failed to marshal migrated record %s: %v", item.Key, err)
+		}
+		if err := ctx.GetStub().PutState(item.Key, recordJSON); err != nil {
+			return 0, fmt.Errorf("failed to put migrated record %s on ledger: %v", item.Key, err)
+		}
+		migrated++
+	}
+
+	return migrated, nil
+}
+
+// validateTags normalizes tags and checks each has the permitted format
+func validateTags(tags []string) ([]string, error) {
+	normalized := normalizeTags(tags)
+	for _, tag := range normalized {
+		if !tagPattern.MatchString(tag) {
+			return nil, fmt.Errorf("invalid tag %q: tags must be lower-case letters, digits, '.', '_' or '-' and at most 64 characters", tag)
+		}
+	}
+	return normalized, nil
+}
+
+// validateConfidence checks that an uploader's confidence in a CTI item is a percentage
+func validateConfidence(confidence int) error {
+	if confidence < 0 || confidence > 100 {
+		return fmt.Errorf("confidence must be between 0 and 100, got %d", confidence)
+	}
+	return nil
+}
+
+// GetCTIItemsAboveConfidence returns the CTI items whose uploader asserted a confidence of at least
+// threshold. Items recorded without a confidence are left out.
+func (cc *SmartContract) GetCTIItemsAboveConfidence(ctx contractapi.TransactionContextInterface, threshold int) ([]*CTIData, error) {
+	if err := validateConfidence(threshold); err != nil {
+		return nil, err
+	}
+
+	allCTIItems, err := cc.GetAllCTIItems(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all CTI data entries: %v", err)
+	}
+
+	ctiItems := []*CTIData{}
+	for _, ctiItem := range allCTIItems {
+		if ctiItem.Confidence != unsetConfidence && ctiItem.Confidence >= threshold {
+			ctiItems = append(ctiItems, ctiItem)
+		}
+	}
+
+	return ctiItems, nil
+}
+
+// validateTechniques upper-cases and de-duplicates ATT&CK technique IDs, rejecting any that are not
+// of the form T#### or T####.###
+func validateTechniques(techniques []string) ([]string, error) {
+	var normalized []string
+	for _, technique := range techniques {
+		technique = strings.ToUpper(strings.TrimSpace(technique))
+		if !techniquePattern.MatchString(technique) {
+			return nil, fmt.Errorf("invalid ATT&CK technique ID %q: expected T#### or T####.###", technique)
+		}
+		if !containsString(normalized, technique) {
+			normalized = append(normalized, technique)
+		}
+	}
+	return normalized, nil
+}
+
+// GetCTIItemsByTechnique returns the CTI items tagged with an ATT&CK technique. Querying a technique
+// also returns items tagged with its sub-techniques; querying a sub-technique returns only its own.
+func (cc *SmartContract) GetCTIItemsByTechnique(ctx contractapi.TransactionContextInterface, techniqueID string) ([]*CTIData, error) {
+	techniques, err := validateTechniques([]string{techniqueID})
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := indexedCTIIDs(ctx, techniqueIndex, techniques[0])
+	if err != nil {
+		return nil, err
+	}
+
+	ctiItems := []*CTIData{}
+	for _, id := range ids {
+		ctiItem, err := readCTIItem(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if ctiItem != nil {
+			ctiItems = append(ctiItems, ctiItem)
+		}
+	}
+
+	return redactForCaller(ctx, ctiItems)
+}
+
+// parseIndicators decodes a JSON array of indicators and checks each value against its type. An empty
+// string means no indicators.
+func parseIndicators(indicatorsJSON string) ([]Indicator, error) {
+	if strings.TrimSpace(indicatorsJSON) == "" {
+		return nil, nil
+	}
+
+	var indicators []Indicator
+	if err := json.Unmarshal([]byte(indicatorsJSON), &indicators); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal indicators: %v", err)
+	}
+	for i := range indicators {
+		normalized, err := normalizeIndicator(indicators[i])
+		if err != nil {
+			return nil, fmt.Errorf("indicator %d: %v", i, err)
+		}
+		indicators[i] = normalized
+	}
+	return indicators, nil
+}
+
+// normalizeIndicator checks an indicator's value against its type, lower-casing the type and any
+// case-insensitive value so equal indicators are indexed alike
+func normalizeIndicator(indicator Indicator) (Indicator, error) {
+	indicator.Type = strings.ToLower(strings.TrimSpace(indicator.Type))
+	indicator.Value = strings.TrimSpace(indicator.Value)
+	if indicator.Value == "" {
+		return indicator, fmt.Errorf("indicator must have a value")
+	}
+
+	switch indicator.Type {
+	case "ipv4":
+		ip := net.ParseIP(indicator.Value)
+		if ip == nil || ip.To4() == nil || strings.Contains(indicator.Value, ":") {
+			return indicator, fmt.Errorf("invalid ipv4 indicator %q: expected a dotted-quad address", indicator.Value)
+		}
+	case "domain":
+		indicator.Value = strings.ToLower(strings.TrimSuffix(indicator.Value, "."))
+		if len(indicator.Value) > 253 || !domainPattern.MatchString(indicator.Value) {
+			return indicator, fmt.Errorf("invalid domain indicator %q", indicator.Value)
+		}
+	case "md5":
+		indicator.Value = strings.ToLower(indicator.Value)
+		if !md5HexPattern.MatchString(indicator.Value) {
+			return indicator, fmt.Errorf("invalid md5 indicator %q: expected 32 hex characters", indicator.Value)
+		}
+	case "sha256":
+		indicator.Value = strings.ToLower(indicator.Value)
+		if !sha256HexPattern.MatchString(indicator.Value) {
+			return indicator, fmt.Errorf("invalid sha256 indicator %q: expected 64 hex characters", indicator.Value)
+		}
+	case "url":
+		parsed, err := url.Parse(indicator.Value)
+		if err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			return indicator, fmt.Errorf("invalid url indicator %q: expected an absolute URL", indicator.Value)
+		}
+	default:
+		return indicator, fmt.Errorf("unknown indicator type %q: must be one of %s", indicator.Type, strings.Join(indicatorTypes, ", "))
+	}
+
+	return indicator, nil
+}
+
+// GetCTIItemsByIndicatorValue returns the CTI items referencing an indicator value. Hashes and domains
+// match regardless of case.
+func (cc *SmartContract) GetCTIItemsByIndicatorValue(ctx contractapi.TransactionContextInterface, value string) ([]*CTIData, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, fmt.Errorf("indicator value must not be empty")
+	}
+
+	// Case-insensitive values are indexed lower-cased; others, such as URLs, as given
+	candidates := []string{value}
+	if lower := strings.ToLower(value); lower != value {
+		candidates = append(candidates, lower)
+	}
+
+	seen := make(map[string]bool)
+	ctiItems := []*CTIData{}
+	for _, candidate := range candidates {
+		ids, err := indexedCTIIDs(ctx, indicatorIndex, candidate)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+
+			ctiItem, err := readCTIItem(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			if ctiItem != nil {
+				ctiItems = append(ctiItems, ctiItem)
+			}
+		}
+	}
+
+	return redactForCaller(ctx, ctiItems)
+}
+
+// checkSeverityRequirements enforces the metadata required at each severity. High and critical items
+// must carry tags, at least one indicator and a description; lower or unset severities need none.
+func checkSeverityRequirements(severity string, description string, tags []string, indicators []Indicator) error {
+	if severity != "" && !containsString(severityLevels, severity) {
+		return fmt.Errorf("invalid severity %q: must be one of %s", severity, strings.Join(severityLevels, ", "))
+	}
+	if severity != "high" && severity != "critical" {
+		return nil
+	}
+
+	if len(tags) == 0 {
+		return fmt.Errorf("tags are required for %s severity CTI items", severity)
+	}
+	if len(indicators) == 0 {
+		return fmt.Errorf("at least one indicator is required for %s severity CTI items", severity)
+	}
+	if strings.TrimSpace(description) == "" {
+		return fmt.Errorf("a description is required for %s severity CTI items", severity)
+	}
+	return nil
+}
+
+// normalizeTags lower-cases and trims tags, dropping empty and duplicate ones
+func normalizeTags(tags []string) []string {
+	seen := make(map[string]bool)
+	var normalized []string
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+// GetTagFacets returns the number of CTI items carrying each tag, read from the tag index
+func (cc *SmartContract) GetTagFacets(ctx contractapi.TransactionContextInterface) (map[string]int, error) {
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(tagIndex, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s index: %v", tagIndex, err)
+	}
+	defer iterator.Close()
+
+	facets := make(map[string]int)
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate over %s index: %v", tagIndex, err)
+		}
+
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(entry.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to split %s index key: %v", tagIndex, err)
+		}
+		if len(attributes) != 2 {
+			continue
+		}
+		facets[attributes[0]]++
+	}
+
+	return facets, nil
+}
+
+// readReview loads a review from the ledger by its ID, returning nil if none exists
+func readReview(ctx contractapi.TransactionContextInterface, reviewID string) (*ReviewData, error) {
+	reviewJSON, err := ctx.GetStub().GetState(reviewKey(reviewID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read review data: %v", err)
+	}
+	if reviewJSON == nil {
+		return nil, nil
+	}
+
+	var review ReviewData
+	if err := json.Unmarshal(reviewJSON, &review); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal review data: %v", err)
+	}
+
+	return &review, nil
+}
+
+// putReview writes a review to the ledger under its ID
+func putReview(ctx contractapi.TransactionContextInterface, review *ReviewData) error {
+	reviewJSON, err := json.Marshal(review)
+	if err != nil {
+		return fmt.Errorf("failed to marshal review data to JSON: %v", err)
+	}
+
+	if err := ctx.GetStub().PutState(reviewKey(review.ID), reviewJSON); err != nil {
+		return fmt.Errorf("failed to put review data on ledger: %v", err)
+	}
+
+	return nil
+}
+
+// VoteReviewHelpful records whether the caller found a review helpful. Each identity holds one vote
+// per review; voting again with the opposite opinion changes the vote.
+func (cc *SmartContract) VoteReviewHelpful(ctx contractapi.TransactionContextInterface, reviewID string, helpful bool) error {
+	voter, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get current peer ID: %v", err)
+	}
+
+	review, err := readReview(ctx, reviewID)
+	if err != nil {
+		return err
+	}
+	if review == nil {
+		return fmt.Errorf("review with ID %s does not exist", reviewID)
+	}
+	if review.UserDataID == voter {
+		return fmt.Errorf("reviewers cannot vote on their own review")
+	}
+
+	voteKey, err := ctx.GetStub().CreateCompositeKey(voteIndex, []string{reviewID, voter})
+	if err != nil {
+		return fmt.Errorf("failed to create %s key: %v", voteIndex, err)
+	}
+	previousVote, err := ctx.GetStub().GetState(voteKey)
+	if err != nil {
+		return fmt.Errorf("failed to read previous vote: %v", err)
+	}
+
+	vote := []byte("0")
+	if helpful {
+		vote = []byte("1")
+	}
+
+	// Withdraw the previous vote before counting the new one
+	if previousVote != nil {
+		if string(previousVote) == string(vote) {
+			return fmt.Errorf("caller has already voted on review %s", reviewID)
+		}
+		if string(previousVote) == "1" {
+			review.HelpfulCount--
+		} else {
+			review.UnhelpfulCount--
+		}
+	}
+	if helpful {
+		review.HelpfulCount++
+	} else {
+		review.UnhelpfulCount++
+	}
+
+	if err := ctx.GetStub().PutState(voteKey, vote); err != nil {
+		return fmt.Errorf("failed to put vote on ledger: %v", err)
+	}
+
+	return putReview(ctx, review)
+}
+
+// GetReviewsByCTISortedByHelpfulness retrieves the reviews of a CTI item, most helpful first
+func (cc *SmartContract) GetReviewsByCTISortedByHelpfulness(ctx contractapi.TransactionContextInterface, ctiDataID string) ([]*ReviewData, error) {
+	reviews, err := cc.GetReviewDataByCTIDataID(ctx, ctiDataID)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(reviews, func(i, j int) bool {
+		if reviews[i].HelpfulCount != reviews[j].HelpfulCount {
+			return reviews[i].HelpfulCount > reviews[j].HelpfulCount
+		}
+		return reviews[i].UnhelpfulCount < reviews[j].UnhelpfulCount
+	})
+
+	return reviews, nil
+}
+
+// GetMyAccessibleCount returns the number of CTI items the caller is entitled to and the total number of items
+func (cc *SmartContract) GetMyAccessibleCount(ctx contractapi.TransactionContextInterface) (*AccessibleCount, error) {
+	access, err := newAccessContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	allCTIItems, err := cc.GetAllCTIItems(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all CTI data entries: %v", err)
+	}
+
+	count := &AccessibleCount{Total: len(allCTIItems)}
+	for _, ctiItem := range allCTIItems {
+		if access.canAccess(ctiItem) {
+			count.Accessible++
+		}
+	}
+
+	return count, nil
+}
+
+// checkOrgQuota returns an error if uploading count more items would exceed the organisation's quota.
+// Organisations without a quota are not counted, so their uploads do not read the organisation index.
+func checkOrgQuota(ctx contractapi.TransactionContextInterface, mspID string, count int) error {
+	if mspID == "" {
+		return nil
+	}
+	maxItems, err := readCounter(ctx, fmt.Sprintf("OrgQuota_%s", mspID), -1)
+	if err != nil {
+		return err
+	}
+	if maxItems < 0 {
+		return nil
+	}
+
+	usage, err := orgQuotaUsage(ctx, mspID)
+	if err != nil {
+		return err
+	}
+	if usage.Used+count > usage.MaxItems {
+		return fmt.Errorf("org quota exceeded: organisation %s has uploaded %d of %d items", mspID, usage.Used, usage.MaxItems)
+	}
+	return nil
+}
+
+// orgQuotaUsage reads an organisation's upload quota and counts its entries in the organisation index.
+// Each item has its own entry, so concurrent uploads do not contend on a shared counter. Items written
+// before the organisation index existed are counted once RebuildIndexes has run.
+func orgQuotaUsage(ctx contractapi.TransactionContextInterface, mspID string) (*OrgQuotaUsage, error) {
+	maxItems, err := readCounter(ctx, fmt.Sprintf("OrgQuota_%s", mspID), -1)
+	if err != nil {
+		return nil, err
+	}
+
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(orgIndex, []string{mspID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get organisation index entries: %v", err)
+	}
+	defer iterator.Close()
+
+	used := 0
+	for iterator.HasNext() {
+		if _, err := iterator.Next(); err != nil {
+			return nil, fmt.Errorf("failed to iterate over organisation index entries: %v", err)
+		}
+		used++
+	}
+	return &OrgQuotaUsage{MSPID: mspID, MaxItems: maxItems, Used: used}, nil
+}
+
+// SetOrgQuota limits the number of CTI items an organisation may have on the ledger
+func (cc *SmartContract) SetOrgQuota(ctx contractapi.TransactionContextInterface, mspID string, maxItems int) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+	if mspID == "" {
+		return fmt.Errorf("MSP ID must not be empty")
+	}
+	if maxItems < 0 {
+		return fmt.Errorf("quota must not be negative, got %d", maxItems)
+	}
+
+	if err := ctx.GetStub().PutState(fmt.Sprintf("OrgQuota_%s", mspID), []byte(strconv.Itoa(maxItems))); err != nil {
+		return fmt.Errorf("failed to put quota of organisation %s on ledger: %v", mspID, err)
+	}
+	return nil
+}
+
+// GetOrgQuotaUsage returns an organisation's upload quota and the number of items counted against it
+func (cc *SmartContract) GetOrgQuotaUsage(ctx contractapi.TransactionContextInterface, mspID string) (*OrgQuotaUsage, error) {
+	if mspID == "" {
+		return nil, fmt.Errorf("MSP ID must not be empty")
+	}
+	return orgQuotaUsage(ctx, mspID)
+}
+
+// GetReviewStatsByOrg groups the valid reviews of a CTI item by the reviewer's organisation and returns
+// each organisation's average scores. Reviews recorded before reviewer organisations were tracked are
+// grouped under "unknown".
+func (cc *SmartContract) GetReviewStatsByOrg(ctx contractapi.TransactionContextInterface, ctiDataID string) ([]*OrgReviewStats, error) {
+	reviews, err := cc.GetReviewDataByCTIDataID(ctx, ctiDataID)
+	if err != nil {
+		return nil, err
+	}
+
+	statsByOrg := make(map[string]*OrgReviewStats)
+	for _, review := range reviews {
+		if review.Invalidated {
+			continue
+		}
+
+		mspID := review.ReviewerMSP
+		if mspID == "" {
+			mspID = "unknown"
+		}
+		stats, ok := statsByOrg[mspID]
+		if !ok {
+			stats = &OrgReviewStats{MSPID: mspID}
+			statsByOrg[mspID] = stats
+		}
+
+		// Accumulate totals, averaged once every review is counted
+		stats.ReviewCount++
+		stats.AverageAccuracy += float64(review.Accuracy)
+		stats.AverageTimeliness += float64(review.Timeliness)
+		stats.AverageCompleteness += float64(review.Completeness)
+		stats.AverageConsistency += float64(review.Consistency)
+		stats.AverageComposite += compositeScore(review)
+	}
+
+	allStats := []*OrgReviewStats{}
+	for _, stats := range statsByOrg {
+		count := float64(stats.ReviewCount)
+		stats.AverageAccuracy /= count
+		stats.AverageTimeliness /= count
+		stats.AverageCompleteness /= count
+		stats.AverageConsistency /= count
+		stats.AverageComposite /= count
+		allStats = append(allStats, stats)
+	}
+
+	sort.Slice(allStats, func(i, j int) bool {
+		return allStats[i].MSPID < allStats[j].MSPID
+	})
+
+	return allStats, nil
+}
+
+// AddTagsToCTIItems appends tags to each listed CTI item and updates the tag index. Tags are normalized
+// and deduplicated. Items that do not exist or that the caller neither uploaded nor administers are
+// skipped and reported rather than failing the whole transaction.
+func (cc *SmartContract) AddTagsToCTIItems(ctx contractapi.TransactionContextInterface, ids []string, tags []string) (*TagUpdateResult, error) {
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("at least one CTI item ID is required")
+	}
+	if len(ids) > maxBulkTagItems {
+		return nil, fmt.Errorf("at most %d CTI items can be tagged at once, got %d", maxBulkTagItems, len(ids))
+	}
+
+	newTags, err := validateTags(tags)
+	if err != nil {
+		return nil, err
+	}
+	if len(newTags) == 0 {
+		return nil, fmt.Errorf("at least one tag is required")
+	}
+
+	caller, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current peer ID: %v", err)
+	}
+	admin, err := isAdmin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &TagUpdateResult{Tagged: []string{}, Skipped: map[string]string{}}
+	for _, id := range ids {
+		ctiItem, err := readCTIItem(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if ctiItem == nil {
+			result.Skipped[id] = "CTI item does not exist"
+			continue
+		}
+		if !admin && ctiItem.Uploader != caller {
+			result.Skipped[id] = "caller is not the uploader of the CTI item"
+			continue
+		}
+
+		// Re-index the item under its extended tag set
+		if err := deleteCTIIndexes(ctx, ctiItem); err != nil {
+			return nil, err
+		}
+		ctiItem.Tags = normalizeTags(append(ctiItem.Tags, newTags...))
+		if err := putCTIIndexes(ctx, ctiItem); err != nil {
+			return nil, err
+		}
+		if err := putCTIItem(ctx, ctiItem); err != nil {
+			return nil, err
+		}
+		if err := appendChangeLog(ctx, id, "tagged", "tags added: "+strings.Join(newTags, ", ")); err != nil {
+			return nil, err
+		}
+
+		result.Tagged = append(result.Tagged, id)
+	}
+
+	return result, nil
+}
+
+// linkKey returns the linkIndex key recording a relationship from one CTI item to another
+func linkKey(ctx contractapi.TransactionContextInterface, link *CTILink) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(linkIndex, []string{link.From, link.Relationship, link.To})
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s index key: %v", linkIndex, err)
+	}
+	return key, nil
+}
+
+// linkedKey returns the linkedIndex key recording a relationship from one CTI item to another
+func linkedKey(ctx contractapi.TransactionContextInterface, link *CTILink) (string, error) {
+	key, err := ctx.GetStub().CreateCompositeKey(linkedIndex, []string{link.To, link.Relationship, link.From})
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s index key: %v", linkedIndex, err)
+	}
+	return key, nil
+}
+
+// LinkCTIItems records that a CTI item supersedes or is related to another. Only the item's uploader or
+// an admin may link it, and both items must exist.
+func (cc *SmartContract) LinkCTIItems(ctx contractapi.TransactionContextInterface, id string, targetID string, relationship string) error {
+	if !containsString(linkRelationships, relationship) {
+		return fmt.Errorf("invalid relationship %q, must be one of %v", relationship, linkRelationships)
+	}
+	if id == targetID {
+		return fmt.Errorf("CTI item %s cannot be linked to itself", id)
+	}
+	if _, err := readOwnedCTIItem(ctx, id); err != nil {
+		return err
+	}
+	target, err := readCTIItem(ctx, targetID)
+	if err != nil {
+		return err
+	}
+	if target == nil || target.Deleted {
+		return fmt.Errorf("CTI item with ID %s does not exist", targetID)
+	}
+
+	link := &CTILink{From: id, To: targetID, Relationship: relationship}
+	key, err := linkKey(ctx, link)
+	if err != nil {
+		return err
+	}
+	reverseKey, err := linkedKey(ctx, link)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().PutState(key, []byte{0x00}); err != nil {
+		return fmt.Errorf("failed to put CTI link: %v", err)
+	}
+	if err := ctx.GetStub().PutState(reverseKey, []byte{0x00}); err != nil {
+		return fmt.Errorf("failed to put CTI link: %v", err)
+	}
+
+	return appendChangeLog(ctx, id, "linked", fmt.Sprintf("%s %s", relationship, targetID))
+}
+
+// UnlinkCTIItems removes a link made with LinkCTIItems. Only the item's uploader or an admin may unlink it.
+func (cc *SmartContract) UnlinkCTIItems(ctx contractapi.TransactionContextInterface, id string, targetID string, relationship string) error {
+	if _, err := readOwnedCTIItem(ctx, id); err != nil {
+		return err
+	}
+
+	link := &CTILink{From: id, To: targetID, Relationship: relationship}
+	key, err := linkKey(ctx, link)
+	if err != nil {
+		return err
+	}
+	existing, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return fmt.Errorf("failed to read CTI link: %v", err)
+	}
+	if existing == nil {
+		return fmt.Errorf("CTI item %s is not linked to %s as %s", id, targetID, relationship)
+	}
+
+	if err := deleteLink(ctx, link); err != nil {
+		return err
+	}
+
+	return appendChangeLog(ctx, id, "unlinked", fmt.Sprintf("%s %s", relationship, targetID))
+}
+
+// deleteLink removes a link from both link indexes
+func deleteLink(ctx contractapi.TransactionContextInterface, link *CTILink) error {
+	key, err := linkKey(ctx, link)
+	if err != nil {
+		return err
+	}
+	reverseKey, err := linkedKey(ctx, link)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().DelState(key); err != nil {
+		return fmt.Errorf("failed to delete CTI link: %v", err)
+	}
+	if err := ctx.GetStub().DelState(reverseKey); err != nil {
+		return fmt.Errorf("failed to delete CTI link: %v", err)
+	}
+	return nil
+}
+
+// readLinks loads the links from a CTI item and the links to it
+func readLinks(ctx contractapi.TransactionContextInterface, id string) ([]*CTILink, error) {
+	var links []*CTILink
+	for _, index := range []string{linkIndex, linkedIndex} {
+		iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(index, []string{id})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s index: %v", index, err)
+		}
+		for iterator.HasNext() {
+			entry, err := iterator.Next()
+			if err != nil {
+				iterator.Close()
+				return nil, fmt.Errorf("failed to iterate over %s index: %v", index, err)
+			}
+			_, attributes, err := ctx.GetStub().SplitCompositeKey(entry.Key)
+			if err != nil {
+				iterator.Close()
+				return nil, fmt.Errorf("failed to split %s index key: %v", index, err)
+			}
+			if index == linkIndex {
+				links = append(links, &CTILink{From: attributes[0], To: attributes[2], Relationship: attributes[1]})
+			} else {
+				links = append(links, &CTILink{From: attributes[2], To: attributes[0], Relationship: attributes[1]})
+			}
+		}
+		iterator.Close()
+	}
+	return links, nil
+}
+
+// GetCTILineage returns the CTI items linked to an item within maxDepth links, following supersede and
+// relationship links in both directions, together with the links between them. Depth is capped at
+// maxLineageDepth and the subgraph at maxLineageNodes items; Truncated is set when either cap cut the
+// traversal short. Items the caller is not entitled to are returned without their CID and encryption key.
+func (cc *SmartContract) GetCTILineage(ctx contractapi.TransactionContextInterface, id string, maxDepth int) (*CTILineage, error) {
+	if maxDepth < 0 {
+		return nil, fmt.Errorf("max depth must not be negative, got %d", maxDepth)
+	}
+	lineage := &CTILineage{ID: id, Nodes: []*CTIData{}, Edges: []*CTILink{}}
+	capped := maxDepth > maxLineageDepth
+	if capped {
+		maxDepth = maxLineageDepth
+	}
+
+	root, err := readCTIItem(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if root == nil || root.Deleted {
+		return nil, fmt.Errorf("CTI item with ID %s does not exist", id)
+	}
+
+	// Walk the graph breadth first, so the items closest to the root are kept when the node cap is hit
+	visited := map[string]bool{id: true}
+	edges := make(map[CTILink]bool)
+	lineage.Nodes = append(lineage.Nodes, root)
+	frontier := []string{id}
+	for depth := 0; depth < maxDepth && len(frontier) > 0; depth++ {
+		var next []string
+		for _, nodeID := range frontier {
+			links, err := readLinks(ctx, nodeID)
+			if err != nil {
+				return nil, err
+			}
+			for _, link := range links {
+				if edges[*link] {
+					continue
+				}
+				neighbourID := link.To
+				if neighbourID == nodeID {
+					neighbourID = link.From
+				}
+				if !visited[neighbourID] {
+					if len(lineage.Nodes) == maxLineageNodes {
+						lineage.Truncated = true
+						continue
+					}
+					neighbour, err := readCTIItem(ctx, neighbourID)
+					if err != nil {
+						return nil, err
+					}
+					if neighbour == nil || neighbour.Deleted {
+						continue
+					}
+					visited[neighbourID] = true
+					lineage.Nodes = append(lineage.Nodes, neighbour)
+					next = append(next, neighbourID)
+				}
+				edges[*link] = true
+				lineage.Edges = append(lineage.Edges, link)
+			}
+		}
+		frontier = next
+	}
+	// Items reached at the capped depth may have links of their own
+	if capped && len(frontier) > 0 {
+		lineage.Truncated = true
+	}
+
+	lineage.Nodes, err = redactForCaller(ctx, lineage.Nodes)
+	if err != nil {
+		return nil, err
+	}
+	return lineage, nil
+}
+
+// canonicalHash returns the hex SHA-256 of a record's JSON encoding. Records are structs, so their
+// field order, and hence the encoding, is fixed.
+func canonicalHash(record interface{}) (string, error) {
+	recordJSON, err := json.Marshal(record)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal record for hashing: %v", err)
+	}
+	sum := sha256.Sum256(recordJSON)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// reviewContentHash returns the canonical hash of a review's immutable fields
+func reviewContentHash(review *ReviewData) (string, error) {
+	return canonicalHash(reviewContent{
+		ID:           review.ID,
+		UserDataID:   review.UserDataID,
+		ReviewerMSP:  review.ReviewerMSP,
+		CTIDataID:    review.CTIDataID,
+		Accuracy:     review.Accuracy,
+		Timeliness:   review.Timeliness,
+		Completeness: review.Completeness,
+		Consistency:  review.Consistency,
+		Scores:       review.Scores,
+		ReviewText:   review.ReviewText,
+		Timestamp:    review.Timestamp,
+		PrivateText:  review.PrivateText,
+	})
+}
+
+// checkReviewIntegrity recomputes a review's content hash and compares it with the stored one
+func checkReviewIntegrity(review *ReviewData) (*ReviewIntegrity, error) {
+	computedHash, err := reviewContentHash(review)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReviewIntegrity{
+		ReviewID:     review.ID,
+		Hashed:       review.ContentHash != "",
+		Verified:     review.ContentHash != "" && review.ContentHash == computedHash,
+		StoredHash:   review.ContentHash,
+		ComputedHash: computedHash,
+	}, nil
+}
+
+// VerifyReviewIntegrity checks that a stored review still matches the content hash recorded when it
+// was written
+func (cc *SmartContract) VerifyReviewIntegrity(ctx contractapi.TransactionContextInterface, reviewID string) (*ReviewIntegrity, error) {
+	review, err := readReview(ctx, reviewID)
+	if err != nil {
+		return nil, err
+	}
+	if review == nil {
+		return nil, fmt.Errorf("review with ID %s does not exist", reviewID)
+	}
+
+	return checkReviewIntegrity(review)
+}
+
+// VerifyAllReviewsIntegrity checks every review on the ledger and returns those that could not be
+// verified, either because they no longer match their content hash or because they have none
+func (cc *SmartContract) VerifyAllReviewsIntegrity(ctx contractapi.TransactionContextInterface) ([]*ReviewIntegrity, error) {
+	allReviewData, err := cc.reviewData(ctx, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all review data entries: %v", err)
+	}
+
+	unverified := []*ReviewIntegrity{}
+	for _, review := range allReviewData {
+		integrity, err := checkReviewIntegrity(review)
+		if err != nil {
+			return nil, err
+		}
+		if !integrity.Verified {
+			unverified = append(unverified, integrity)
+		}
+	}
+
+	return unverified, nil
+}
+
+// GetCTIItemSigned retrieves a CTI item, as GetCTIItemForCaller does, together with a canonical hash
+// that consumers outside Fabric can verify against the endorsement of the response
+func (cc *SmartContract) GetCTIItemSigned(ctx contractapi.TransactionContextInterface, id string) (*SignedCTIItem, error) {
+	ctiItem, err := cc.GetCTIItemForCaller(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := canonicalHash(ctiItem)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SignedCTIItem{
+		Item:          ctiItem,
+		CanonicalHash: hash,
+		HashAlgorithm: "SHA-256",
+		TxID:          ctx.GetStub().GetTxID(),
+	}, nil
+}
+
+// GetCTIItemsAboveQuality retrieves the CTI items whose cached composite review score is at least
+// minComposite across at least minReviews valid reviews
+func (cc *SmartContract) GetCTIItemsAboveQuality(ctx contractapi.TransactionContextInterface, minComposite float64, minReviews int) ([]*CTIData, error) {
+	if math.IsNaN(minComposite) || minComposite < 0 {
+		return nil, fmt.Errorf("minimum composite score must be a non-negative number, got %v", minComposite)
+	}
+	if minReviews < 1 {
+		return nil, fmt.Errorf("minimum review count must be at least 1, got %d", minReviews)
+	}
+
+	allCTIItems, err := cc.GetAllCTIItems(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all CTI data entries: %v", err)
+	}
+
+	var qualityItems []*CTIData
+	for _, ctiItem := range allCTIItems {
+		if ctiItem.ReviewCount >= minReviews && ctiItem.CompositeScore >= minComposite {
+			qualityItems = append(qualityItems, ctiItem)
+		}
+	}
+
+	return qualityItems, nil
+}
+
+// ctiItemHistory reads every recorded version of a CTI item, oldest first. Versions written under
+// the item's un-padded key before MigrateCTIKeys moved it are included.
+func ctiItemHistory(ctx contractapi.TransactionContextInterface, id string) ([]*CTIHistoryRecord, error) {
+	keys := []string{ctiKey(id)}
+	if legacyKey := "CTI_" + id; legacyKey != keys[0] {
+		keys = append(keys, legacyKey)
+	}
+
+	history := []*CTIHistoryRecord{}
+	for _, key := range keys {
+		versions, err := keyHistory(ctx, key, id)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, versions...)
+	}
+
+	sort.SliceStable(history, func(i, j int) bool {
+		return history[i].Timestamp < history[j].Timestamp
+	})
+
+	return history, nil
+}
+
+// keyHistory reads the versions of CTI item id recorded under one ledger key
+func keyHistory(ctx contractapi.TransactionContextInterface, key string, id string) ([]*CTIHistoryRecord, error) {
+	iterator, err := ctx.GetStub().GetHistoryForKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history of CTI item %s: %v", id, err)
+	}
+	defer iterator.Close()
+
+	var history []*CTIHistoryRecord
+	for iterator.HasNext() {
+		modification, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate over history of CTI item %s: %v", id, err)
+		}
+
+		record := &CTIHistoryRecord{
+			TxID:      modification.TxId,
+			Timestamp: modification.Timestamp.GetSeconds(),
+			IsDelete:  modification.IsDelete,
+		}
+		if !modification.IsDelete {
+			var ctiItem CTIData
+			if err := json.Unmarshal(modification.Value, &ctiItem); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal CTI item history: %v", err)
+			}
+			record.Value = &ctiItem
+		}
+		history = append(history, record)
+	}
+
+	return history, nil
+}
+
+// canAccessHistory reports whether the caller may see the CIDs and encryption keys in a CTI item's
+// history, deciding against the most recent version that existed
+func canAccessHistory(ctx contractapi.TransactionContextInterface, history []*CTIHistoryRecord) (bool, error) {
+	var latest *CTIData
+	for _, record := range history {
+		if record.Value != nil {
+			latest = record.Value
+		}
+	}
+	if latest == nil {
+		return true, nil
+	}
+	return canAccess(ctx, latest)
+}
+
+// GetCTIItemHistory returns every recorded version of a CTI item, oldest first, including deletions.
+// Each version names the identity whose change produced it, taken from the item's changelog. The CIDs
+// and encryption keys are redacted unless the caller is entitled to the item.
+func (cc *SmartContract) GetCTIItemHistory(ctx contractapi.TransactionContextInterface, id string) ([]*CTIHistoryRecord, error) {
+	history, err := ctiItemHistory(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	entitled, err := canAccessHistory(ctx, history)
+	if err != nil {
+		return nil, err
+	}
+	if !entitled {
+		for _, record := range history {
+			if record.Value != nil {
+				record.Value = redactCTIItem(record.Value)
+			}
+		}
+	}
+
+	changeLog, err := cc.GetCTIChangeLog(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	actors := make(map[string]string)
+	for _, entry := range changeLog {
+		actors[entry.TxID] = entry.Actor
+	}
+	for _, record := range history {
+		record.ChangedBy = actors[record.TxID]
+	}
+
+	return history, nil
+}
+
+// stateAt returns the version of an item in effect at a timestamp, or nil if it did not exist then
+func stateAt(history []*CTIHistoryRecord, timestamp int64) *CTIData {
+	var state *CTIData
+	for _, record := range history {
+		if record.Timestamp > timestamp {
+			break
+		}
+		state = record.Value
+	}
+	return state
+}
+
+// ctiItemFields returns the JSON-encoded value of each field of a CTI item
+func ctiItemFields(ctiItem *CTIData) (map[string]string, error) {
+	fields := make(map[string]string)
+	if ctiItem == nil {
+		return fields, nil
+	}
+
+	ctiItemJSON, err := json.Marshal(ctiItem)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal CTI item: %v", err)
+	}
+	var rawFields map[string]json.RawMessage
+	if err := json.Unmarshal(ctiItemJSON, &rawFields); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal CTI item fields: %v", err)
+	}
+	for field, value := range rawFields {
+		fields[field] = string(value)
+	}
+
+	return fields, nil
+}
+
+// DiffCTIItem compares the state of a CTI item at fromTs with its state at toTs and returns the fields
+// that changed. Either state may be absent if the item did not exist at that time. The CID and
+// encryption key are redacted unless the caller is entitled to the item.
+func (cc *SmartContract) DiffCTIItem(ctx contractapi.TransactionContextInterface, id string, fromTs, toTs int64) (*CTIItemDiff, error) {
+	if fromTs > toTs {
+		return nil, fmt.Errorf("from timestamp %d is after to timestamp %d", fromTs, toTs)
+	}
+
+	history, err := ctiItemHistory(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(history) == 0 {
+		return nil, fmt.Errorf("CTI item with ID %s has no history", id)
+	}
+
+	fromState := stateAt(history, fromTs)
+	toState := stateAt(history, toTs)
+
+	entitled, err := canAccessHistory(ctx, history)
+	if err != nil {
+		return nil, err
+	}
+	if !entitled {
+		if fromState != nil {
+			fromState = redactCTIItem(fromState)
+		}
+		if toState != nil {
+			toState = redactCTIItem(toState)
+		}
+	}
+
+	fromFields, err := ctiItemFields(fromState)
+	if err != nil {
+		return nil, err
+	}
+	toFields, err := ctiItemFields(toState)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &CTIItemDiff{
+		ID:            id,
+		FromTimestamp: fromTs,
+		ToTimestamp:   toTs,
+		ExistedAtFrom: fromState != nil,
+		ExistedAtTo:   toState != nil,
+		Changes:       []*FieldChange{},
+	}
+
+	fieldNames := make(map[string]bool)
+	for field := range fromFields {
+		fieldNames[field] = true
+	}
+	for field := range toFields {
+		fieldNames[field] = true
+	}
+	for field := range fieldNames {
+		if fromFields[field] != toFields[field] {
+			diff.Changes = append(diff.Changes, &FieldChange{Field: field, Before: fromFields[field], After: toFields[field]})
+		}
+	}
+	sort.Slice(diff.Changes, func(i, j int) bool {
+		return diff.Changes[i].Field < diff.Changes[j].Field
+	})
+
+	return diff, nil
+}
+
+// indexedCTIIDs returns the IDs of the CTI items indexed under value in a composite index
+func indexedCTIIDs(ctx contractapi.TransactionContextInterface, index string, value string) ([]string, error) {
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(index, []string{value})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s index: %v", index, err)
+	}
+	defer iterator.Close()
+
+	var ids []string
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate over %s index: %v", index, err)
+		}
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(entry.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to split %s index key: %v", index, err)
+		}
+		ids = append(ids, attributes[1])
+	}
+
+	return ids, nil
+}
+
+// GetCTIItemsByUploader returns the CTI items uploaded by one uploader, resolved through the uploader index
+func (cc *SmartContract) GetCTIItemsByUploader(ctx contractapi.TransactionContextInterface, uploader string) ([]*CTIData, error) {
+	if uploader == "" {
+		return nil, fmt.Errorf("uploader must not be empty")
+	}
+
+	ids, err := indexedCTIIDs(ctx, uploaderIndex, uploader)
+	if err != nil {
+		return nil, err
+	}
+
+	ctiItems := []*CTIData{}
+	for _, id := range ids {
+		ctiItem, err := readCTIItem(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if ctiItem != nil {
+			ctiItems = append(ctiItems, ctiItem)
+		}
+	}
+
+	return redactForCaller(ctx, ctiItems)
+}
+
+// GetCTIItemsByUploaders returns the CTI items uploaded by any of the listed uploaders, newest first
+func (cc *SmartContract) GetCTIItemsByUploaders(ctx contractapi.TransactionContextInterface, uploaders []string) ([]*CTIData, error) {
+	if len(uploaders) == 0 {
+		return nil, fmt.Errorf("at least one uploader is required")
+	}
+	if len(uploaders) > maxUploaderListSize {
+		return nil, fmt.Errorf("at most %d uploaders can be queried at once, got %d", maxUploaderListSize, len(uploaders))
+	}
+
+	seen := make(map[string]bool)
+	var ctiItems []*CTIData
+	for _, uploader := range uploaders {
+		ids, err := indexedCTIIDs(ctx, uploaderIndex, uploader)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range ids {
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+
+			ctiItem, err := readCTIItem(ctx, id)
+			if err != nil {
+				return nil, err
+			}
+			if ctiItem != nil {
+				ctiItems = append(ctiItems, ctiItem)
+			}
+		}
+	}
+
+	sort.SliceStable(ctiItems, func(i, j int) bool {
+		return ctiItems[i].Timestamp > ctiItems[j].Timestamp
+	})
+
+	return redactForCaller(ctx, ctiItems)
+}
+
+// SetUploadStake sets the balance an uploader must stake on each new CTI item. A stake of 0 disables staking.
+func (cc *SmartContract) SetUploadStake(ctx contractapi.TransactionContextInterface, stake int) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+	if stake < 0 {
+		return fmt.Errorf("stake must not be negative, got %d", stake)
+	}
+
+	if err := ctx.GetStub().PutState(uploadStakeKey, []byte(strconv.Itoa(stake))); err != nil {
+		return fmt.Errorf("failed to put upload stake on ledger: %v", err)
+	}
+	return nil
+}
+
+// GetUploadStake returns the balance an uploader must stake on each new CTI item
+func (cc *SmartContract) GetUploadStake(ctx contractapi.TransactionContextInterface) (int, error) {
+	return readCounter(ctx, uploadStakeKey, 0)
+}
+
+// paidReviewerReward returns the reviewer reward credited for a review. Reviews paid before reward
+// amounts were recorded were paid defaultReviewerReward.
+func paidReviewerReward(review *ReviewData) int {
+	if !review.RewardPaid {
+		return 0
+	}
+	if review.SchemaVersion < 5 && review.RewardAmount == 0 {
+		return defaultReviewerReward
+	}
+	return review.RewardAmount
+}
+
+// ForfeitCTIStake forfeits the stake held on a CTI item confirmed to be false. The stake is removed
+// from circulation rather than credited to anyone.
+func (cc *SmartContract) ForfeitCTIStake(ctx contractapi.TransactionContextInterface, id string) (int, error) {
+	permissions, err := callerPermissions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if !permissions.CanModerate {
+		return 0, fmt.Errorf("caller is not authorized: admin or moderator role required")
+	}
+
+	ctiItem, err := readCTIItem(ctx, id)
+	if err != nil {
+		return 0, err
+	}
+	if ctiItem == nil {
+		return 0, fmt.Errorf("CTI item with ID %s does not exist", id)
+	}
+	if ctiItem.StakeEscrow == 0 {
+		return 0, fmt.Errorf("CTI item %s holds no stake", id)
+	}
+
+	forfeited := ctiItem.StakeEscrow
+	ctiItem.StakeEscrow = 0
+	if err := putCTIItem(ctx, ctiItem); err != nil {
+		return 0, err
+	}
+	if err := notifyUser(ctx, ctiItem.Uploader, "stake-forfeited", id, fmt.Sprintf("stake of %d on CTI item %s was forfeited", forfeited, id)); err != nil {
+		return 0, err
+	}
+
+	return forfeited, nil
+}
+
+// reviewSortField returns the review document field holding a dimension's score. The original four
+// dimensions are also stored in fixed fields, which reviews from before configurable dimensions have too.
+func reviewSortField(dimension string) string {
+	if containsString(defaultReviewDimensions, dimension) {
+		return dimension
+	}
+	return "Scores." + dimension
+}
+
+// GetReviewsByCTIAdvanced returns one page of the reviews of a CTI item sorted on a review dimension.
+// It runs a CouchDB rich query, so it requires CouchDB as the state database; LevelDB peers reject it
+// and GetReviewDataByCTIDataID must be used there instead. The original four dimensions are backed by
+// the indexes under META-INF/statedb/couchdb/indexes; an index for a custom dimension on
+// ["CTIDataID", "Scores.<dimension>"] has to be packaged with the chaincode before sorting on it.
+func (cc *SmartContract) GetReviewsByCTIAdvanced(ctx contractapi.TransactionContextInterface, ctiDataID string, pageSize int32, bookmark string, sortDimension string, descending bool) (*ReviewsPage, error) {
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("page size must be positive, got %d", pageSize)
+	}
+
+	dimensions, err := readReviewDimensions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !containsString(dimensions, sortDimension) {
+		return nil, fmt.Errorf("unknown review dimension %s", sortDimension)
+	}
+
+	direction := "asc"
+	if descending {
+		direction = "desc"
+	}
+	sortField := reviewSortField(sortDimension)
+	query := map[string]interface{}{
+		"selector": map[string]interface{}{
+			"CTIDataID": ctiDataID,
+			sortField:   map[string]interface{}{"$exists": true},
+		},
+		"sort": []map[string]string{
+			{"CTIDataID": direction},
+			{sortField: direction},
+		},
+	}
+	queryJSON, err := json.Marshal(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal review query: %v", err)
+	}
+
+	iterator, metadata, err := ctx.GetStub().GetQueryResultWithPagination(string(queryJSON), pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query reviews of CTI item %s: %v", ctiDataID, err)
+	}
+	defer iterator.Close()
+
+	page := &ReviewsPage{Reviews: []*ReviewData{}}
+	for iterator.HasNext() {
+		item, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get next item in iterator: %v", err)
+		}
+
+		var review ReviewData
+		if err := json.Unmarshal(item.Value, &review); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal review data: %v", err)
+		}
+		page.Reviews = append(page.Reviews, &review)
+	}
+
+	if metadata != nil {
+		page.FetchedCount = metadata.FetchedRecordsCount
+		// A short page means the results have been exhausted
+		if metadata.FetchedRecordsCount == pageSize {
+			page.Bookmark = metadata.Bookmark
+		}
+	}
+
+	return page, nil
+}
+
+// rewardableReviews returns the IDs of the reviews that earn a reward: each reviewer's earliest
+// review of each CTI item
+func rewardableReviews(reviews []*ReviewData) map[string]bool {
+	first := make(map[string]*ReviewData)
+	for _, review := range reviews {
+		key := review.UserDataID + "\x00" + review.CTIDataID
+		earliest, ok := first[key]
+		if !ok || review.Timestamp < earliest.Timestamp || (review.Timestamp == earliest.Timestamp && review.ID < earliest.ID) {
+			first[key] = review
+		}
+	}
+
+	rewardable := make(map[string]bool)
+	for _, review := range first {
+		rewardable[review.ID] = true
+	}
+	return rewardable
+}
+
+// unpaidReviewRewards compares the rewards a reviewer's reviews earned with those credited
+func (cc *SmartContract) unpaidReviewRewards(ctx contractapi.TransactionContextInterface, userID string) (*RewardShortfall, []*ReviewData, error) {
+	allReviewData, err := cc.reviewData(ctx, true)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get all review data entries: %v", err)
+	}
+
+	var userReviews []*ReviewData
+	for _, review := range allReviewData {
+		if review.UserDataID == userID {
+			userReviews = append(userReviews, review)
+		}
+	}
+
+	reward, err := readCounter(ctx, reviewerRewardKey, defaultReviewerReward)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	shortfall := &RewardShortfall{UserID: userID, UnpaidIDs: []string{}}
+	var unpaid []*ReviewData
+	rewardable := rewardableReviews(userReviews)
+	for _, review := range userReviews {
+		if review.RewardPaid {
+			shortfall.PaidRewards++
+			continue
+		}
+		// Invalidated reviews never earn a reward
+		if rewardable[review.ID] && !review.Invalidated {
+			unpaid = append(unpaid, review)
+			shortfall.UnpaidIDs = append(shortfall.UnpaidIDs, review.ID)
+			shortfall.Owed += reward
+		}
+	}
+
+	return shortfall, unpaid, nil
+}
+
+// GetUnpaidReviewRewards returns the reviewer rewards a user earned but was never credited
+func (cc *SmartContract) GetUnpaidReviewRewards(ctx contractapi.TransactionContextInterface, userID string) (*RewardShortfall, error) {
+	shortfall, _, err := cc.unpaidReviewRewards(ctx, userID)
+	return shortfall, err
+}
+
+// PayOutstandingRewards credits a user with the reviewer rewards they are owed and marks the reviews
+// paid. Rewards beyond the user's reward mint allowance for the day stay owed; the returned shortfall
+// lists them.
+func (cc *SmartContract) PayOutstandingRewards(ctx contractapi.TransactionContextInterface, userID string) (*RewardShortfall, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	shortfall, unpaid, err := cc.unpaidReviewRewards(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if shortfall.Owed == 0 {
+		return shortfall, nil
+	}
+
+	userData, err := readUserData(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if userData == nil {
+		userData = newUserData(userID)
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each review was owed the current reward
+	reward, err := readCounter(ctx, reviewerRewardKey, defaultReviewerReward)
+	if err != nil {
+		return nil, err
+	}
+	paid := 0
+	for i, review := range unpaid {
+		minted, err := mintReviewerReward(ctx, userData, reward, now)
+		if err != nil {
+			return nil, err
+		}
+		if !minted {
+			shortfall.UnpaidIDs = shortfall.UnpaidIDs[i:]
+			break
+		}
+		review.RewardPaid = true
+		review.RewardAmount = reward
+		if err := putReview(ctx, review); err != nil {
+			return nil, err
+		}
+		paid += reward
+		shortfall.PaidRewards++
+		if i == len(unpaid)-1 {
+			shortfall.UnpaidIDs = []string{}
+		}
+	}
+	shortfall.Owed -= paid
+	if paid == 0 {
+		return shortfall, nil
+	}
+
+	if err := adjustUserBalances(userData, paid, 0); err != nil {
+		return nil, err
+	}
+	userData.ReviewPoints += paid
+	if err := putUserData(ctx, userData); err != nil {
+		return nil, err
+	}
+	if err := notifyUser(ctx, userID, "funds-received", ctx.GetStub().GetTxID(), fmt.Sprintf("received %d points in outstanding review rewards", paid)); err != nil {
+		return nil, err
+	}
+
+	return shortfall, nil
+}
+
+// TransferBalance moves amount from the caller's balance to another user's. Both balances are read and
+// written in this transaction, so the transfer commits or fails as a whole.
+func (cc *SmartContract) TransferBalance(ctx contractapi.TransactionContextInterface, toUserID string, amount int) error {
+	if amount <= 0 {
+		return fmt.Errorf("transfer amount must be positive, got %d", amount)
+	}
+
+	fromUserID, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get client identity: %v", err)
+	}
+	if fromUserID == toUserID {
+		return fmt.Errorf("cannot transfer balance to yourself")
+	}
+
+	fromData, err := readUserData(ctx, fromUserID)
+	if err != nil {
+		return err
+	}
+	if fromData == nil {
+		return fmt.Errorf("user data with ID %s does not exist", fromUserID)
+	}
+	toData, err := readUserData(ctx, toUserID)
+	if err != nil {
+		return err
+	}
+	if toData == nil {
+		return fmt.Errorf("user data with ID %s does not exist", toUserID)
+	}
+
+	// Debit first so an overdraft is rejected before anything is credited
+	if err := adjustUserBalances(fromData, 0, -amount); err != nil {
+		return err
+	}
+	if err := adjustUserBalances(toData, 0, amount); err != nil {
+		return err
+	}
+	if err := putUserData(ctx, fromData); err != nil {
+		return err
+	}
+	if err := putUserData(ctx, toData); err != nil {
+		return err
+	}
+
+	return notifyUser(ctx, toUserID, "funds-received", ctx.GetStub().GetTxID(), fmt.Sprintf("received a transfer of %d from %s", amount, fromUserID))
+}
+
+// BalanceDepositedEvent is the payload of the event emitted when an admin credits a user's balance
+type BalanceDepositedEvent struct {
+	UserID      string `json:"UserID"`
+	Amount      int    `json:"Amount"`
+	Balance     int    `json:"Balance"`
+	DepositedBy string `json:"DepositedBy"`
+}
+
+// DepositBalance credits amount to an existing user's balance. Only admins may deposit; each deposit is
+// recorded in the admin audit log.
+func (cc *SmartContract) DepositBalance(ctx contractapi.TransactionContextInterface, userID string, amount int) error {
+	permissions, err := callerPermissions(ctx)
+	if err != nil {
+		return err
+	}
+	if !permissions.IsAdmin {
+		return fmt.Errorf("caller is not authorized: only admins can deposit balance")
+	}
+	if amount <= 0 {
+		return fmt.Errorf("deposit amount must be positive, got %d", amount)
+	}
+
+	userData, err := readUserData(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if userData == nil {
+		return fmt.Errorf("user data with ID %s does not exist", userID)
+	}
+
+	if err := adjustUserBalances(userData, 0, amount); err != nil {
+		return err
+	}
+	if err := putUserData(ctx, userData); err != nil {
+		return err
+	}
+	if err := recordAdminAction(ctx, "DepositBalance", userID, fmt.Sprintf("deposited %d, balance now %d", amount, userData.Balance)); err != nil {
+		return err
+	}
+
+	eventJSON, err := json.Marshal(BalanceDepositedEvent{UserID: userID, Amount: amount, Balance: userData.Balance, DepositedBy: permissions.ID})
+	if err != nil {
+		return fmt.Errorf("failed to marshal deposit event: %v", err)
+	}
+	if err := ctx.GetStub().SetEvent("BalanceDeposited", eventJSON); err != nil {
+		return fmt.Errorf("failed to set deposit event: %v", err)
+	}
+
+	return nil
+}
+
+// ScanBrokenReferences reports dangling references: reviews of CTI items that no longer exist, index
+// entries pointing at missing CTI items or reviews, and links to missing CTI items. Reviews are scanned
+// one page at a time; the indexes and links are scanned on the first page only, when bookmark is empty.
+func (cc *SmartContract) ScanBrokenReferences(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*BrokenReferenceReport, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("page size must be positive, got %d", pageSize)
+	}
+
+	report := &BrokenReferenceReport{DanglingReviews: []*BrokenReference{}, DanglingIndexEntries: []*BrokenReference{}, DanglingLinks: []*BrokenReference{}}
+
+	iterator, metadata, err := ctx.GetStub().GetStateByRangeWithPagination(reviewRangeStart, reviewRangeEnd, pageSize, bookmark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read review data entries: %v", err)
+	}
+	defer iterator.Close()
+
+	for iterator.HasNext() {
+		item, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get next item in iterator: %v", err)
+		}
+
+		var review ReviewData
+		if err := json.Unmarshal(item.Value, &review); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal review data: %v", err)
+		}
+		ctiItem, err := readCTIItem(ctx, review.CTIDataID)
+		if err != nil {
+			return nil, err
+		}
+		if ctiItem == nil {
+			report.DanglingReviews = append(report.DanglingReviews, &BrokenReference{Key: item.Key, MissingKey: ctiKey(review.CTIDataID)})
+		}
+	}
+
+	if metadata != nil && metadata.FetchedRecordsCount == pageSize {
+		report.Bookmark = metadata.Bookmark
+	}
+
+	if bookmark == "" {
+		for _, index := range ctiIndexes {
+			danglers, err := danglingIndexEntries(ctx, index, func(attributes []string) string {
+				return ctiKey(attributes[len(attributes)-1])
+			})
+			if err != nil {
+				return nil, err
+			}
+			report.DanglingIndexEntries = append(report.DanglingIndexEntries, danglers...)
+		}
+
+		danglers, err := danglingIndexEntries(ctx, reviewerIndex, func(attributes []string) string {
+			return reviewKey(attributes[2])
+		})
+		if err != nil {
+			return nil, err
+		}
+		report.DanglingIndexEntries = append(report.DanglingIndexEntries, danglers...)
+
+		// A link is recorded under both of its ends, so checking the far end of each entry covers both
+		for _, index := range []string{linkIndex, linkedIndex} {
+			danglers, err := danglingIndexEntries(ctx, index, func(attributes []string) string {
+				return ctiKey(attributes[2])
+			})
+			if err != nil {
+				return nil, err
+			}
+			report.DanglingLinks = append(report.DanglingLinks, danglers...)
+		}
+	}
+
+	return report, nil
+}
+
+// danglingIndexEntries returns the entries of an index whose target key, derived from the entry's
+// attributes, is missing from the ledger
+func danglingIndexEntries(ctx contractapi.TransactionContextInterface, index string, target func(attributes []string) string) ([]*BrokenReference, error) {
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(index, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s index: %v", index, err)
+	}
+	defer iterator.Close()
+
+	var danglers []*BrokenReference
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate over %s index: %v", index, err)
+		}
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(entry.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to split %s index key: %v", index, err)
+		}
+
+		targetKey := target(attributes)
+		value, err := ctx.GetStub().GetState(targetKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s from ledger: %v", targetKey, err)
+		}
+		if value == nil {
+			danglers = append(danglers, &BrokenReference{Key: entry.Key, MissingKey: targetKey})
+		}
+	}
+
+	return danglers, nil
+}
+
+// followKeys returns the keys recording that follower follows a CTI item, in both indexes
+func followKeys(ctx contractapi.TransactionContextInterface, id string, follower string) (string, string, error) {
+	followerKey, err := ctx.GetStub().CreateCompositeKey(followerIndex, []string{id, follower})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create %s index key: %v", followerIndex, err)
+	}
+	followingKey, err := ctx.GetStub().CreateCompositeKey(followingIndex, []string{follower, id})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create %s index key: %v", followingIndex, err)
+	}
+	return followerKey, followingKey, nil
+}
+
+// ctiItemFollowers returns the identities following a CTI item
+func ctiItemFollowers(ctx contractapi.TransactionContextInterface, id string) ([]string, error) {
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(followerIndex, []string{id})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s index: %v", followerIndex, err)
+	}
+	defer iterator.Close()
+
+	followers := []string{}
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate over %s index: %v", followerIndex, err)
+		}
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(entry.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to split %s index key: %v", followerIndex, err)
+		}
+		followers = append(followers, attributes[1])
+	}
+
+	return followers, nil
+}
+
+// FollowCTIItem subscribes the caller to update notifications for a CTI item
+func (cc *SmartContract) FollowCTIItem(ctx contractapi.TransactionContextInterface, id string) error {
+	follower, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get current peer ID: %v", err)
+	}
+
+	ctiItem, err := readCTIItem(ctx, id)
+	if err != nil {
+		return err
+	}
+	if ctiItem == nil {
+		return fmt.Errorf("CTI item with ID %s does not exist", id)
+	}
+
+	followerKey, followingKey, err := followKeys(ctx, id, follower)
+	if err != nil {
+		return err
+	}
+	existing, err := ctx.GetStub().GetState(followerKey)
+	if err != nil {
+		return fmt.Errorf("failed to read follow entry: %v", err)
+	}
+	if existing != nil {
+		return fmt.Errorf("caller already follows CTI item %s", id)
+	}
+
+	if err := ctx.GetStub().PutState(followerKey, []byte{0x00}); err != nil {
+		return fmt.Errorf("failed to put follow entry: %v", err)
+	}
+	if err := ctx.GetStub().PutState(followingKey, []byte{0x00}); err != nil {
+		return fmt.Errorf("failed to put follow entry: %v", err)
+	}
+
+	return nil
+}
+
+// UnfollowCTIItem stops update notifications for a CTI item the caller follows
+func (cc *SmartContract) UnfollowCTIItem(ctx contractapi.TransactionContextInterface, id string) error {
+	follower, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get current peer ID: %v", err)
+	}
+
+	followerKey, followingKey, err := followKeys(ctx, id, follower)
+	if err != nil {
+		return err
+	}
+	existing, err := ctx.GetStub().GetState(followerKey)
+	if err != nil {
+		return fmt.Errorf("failed to read follow entry: %v", err)
+	}
+	if existing == nil {
+		return fmt.Errorf("caller does not follow CTI item %s", id)
+	}
+
+	if err := ctx.GetStub().DelState(followerKey); err != nil {
+		return fmt.Errorf("failed to delete follow entry: %v", err)
+	}
+	if err := ctx.GetStub().DelState(followingKey); err != nil {
+		return fmt.Errorf("failed to delete follow entry: %v", err)
+	}
+
+	return nil
+}
+
+// GetMyFollowedCTIItems returns the CTI items the caller follows, redacted where the caller is not
+// entitled to their content. Followed items that have since been deleted are left out.
+func (cc *SmartContract) GetMyFollowedCTIItems(ctx contractapi.TransactionContextInterface) ([]*CTIData, error) {
+	access, err := newAccessContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(followingIndex, []string{access.caller})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s index: %v", followingIndex, err)
+	}
+	defer iterator.Close()
+
+	followed := []*CTIData{}
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate over %s index: %v", followingIndex, err)
+		}
+		_, attributes, err := ctx.GetStub().SplitCompositeKey(entry.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to split %s index key: %v", followingIndex, err)
+		}
+
+		ctiItem, err := readCTIItem(ctx, attributes[1])
+		if err != nil {
+			return nil, err
+		}
+		if ctiItem == nil {
+			continue
+		}
+		if !access.canAccess(ctiItem) {
+			ctiItem = redactCTIItem(ctiItem)
+		}
+		followed = append(followed, ctiItem)
+	}
+
+	return followed, nil
+}
+
+// GetScoreHelpfulnessCorrelation correlates the composite scores of a CTI item's valid reviews with
+// their helpful vote counts. A negative correlation means harsher reviews are found more helpful.
+func (cc *SmartContract) GetScoreHelpfulnessCorrelation(ctx contractapi.TransactionContextInterface, ctiDataID string) (*ScoreHelpfulnessCorrelation, error) {
+	reviews, err := cc.GetReviewDataByCTIDataID(ctx, ctiDataID)
+	if err != nil {
+		return nil, err
+	}
+
+	var scores, helpful []float64
+	for _, review := range reviews {
+		if !review.Invalidated {
+			scores = append(scores, compositeScore(review))
+			helpful = append(helpful, float64(review.HelpfulCount))
+		}
+	}
+	if len(scores) < minCorrelationReviews {
+		return nil, fmt.Errorf("CTI item %s has %d reviews, at least %d are needed to compute a correlation", ctiDataID, len(scores), minCorrelationReviews)
+	}
+
+	n := float64(len(scores))
+	var meanScore, meanHelpful float64
+	for i := range scores {
+		meanScore += scores[i]
+		meanHelpful += helpful[i]
+	}
+	meanScore /= n
+	meanHelpful /= n
+
+	var covariance, scoreVariance, helpfulVariance float64
+	for i := range scores {
+		dScore, dHelpful := scores[i]-meanScore, helpful[i]-meanHelpful
+		covariance += dScore * dHelpful
+		scoreVariance += dScore * dScore
+		helpfulVariance += dHelpful * dHelpful
+	}
+	if scoreVariance == 0 || helpfulVariance == 0 {
+		return nil, fmt.Errorf("correlation is undefined for CTI item %s: its review scores or helpful votes do not vary", ctiDataID)
+	}
+
+	return &ScoreHelpfulnessCorrelation{
+		CTIDataID:   ctiDataID,
+		ReviewCount: len(scores),
+		Correlation: covariance / math.Sqrt(scoreVariance*helpfulVariance),
+	}, nil
+}
+
+// recordAdminAction appends an entry to the admin audit log
+func recordAdminAction(ctx contractapi.TransactionContextInterface, action string, target string, details string) error {
+	actor, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get current peer ID: %v", err)
+	}
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	txID := ctx.GetStub().GetTxID()
+
+	entryJSON, err := json.Marshal(AuditEntry{TxID: txID, Timestamp: timestamp, Actor: actor, Action: action, Target: target, Details: details})
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %v", err)
+	}
+
+	// Zero-padding keeps the entries in time order
+	key, err := ctx.GetStub().CreateCompositeKey(auditIndex, []string{fmt.Sprintf("%020d", timestamp), txID, action})
+	if err != nil {
+		return fmt.Errorf("failed to create %s key: %v", auditIndex, err)
+	}
+	if err := ctx.GetStub().PutState(key, entryJSON); err != nil {
+		return fmt.Errorf("failed to put audit entry on ledger: %v", err)
+	}
+
+	return nil
+}
+
+// GetAdminAuditLog returns the admin audit log, oldest first
+func (cc *SmartContract) GetAdminAuditLog(ctx contractapi.TransactionContextInterface) ([]*AuditEntry, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return nil, err
+	}
+
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(auditIndex, []string{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %v", err)
+	}
+	defer iterator.Close()
+
+	entries := []*AuditEntry{}
+	for iterator.HasNext() {
+		item, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate over audit log: %v", err)
+		}
+
+		var entry AuditEntry
+		if err := json.Unmarshal(item.Value, &entry); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal audit entry: %v", err)
+		}
+		entries = append(entries, &entry)
+	}
+
+	return entries, nil
+}
+
+// ResetUserAccount zeroes a user's points, balance, upload count and subscription while keeping the
+// record, for abuse remediation. The reset is recorded in the admin audit log.
+func (cc *SmartContract) ResetUserAccount(ctx contractapi.TransactionContextInterface, userID string) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+
+	userData, err := readUserData(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if userData == nil {
+		return fmt.Errorf("User data for user %s does not exist", userID)
+	}
+
+	details := fmt.Sprintf("points %d, balance %d, upload count %d, subscribed %d", userData.Points, userData.Balance, userData.UploadCount, userData.Subscribed)
+	userData.Points = 0
+	userData.Balance = 0
+	userData.UploadCount = 0
+	userData.Subscribed = 0
+	if err := putUserData(ctx, userData); err != nil {
+		return err
+	}
+
+	return recordAdminAction(ctx, "ResetUserAccount", userID, details)
+}
+
+// AdminSetUserData overwrites a user's upload count, points, subscription and balance, creating the
+// record if needed. It exists for migrations; the change is recorded in the admin audit log.
+func (cc *SmartContract) AdminSetUserData(ctx contractapi.TransactionContextInterface, userID string, uploadCount, points, subscribed, balance int) error {
+	if err := requireAdmin(ctx); err != nil {
+		return err
+	}
+	if err := validateUserBalances(points, balance); err != nil {
+		return err
+	}
+
+	userData, err := readUserData(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if userData == nil {
+		userData = newUserData(userID)
+	}
+
+	details := fmt.Sprintf("points %d -> %d, balance %d -> %d, upload count %d -> %d, subscribed %d -> %d",
+		userData.Points, points, userData.Balance, balance, userData.UploadCount, uploadCount, userData.Subscribed, subscribed)
+	userData.UploadCount = uploadCount
+	userData.Points = points
+	userData.Subscribed = subscribed
+	userData.Balance = balance
+	if err := putUserData(ctx, userData); err != nil {
+		return err
+	}
+
+	return recordAdminAction(ctx, "AdminSetUserData", userID, details)
+}
+
+// isExpired reports whether a CTI item's expiry has passed at the given time
+func isExpired(ctiItem *CTIData, now int64) bool {
+	return ctiItem.ExpiresAt > 0 && ctiItem.ExpiresAt <= now
+}
+
+// SetCTIItemExpiry sets the time after which a CTI item is no longer current. Only the uploader or an
+// admin may set it; an expiry of 0 means the item never expires.
+func (cc *SmartContract) SetCTIItemExpiry(ctx contractapi.TransactionContextInterface, id string, expiresAt int64) error {
+	if expiresAt < 0 {
+		return fmt.Errorf("expiry must not be negative, got %d", expiresAt)
+	}
+
+	permissions, err := callerPermissions(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctiItem, err := readCTIItem(ctx, id)
+	if err != nil {
+		return err
+	}
+	if ctiItem == nil {
+		return fmt.Errorf("CTI item with ID %s does not exist", id)
+	}
+	if !permissions.IsAdmin && ctiItem.Uploader != permissions.ID {
+		return fmt.Errorf("caller is not authorized: only the uploader or an admin can set the expiry of CTI item %s", id)
+	}
+
+	ctiItem.ExpiresAt = expiresAt
+	if err := putCTIItem(ctx, ctiItem); err != nil {
+		return err
+	}
+
+	summary := "expiry cleared"
+	if expiresAt > 0 {
+		summary = fmt.Sprintf("expiry set to %d", expiresAt)
+	}
+	return appendChangeLog(ctx, id, "expiry-set", summary)
+}
+
+// ctiCreatedAt returns when a CTI item was recorded on the ledger, in Unix seconds. Items from before
+// creation times were recorded fall back to their uploader-supplied timestamp, which may be in
+// milliseconds.
+func ctiCreatedAt(ctiItem *CTIData) int64 {
+	if ctiItem.CreatedAt > 0 {
+		return ctiItem.CreatedAt
+	}
+	if ctiItem.Timestamp > 1e11 {
+		return ctiItem.Timestamp / 1000
+	}
+	return ctiItem.Timestamp
+}
+
+// freshnessScore rates how current a CTI item is at time now, between 0 and 1:
+//
+//	age      = 0.5 ^ ((now - CreatedAt) / 30 days)
+//	review   = 0.5 ^ ((now - LastReviewedAt) / 14 days), or 0 if never reviewed
+//	lifetime = (ExpiresAt - now) / (ExpiresAt - CreatedAt), clamped to [0, 1], or 1 without an expiry
+//	score    = (0.6 * age + 0.4 * review) * lifetime
+//
+// Ages are measured from the transaction time the item was recorded, not the uploader's timestamp.
+func freshnessScore(ctiItem *CTIData, now int64) float64 {
+	createdAt := ctiCreatedAt(ctiItem)
+	age := math.Pow(0.5, math.Max(float64(now-createdAt), 0)/freshnessAgeHalfLife)
+
+	review := 0.0
+	if ctiItem.LastReviewedAt > 0 {
+		review = math.Pow(0.5, math.Max(float64(now-ctiItem.LastReviewedAt), 0)/freshnessReviewHalfLife)
+	}
+
+	lifetime := 1.0
+	if ctiItem.ExpiresAt > 0 {
+		total := ctiItem.ExpiresAt - createdAt
+		if total > 0 {
+			lifetime = math.Min(math.Max(float64(ctiItem.ExpiresAt-now)/float64(total), 0), 1)
+		} else {
+			lifetime = 0
+		}
+	}
+
+	return (0.6*age + 0.4*review) * lifetime
+}
+
+// maxInt returns the larger of a and b
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// GetCTIItemsByFreshness returns the limit freshest unexpired CTI items, freshest first. See
+// freshnessScore for how freshness is computed.
+func (cc *SmartContract) GetCTIItemsByFreshness(ctx contractapi.TransactionContextInterface, limit int) ([]*RankedCTIItem, error) {
+	if limit < 1 || limit > maxRankedItems {
+		return nil, fmt.Errorf("limit must be between 1 and %d, got %d", maxRankedItems, limit)
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	allCTIItems, err := cc.GetAllCTIItems(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all CTI data entries: %v", err)
+	}
+
+	ranked := []*RankedCTIItem{}
+	for _, ctiItem := range allCTIItems {
+		if ctiItem.Archived || isExpired(ctiItem, now) {
+			continue
+		}
+		ranked = append(ranked, &RankedCTIItem{Item: ctiItem, Score: freshnessScore(ctiItem, now)})
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Score > ranked[j].Score
+	})
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+
+	return ranked, nil
+}
+
+// ExpireStaleCTIItems archives up to pageSize CTI items whose expiry has passed. Archived items stay on
+// the ledger but leave the tag index and their organisation's upload count. It returns the number
+// archived and the key to resume from, or an empty bookmark once every item has been scanned. It may
+// be run by an admin or by an oracle acting as a scheduled job.
+func (cc *SmartContract) ExpireStaleCTIItems(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*ExpiryResult, error) {
+	permissions, err := callerPermissions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !permissions.CanExpireItems {
+		return nil, fmt.Errorf("caller is not authorized: admin or oracle role required")
+	}
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("page size must be positive, got %d", pageSize)
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ExpiryResult{}
+	var expiredIDs []string
+	var notifications []*Notification
+	result.Bookmark, err = scanPage(ctx, ctiRangeStart, ctiRangeEnd, bookmark, pageSize, func(key string, value []byte) error {
+		var ctiItem CTIData
+		if err := json.Unmarshal(value, &ctiItem); err != nil {
+			return fmt.Errorf("failed to unmarshal CTI data: %v", err)
+		}
+		if ctiItem.Archived || ctiItem.Deleted || !isExpired(&ctiItem, now) {
+			return nil
+		}
+
+		if err := deleteCTIIndexes(ctx, &ctiItem); err != nil {
+			return err
+		}
+		ctiItem.Archived = true
+		ctiItem.ArchivedAt = now
+		if err := putCTIItem(ctx, &ctiItem); err != nil {
+			return err
+		}
+		if err := putCTIIndexes(ctx, &ctiItem); err != nil {
+			return err
+		}
+		if err := appendChangeLog(ctx, ctiItem.ID, "archived", fmt.Sprintf("archived after expiring at %d", ctiItem.ExpiresAt)); err != nil {
+			return err
+		}
+		notifications = append(notifications, &Notification{Recipient: ctiItem.Uploader, Type: "item-archived", RefID: ctiItem.ID, Message: fmt.Sprintf("CTI item %s expired and was archived", ctiItem.ID)})
+		expiredIDs = append(expiredIDs, ctiItem.ID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := notifyUsers(ctx, notifications); err != nil {
+		return nil, err
+	}
+
+	result.Expired = len(expiredIDs)
+	if len(expiredIDs) > 0 {
+		eventJSON, err := json.Marshal(CTIItemsExpiredEvent{IDs: expiredIDs})
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal expiry event: %v", err)
+		}
+		if err := ctx.GetStub().SetEvent("CTIItemsExpired", eventJSON); err != nil {
+			return nil, fmt.Errorf("failed to set expiry event: %v", err)
+		}
+	}
+
+	return result, nil
+}
+
+// logger returns the logger operations are logged to
+func (cc *SmartContract) logger() Logger {
+	if cc.Logger != nil {
+		return cc.Logger
+	}
+	return defaultLogger
+}
+
+// operationLine formats a structured key=value log line for an operation. Only transaction metadata and
+// the outcome are logged, never arguments, so sensitive fields such as encryption keys stay out of logs.
+func operationLine(ctx contractapi.TransactionContextInterface, operation string, outcome string, err error) string {
+	mspID, mspErr := ctx.GetClientIdentity().GetMSPID()
+	if mspErr != nil {
+		mspID = "unknown"
+	}
+
+	line := fmt.Sprintf("op=%s txid=%s msp=%q outcome=%s", operation, ctx.GetStub().GetTxID(), mspID, outcome)
+	if err != nil {
+		line += fmt.Sprintf(" error=%q", err.Error())
+	}
+	return line
+}
+
+// logOperationStart logs the start of an operation at debug level
+func (cc *SmartContract) logOperationStart(ctx contractapi.TransactionContextInterface, operation string) {
+	cc.logger().Debugf("%s", operationLine(ctx, operation, "started", nil))
+}
+
+// logOperationEnd logs the outcome of an operation at info level
+func (cc *SmartContract) logOperationEnd(ctx contractapi.TransactionContextInterface, operation string, err error) {
+	outcome := "ok"
+	if err != nil {
+		outcome = "error"
+	}
+	cc.logger().Infof("%s", operationLine(ctx, operation, outcome, err))
+}
+
+// normalizeTLP upper-cases a TLP marking and checks it is one of the known markings
+func normalizeTLP(tlp string) (string, error) {
+	tlp = strings.ToUpper(strings.TrimSpace(tlp))
+	if !containsString(tlpMarkings, tlp) {
+		return "", fmt.Errorf("invalid TLP marking %q: must be one of %s", tlp, strings.Join(tlpMarkings, ", "))
+	}
+	return tlp, nil
+}
+
+// GetCTIItemsByTLP returns the CTI items carrying a TLP marking, resolved through the TLP index.
+// Items recorded before TLP markings were introduced carry none and are never returned.
+func (cc *SmartContract) GetCTIItemsByTLP(ctx contractapi.TransactionContextInterface, tlp string) ([]*CTIData, error) {
+	tlp, err := normalizeTLP(tlp)
+	if err != nil {
+		return nil, err
+	}
+
+	ids, err := indexedCTIIDs(ctx, tlpIndex, tlp)
+	if err != nil {
+		return nil, err
+	}
+
+	ctiItems := []*CTIData{}
+	for _, id := range ids {
+		ctiItem, err := readCTIItem(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if ctiItem != nil {
+			ctiItems = append(ctiItems, ctiItem)
+		}
+	}
+
+	return redactForCaller(ctx, ctiItems)
+}
+
+// normalizeRegion upper-cases a region code and checks it is a known ISO 3166-1 alpha-2 code. An
+// empty region clears the item's region.
+func normalizeRegion(region string) (string, error) {
+	region = strings.ToUpper(strings.TrimSpace(region))
+	if region != "" && !regionCodes[region] {
+		return "", fmt.Errorf("invalid region %q: regions must be ISO 3166-1 alpha-2 country codes", region)
+	}
+	return region, nil
+}
+
+// SetCTIItemRegion sets the country a CTI item concerns. Only the uploader or an admin may set it; an
+// empty region clears it.
+func (cc *SmartContract) SetCTIItemRegion(ctx contractapi.TransactionContextInterface, id string, region string) error {
+	region, err := normalizeRegion(region)
+	if err != nil {
+		return err
+	}
+
+	permissions, err := callerPermissions(ctx)
+	if err != nil {
+		return err
+	}
+
+	ctiItem, err := readCTIItem(ctx, id)
+	if err != nil {
+		return err
+	}
+	if ctiItem == nil {
+		return fmt.Errorf("CTI item with ID %s does not exist", id)
+	}
+	if !permissions.IsAdmin && ctiItem.Uploader != permissions.ID {
+		return fmt.Errorf("caller is not authorized: only the uploader or an admin can set the region of CTI item %s", id)
+	}
+
+	if err := deleteCTIIndexes(ctx, ctiItem); err != nil {
+		return err
+	}
+	ctiItem.Region = region
+	if err := putCTIItem(ctx, ctiItem); err != nil {
+		return err
+	}
+	if err := putCTIIndexes(ctx, ctiItem); err != nil {
+		return err
+	}
+
+	summary := "region cleared"
+	if region != "" {
+		summary = "region set to " + region
+	}
+	return appendChangeLog(ctx, id, "region-set", summary)
+}
+
+// GetCTIItemsByRegion returns the CTI items concerning a country, given as an ISO 3166-1 alpha-2 code
+func (cc *SmartContract) GetCTIItemsByRegion(ctx contractapi.TransactionContextInterface, region string) ([]*CTIData, error) {
+	region, err := normalizeRegion(region)
+	if err != nil {
+		return nil, err
+	}
+	if region == "" {
+		return nil, fmt.Errorf("region must not be empty")
+	}
+
+	ids, err := indexedCTIIDs(ctx, regionIndex, region)
+	if err != nil {
+		return nil, err
+	}
+
+	ctiItems := []*CTIData{}
+	for _, id := range ids {
+		ctiItem, err := readCTIItem(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if ctiItem != nil {
+			ctiItems = append(ctiItems, ctiItem)
+		}
+	}
+
+	return redactForCaller(ctx, ctiItems)
+}
+
+// GetSimilarCTIItems returns up to limit CTI items ranked by the number of tags and indicator values they
+// share with the given item, most similar first. The item itself and archived items are left out.
+func (cc *SmartContract) GetSimilarCTIItems(ctx contractapi.TransactionContextInterface, id string, limit int) ([]*RankedCTIItem, error) {
+	if limit < 1 || limit > maxRankedItems {
+		return nil, fmt.Errorf("limit must be between 1 and %d, got %d", maxRankedItems, limit)
+	}
+
+	ctiItem, err := readCTIItem(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if ctiItem == nil {
+		return nil, fmt.Errorf("CTI item with ID %s does not exist", id)
+	}
+
+	// Count shared tags through the tag index, which only holds unarchived items
+	shared := make(map[string]int)
+	for _, tag := range normalizeTags(ctiItem.Tags) {
+		ids, err := indexedCTIIDs(ctx, tagIndex, tag)
+		if err != nil {
+			return nil, err
+		}
+		for _, otherID := range ids {
+			if otherID != id {
+				shared[otherID]++
+			}
+		}
+	}
+
+	// Count shared indicator values through the indicator index; archived items are dropped below
+	var values []string
+	for _, indicator := range ctiItem.Indicators {
+		if !containsString(values, indicator.Value) {
+			values = append(values, indicator.Value)
+		}
+	}
+	for _, value := range values {
+		ids, err := indexedCTIIDs(ctx, indicatorIndex, value)
+		if err != nil {
+			return nil, err
+		}
+		for _, otherID := range ids {
+			if otherID != id {
+				shared[otherID]++
+			}
+		}
+	}
+
+	access, err := newAccessContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ranked := []*RankedCTIItem{}
+	for otherID, count := range shared {
+		other, err := readCTIItem(ctx, otherID)
+		if err != nil {
+			return nil, err
+		}
+		if other == nil || other.Archived {
+			continue
+		}
+		if !access.canAccess(other) {
+			other = redactCTIItem(other)
+		}
+		ranked = append(ranked, &RankedCTIItem{Item: other, Score: float64(count)})
+	}
+
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
+		}
+		return ranked[i].Item.Timestamp > ranked[j].Item.Timestamp
+	})
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+
+	return ranked, nil
+}
+
+// GetMyReviewSummary summarises how the caller's uploaded CTI items have been reviewed
+func (cc *SmartContract) GetMyReviewSummary(ctx contractapi.TransactionContextInterface) (*UploaderReviewSummary, error) {
+	caller, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current peer ID: %v", err)
+	}
+
+	ids, err := indexedCTIIDs(ctx, uploaderIndex, caller)
+	if err != nil {
+		return nil, err
+	}
+	uploaded := make(map[string]bool)
+	for _, id := range ids {
+		uploaded[id] = true
+	}
+
+	allReviewData, err := cc.GetAllReviewData(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all review data entries: %v", err)
+	}
+
+	summary := &UploaderReviewSummary{UploaderID: caller, ItemCount: len(ids), DimensionAverages: map[string]float64{}}
+	dimensionCounts := make(map[string]int)
+	itemReviews := make(map[string][]*ReviewData)
+	for _, review := range allReviewData {
+		if !uploaded[review.CTIDataID] {
+			continue
+		}
+		itemReviews[review.CTIDataID] = append(itemReviews[review.CTIDataID], review)
+		summary.ReviewsReceived++
+		summary.AverageComposite += compositeScore(review)
+		for dimension, score := range reviewScores(review) {
+			summary.DimensionAverages[dimension] += float64(score)
+			dimensionCounts[dimension]++
+		}
+	}
+
+	// Nothing to average before the first review arrives
+	if summary.ReviewsReceived == 0 {
+		return summary, nil
+	}
+	summary.AverageComposite /= float64(summary.ReviewsReceived)
+	for dimension, count := range dimensionCounts {
+		summary.DimensionAverages[dimension] /= float64(count)
+	}
+
+	// Walk items in ID order so ties resolve the same way on every peer
+	reviewedIDs := make([]string, 0, len(itemReviews))
+	for id := range itemReviews {
+		reviewedIDs = append(reviewedIDs, id)
+	}
+	sort.Strings(reviewedIDs)
+	for _, id := range reviewedIDs {
+		score := averageCompositeScore(itemReviews[id])
+		if summary.BestItemID == "" || score > summary.BestItemScore {
+			summary.BestItemID, summary.BestItemScore = id, score
+		}
+		if summary.WorstItemID == "" || score < summary.WorstItemScore {
+			summary.WorstItemID, summary.WorstItemScore = id, score
+		}
+	}
+
+	return summary, nil
+}
+
+// appendChangeLog adds an entry to a CTI item's changelog. Entries are separate keys, so concurrent
+// changes to different items never conflict over a shared list.
+func appendChangeLog(ctx contractapi.TransactionContextInterface, id string, action string, summary string) error {
+	actor, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get current peer ID: %v", err)
+	}
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	txID := ctx.GetStub().GetTxID()
+
+	entryJSON, err := json.Marshal(ChangeLogEntry{Action: action, Actor: actor, Timestamp: timestamp, TxID: txID, Summary: summary})
+	if err != nil {
+		return fmt.Errorf("failed to marshal changelog entry: %v", err)
+	}
+
+	// Zero-padding keeps each item's entries in time order
+	key, err := ctx.GetStub().CreateCompositeKey(changeLogIndex, []string{id, fmt.Sprintf("%020d", timestamp), txID, action})
+	if err != nil {
+		return fmt.Errorf("failed to create %s key: %v", changeLogIndex, err)
+	}
+	if err := ctx.GetStub().PutState(key, entryJSON); err != nil {
+		return fmt.Errorf("failed to put changelog entry on ledger: %v", err)
+	}
+
+	return nil
+}
+
+// describeCTIItemChanges summarises the content changes made by an update, without revealing the
+// CID or encryption key themselves
+func describeCTIItemChanges(before, after *CTIData) string {
+	var changes []string
+	if before.Name != after.Name {
+		changes = append(changes, "name changed")
+	}
+	if before.CID != after.CID {
+		changes = append(changes, "content CID changed")
+	}
+	if before.EncryptKey != after.EncryptKey {
+		changes = append(changes, "encryption key rotated")
+	}
+	if before.Level != after.Level {
+		changes = append(changes, fmt.Sprintf("level changed from %d to %d", before.Level, after.Level))
+	}
+	if strings.Join(before.Techniques, ",") != strings.Join(after.Techniques, ",") {
+		changes = append(changes, "ATT&CK techniques changed")
+	}
+	if before.Confidence != after.Confidence {
+		changes = append(changes, fmt.Sprintf("confidence changed from %d to %d", before.Confidence, after.Confidence))
+	}
+	if before.TLP != after.TLP {
+		changes = append(changes, fmt.Sprintf("TLP changed from %q to %q", before.TLP, after.TLP))
+	}
+	if before.Timestamp != after.Timestamp {
+		changes = append(changes, "timestamp changed")
+	}
+	if before.Uploader != after.Uploader {
+		changes = append(changes, "uploader changed")
+	}
+	if len(changes) == 0 {
+		return "no content changes"
+	}
+	return strings.Join(changes, "; ")
+}
+
+// GetCTIChangeLog returns the changelog of a CTI item, oldest first
+func (cc *SmartContract) GetCTIChangeLog(ctx contractapi.TransactionContextInterface, id string) ([]*ChangeLogEntry, error) {
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(changeLogIndex, []string{id})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read changelog of CTI item %s: %v", id, err)
+	}
+	defer iterator.Close()
+
+	entries := []*ChangeLogEntry{}
+	for iterator.HasNext() {
+		item, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate over changelog of CTI item %s: %v", id, err)
+		}
+
+		var entry ChangeLogEntry
+		if err := json.Unmarshal(item.Value, &entry); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal changelog entry: %v", err)
+		}
+		entries = append(entries, &entry)
+	}
+
+	return entries, nil
+}
+
+// AckActivity records that the caller has caught up with activity on the platform, so the next
+// GetActivitySince counts from now
+func (cc *SmartContract) AckActivity(ctx contractapi.TransactionContextInterface) error {
+	caller, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get current peer ID: %v", err)
+	}
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	userData, err := readUserData(ctx, caller)
+	if err != nil {
+		return err
+	}
+	if userData == nil {
+		userData = newUserData(caller)
+	}
+	userData.LastSeenAt = now
+	userData.LastSeenPoints = userData.Points
+
+	return putUserData(ctx, userData)
+}
+
+// GetActivitySince counts the CTI items uploaded, the reviews received on the caller's items and the
+// change in the caller's points since the caller last called AckActivity. Items count as new by the
+// time they were recorded on the ledger, not the uploader-supplied timestamp. Callers who never
+// acknowledged their activity see everything as new.
+func (cc *SmartContract) GetActivitySince(ctx contractapi.TransactionContextInterface) (*ActivitySummary, error) {
+	caller, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current peer ID: %v", err)
+	}
+
+	userData, err := readUserData(ctx, caller)
+	if err != nil {
+		return nil, err
+	}
+	if userData == nil {
+		userData = newUserData(caller)
+	}
+	summary := &ActivitySummary{Since: userData.LastSeenAt, PointsChange: userData.Points - userData.LastSeenPoints}
+
+	allCTIItems, err := cc.GetAllCTIItems(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all CTI data entries: %v", err)
+	}
+	uploaded := make(map[string]bool)
+	for _, ctiItem := range allCTIItems {
+		if ctiCreatedAt(ctiItem) > summary.Since {
+			summary.NewCTIItems++
+		}
+		if ctiItem.Uploader == caller {
+			uploaded[ctiItem.ID] = true
+		}
+	}
+
+	allReviewData, err := cc.GetAllReviewData(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all review data entries: %v", err)
+	}
+	for _, review := range allReviewData {
+		if uploaded[review.CTIDataID] && review.Timestamp > summary.Since {
+			summary.NewReviewsOnItems++
+		}
+	}
+
+	return summary, nil
 }
 
-// CTIData represents the data structure for CTI data entries
-type CTIData struct {
-	ID         string `json:"ID"`
-	Name       string `json:"Name"`
-	Uploader   string `json:"Uploader"`
-	Timestamp  int    `json:"Timestamp"`
-	CID        string `json:"CID"`
-	EncryptKey string `json:"encryptKey"`
-	Points     int    `json:"Points"`
-	Level      int    `json:"Level"`
+// SetCTIContentHash records the hex SHA-256 of a CTI item's off-chain content, against which oracles
+// later verify the content. Only the uploader may set it. Changing the hash of a reviewed item flags
+// the item for re-review.
+func (cc *SmartContract) SetCTIContentHash(ctx contractapi.TransactionContextInterface, id string, contentHash string) error {
+	contentHash = strings.ToLower(contentHash)
+	if !sha256HexPattern.MatchString(contentHash) {
+		return fmt.Errorf("invalid content hash %q: expected 64 hex characters", contentHash)
+	}
+
+	caller, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get current peer ID: %v", err)
+	}
+
+	ctiItem, err := readCTIItem(ctx, id)
+	if err != nil {
+		return err
+	}
+	if ctiItem == nil {
+		return fmt.Errorf("CTI item with ID %s does not exist", id)
+	}
+	if ctiItem.Uploader != caller {
+		return fmt.Errorf("caller is not authorized: only the uploader can set the content hash of CTI item %s", id)
+	}
+
+	// Reviews vetted the content the old hash described, so a reviewed item must be reviewed again
+	if ctiItem.ContentHash == contentHash {
+		return nil
+	}
+	if ctiItem.ReviewCount > 0 {
+		ctiItem.NeedsReReview = true
+	}
+	ctiItem.ContentHash = contentHash
+	ctiItem.TamperSuspected = false
+	if err := putCTIItem(ctx, ctiItem); err != nil {
+		return err
+	}
+	return appendChangeLog(ctx, id, "content-hash-set", "content hash set to "+contentHash)
 }
 
-// UserData represents the data structure for user entries
-type UserData struct {
-	ID          string `json:"ID"`
-	UserLevel   int    `json:"UserLevel"`
-	UploadCount int    `json:"UploadCount"`
-	Points      int    `json:"Points"`
-	Subscribed  int    `json:"Subscribed"`
-	Balance     int    `json:"Balance"`
+// VerifyContentHashesBatch compares an oracle's report of computed content hashes, a JSON array of
+// {"id", "computedHash"} objects, with the hashes stored on the CTI items. Mismatching items are
+// flagged TamperSuspected and matching ones cleared. Items without a stored hash are not judged.
+// It returns the IDs of the mismatching items.
+func (cc *SmartContract) VerifyContentHashesBatch(ctx contractapi.TransactionContextInterface, reportJSON string) ([]string, error) {
+	permissions, err := callerPermissions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !permissions.IsOracle {
+		return nil, fmt.Errorf("caller is not authorized: oracle role required")
+	}
+
+	var report []ContentHashReport
+	if err := json.Unmarshal([]byte(reportJSON), &report); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal content hash report: %v", err)
+	}
+	if len(report) == 0 {
+		return nil, fmt.Errorf("content hash report is empty")
+	}
+	if len(report) > maxHashReportSize {
+		return nil, fmt.Errorf("at most %d content hashes can be reported at once, got %d", maxHashReportSize, len(report))
+	}
+
+	mismatched := []string{}
+	for _, entry := range report {
+		ctiItem, err := readCTIItem(ctx, entry.ID)
+		if err != nil {
+			return nil, err
+		}
+		if ctiItem == nil {
+			return nil, fmt.Errorf("CTI item with ID %s does not exist", entry.ID)
+		}
+		if ctiItem.ContentHash == "" {
+			continue
+		}
+
+		suspect := !strings.EqualFold(ctiItem.ContentHash, entry.ComputedHash)
+		if suspect {
+			mismatched = append(mismatched, entry.ID)
+		}
+		if ctiItem.TamperSuspected != suspect {
+			ctiItem.TamperSuspected = suspect
+			if err := putCTIItem(ctx, ctiItem); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return mismatched, nil
 }
 
-// ReviewData represents the data structure for review entries
-type ReviewData struct {
-	ID           string `json:"ID"`
-	UserDataID   string `json:"UserDataID"`
-	CTIDataID    string `json:"CTIDataID"`
-	Accuracy     int    `json:"Accuracy"`
-	Timeliness   int    `json:"Timeliness"`
-	Completeness int    `json:"Completeness"`
-	Consistency  int    `json:"Consistency"`
-	ReviewText   string `json:"ReviewText"`
-}
-
-// AddCTIItem adds a new CTI item to the ledger
-func (cc *SmartContract) AddCTIItem(ctx contractapi.TransactionContextInterface, name string, timestamp int, cid string, encryptKey string, points int, level int) error {
-	// Get the current peer ID
-	uploader, err := ctx.GetClientIdentity().GetID()
+// accessLogKey returns the access log key for an access to a CTI item at a time
+func accessLogKey(timestamp int64, id string, txID string) string {
+	return fmt.Sprintf("%s%020d_%s_%s", accessLogPrefix, timestamp, id, txID)
+}
+
+// RecordCTIAccess logs that the caller unlocked a CTI item's content. Only callers entitled to the
+// item can record an access.
+func (cc *SmartContract) RecordCTIAccess(ctx contractapi.TransactionContextInterface, id string) error {
+	ctiItem, err := readCTIItem(ctx, id)
 	if err != nil {
-		return fmt.Errorf("failed to get uploader ID: %v", err)
+		return err
+	}
+	if ctiItem == nil {
+		return fmt.Errorf("CTI item with ID %s does not exist", id)
 	}
 
-	// Get the current ID from the ledger
-	idBytes, err := ctx.GetStub().GetState("latestID")
+	entitled, err := canAccess(ctx, ctiItem)
 	if err != nil {
-		//return fmt.Errorf("failed to read latest ID from ledger: %v", err)
+		return err
 	}
-	var latestID int
-	if idBytes == nil {
-		latestID = 1 // Start with ID = 1 if it's the first entry
-	} else {
-		latestID, err = strconv.Atoi(string(idBytes))
+	if !entitled {
+		return fmt.Errorf("caller is not entitled to CTI item %s", id)
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+	if err := ctx.GetStub().PutState(accessLogKey(now, id, ctx.GetStub().GetTxID()), []byte(id)); err != nil {
+		return fmt.Errorf("failed to put access log entry on ledger: %v", err)
+	}
+
+	return nil
+}
+
+// GetTrendingCTIItems ranks CTI items by the number of accesses recorded in the last windowSeconds
+// and returns the top limit, most accessed first. Archived items are left out.
+func (cc *SmartContract) GetTrendingCTIItems(ctx contractapi.TransactionContextInterface, windowSeconds int64, limit int) ([]*RankedCTIItem, error) {
+	if windowSeconds < 1 || windowSeconds > maxTrendingWindow {
+		return nil, fmt.Errorf("window must be between 1 and %d seconds, got %d", maxTrendingWindow, windowSeconds)
+	}
+	if limit < 1 || limit > maxRankedItems {
+		return nil, fmt.Errorf("limit must be between 1 and %d, got %d", maxRankedItems, limit)
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read every access from the start of the window up to and including now
+	startKey := fmt.Sprintf("%s%020d", accessLogPrefix, now-windowSeconds)
+	endKey := fmt.Sprintf("%s%020d", accessLogPrefix, now+1)
+	iterator, err := ctx.GetStub().GetStateByRange(startKey, endKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read access log: %v", err)
+	}
+	defer iterator.Close()
+
+	accesses := make(map[string]int)
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
 		if err != nil {
-			return fmt.Errorf("failed to convert latest ID to integer: %v", err)
+			return nil, fmt.Errorf("failed to iterate over access log: %v", err)
 		}
-		latestID++ // Increment the ID
+		accesses[string(entry.Value)]++
 	}
 
-	// Create the CTIData instance
-	ctiItem := CTIData{
-		ID:         strconv.Itoa(latestID),
-		Name:       name,
-		Uploader:   uploader,
-		Timestamp:  timestamp,
-		CID:        cid,
-		EncryptKey: encryptKey,
-		Points:     points,
-		Level:      level,
+	access, err := newAccessContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ranked := []*RankedCTIItem{}
+	for id, count := range accesses {
+		ctiItem, err := readCTIItem(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if ctiItem == nil || ctiItem.Archived {
+			continue
+		}
+		if !access.canAccess(ctiItem) {
+			ctiItem = redactCTIItem(ctiItem)
+		}
+		ranked = append(ranked, &RankedCTIItem{Item: ctiItem, Score: float64(count)})
 	}
 
-	// Convert CTIData to JSON
-	ctiItemJSON, err := json.Marshal(ctiItem)
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
+		}
+		return ranked[i].Item.ID < ranked[j].Item.ID
+	})
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+
+	return ranked, nil
+}
+
+// allUserData reads every user record on the ledger
+func allUserData(ctx contractapi.TransactionContextInterface) ([]*UserData, error) {
+	prefix := recordKeyPrefixes[RecordTypeUser]
+	iterator, err := ctx.GetStub().GetStateByRange(prefix, prefix+"\U0010FFFF")
 	if err != nil {
-		return fmt.Errorf("failed to marshal CTIData to JSON: %v", err)
+		return nil, fmt.Errorf("failed to read user data entries: %v", err)
+	}
+	defer iterator.Close()
+
+	var users []*UserData
+	for iterator.HasNext() {
+		item, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get next item in iterator: %v", err)
+		}
+
+		var userData UserData
+		if err := json.Unmarshal(item.Value, &userData); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal user data: %v", err)
+		}
+		users = append(users, &userData)
+	}
+
+	return users, nil
+}
+
+// GetReviewParticipation estimates what fraction of the users entitled to a CTI item have reviewed it.
+// Entitled users are those subscribed at or above the item's level; reviewers are counted once each.
+func (cc *SmartContract) GetReviewParticipation(ctx contractapi.TransactionContextInterface, ctiDataID string) (*ReviewParticipation, error) {
+	ctiItem, err := readCTIItem(ctx, ctiDataID)
+	if err != nil {
+		return nil, err
+	}
+	if ctiItem == nil {
+		return nil, fmt.Errorf("CTI item with ID %s does not exist", ctiDataID)
+	}
+
+	reviews, err := cc.GetReviewDataByCTIDataID(ctx, ctiDataID)
+	if err != nil {
+		return nil, err
+	}
+	reviewers := make(map[string]bool)
+	for _, review := range reviews {
+		reviewers[review.UserDataID] = true
 	}
 
-	// Put the CTIData on the ledger
-	if err := ctx.GetStub().PutState(fmt.Sprintf("CTI_%d", latestID), ctiItemJSON); err != nil {
-		return fmt.Errorf("failed to put CTI data on ledger: %v", err)
+	users, err := allUserData(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	// Update the latest ID on the ledger
-	if err := ctx.GetStub().PutState("latestID", []byte(strconv.Itoa(latestID))); err != nil {
-		return fmt.Errorf("failed to update latest ID on ledger: %v", err)
+	participation := &ReviewParticipation{CTIDataID: ctiDataID, ReviewCount: len(reviews), Reviewers: len(reviewers)}
+	for _, userData := range users {
+		if userData.Subscribed >= ctiItem.Level {
+			participation.EntitledUsers++
+		}
+	}
+	if participation.EntitledUsers > 0 {
+		participation.Rate = float64(participation.Reviewers) / float64(participation.EntitledUsers)
 	}
 
+	return participation, nil
+}
+
+// validateCID checks that a CID is a well-formed CIDv0 or base32 CIDv1 string
+func validateCID(cid string) error {
+	if !cidV0Pattern.MatchString(cid) && !cidV1Pattern.MatchString(cid) {
+		return fmt.Errorf("invalid CID %q: expected a base58 CIDv0 (Qm...) or base32 CIDv1 (b...)", cid)
+	}
 	return nil
 }
 
-func (cc *SmartContract) UpdateCTIItem(ctx contractapi.TransactionContextInterface, id string, name string, timestamp int, cid string, encryptKey string, points, level int) error {
-	// Get the current peer ID
-	uploader, err := ctx.GetClientIdentity().GetID()
+// ValidateCID reports whether a CID is well-formed, so clients can check one before submitting it.
+// An invalid CID is reported with an error describing the expected formats.
+func (cc *SmartContract) ValidateCID(ctx contractapi.TransactionContextInterface, cid string) (bool, error) {
+	if err := validateCID(cid); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// UpdateCTICID points a CTI item at re-pinned content, replacing its CID and content hash together.
+// Only the uploader may do so. Reviews vetted the old content, so a reviewed item is flagged for
+// re-review, and the CID's availability and tamper checks start over.
+func (cc *SmartContract) UpdateCTICID(ctx contractapi.TransactionContextInterface, id string, newCID string, newContentHash string) error {
+	if err := validateCID(newCID); err != nil {
+		return err
+	}
+	if newContentHash == "" {
+		return fmt.Errorf("a content hash is required when changing the CID")
+	}
+	newContentHash = strings.ToLower(newContentHash)
+	if !sha256HexPattern.MatchString(newContentHash) {
+		return fmt.Errorf("invalid content hash %q: expected 64 hex characters", newContentHash)
+	}
+
+	caller, err := ctx.GetClientIdentity().GetID()
 	if err != nil {
-		return fmt.Errorf("failed to get uploader ID: %v", err)
+		return fmt.Errorf("failed to get current peer ID: %v", err)
 	}
 
-	// Check if the CTI item exists
-	ctiItemJSON, err := ctx.GetStub().GetState(fmt.Sprintf("CTI_%s", id))
+	ctiItem, err := readCTIItem(ctx, id)
 	if err != nil {
-		return fmt.Errorf("failed to read CTI item from ledger: %v", err)
+		return err
 	}
-	if ctiItemJSON == nil {
+	if ctiItem == nil {
 		return fmt.Errorf("CTI item with ID %s does not exist", id)
 	}
+	if ctiItem.Uploader != caller {
+		return fmt.Errorf("caller is not authorized: only the uploader can change the CID of CTI item %s", id)
+	}
 
-	// Update the CTI item
-	ctiItem := CTIData{
-		ID:         id,
-		Name:       name,
-		Uploader:   uploader,
-		Timestamp:  timestamp,
-		CID:        cid,
-		EncryptKey: encryptKey,
-		Points:     points,
-		Level:      level,
+	// Re-index under the new CID
+	if err := deleteCTIIndexes(ctx, ctiItem); err != nil {
+		return err
+	}
+	ctiItem.CID = newCID
+	ctiItem.ContentHash = newContentHash
+	ctiItem.CIDAvailable = false
+	ctiItem.LastCheckedAt = 0
+	ctiItem.TamperSuspected = false
+	if ctiItem.ReviewCount > 0 {
+		ctiItem.NeedsReReview = true
+	}
+	if err := putCTIItem(ctx, ctiItem); err != nil {
+		return err
+	}
+	if err := putCTIIndexes(ctx, ctiItem); err != nil {
+		return err
+	}
+	if err := appendChangeLog(ctx, id, "cid-updated", "content re-pinned to a new CID"); err != nil {
+		return err
 	}
 
-	// Convert CTI data to JSON
-	ctiItemJSON, err = json.Marshal(ctiItem)
+	eventJSON, err := json.Marshal(CTICIDUpdatedEvent{ID: id, ContentHash: newContentHash, UpdatedBy: caller})
 	if err != nil {
-		return fmt.Errorf("failed to marshal CTI item to JSON: %v", err)
+		return fmt.Errorf("failed to marshal CID update event: %v", err)
+	}
+	if err := ctx.GetStub().SetEvent("CTICIDUpdated", eventJSON); err != nil {
+		return fmt.Errorf("failed to set CID update event: %v", err)
 	}
 
-	// Put the updated CTI item on the ledger
-	if err := ctx.GetStub().PutState(fmt.Sprintf("CTI_%s", id), ctiItemJSON); err != nil {
-		return fmt.Errorf("failed to put updated CTI item on ledger: %v", err)
+	return nil
+}
+
+// GetCTIItemsByReviewVelocity ranks CTI items by the number of reviews they received in the last
+// windowSeconds and returns the top limit, most reviewed first. Archived items are left out.
+func (cc *SmartContract) GetCTIItemsByReviewVelocity(ctx contractapi.TransactionContextInterface, windowSeconds int64, limit int) ([]*RankedCTIItem, error) {
+	if windowSeconds < 1 || windowSeconds > maxTrendingWindow {
+		return nil, fmt.Errorf("window must be between 1 and %d seconds, got %d", maxTrendingWindow, windowSeconds)
+	}
+	if limit < 1 || limit > maxRankedItems {
+		return nil, fmt.Errorf("limit must be between 1 and %d, got %d", maxRankedItems, limit)
+	}
+
+	now, err := txTimestamp(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	allReviewData, err := cc.GetAllReviewData(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get all review data entries: %v", err)
+	}
+	recentReviews := make(map[string]int)
+	for _, review := range allReviewData {
+		if review.Timestamp >= now-windowSeconds && review.Timestamp <= now {
+			recentReviews[review.CTIDataID]++
+		}
+	}
+
+	access, err := newAccessContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ranked := []*RankedCTIItem{}
+	for id, count := range recentReviews {
+		ctiItem, err := readCTIItem(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if ctiItem == nil || ctiItem.Archived {
+			continue
+		}
+		if !access.canAccess(ctiItem) {
+			ctiItem = redactCTIItem(ctiItem)
+		}
+		ranked = append(ranked, &RankedCTIItem{Item: ctiItem, Score: float64(count)})
+	}
+
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
+		}
+		return ranked[i].Item.ID < ranked[j].Item.ID
+	})
+	if len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+
+	return ranked, nil
+}
+
+// notifyUser adds a notification to a user's inbox. See notifyUsers.
+func notifyUser(ctx contractapi.TransactionContextInterface, recipient string, notificationType string, refID string, message string) error {
+	return notifyUsers(ctx, []*Notification{{Recipient: recipient, Type: notificationType, RefID: refID, Message: message}})
+}
+
+// notifyUsers adds notifications to their recipients' inboxes, evicting the oldest ones once an inbox is
+// full. Notification IDs start with the zero-padded transaction timestamp, so each inbox is kept in time
+// order. Range reads do not see the transaction's own writes, so a transaction must send all of its
+// notifications in one call for every inbox to stay within maxNotificationsPerUser.
+func notifyUsers(ctx contractapi.TransactionContextInterface, notifications []*Notification) error {
+	timestamp, err := txTimestamp(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Read each recipient's inbox once, in the order recipients are first notified
+	inboxes := make(map[string][]string)
+	for _, notification := range notifications {
+		notification.ID = fmt.Sprintf("%020d_%s_%s_%s", timestamp, ctx.GetStub().GetTxID(), notification.Type, notification.RefID)
+		notification.Timestamp = timestamp
+
+		key, err := ctx.GetStub().CreateCompositeKey(notificationIndex, []string{notification.Recipient, notification.ID})
+		if err != nil {
+			return fmt.Errorf("failed to create %s key: %v", notificationIndex, err)
+		}
+		keys, ok := inboxes[notification.Recipient]
+		if !ok {
+			keys, err = inboxKeys(ctx, notification.Recipient)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Make room before adding
+		evicted := 0
+		for ; evicted <= len(keys)-maxNotificationsPerUser; evicted++ {
+			if err := ctx.GetStub().DelState(keys[evicted]); err != nil {
+				return fmt.Errorf("failed to evict notification: %v", err)
+			}
+		}
+		keys = keys[evicted:len(keys):len(keys)]
+		if !containsString(keys, key) {
+			keys = append(keys, key)
+		}
+		inboxes[notification.Recipient] = keys
+
+		notificationJSON, err := json.Marshal(notification)
+		if err != nil {
+			return fmt.Errorf("failed to marshal notification: %v", err)
+		}
+		if err := ctx.GetStub().PutState(key, notificationJSON); err != nil {
+			return fmt.Errorf("failed to put notification on ledger: %v", err)
+		}
 	}
 
 	return nil
 }
 
-// GetCTIItem retrieves a CTI item from the ledger by its ID
-func (cc *SmartContract) GetCTIItem(ctx contractapi.TransactionContextInterface, id int) (*CTIData, error) {
-	ctiItemJSON, err := ctx.GetStub().GetState(fmt.Sprintf("CTI_%d", id))
+// inboxKeys returns the keys of a recipient's committed notifications, oldest first
+func inboxKeys(ctx contractapi.TransactionContextInterface, recipient string) ([]string, error) {
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(notificationIndex, []string{recipient})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notifications of %s: %v", recipient, err)
+	}
+	defer iterator.Close()
+
+	var keys []string
+	for iterator.HasNext() {
+		item, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate over notifications of %s: %v", recipient, err)
+		}
+		keys = append(keys, item.Key)
+	}
+	return keys, nil
+}
+
+// GetMyNotifications returns the caller's notifications, oldest first, optionally only the unread ones
+func (cc *SmartContract) GetMyNotifications(ctx contractapi.TransactionContextInterface, unreadOnly bool) ([]*Notification, error) {
+	caller, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current peer ID: %v", err)
+	}
+
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(notificationIndex, []string{caller})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notifications: %v", err)
+	}
+	defer iterator.Close()
+
+	notifications := []*Notification{}
+	for iterator.HasNext() {
+		item, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate over notifications: %v", err)
+		}
+
+		var notification Notification
+		if err := json.Unmarshal(item.Value, &notification); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal notification: %v", err)
+		}
+		if unreadOnly && notification.Read {
+			continue
+		}
+		notifications = append(notifications, &notification)
+	}
+
+	return notifications, nil
+}
+
+// MarkNotificationRead marks one of the caller's notifications as read
+func (cc *SmartContract) MarkNotificationRead(ctx contractapi.TransactionContextInterface, notificationID string) error {
+	caller, err := ctx.GetClientIdentity().GetID()
+	if err != nil {
+		return fmt.Errorf("failed to get current peer ID: %v", err)
+	}
+
+	// Keying by recipient means callers can only reach their own notifications
+	key, err := ctx.GetStub().CreateCompositeKey(notificationIndex, []string{caller, notificationID})
+	if err != nil {
+		return fmt.Errorf("failed to create %s key: %v", notificationIndex, err)
+	}
+	notificationJSON, err := ctx.GetStub().GetState(key)
+	if err != nil {
+		return fmt.Errorf("failed to read notification: %v", err)
+	}
+	if notificationJSON == nil {
+		return fmt.Errorf("notification %s does not exist", notificationID)
+	}
+
+	var notification Notification
+	if err := json.Unmarshal(notificationJSON, &notification); err != nil {
+		return fmt.Errorf("failed to unmarshal notification: %v", err)
+	}
+	if notification.Read {
+		return nil
+	}
+	notification.Read = true
+
+	notificationJSON, err = json.Marshal(notification)
 	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %v", err)
+	}
+	if err := ctx.GetStub().PutState(key, notificationJSON); err != nil {
+		return fmt.Errorf("failed to put notification on ledger: %v", err)
+	}
+
+	return nil
+}
+
+// partnerCTIItem returns a copy of a CTI item fit for an external partner, without the content
+// locator, encryption key, subscription gate or other internal bookkeeping
+func partnerCTIItem(ctiItem *CTIData) *CTIData {
+	partnerItem := redactCTIItem(ctiItem)
+	partnerItem.Uploader = ""
+	partnerItem.Level = 0
+	partnerItem.StakeEscrow = 0
+	partnerItem.NeedsReReview = false
+	return partnerItem
+}
+
+// ExportForPartner returns a page of the CTI items that may be shared with the partner organisation
+// partnerMSP: active, untampered items marked TLP WHITE or GREEN and scoring at least minQuality, with
+// internal fields stripped. Unmarked items are never exported.
+// Items the partner uploaded itself are left out. Filters are applied after paging, so a page may hold
+// fewer than pageSize items; an empty bookmark marks the last page.
+func (cc *SmartContract) ExportForPartner(ctx contractapi.TransactionContextInterface, partnerMSP string, minQuality float64, pageSize int32, bookmark string) (*CTIItemsPage, error) {
+	if err := requireAdmin(ctx); err != nil {
 		return nil, err
 	}
-	if ctiItemJSON == nil {
-		return nil, fmt.Errorf("CTI item with ID %d does not exist", id)
+	if partnerMSP == "" {
+		return nil, fmt.Errorf("partner MSP ID must not be empty")
+	}
+	if math.IsNaN(minQuality) || minQuality < 0 {
+		return nil, fmt.Errorf("minimum quality must not be negative, got %v", minQuality)
+	}
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("page size must be positive, got %d", pageSize)
 	}
 
-	var ctiItem CTIData
-	err = json.Unmarshal(ctiItemJSON, &ctiItem)
+	now, err := txTimestamp(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	return &ctiItem, nil
-}
-
-// GetAllCTIItems retrieves all CTI data entries from the ledger
-func (cc *SmartContract) GetAllCTIItems(ctx contractapi.TransactionContextInterface) ([]*CTIData, error) {
-	resultsIterator, err := ctx.GetStub().GetStateByRange("CTI_0", "CTI_999999")
+	iterator, metadata, err := ctx.GetStub().GetStateByRangeWithPagination(ctiRangeStart, ctiRangeEnd, pageSize, bookmark)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get CTI data range: %v", err)
 	}
-	defer resultsIterator.Close()
+	defer iterator.Close()
 
-	var ctiItems []*CTIData
-	for resultsIterator.HasNext() {
-		item, err := resultsIterator.Next()
+	page := &CTIItemsPage{Items: []*CTIData{}}
+	for iterator.HasNext() {
+		item, err := iterator.Next()
 		if err != nil {
 			return nil, fmt.Errorf("failed to iterate over CTI data range: %v", err)
 		}
@@ -182,348 +7917,601 @@ func (cc *SmartContract) GetAllCTIItems(ctx contractapi.TransactionContextInterf
 		if err := json.Unmarshal(item.Value, &ctiItem); err != nil {
 			return nil, fmt.Errorf("failed to unmarshal CTI data: %v", err)
 		}
-		ctiItems = append(ctiItems, &ctiItem)
+		if ctiItem.Archived || ctiItem.Deleted || isExpired(&ctiItem, now) || ctiItem.TamperSuspected {
+			continue
+		}
+		if !containsString(partnerTLPMarkings, ctiItem.TLP) {
+			continue
+		}
+		if ctiItem.UploaderMSP == partnerMSP || ctiItem.CompositeScore < minQuality {
+			continue
+		}
+		page.Items = append(page.Items, partnerCTIItem(&ctiItem))
 	}
 
-	return ctiItems, nil
+	if metadata != nil {
+		page.FetchedCount = metadata.FetchedRecordsCount
+		if metadata.FetchedRecordsCount == pageSize {
+			page.Bookmark = metadata.Bookmark
+		}
+	}
+
+	return page, nil
 }
 
-// AddUserData adds user statistics data to the ledger
-func (cc *SmartContract) AddUserData(ctx contractapi.TransactionContextInterface, uploadCount int, points int, subscribed int, balance int) error {
-	user, err := ctx.GetClientIdentity().GetID()
+// reconcileUserStats recomputes a user's upload count, authored review count and review points from
+// the uploader and reviewer indexes, reporting whether any of them had drifted
+func reconcileUserStats(ctx contractapi.TransactionContextInterface, userData *UserData) (bool, error) {
+	uploads, err := indexedCTIIDs(ctx, uploaderIndex, userData.ID)
 	if err != nil {
-		return fmt.Errorf("failed to get client identity: %v", err)
+		return false, err
 	}
 
-	userData := UserData{
-		ID:          user,
-		UploadCount: uploadCount,
-		Points:      points,
-		Subscribed:  subscribed,
-		Balance:     balance,
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(reviewerIndex, []string{userData.ID})
+	if err != nil {
+		return false, fmt.Errorf("failed to read %s index: %v", reviewerIndex, err)
 	}
+	defer iterator.Close()
 
-	userDataJSON, err := json.Marshal(userData)
-	if err != nil {
-		return err
+	authored := 0
+	reviewPoints := 0
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			return false, fmt.Errorf("failed to iterate over %s index: %v", reviewerIndex, err)
+		}
+		_, keyParts, err := ctx.GetStub().SplitCompositeKey(entry.Key)
+		if err != nil {
+			return false, fmt.Errorf("failed to split %s index key: %v", reviewerIndex, err)
+		}
+		review, err := readReview(ctx, keyParts[2])
+		if err != nil {
+			return false, err
+		}
+		if review == nil {
+			continue
+		}
+		authored++
+		reviewPoints += paidReviewerReward(review)
 	}
 
-	return ctx.GetStub().PutState(fmt.Sprintf("UserData_%s", user), userDataJSON)
+	drifted := userData.UploadCount != len(uploads) || userData.ReviewsAuthored != authored || userData.ReviewPoints != reviewPoints
+	userData.UploadCount = len(uploads)
+	userData.ReviewsAuthored = authored
+	userData.ReviewPoints = reviewPoints
+	return drifted, nil
 }
 
-// GetUserData retrieves user statistics data from the ledger by user ID
-func (cc *SmartContract) GetUserDataOld(ctx contractapi.TransactionContextInterface, user string) (*UserData, error) {
-	userDataJSON, err := ctx.GetStub().GetState(fmt.Sprintf("UserData_%s", user))
-	if err != nil {
+// ReconcileAllUserStats recomputes the upload count, authored review count and review points of up to
+// pageSize users from the CTI items and reviews on the ledger, correcting any that drifted. It returns
+// the number scanned and corrected and the key to resume from, or an empty bookmark once every user
+// has been reconciled. Balances and points themselves are left alone.
+func (cc *SmartContract) ReconcileAllUserStats(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (*ReconcileResult, error) {
+	if err := requireAdmin(ctx); err != nil {
 		return nil, err
 	}
-	if userDataJSON == nil {
-		return nil, fmt.Errorf("User data for user %s does not exist", user)
+	if pageSize <= 0 {
+		return nil, fmt.Errorf("page size must be positive, got %d", pageSize)
 	}
 
-	var userData UserData
-	err = json.Unmarshal(userDataJSON, &userData)
+	prefix := recordKeyPrefixes[RecordTypeUser]
+	result := &ReconcileResult{}
+	var err error
+	result.Bookmark, err = scanPage(ctx, prefix, prefix+"\U0010FFFF", bookmark, pageSize, func(key string, value []byte) error {
+		result.Scanned++
+
+		var userData UserData
+		if err := json.Unmarshal(value, &userData); err != nil {
+			return fmt.Errorf("failed to unmarshal user data: %v", err)
+		}
+		drifted, err := reconcileUserStats(ctx, &userData)
+		if err != nil {
+			return err
+		}
+		if !drifted {
+			return nil
+		}
+		if err := putUserData(ctx, &userData); err != nil {
+			return err
+		}
+		result.Corrected++
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	return &userData, nil
+	return result, nil
 }
 
-/*
-// GetUserData retrieves user statistics data from the ledger by peer ID
-func (cc *SmartContract) GetUserData(ctx contractapi.TransactionContextInterface) (*UserData, error) {
-	// Retrieve the current peer ID
-	peerID, err := ctx.GetClientIdentity().GetID()
+// GetReviewerImpact measures how often a reviewer's early reviews landed close to the consensus the
+// reviewed items eventually reached. Invalidated reviews count neither as samples nor toward consensus.
+func (cc *SmartContract) GetReviewerImpact(ctx contractapi.TransactionContextInterface, userDataID string) (*ReviewerImpact, error) {
+	iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(reviewerIndex, []string{userDataID})
 	if err != nil {
-		return nil, fmt.Errorf("failed to get current peer ID: %v", err)
+		return nil, fmt.Errorf("failed to read %s index: %v", reviewerIndex, err)
 	}
+	defer iterator.Close()
 
-	userDataJSON, err := ctx.GetStub().GetState(fmt.Sprintf("UserData_%s", peerID))
-	if err != nil {
-		return nil, err
+	var ctiIDs []string
+	for iterator.HasNext() {
+		entry, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate over %s index: %v", reviewerIndex, err)
+		}
+		_, keyParts, err := ctx.GetStub().SplitCompositeKey(entry.Key)
+		if err != nil {
+			return nil, fmt.Errorf("failed to split %s index key: %v", reviewerIndex, err)
+		}
+		if !containsString(ctiIDs, keyParts[1]) {
+			ctiIDs = append(ctiIDs, keyParts[1])
+		}
 	}
-	if userDataJSON == nil {
-		return nil, fmt.Errorf("User data for peer %s does not exist", peerID)
+
+	impact := &ReviewerImpact{UserDataID: userDataID}
+	for _, ctiID := range ctiIDs {
+		reviews, err := cc.GetReviewDataByCTIDataID(ctx, ctiID)
+		if err != nil {
+			return nil, err
+		}
+
+		var valid []*ReviewData
+		var total float64
+		for _, review := range reviews {
+			if !review.Invalidated {
+				valid = append(valid, review)
+				total += compositeScore(review)
+			}
+		}
+		if len(valid) < minConsensusReviews {
+			continue
+		}
+		consensus := total / float64(len(valid))
+
+		// Only the reviewer's earliest review of the item is a sample, and only if it came early
+		sort.SliceStable(valid, func(i, j int) bool {
+			return valid[i].Timestamp < valid[j].Timestamp
+		})
+		for i, review := range valid {
+			if review.UserDataID != userDataID {
+				continue
+			}
+			if i < earlyReviewCount {
+				impact.Samples++
+				if math.Abs(compositeScore(review)-consensus) <= impactTolerance {
+					impact.Matches++
+				}
+			}
+			break
+		}
 	}
 
-	var userData UserData
-	err = json.Unmarshal(userDataJSON, &userData)
-	if err != nil {
-		return nil, err
+	if impact.Samples > 0 {
+		impact.ImpactScore = float64(impact.Matches) / float64(impact.Samples)
 	}
 
-	return &userData, nil
+	return impact, nil
 }
-*/
 
-// GetUserData retrieves user statistics data from the ledger by peer ID.
-// If user data doesn't exist, it creates an empty user data entry with the current peer ID.
-func (cc *SmartContract) GetUserData(ctx contractapi.TransactionContextInterface) (*UserData, error) {
-	// Retrieve the current peer ID
-	peerID, err := ctx.GetClientIdentity().GetID()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get current peer ID: %v", err)
+// MigrateCTIKeys moves up to pageSize CTI items stored under un-padded keys to their zero-padded keys.
+// It returns the key to resume from, or an empty bookmark once every item has been scanned. Indexes
+// refer to items by ID and are unaffected, but a migrated item's key history restarts at its new key.
+func (cc *SmartContract) MigrateCTIKeys(ctx contractapi.TransactionContextInterface, pageSize int32, bookmark string) (string, error) {
+	if err := requireAdmin(ctx); err != nil {
+		return "", err
 	}
-
-	userDataJSON, err := ctx.GetStub().GetState(fmt.Sprintf("UserData_%s", peerID))
-	if err != nil {
-		return nil, err
+	if pageSize <= 0 {
+		return "", fmt.Errorf("page size must be positive, got %d", pageSize)
 	}
 
-	if userDataJSON == nil {
-		// Create empty user data
-		userData := &UserData{
-			ID:          peerID,
-			UploadCount: 0,
-			Points:      0,
-			Subscribed:  0,
-			Balance:     0,
+	return scanPage(ctx, ctiRangeStart, ctiRangeEnd, bookmark, pageSize, func(key string, value []byte) error {
+		var ctiItem CTIData
+		if err := json.Unmarshal(value, &ctiItem); err != nil {
+			return fmt.Errorf("failed to unmarshal CTI data: %v", err)
 		}
-
-		// Marshal the user data to JSON
-		userDataJSON, err := json.Marshal(userData)
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal user data: %v", err)
+		if key == ctiKey(ctiItem.ID) {
+			return nil
 		}
 
-		// Save the empty user data on the ledger
-		err = ctx.GetStub().PutState(fmt.Sprintf("UserData_%s", peerID), userDataJSON)
-		if err != nil {
-			return nil, fmt.Errorf("failed to put user data on ledger: %v", err)
+		if err := ctx.GetStub().PutState(ctiKey(ctiItem.ID), value); err != nil {
+			return fmt.Errorf("failed to put CTI item %s on ledger: %v", ctiItem.ID, err)
+		}
+		if err := ctx.GetStub().DelState(key); err != nil {
+			return fmt.Errorf("failed to delete un-padded key %s: %v", key, err)
 		}
+		return nil
+	})
+}
 
-		return userData, nil
-	}
+// CTIQueryFilter selects CTI items for BuildCTIQuery. Unset fields do not constrain the query; the
+// level and timestamp bounds are inclusive.
+type CTIQueryFilter struct {
+	Uploader      string
+	MinLevel      *int
+	MaxLevel      *int
+	FromTimestamp *int64
+	ToTimestamp   *int64
+}
 
-	var userData UserData
-	err = json.Unmarshal(userDataJSON, &userData)
-	if err != nil {
-		return nil, err
+// BuildCTIQuery builds a CouchDB query for QueryCTIItems from the common CTI item filters
+func BuildCTIQuery(filter CTIQueryFilter) (string, error) {
+	selector := map[string]interface{}{
+		// Only CTI item documents carry a CID
+		"CID": map[string]interface{}{"$exists": true},
+	}
+	if filter.Uploader != "" {
+		selector["Uploader"] = filter.Uploader
 	}
 
-	return &userData, nil
-}
+	level := map[string]interface{}{}
+	if filter.MinLevel != nil {
+		level["$gte"] = *filter.MinLevel
+	}
+	if filter.MaxLevel != nil {
+		level["$lte"] = *filter.MaxLevel
+	}
+	if filter.MinLevel != nil && filter.MaxLevel != nil && *filter.MinLevel > *filter.MaxLevel {
+		return "", fmt.Errorf("minimum level %d is above maximum level %d", *filter.MinLevel, *filter.MaxLevel)
+	}
+	if len(level) > 0 {
+		selector["Level"] = level
+	}
 
-// UpdateUserData updates the user data for the current peer with the provided fields
-func (cc *SmartContract) UpdateUserData(ctx contractapi.TransactionContextInterface, uploadCount, points, subscribed, balance int) error {
-	// Retrieve the current peer ID
-	peerID, err := ctx.GetClientIdentity().GetID()
-	if err != nil {
-		return fmt.Errorf("failed to get current peer ID: %v", err)
+	timestamp := map[string]interface{}{}
+	if filter.FromTimestamp != nil {
+		timestamp["$gte"] = *filter.FromTimestamp
+	}
+	if filter.ToTimestamp != nil {
+		timestamp["$lte"] = *filter.ToTimestamp
+	}
+	if filter.FromTimestamp != nil && filter.ToTimestamp != nil && *filter.FromTimestamp > *filter.ToTimestamp {
+		return "", fmt.Errorf("timestamp range starts at %d, after it ends at %d", *filter.FromTimestamp, *filter.ToTimestamp)
+	}
+	if len(timestamp) > 0 {
+		selector["Timestamp"] = timestamp
 	}
 
-	// Check if user data exists
-	existingUserDataJSON, err := ctx.GetStub().GetState(fmt.Sprintf("UserData_%s", peerID))
+	queryJSON, err := json.Marshal(map[string]interface{}{"selector": selector})
 	if err != nil {
-		return err
+		return "", fmt.Errorf("failed to marshal CTI query: %v", err)
 	}
+	return string(queryJSON), nil
+}
 
-	if existingUserDataJSON == nil {
-		return fmt.Errorf("User data for peer %s does not exist", peerID)
+// QueryCTIItems returns the CTI items matching a CouchDB query, such as one built by BuildCTIQuery. It
+// runs a rich query, so it requires CouchDB as the state database; LevelDB peers reject it. Documents
+// other than CTI items that match the query are left out.
+func (cc *SmartContract) QueryCTIItems(ctx contractapi.TransactionContextInterface, queryString string) ([]*CTIData, error) {
+	if strings.TrimSpace(queryString) == "" {
+		return nil, fmt.Errorf("query must not be empty")
 	}
 
-	// Retrieve existing user data
-	var existingUserData UserData
-	err = json.Unmarshal(existingUserDataJSON, &existingUserData)
+	iterator, err := ctx.GetStub().GetQueryResult(queryString)
 	if err != nil {
-		return fmt.Errorf("failed to unmarshal existing user data: %v", err)
+		return nil, fmt.Errorf("failed to run CTI query: %v", err)
 	}
+	defer iterator.Close()
 
-	// Update user data fields
-	existingUserData.UploadCount = uploadCount
-	existingUserData.Points = points
-	existingUserData.Subscribed = subscribed
-	existingUserData.Balance = balance
+	ctiItems := []*CTIData{}
+	for iterator.HasNext() {
+		item, err := iterator.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to iterate over CTI query results: %v", err)
+		}
+		if !strings.HasPrefix(item.Key, recordKeyPrefixes[RecordTypeCTI]) {
+			continue
+		}
 
-	// Marshal the updated user data
-	userDataJSON, err := json.Marshal(existingUserData)
-	if err != nil {
-		return fmt.Errorf("failed to marshal updated user data: %v", err)
+		var ctiItem CTIData
+		if err := json.Unmarshal(item.Value, &ctiItem); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal CTI data: %v", err)
+		}
+		ctiItems = append(ctiItems, &ctiItem)
 	}
 
-	// Put the updated user data on the ledger
-	err = ctx.GetStub().PutState(fmt.Sprintf("UserData_%s", peerID), userDataJSON)
-	if err != nil {
-		return fmt.Errorf("failed to put updated user data on ledger: %v", err)
-	}
+	return redactForCaller(ctx, ctiItems)
+}
 
-	return nil
+// richQueryUnsupported reports whether a query failed because the state database does not support rich
+// queries. LevelDB peers reject them with "ExecuteQuery not supported for leveldb".
+func richQueryUnsupported(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "not supported")
 }
 
-// AddReviewDataByCTIDataID adds review data for a specific CTI data ID
-func (cc *SmartContract) AddReviewData(ctx contractapi.TransactionContextInterface, ctiDataID string, accuracy, timeliness, completeness, consistency int, reviewText string) error {
-	// Retrieve the current peer ID
-	peerID, err := ctx.GetClientIdentity().GetID()
-	if err != nil {
-		return fmt.Errorf("failed to get current peer ID: %v", err)
+// GetCTIItemsByTimestampRange returns the CTI items whose Timestamp lies between startTs and endTs
+// inclusive. On CouchDB peers the window is selected by a rich query; LevelDB peers reject rich queries,
+// so there every item is scanned and filtered instead. Any other query failure is returned. Deleted items
+// are left out.
+func (cc *SmartContract) GetCTIItemsByTimestampRange(ctx contractapi.TransactionContextInterface, startTs, endTs int64) ([]*CTIData, error) {
+	if startTs > endTs {
+		return nil, fmt.Errorf("timestamp range starts at %d, after it ends at %d", startTs, endTs)
 	}
 
-	// Check if the CTI item exists
-	ctiItemJSON, err := ctx.GetStub().GetState(fmt.Sprintf("CTI_%s", ctiDataID))
+	query, err := BuildCTIQuery(CTIQueryFilter{FromTimestamp: &startTs, ToTimestamp: &endTs})
 	if err != nil {
-		return fmt.Errorf("failed to read CTI item from ledger: %v", err)
+		return nil, err
 	}
-	if ctiItemJSON == nil {
-		return fmt.Errorf("CTI item with ID %s does not exist", ctiDataID)
+	candidates, err := cc.QueryCTIItems(ctx, query)
+	if richQueryUnsupported(err) {
+		candidates, err = cc.ctiData(ctx, false)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get all CTI data entries: %v", err)
+		}
+	} else if err != nil {
+		return nil, err
 	}
 
-	// Generate a unique ID for the review data
-	reviewID, err := generateUniqueID(ctx, "Review")
-	if err != nil {
-		return fmt.Errorf("failed to generate review ID: %v", err)
+	ctiItems := []*CTIData{}
+	for _, ctiItem := range candidates {
+		if !ctiItem.Deleted && ctiItem.Timestamp >= startTs && ctiItem.Timestamp <= endTs {
+			ctiItems = append(ctiItems, ctiItem)
+		}
 	}
 
-	// Create the review data instance
-	review := ReviewData{
-		ID:           reviewID,
-		UserDataID:   peerID,
-		CTIDataID:    ctiDataID,
-		Accuracy:     accuracy,
-		Timeliness:   timeliness,
-		Completeness: completeness,
-		Consistency:  consistency,
-		ReviewText:   reviewText,
+	return redactForCaller(ctx, ctiItems)
+}
+
+// SearchCTIItemsByName returns the CTI items whose name contains query, ignoring case and surrounding
+// whitespace. An empty query matches nothing rather than every item. Deleted items are left out.
+func (cc *SmartContract) SearchCTIItemsByName(ctx contractapi.TransactionContextInterface, query string) ([]*CTIData, error) {
+	query = strings.ToLower(strings.TrimSpace(query))
+	ctiItems := []*CTIData{}
+	if query == "" {
+		return ctiItems, nil
 	}
 
-	// Convert review data to JSON
-	reviewJSON, err := json.Marshal(review)
+	allCTIItems, err := cc.GetAllCTIItems(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to marshal review data to JSON: %v", err)
+		return nil, fmt.Errorf("failed to get all CTI data entries: %v", err)
 	}
-
-	// Put the review data on the ledger
-	if err := ctx.GetStub().PutState(fmt.Sprintf("Review_%s", reviewID), reviewJSON); err != nil {
-		return fmt.Errorf("failed to put review data on ledger: %v", err)
+	for _, ctiItem := range allCTIItems {
+		if strings.Contains(strings.ToLower(ctiItem.Name), query) {
+			ctiItems = append(ctiItems, ctiItem)
+		}
 	}
 
-	return nil
+	return ctiItems, nil
 }
 
-// generateUniqueID generates a unique ID for a given prefix
-func generateUniqueID(ctx contractapi.TransactionContextInterface, prefix string) (string, error) {
-	// Retrieve the current ID for the given prefix
-	idBytes, err := ctx.GetStub().GetState("latestID_" + prefix)
-	if err != nil {
-		return "", fmt.Errorf("failed to read latest ID for prefix %s: %v", prefix, err)
-	}
+// stixNamespace is the UUIDv5 namespace the STIX 2.1 specification defines for deterministic
+// identifiers. Every STIX object exported for a CTI item takes its ID from this namespace and the
+// item's own identifiers, so repeated exports of an item yield the same STIX IDs.
+var stixNamespace = [16]byte{0x00, 0xab, 0xed, 0xb4, 0xaa, 0x42, 0x46, 0x6c, 0x9c, 0x01, 0xfe, 0xd2, 0x33, 0x15, 0xa9, 0xb7}
 
-	// Convert the ID to an integer
-	var latestID int
-	if idBytes == nil {
-		latestID = 1 // Start with ID = 1 if it's the first entry
-	} else {
-		latestID, err = strconv.Atoi(string(idBytes))
-		if err != nil {
-			return "", fmt.Errorf("failed to convert latest ID to integer: %v", err)
-		}
-		latestID++ // Increment the ID
-	}
+// stixTLPMarkings maps TLP markings to the marking definitions predefined by STIX 2.1
+var stixTLPMarkings = map[string]string{
+	"WHITE": "marking-definition--613f2e26-407d-48c7-9eca-b8e91df99dc9",
+	"GREEN": "marking-definition--34098fce-860f-48ae-8e50-ebd3cc5e41da",
+	"AMBER": "marking-definition--f88d31f6-486f-44da-b317-01333bde0b82",
+	"RED":   "marking-definition--5e57c739-391a-4eb3-b6be-7d15ca92d5ed",
+}
 
-	// Update the latest ID on the ledger
-	if err := ctx.GetStub().PutState("latestID_"+prefix, []byte(strconv.Itoa(latestID))); err != nil {
-		return "", fmt.Errorf("failed to update latest ID for prefix %s on ledger: %v", prefix, err)
-	}
+// stixPatterns gives the STIX pattern for each indicator type; %s is the quoted indicator value
+var stixPatterns = map[string]string{
+	"ipv4":   "[ipv4-addr:value = %s]",
+	"domain": "[domain-name:value = %s]",
+	"md5":    "[file:hashes.MD5 = %s]",
+	"sha256": "[file:hashes.'SHA-256' = %s]",
+	"url":    "[url:value = %s]",
+}
 
-	// Return the generated ID
-	return fmt.Sprintf("%s_%d", prefix, latestID), nil
+// stixBundle is a STIX 2.1 bundle of objects
+type stixBundle struct {
+	Type    string        `json:"type"`
+	ID      string        `json:"id"`
+	Objects []*stixObject `json:"objects"`
 }
 
-// GetAllReviewData retrieves all review data entries from the ledger
-func (cc *SmartContract) GetAllReviewData(ctx contractapi.TransactionContextInterface) ([]*ReviewData, error) {
-	// Construct partial composite key for review data
-	startKey := "Review_"
-	endKey := "Review_z" // Assumes z as the upper limit for ASCII characters
+// stixObject holds the STIX 2.1 object types a CTI item is exported as: a report, its indicators, the
+// ATT&CK techniques it references and the identity of the uploading organisation
+type stixObject struct {
+	Type               string                  `json:"type"`
+	SpecVersion        string                  `json:"spec_version"`
+	ID                 string                  `json:"id"`
+	CreatedByRef       string                  `json:"created_by_ref,omitempty"`
+	Created            string                  `json:"created"`
+	Modified           string                  `json:"modified"`
+	Name               string                  `json:"name,omitempty"`
+	Description        string                  `json:"description,omitempty"`
+	IdentityClass      string                  `json:"identity_class,omitempty"`
+	ReportTypes        []string                `json:"report_types,omitempty"`
+	Published          string                  `json:"published,omitempty"`
+	ObjectRefs         []string                `json:"object_refs,omitempty"`
+	IndicatorTypes     []string                `json:"indicator_types,omitempty"`
+	Pattern            string                  `json:"pattern,omitempty"`
+	PatternType        string                  `json:"pattern_type,omitempty"`
+	ValidFrom          string                  `json:"valid_from,omitempty"`
+	Labels             []string                `json:"labels,omitempty"`
+	Confidence         *int                    `json:"confidence,omitempty"`
+	ExternalReferences []stixExternalReference `json:"external_references,omitempty"`
+	ObjectMarkingRefs  []string                `json:"object_marking_refs,omitempty"`
+	CID                string                  `json:"x_cti_cid,omitempty"`
+	Level              int                     `json:"x_cti_level,omitempty"`
+}
 
-	// Get iterator for all review data entries
-	iterator, err := ctx.GetStub().GetStateByRange(startKey, endKey)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read all review data entries: %v", err)
-	}
-	defer iterator.Close()
+// stixExternalReference points a STIX object at an entry in an external catalogue such as ATT&CK
+type stixExternalReference struct {
+	SourceName string `json:"source_name"`
+	ExternalID string `json:"external_id,omitempty"`
+	URL        string `json:"url,omitempty"`
+}
 
-	// Iterate through the results and unmarshal review data entries
-	var reviews []*ReviewData
-	for iterator.HasNext() {
-		item, err := iterator.Next()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get next item in iterator: %v", err)
-		}
+// stixID returns the deterministic STIX identifier of an object of the given type named by name
+func stixID(objectType string, name string) string {
+	hash := sha1.New()
+	hash.Write(stixNamespace[:])
+	hash.Write([]byte(name))
+	uuid := hash.Sum(nil)[:16]
+	uuid[6] = (uuid[6] & 0x0f) | 0x50
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%s--%x-%x-%x-%x-%x", objectType, uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
+}
 
-		// Unmarshal review data
-		var review ReviewData
-		err = json.Unmarshal(item.Value, &review)
-		if err != nil {
-			return nil, fmt.Errorf("failed to unmarshal review data: %v", err)
-		}
+// stixTimestamp formats Unix seconds as a STIX timestamp
+func stixTimestamp(seconds int64) string {
+	return time.Unix(seconds, 0).UTC().Format("2006-01-02T15:04:05.000Z")
+}
 
-		// Append review data to result list
-		reviews = append(reviews, &review)
+// stixQuote quotes a value as a STIX pattern string literal
+func stixQuote(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	return "'" + strings.ReplaceAll(value, "'", `\'`) + "'"
+}
+
+// stixObjects maps a CTI item to a STIX report together with the objects it references. The item's
+// creation time, as given by ctiCreatedAt, is used for every object. The encryption key is never
+// exported.
+func stixObjects(ctiItem *CTIData) []*stixObject {
+	createdAt := ctiCreatedAt(ctiItem)
+	created := stixTimestamp(createdAt)
+	modified := created
+	if ctiItem.UpdatedAt > createdAt {
+		modified = stixTimestamp(ctiItem.UpdatedAt)
 	}
 
-	return reviews, nil
-}
+	var markings []string
+	if marking, ok := stixTLPMarkings[ctiItem.TLP]; ok {
+		markings = []string{marking}
+	}
+	var confidence *int
+	if ctiItem.Confidence != unsetConfidence {
+		value := ctiItem.Confidence
+		confidence = &value
+	}
 
-// GetAllReviewData retrieves all review data entries from the ledger and filters them by the specified CTI data ID
-func (cc *SmartContract) GetReviewDataByCTIDataID(ctx contractapi.TransactionContextInterface, ctiDataID string) ([]*ReviewData, error) {
-	// Get all review data entries from the ledger
-	allReviewData, err := cc.GetAllReviewData(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get all review data entries: %v", err)
+	// The uploading organisation is the creator of every exported object
+	creatorName := ctiItem.UploaderMSP
+	if creatorName == "" {
+		creatorName = ctiItem.Uploader
+	}
+	creator := &stixObject{
+		Type:          "identity",
+		SpecVersion:   "2.1",
+		ID:            stixID("identity", "identity:"+creatorName),
+		Created:       created,
+		Modified:      created,
+		Name:          creatorName,
+		IdentityClass: "organization",
 	}
 
-	// Filter review data by the specified CTI data ID
-	var filteredReviews []*ReviewData
-	for _, review := range allReviewData {
-		if review.CTIDataID == ctiDataID {
-			filteredReviews = append(filteredReviews, review)
+	var refs []*stixObject
+	for _, indicator := range ctiItem.Indicators {
+		pattern, ok := stixPatterns[indicator.Type]
+		if !ok {
+			continue
 		}
+		refs = append(refs, &stixObject{
+			Type:              "indicator",
+			SpecVersion:       "2.1",
+			ID:                stixID("indicator", "cti:"+ctiItem.ID+":indicator:"+indicator.Type+":"+indicator.Value),
+			CreatedByRef:      creator.ID,
+			Created:           created,
+			Modified:          modified,
+			Name:              fmt.Sprintf("%s %s", indicator.Type, indicator.Value),
+			IndicatorTypes:    []string{"malicious-activity"},
+			Pattern:           fmt.Sprintf(pattern, stixQuote(indicator.Value)),
+			PatternType:       "stix",
+			ValidFrom:         created,
+			Confidence:        confidence,
+			ObjectMarkingRefs: markings,
+		})
+	}
+	for _, technique := range ctiItem.Techniques {
+		refs = append(refs, &stixObject{
+			Type:         "attack-pattern",
+			SpecVersion:  "2.1",
+			ID:           stixID("attack-pattern", "technique:"+technique),
+			CreatedByRef: creator.ID,
+			Created:      created,
+			Modified:     created,
+			Name:         technique,
+			ExternalReferences: []stixExternalReference{{
+				SourceName: "mitre-attack",
+				ExternalID: technique,
+				URL:        "https://attack.mitre.org/techniques/" + strings.ReplaceAll(technique, ".", "/") + "/",
+			}},
+		})
 	}
 
-	return filteredReviews, nil
-}
-
-// GetCTIItemsFilteredBySubscriptionLevel retrieves CTI data entries from the ledger filtered by subscription level
-func (cc *SmartContract) GetCTIItemsFilteredBySubscriptionLevel(ctx contractapi.TransactionContextInterface) ([]*CTIData, error) {
-	// Retrieve all CTI data entries from the ledger
-	allCTIItems, err := cc.GetAllCTIItems(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get all CTI data entries: %v", err)
+	// A report must reference at least one object, so an item with neither indicators nor techniques
+	// references its creator
+	objectRefs := []string{}
+	for _, ref := range refs {
+		objectRefs = append(objectRefs, ref.ID)
+	}
+	if len(objectRefs) == 0 {
+		objectRefs = append(objectRefs, creator.ID)
 	}
 
-	// Retrieve user data for the current peer
-	userData, err := cc.GetUserData(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get user data: %v", err)
+	report := &stixObject{
+		Type:              "report",
+		SpecVersion:       "2.1",
+		ID:                stixID("report", "cti:"+ctiItem.ID),
+		CreatedByRef:      creator.ID,
+		Created:           created,
+		Modified:          modified,
+		Name:              ctiItem.Name,
+		Description:       ctiItem.Description,
+		ReportTypes:       []string{"threat-report"},
+		Published:         created,
+		ObjectRefs:        objectRefs,
+		Labels:            ctiItem.Tags,
+		Confidence:        confidence,
+		ObjectMarkingRefs: markings,
+		CID:               ctiItem.CID,
+		Level:             ctiItem.Level,
 	}
 
-	// Filter CTI data entries based on subscription level
-	var filteredCTIItems []*CTIData
-	for _, ctiItem := range allCTIItems {
-		if ctiItem.Level <= userData.Subscribed {
-			filteredCTIItems = append(filteredCTIItems, ctiItem)
+	return append([]*stixObject{report, creator}, refs...)
+}
+
+// stixBundleJSON wraps the STIX objects of CTI items in a bundle, keeping only the first copy of
+// objects shared between items. The bundle ID is derived from the item IDs.
+func stixBundleJSON(ctiItems []*CTIData) (string, error) {
+	bundle := stixBundle{Type: "bundle", Objects: []*stixObject{}}
+	seen := make(map[string]bool)
+	ids := make([]string, 0, len(ctiItems))
+	for _, ctiItem := range ctiItems {
+		ids = append(ids, ctiItem.ID)
+		for _, object := range stixObjects(ctiItem) {
+			if seen[object.ID] {
+				continue
+			}
+			seen[object.ID] = true
+			bundle.Objects = append(bundle.Objects, object)
 		}
 	}
+	bundle.ID = stixID("bundle", "bundle:"+strings.Join(ids, ","))
 
-	return filteredCTIItems, nil
+	bundleJSON, err := json.Marshal(bundle)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal STIX bundle: %v", err)
+	}
+	return string(bundleJSON), nil
 }
 
-// DeleteCTIItemByID deletes a CTI data entry from the ledger by its ID
-func (cc *SmartContract) DeleteCTIItemByID(ctx contractapi.TransactionContextInterface, id string) error {
-	// Check if the CTI data entry exists
-	existingItemJSON, err := ctx.GetStub().GetState(fmt.Sprintf("CTI_%s", id))
+// ExportCTIItemAsSTIX returns a STIX 2.1 bundle holding a CTI item as a report, with its indicators,
+// ATT&CK techniques and uploading organisation. STIX IDs are UUIDv5 values derived from the item, so
+// they are stable across exports. The CID is only included for callers entitled to the item.
+func (cc *SmartContract) ExportCTIItemAsSTIX(ctx contractapi.TransactionContextInterface, id string) (string, error) {
+	ctiItem, err := cc.GetCTIItem(ctx, id)
 	if err != nil {
-		return fmt.Errorf("failed to read CTI data entry: %v", err)
+		return "", err
 	}
-	if existingItemJSON == nil {
-		return fmt.Errorf("CTI data entry with ID %s does not exist", id)
+	if ctiItem.Deleted {
+		return "", fmt.Errorf("CTI item %s has been deleted", id)
 	}
 
-	// Delete the CTI data entry from the ledger
-	err = ctx.GetStub().DelState(fmt.Sprintf("CTI_%s", id))
+	return stixBundleJSON([]*CTIData{ctiItem})
+}
+
+// ExportAllCTIAsSTIXBundle returns every CTI item that has not been deleted as one STIX 2.1 bundle,
+// mapped as in ExportCTIItemAsSTIX
+func (cc *SmartContract) ExportAllCTIAsSTIXBundle(ctx contractapi.TransactionContextInterface) (string, error) {
+	allCTIItems, err := cc.GetAllCTIItems(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to delete CTI data entry: %v", err)
+		return "", fmt.Errorf("failed to get all CTI data entries: %v", err)
 	}
 
-	return nil
+	return stixBundleJSON(allCTIItems)
 }
@@ -0,0 +1,5221 @@
+package chaincode
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dstrukturos/cti/ctitest"
+	"github.com/hyperledger/fabric-chaincode-go/shim"
+	"github.com/hyperledger/fabric-contract-api-go/contractapi"
+	"github.com/hyperledger/fabric-protos-go/ledger/queryresult"
+)
+
+const testCID = "QmYwAPJzv5CZsnA625s3Xf2nemtYgPpHdWEz79ojWnPbdG"
+
+var (
+	alice  = &ctitest.Identity{ID: "alice", MSPID: "Org1MSP"}
+	bob    = &ctitest.Identity{ID: "bob", MSPID: "Org2MSP"}
+	carol  = &ctitest.Identity{ID: "carol", MSPID: "Org3MSP"}
+	dave   = &ctitest.Identity{ID: "dave", MSPID: "Org2MSP"}
+	erin   = &ctitest.Identity{ID: "erin", MSPID: "Org3MSP"}
+	admin  = &ctitest.Identity{ID: "admin", MSPID: "Org1MSP", Attributes: map[string]string{"cti.role": "admin"}}
+	oracle = &ctitest.Identity{ID: "oracle", MSPID: "Org1MSP", Attributes: map[string]string{"cti.role": "oracle"}}
+)
+
+// invoke runs fn as identity in a committed transaction and fails the test if either fails
+func invoke(t *testing.T, l *ctitest.Ledger, identity *ctitest.Identity, fn func(ctx contractapi.TransactionContextInterface) error) {
+	t.Helper()
+	if err := l.Invoke(identity, fn); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// invokeForEvent runs fn like invoke and returns the event the transaction set, or nil if it set none
+func invokeForEvent(t *testing.T, l *ctitest.Ledger, identity *ctitest.Identity, fn func(ctx contractapi.TransactionContextInterface) error) *ctitest.Event {
+	t.Helper()
+	tx := l.NewTransaction(identity)
+	if err := fn(tx.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	return tx.Event()
+}
+
+// addItem adds a level 1 CTI item as identity and returns its ID
+func addItem(t *testing.T, cc *SmartContract, l *ctitest.Ledger, identity *ctitest.Identity, name string) string {
+	t.Helper()
+	var id string
+	invoke(t, l, identity, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		id, err = cc.AddCTIItem(ctx, name, 1, testCID, "key", 1, 1, "low", "", nil, "", "GREEN", nil, 50)
+		return err
+	})
+	return id
+}
+
+// userDataOf reads a user's record, or an empty one if the user has none
+func userDataOf(t *testing.T, l *ctitest.Ledger, userID string) *UserData {
+	t.Helper()
+	var userData *UserData
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		userData, err = readUserData(ctx, userID)
+		return err
+	})
+	if userData == nil {
+		userData = &UserData{ID: userID}
+	}
+	return userData
+}
+
+// setUserData overwrites a user's record as an admin would for a migration
+func setUserData(t *testing.T, cc *SmartContract, l *ctitest.Ledger, userID string, uploadCount, points, subscribed, balance int) {
+	t.Helper()
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AdminSetUserData(ctx, userID, uploadCount, points, subscribed, balance)
+	})
+}
+
+// ctiItemOf reads a CTI item directly from the ledger
+func ctiItemOf(t *testing.T, l *ctitest.Ledger, id string) *CTIData {
+	t.Helper()
+	var ctiItem *CTIData
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		ctiItem, err = readCTIItem(ctx, id)
+		return err
+	})
+	return ctiItem
+}
+
+// putRawCTIItem writes a CTI item straight to the ledger, leaving it out of the indexes
+func putRawCTIItem(t *testing.T, l *ctitest.Ledger, ctiItem *CTIData) {
+	t.Helper()
+	ctiItemJSON, err := json.Marshal(ctiItem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Put(ctiKey(ctiItem.ID), ctiItemJSON)
+}
+
+// putLegacyItem writes a CTI item straight to the ledger under the given key, such as the un-padded
+// keys items were stored under before keys were zero-padded
+func putLegacyItem(t *testing.T, l *ctitest.Ledger, key string, id string) {
+	t.Helper()
+	ctiItemJSON, err := json.Marshal(&CTIData{ID: id, Name: "legacy " + id, CID: testCID, Uploader: "alice", Level: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Put(key, ctiItemJSON)
+}
+
+// reviewIDOf returns the ID of the review a user wrote of a CTI item
+func reviewIDOf(t *testing.T, l *ctitest.Ledger, userID string, ctiID string) string {
+	t.Helper()
+	var reviewID string
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(reviewerIndex, []string{userID, ctiID})
+		if err != nil {
+			return err
+		}
+		defer iterator.Close()
+		if !iterator.HasNext() {
+			return fmt.Errorf("%s has not reviewed CTI item %s", userID, ctiID)
+		}
+		entry, err := iterator.Next()
+		if err != nil {
+			return err
+		}
+		_, keyParts, err := ctx.GetStub().SplitCompositeKey(entry.Key)
+		if err != nil {
+			return err
+		}
+		reviewID = keyParts[2]
+		return nil
+	})
+	return reviewID
+}
+
+// indexEntries returns the keys of every entry in a composite index
+func indexEntries(t *testing.T, l *ctitest.Ledger, index string, attributes ...string) []string {
+	t.Helper()
+	var keys []string
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		iterator, err := ctx.GetStub().GetStateByPartialCompositeKey(index, attributes)
+		if err != nil {
+			return err
+		}
+		defer iterator.Close()
+		for iterator.HasNext() {
+			entry, err := iterator.Next()
+			if err != nil {
+				return err
+			}
+			keys = append(keys, entry.Key)
+		}
+		return nil
+	})
+	return keys
+}
+
+func TestRebuildIndexesAndStaleEntrySweep(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	for i := 1; i <= 25; i++ {
+		putRawCTIItem(t, l, &CTIData{ID: fmt.Sprint(i), Name: fmt.Sprintf("item %d", i), Uploader: "alice", CID: testCID, Level: i % 3})
+	}
+
+	// Plant a stale level entry for an item that has moved level
+	var staleKey string
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		staleKey, err = ctx.GetStub().CreateCompositeKey(levelIndex, []string{"9", "1"})
+		return err
+	})
+	l.Put(staleKey, []byte{0x00})
+
+	if err := l.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := cc.RebuildIndexes(ctx, 10, "")
+		return err
+	}); err == nil {
+		t.Error("a non-admin rebuilt the indexes")
+	}
+
+	bookmark := ""
+	for pages := 0; ; pages++ {
+		invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			bookmark, err = cc.RebuildIndexes(ctx, 10, bookmark)
+			return err
+		})
+		if bookmark == "" {
+			if pages != 3 {
+				t.Errorf("rebuild took %d pages, want 4", pages+1)
+			}
+			break
+		}
+	}
+
+	if got := len(indexEntries(t, l, uploaderIndex, "alice")); got != 25 {
+		t.Errorf("uploader index has %d entries for alice, want 25", got)
+	}
+	if got := len(indexEntries(t, l, levelIndex, "1")); got != 9 {
+		t.Errorf("level index has %d entries at level 1, want 9", got)
+	}
+	if got := len(indexEntries(t, l, cidIndex, testCID)); got != 25 {
+		t.Errorf("CID index has %d entries, want 25", got)
+	}
+
+	var stale []string
+	for {
+		var page *StaleIndexEntries
+		invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			page, err = cc.FindStaleIndexEntries(ctx, 10, bookmark)
+			return err
+		})
+		stale = append(stale, page.Keys...)
+		if bookmark = page.Bookmark; bookmark == "" {
+			break
+		}
+	}
+	if len(stale) != 1 || stale[0] != staleKey {
+		t.Fatalf("found stale entries %q, want only the planted one", stale)
+	}
+
+	// Entries that are valid again by the time they are deleted are kept
+	validKey := indexEntries(t, l, levelIndex, "1")[0]
+	var deleted int
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		deleted, err = cc.DeleteStaleIndexEntries(ctx, append(stale, validKey))
+		return err
+	})
+	if deleted != 1 || l.Get(staleKey) != nil {
+		t.Errorf("deleted %d entries, stale entry present: %v", deleted, l.Get(staleKey) != nil)
+	}
+	if l.Get(validKey) == nil {
+		t.Error("a valid index entry was deleted")
+	}
+}
+
+func TestReputationCachedOnReviewAndRecomputed(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "phishing kit")
+
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddReviewData(ctx, id, 5, 5, 5, 5, "accurate")
+	})
+	if userData := userDataOf(t, l, "alice"); userData.Reputation != 5 || userData.ReviewsReceived != 1 {
+		t.Errorf("after one review alice has reputation %v from %d reviews", userData.Reputation, userData.ReviewsReceived)
+	}
+	invoke(t, l, carol, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddReviewData(ctx, id, 2, 2, 3, 3, "outdated")
+	})
+	if userData := userDataOf(t, l, "alice"); userData.Reputation != 3.75 || userData.ReviewsReceived != 2 {
+		t.Errorf("after two reviews alice has reputation %v from %d reviews", userData.Reputation, userData.ReviewsReceived)
+	}
+
+	// A stale cache is corrected by a forced recompute
+	stale, err := json.Marshal(&UserData{ID: "alice", Reputation: 1, ReviewsReceived: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Put("UserData_alice", stale)
+	if err := l.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := cc.ForceRecomputeReputation(ctx, "alice")
+		return err
+	}); err == nil {
+		t.Error("a non-admin forced a reputation recompute")
+	}
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := cc.ForceRecomputeReputation(ctx, "alice")
+		return err
+	})
+	if userData := userDataOf(t, l, "alice"); userData.Reputation != 3.75 || userData.ReviewsReceived != 2 {
+		t.Errorf("after a recompute alice has reputation %v from %d reviews", userData.Reputation, userData.ReviewsReceived)
+	}
+}
+
+func TestGetCTIItemForCallerRedactsUnentitledCallers(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "phishing kit")
+	setUserData(t, cc, l, "carol", 0, 0, 1, 0)
+
+	for _, tc := range []struct {
+		caller   *ctitest.Identity
+		entitled bool
+	}{
+		{alice, true},
+		{admin, true},
+		{carol, true},
+		{bob, false},
+	} {
+		var ctiItem *CTIData
+		invoke(t, l, tc.caller, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			ctiItem, err = cc.GetCTIItemForCaller(ctx, id)
+			return err
+		})
+		if ctiItem.Name != "phishing kit" {
+			t.Errorf("%s got item metadata %+v", tc.caller.ID, ctiItem)
+		}
+		if full := ctiItem.CID == testCID && ctiItem.EncryptKey == "key"; full != tc.entitled {
+			t.Errorf("%s got CID %q and key %q, entitled %v", tc.caller.ID, ctiItem.CID, ctiItem.EncryptKey, tc.entitled)
+		}
+	}
+}
+
+func TestListAndRankedGettersRedactUnentitledCallers(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	target := addItem(t, cc, l, alice, "emotet loader")
+	other := addItem(t, cc, l, alice, "emotet dropper")
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := cc.AddTagsToCTIItems(ctx, []string{target, other}, []string{"emotet"})
+		return err
+	})
+	setUserData(t, cc, l, "carol", 0, 0, 1, 0)
+	for _, id := range []string{target, other} {
+		invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.RecordCTIAccess(ctx, id)
+		})
+		invoke(t, l, carol, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.AddReviewData(ctx, id, 4, 4, 4, 4, "")
+		})
+	}
+
+	unranked := func(ranked []*RankedCTIItem, err error) ([]*CTIData, error) {
+		var ctiItems []*CTIData
+		for _, item := range ranked {
+			ctiItems = append(ctiItems, item.Item)
+		}
+		return ctiItems, err
+	}
+	getters := map[string]func(ctx contractapi.TransactionContextInterface) ([]*CTIData, error){
+		"GetAllCTIItems":                 cc.GetAllCTIItems,
+		"GetAllCTIItemsIncludingDeleted": cc.GetAllCTIItemsIncludingDeleted,
+		"GetCTIItemsPage": func(ctx contractapi.TransactionContextInterface) ([]*CTIData, error) {
+			page, err := cc.GetCTIItemsPage(ctx, 10, "")
+			if err != nil {
+				return nil, err
+			}
+			return page.Items, nil
+		},
+		"GetCTIItemsByUploader": func(ctx contractapi.TransactionContextInterface) ([]*CTIData, error) {
+			return cc.GetCTIItemsByUploader(ctx, "alice")
+		},
+		"GetCTIItemsByUploaders": func(ctx contractapi.TransactionContextInterface) ([]*CTIData, error) {
+			return cc.GetCTIItemsByUploaders(ctx, []string{"alice"})
+		},
+		"GetCTIItemsByTLP": func(ctx contractapi.TransactionContextInterface) ([]*CTIData, error) {
+			return cc.GetCTIItemsByTLP(ctx, "GREEN")
+		},
+		"GetCTIItemsByTimestampRange": func(ctx contractapi.TransactionContextInterface) ([]*CTIData, error) {
+			return cc.GetCTIItemsByTimestampRange(ctx, 0, 1<<40)
+		},
+		"GetCTIItemsByFreshness": func(ctx contractapi.TransactionContextInterface) ([]*CTIData, error) {
+			return unranked(cc.GetCTIItemsByFreshness(ctx, 10))
+		},
+		"GetSimilarCTIItems": func(ctx contractapi.TransactionContextInterface) ([]*CTIData, error) {
+			return unranked(cc.GetSimilarCTIItems(ctx, target, 10))
+		},
+		"GetTrendingCTIItems": func(ctx contractapi.TransactionContextInterface) ([]*CTIData, error) {
+			return unranked(cc.GetTrendingCTIItems(ctx, 24*60*60, 10))
+		},
+		"GetCTIItemsByReviewVelocity": func(ctx contractapi.TransactionContextInterface) ([]*CTIData, error) {
+			return unranked(cc.GetCTIItemsByReviewVelocity(ctx, 24*60*60, 10))
+		},
+	}
+
+	for name, get := range getters {
+		for _, tc := range []struct {
+			caller   *ctitest.Identity
+			entitled bool
+		}{
+			{alice, true},
+			{admin, true},
+			{carol, true},
+			{bob, false},
+		} {
+			var ctiItems []*CTIData
+			invoke(t, l, tc.caller, func(ctx contractapi.TransactionContextInterface) error {
+				var err error
+				ctiItems, err = get(ctx)
+				return err
+			})
+			if len(ctiItems) == 0 {
+				t.Errorf("%s returned no items to %s", name, tc.caller.ID)
+			}
+			for _, ctiItem := range ctiItems {
+				if ctiItem.Name == "" {
+					t.Errorf("%s gave %s item metadata %+v", name, tc.caller.ID, ctiItem)
+				}
+				if full := ctiItem.CID == testCID && ctiItem.EncryptKey == "key"; full != tc.entitled {
+					t.Errorf("%s gave %s CID %q and key %q, entitled %v", name, tc.caller.ID, ctiItem.CID, ctiItem.EncryptKey, tc.entitled)
+				}
+			}
+		}
+	}
+}
+
+func TestSelfReviewsRejectedAndInvalidated(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "phishing kit")
+
+	if err := l.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddReviewData(ctx, id, 5, 5, 5, 5, "mine is great")
+	}); err == nil {
+		t.Fatal("an uploader reviewed their own CTI item")
+	}
+
+	// A self-review left before the check existed is invalidated and dropped from reputation
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddReviewData(ctx, id, 4, 4, 4, 4, "good")
+	})
+	selfReview, err := json.Marshal(&ReviewData{ID: "Review_99", UserDataID: "alice", CTIDataID: id, Accuracy: 1, Timeliness: 1, Completeness: 1, Consistency: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Put("Review_Review_99", selfReview)
+
+	var invalidated int
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		invalidated, err = cc.InvalidateSelfReviews(ctx)
+		return err
+	})
+	if invalidated != 1 {
+		t.Errorf("invalidated %d reviews, want 1", invalidated)
+	}
+	var review ReviewData
+	if err := json.Unmarshal(l.Get("Review_Review_99"), &review); err != nil || !review.Invalidated {
+		t.Errorf("self-review is %+v, want it invalidated", review)
+	}
+	if userData := userDataOf(t, l, "alice"); userData.Reputation != 4 || userData.ReviewsReceived != 1 {
+		t.Errorf("alice has reputation %v from %d reviews, want 4 from 1", userData.Reputation, userData.ReviewsReceived)
+	}
+}
+
+func TestGetCTIScoreTrend(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "phishing kit")
+	trend := func() *ScoreTrend {
+		var trend *ScoreTrend
+		invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			trend, err = cc.GetCTIScoreTrend(ctx, id)
+			return err
+		})
+		return trend
+	}
+
+	for i, reviewer := range []*ctitest.Identity{bob, carol, dave, erin} {
+		if trend := trend(); trend.Direction != TrendInsufficientData || trend.ReviewCount != i {
+			t.Errorf("trend after %d reviews is %+v", i, trend)
+		}
+		score := 2
+		if i >= 2 {
+			score = 4
+		}
+		l.Advance(time.Hour)
+		invoke(t, l, reviewer, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.AddReviewData(ctx, id, score, score, score, score, "")
+		})
+	}
+
+	if trend := trend(); trend.Direction != TrendImproving || trend.EarlierAverage != 2 || trend.LaterAverage != 4 || trend.Delta != 2 {
+		t.Errorf("trend after improving reviews is %+v", trend)
+	}
+}
+
+func TestExportAllPagesThroughEveryRecord(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	var ids []string
+	for i := 0; i < 4; i++ {
+		ids = append(ids, addItem(t, cc, l, alice, fmt.Sprintf("item %d", i)))
+	}
+	setUserData(t, cc, l, "bob", 0, 0, 1, 0)
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddReviewData(ctx, ids[0], 4, 4, 4, 4, "ok")
+	})
+
+	if err := l.Invoke(bob, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := cc.ExportAll(ctx, 3, "")
+		return err
+	}); err == nil {
+		t.Error("a non-admin exported the ledger")
+	}
+
+	exported := make(map[string]ExportRecord)
+	bookmark := ""
+	for {
+		var page *ExportPage
+		invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			page, err = cc.ExportAll(ctx, 3, bookmark)
+			return err
+		})
+		for _, line := range strings.Split(strings.TrimSuffix(page.Records, "\n"), "\n") {
+			if line == "" {
+				continue
+			}
+			var record ExportRecord
+			if err := json.Unmarshal([]byte(line), &record); err != nil {
+				t.Fatal(err)
+			}
+			if _, seen := exported[record.Key]; seen {
+				t.Errorf("record %s was exported twice", record.Key)
+			}
+			exported[record.Key] = record
+		}
+		if bookmark = page.Bookmark; bookmark == "" {
+			break
+		}
+	}
+
+	// Four items, alice's and bob's user data and one review; counters are left out
+	if len(exported) != 7 {
+		t.Errorf("exported %d records, want 7", len(exported))
+	}
+	for key, record := range exported {
+		if string(record.Record) != string(l.Get(key)) {
+			t.Errorf("exported %s as %s, ledger has %s", key, record.Record, l.Get(key))
+		}
+		if record.Type != recordTypeForKey(key) {
+			t.Errorf("exported %s tagged %s", key, record.Type)
+		}
+	}
+}
+
+// exportAll pages through ExportAll and returns every exported line
+func exportAll(t *testing.T, cc *SmartContract, l *ctitest.Ledger) string {
+	t.Helper()
+	var ndjson strings.Builder
+	bookmark := ""
+	for {
+		var page *ExportPage
+		invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			page, err = cc.ExportAll(ctx, 3, bookmark)
+			return err
+		})
+		ndjson.WriteString(page.Records)
+		if bookmark = page.Bookmark; bookmark == "" {
+			return ndjson.String()
+		}
+	}
+}
+
+func TestImportRecordsRoundTripsAnExport(t *testing.T) {
+	cc := &SmartContract{}
+	source := ctitest.NewLedger()
+	invoke(t, source, alice, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddUserData(ctx)
+	})
+	var ids []string
+	for i := 0; i < 3; i++ {
+		ids = append(ids, addItem(t, cc, source, alice, fmt.Sprintf("item %d", i)))
+	}
+	setUserData(t, cc, source, "bob", 0, 0, 1, 5)
+	invoke(t, source, bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddReviewData(ctx, ids[0], 4, 4, 4, 4, "ok")
+	})
+	invoke(t, source, carol, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddReviewData(ctx, ids[1], 2, 2, 2, 2, "weak")
+	})
+	ndjson := exportAll(t, cc, source)
+
+	target := ctitest.NewLedger()
+	if err := target.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := cc.ImportRecords(ctx, ndjson, false)
+		return err
+	}); err == nil {
+		t.Error("a non-admin imported records")
+	}
+	var result *ImportResult
+	invoke(t, target, admin, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		result, err = cc.ImportRecords(ctx, ndjson, false)
+		return err
+	})
+	if result.Imported != 8 || result.Skipped != 0 {
+		t.Errorf("import result is %+v, want 8 imported", result)
+	}
+	if exported := exportAll(t, cc, target); exported != ndjson {
+		t.Errorf("re-export differs from the original:\n%s\nwant\n%s", exported, ndjson)
+	}
+	if len(indexEntries(t, target, uploaderIndex, "alice")) != 3 {
+		t.Error("imported CTI items were not indexed")
+	}
+
+	// New uploads do not collide with the imported IDs
+	if id := addItem(t, cc, target, bob, "after import"); containsString(ids, id) {
+		t.Errorf("first upload after the import reused imported ID %s", id)
+	}
+
+	// Existing records are skipped unless replacement is requested
+	invoke(t, target, admin, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		result, err = cc.ImportRecords(ctx, ndjson, false)
+		return err
+	})
+	if result.Imported != 0 || result.Skipped != 8 {
+		t.Errorf("re-import without replacement is %+v, want 8 skipped", result)
+	}
+
+	// A replaced review moves its uploader's reputation, even though only the review was imported, and
+	// its reviewer is credited the reward recorded on it
+	reviewID := reviewIDOf(t, source, "carol", ids[1])
+	review := ReviewData{ID: reviewID, UserDataID: "carol", CTIDataID: ids[1], Accuracy: 5, Timeliness: 5, Completeness: 5, Consistency: 5, RewardPaid: true, RewardAmount: 3, SchemaVersion: SchemaVersion}
+	reviewJSON, err := json.Marshal(&review)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := json.Marshal(&ExportRecord{Type: RecordTypeReview, Key: reviewKey(reviewID), Record: reviewJSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+	invoke(t, target, admin, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		result, err = cc.ImportRecords(ctx, string(line), true)
+		return err
+	})
+	if userData := userDataOf(t, target, "alice"); userData.Reputation != 4.5 || userData.ReviewsReceived != 2 {
+		t.Errorf("alice has reputation %v from %d reviews after the import, want 4.5 from 2", userData.Reputation, userData.ReviewsReceived)
+	}
+	if got := userDataOf(t, target, "carol").ReviewPoints; got != 3 {
+		t.Errorf("carol has %d review points after the import, want 3", got)
+	}
+	invoke(t, target, admin, func(ctx contractapi.TransactionContextInterface) error {
+		ctiItem, err := readCTIItem(ctx, ids[1])
+		if err != nil {
+			return err
+		}
+		if ctiItem.ReviewCount != 1 || ctiItem.CompositeScore != 5 {
+			t.Errorf("item %s has %d reviews scoring %v after the import, want 1 scoring 5", ids[1], ctiItem.ReviewCount, ctiItem.CompositeScore)
+		}
+		return nil
+	})
+
+	// Imported users have their counters recomputed from the ledger
+	invoke(t, target, admin, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := cc.ImportRecords(ctx, `{"Type":"UserData","Key":"UserData_bob","Record":{"ID":"bob","Points":1,"Subscribed":1,"Balance":5}}`, true)
+		return err
+	})
+	if got := userDataOf(t, target, "bob"); got.UploadCount != 1 || got.ReviewsAuthored != 1 || got.ReviewPoints != 1 {
+		t.Errorf("imported bob has %d uploads and %d reviews for %d points, want 1, 1 and 1", got.UploadCount, got.ReviewsAuthored, got.ReviewPoints)
+	}
+
+	// Soft-deleted items are not counted as uploads
+	invoke(t, target, admin, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := cc.ImportRecords(ctx, `{"Type":"CTIData","Key":"CTI_0000000009","Record":{"ID":"9","Uploader":"bob","CID":"`+testCID+`","Deleted":true}}`, true)
+		return err
+	})
+	if got := userDataOf(t, target, "bob").UploadCount; got != 1 {
+		t.Errorf("bob has %d uploads after importing a deleted item, want 1", got)
+	}
+
+	// User records with negative balances are rejected
+	if err := target.Invoke(admin, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := cc.ImportRecords(ctx, `{"Type":"UserData","Key":"UserData_dave","Record":{"ID":"dave","Balance":-5}}`, true)
+		return err
+	}); err == nil {
+		t.Error("a user record with a negative balance was imported")
+	}
+
+	// CTI records are held to the checks AddCTIItem makes
+	for _, record := range []string{
+		`{"Type":"CTIData","Key":"CTI_0000000009","Record":{"ID":"9","CID":"` + testCID + `","Tags":["no spaces allowed"]}}`,
+		`{"Type":"CTIData","Key":"CTI_0000000009","Record":{"ID":"9","CID":"not-a-cid"}}`,
+		`{"Type":"CTIData","Key":"CTI_0000000009","Record":{"ID":"9","CID":"` + testCID + `","TLP":"BLUE"}}`,
+		`{"Type":"CTIData","Key":"CTI_0000000009","Record":{"ID":"9","CID":"` + testCID + `","Techniques":["phishing"]}}`,
+		`{"Type":"CTIData","Key":"CTI_0000000009","Record":{"ID":"9","CID":"` + testCID + `","Confidence":150}}`,
+	} {
+		if err := target.Invoke(admin, func(ctx contractapi.TransactionContextInterface) error {
+			_, err := cc.ImportRecords(ctx, record, true)
+			return err
+		}); err == nil {
+			t.Errorf("invalid CTI record %s was imported", record)
+		}
+	}
+
+	// Reviews scored outside the score range are rejected
+	review.Accuracy = 9
+	reviewJSON, err = json.Marshal(&review)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outOfRange, err := json.Marshal(&ExportRecord{Type: RecordTypeReview, Key: reviewKey(reviewID), Record: reviewJSON})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := target.Invoke(admin, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := cc.ImportRecords(ctx, string(outOfRange), true)
+		return err
+	}); err == nil {
+		t.Error("a review scored out of range was imported")
+	}
+
+	// Records that do not match their key are rejected
+	if err := target.Invoke(admin, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := cc.ImportRecords(ctx, strings.Replace(string(line), reviewKey(reviewID), reviewKey("other"), 1), true)
+		return err
+	}); err == nil {
+		t.Error("a review imported under another review's key was accepted")
+	}
+}
+
+func TestGetCTIItemsPendingCIDCheck(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	var ids []string
+	for i, timestamp := range []int64{100, 300, 200} {
+		invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+			id, err := cc.AddCTIItem(ctx, fmt.Sprintf("item %d", i+1), timestamp, testCID, "key", 1, 1, "low", "", nil, "", "GREEN", nil, 50)
+			ids = append(ids, id)
+			return err
+		})
+	}
+
+	if err := l.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.RecordCIDAvailability(ctx, ids[2], true)
+	}); err == nil {
+		t.Error("a non-oracle recorded CID availability")
+	}
+	invoke(t, l, oracle, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.RecordCIDAvailability(ctx, ids[2], false)
+	})
+
+	var pending []*CTIData
+	invoke(t, l, oracle, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		pending, err = cc.GetCTIItemsPendingCIDCheck(ctx)
+		return err
+	})
+	if len(pending) != 2 || pending[0].ID != ids[1] || pending[1].ID != ids[0] {
+		t.Errorf("pending items are %+v, want items 2 and 1, newest first", pending)
+	}
+}
+
+func TestGetReviewTextRestrictedForPrivateReviews(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "phishing kit")
+
+	if err := l.Invoke(bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddPrivateReviewData(ctx, id, 4, 4, 4, 4)
+	}); err == nil {
+		t.Error("a private review was added without its text")
+	}
+	transient := map[string][]byte{"reviewText": []byte("seen in our incident")}
+	tx := l.NewTransaction(bob)
+	tx.SetTransient(transient)
+	if err := cc.AddPrivateReviewData(tx.Context(), id, 4, 4, 4, 4); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	reviewID := reviewIDOf(t, l, "bob", id)
+	if strings.Contains(string(l.Get(reviewKey(reviewID))), "incident") {
+		t.Error("private review text was written to the public state")
+	}
+
+	for _, tc := range []struct {
+		caller     *ctitest.Identity
+		authorized bool
+	}{
+		{bob, true},
+		{alice, true},
+		{carol, false},
+	} {
+		var text string
+		err := l.Invoke(tc.caller, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			text, err = cc.GetReviewText(ctx, reviewID)
+			return err
+		})
+		if tc.authorized && (err != nil || text != "seen in our incident") {
+			t.Errorf("%s read %q, %v", tc.caller.ID, text, err)
+		}
+		if !tc.authorized && err == nil {
+			t.Errorf("%s read the private review text %q", tc.caller.ID, text)
+		}
+	}
+}
+
+func TestAddCTIItemPrivateKeepsKeyOffPublicState(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	var id string
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		id, err = cc.AddCTIItemPrivate(ctx, "phishing kit", 1, testCID, "secret-key", 1, 1, "low", "", nil, "", "GREEN", nil, 50)
+		return err
+	})
+	if strings.Contains(string(l.Get(ctiKey(id))), "secret-key") {
+		t.Error("the encryption key was written to the public state")
+	}
+	if ctiItem := ctiItemOf(t, l, id); !ctiItem.PrivateKey || ctiItem.EncryptKey != "" {
+		t.Errorf("public record has PrivateKey %v and key %q", ctiItem.PrivateKey, ctiItem.EncryptKey)
+	}
+	stored, err := l.NewTransaction(admin).GetPrivateData(ctiKeyCollection, id)
+	if err != nil || string(stored) != "secret-key" {
+		t.Errorf("collection holds %q, %v", stored, err)
+	}
+
+	setUserData(t, cc, l, "carol", 0, 0, 1, 0)
+	for _, tc := range []struct {
+		caller   *ctitest.Identity
+		entitled bool
+	}{
+		{alice, true},
+		{admin, true},
+		{carol, true},
+		{bob, false},
+	} {
+		var encryptKey string
+		err := l.Invoke(tc.caller, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			encryptKey, err = cc.GetCTIItemKey(ctx, id)
+			return err
+		})
+		if tc.entitled && (err != nil || encryptKey != "secret-key") {
+			t.Errorf("%s read key %q, %v", tc.caller.ID, encryptKey, err)
+		}
+		if !tc.entitled && err == nil {
+			t.Errorf("%s read the private key %q", tc.caller.ID, encryptKey)
+		}
+	}
+	invoke(t, l, carol, func(ctx contractapi.TransactionContextInterface) error {
+		page, err := cc.SyncAccessibleCTIItems(ctx, 0, 10, "")
+		if err == nil && (len(page.Items) != 1 || page.Items[0].EncryptKey != "secret-key") {
+			t.Errorf("sync returned %+v, want the item with its private key", page.Items)
+		}
+		return err
+	})
+
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.DeleteCTIItemByID(ctx, id)
+	})
+	if stored, _ := l.NewTransaction(admin).GetPrivateData(ctiKeyCollection, id); stored != nil {
+		t.Errorf("collection still holds key %q after the item was deleted", stored)
+	}
+}
+
+func TestAddCTIItemWithTransientKeyReadsKeyFromTransientMap(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	add := func(transient map[string][]byte) (string, error) {
+		tx := l.NewTransaction(alice)
+		tx.SetTransient(transient)
+		id, err := cc.AddCTIItemWithTransientKey(tx.Context(), "phishing kit", 1, testCID, 1, 1, "low", "", nil, "", "GREEN", nil, 50)
+		if err != nil {
+			return "", err
+		}
+		return id, tx.Commit()
+	}
+
+	for _, transient := range []map[string][]byte{nil, {"key": []byte("secret-key")}, {"encryptKey": nil}} {
+		if _, err := add(transient); err == nil {
+			t.Errorf("an item was added with transient map %v", transient)
+		}
+	}
+
+	id, err := add(map[string][]byte{"encryptKey": []byte("secret-key")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(l.Get(ctiKey(id))), "secret-key") {
+		t.Error("the encryption key was written to the public state")
+	}
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		encryptKey, err := cc.GetCTIItemKey(ctx, id)
+		if err != nil {
+			return err
+		}
+		if encryptKey != "secret-key" {
+			t.Errorf("the stored key is %q", encryptKey)
+		}
+		return nil
+	})
+}
+
+func TestCTIAccessGrantsOverrideSubscriptionLevel(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	var id string
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		id, err = cc.AddCTIItemPrivate(ctx, "phishing kit", 1, testCID, "secret-key", 1, 3, "low", "", nil, "", "GREEN", nil, 50)
+		return err
+	})
+	// bobCanRead reports whether bob can fetch the key and sees the item unredacted in bulk reads
+	bobCanRead := func() (bool, bool) {
+		keyErr := l.Invoke(bob, func(ctx contractapi.TransactionContextInterface) error {
+			_, err := cc.GetCTIItemKey(ctx, id)
+			return err
+		})
+		var ctiItems []*CTIData
+		invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			ctiItems, err = cc.GetAllCTIItems(ctx)
+			return err
+		})
+		return keyErr == nil, len(ctiItems) == 1 && ctiItems[0].CID == testCID
+	}
+	isGranted := func() bool {
+		var granted bool
+		invoke(t, l, carol, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			granted, err = cc.IsGrantedCTIAccess(ctx, id, "bob")
+			return err
+		})
+		return granted
+	}
+
+	if key, listed := bobCanRead(); key || listed {
+		t.Fatalf("bob without a grant read the key %v or the CID %v", key, listed)
+	}
+	if err := l.Invoke(carol, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.GrantCTIAccess(ctx, id, "bob")
+	}); err == nil {
+		t.Error("a caller who does not own the item granted access to it")
+	}
+
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.GrantCTIAccess(ctx, id, "bob")
+	})
+	if !isGranted() {
+		t.Error("the grant was not recorded")
+	}
+	if key, listed := bobCanRead(); !key || !listed {
+		t.Errorf("bob with a grant read the key %v and the CID %v", key, listed)
+	}
+
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.RevokeCTIAccess(ctx, id, "bob")
+	})
+	if isGranted() {
+		t.Error("the grant survived its revocation")
+	}
+	if key, listed := bobCanRead(); key || listed {
+		t.Errorf("bob after revocation read the key %v or the CID %v", key, listed)
+	}
+	if err := l.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.RevokeCTIAccess(ctx, id, "bob")
+	}); err == nil {
+		t.Error("a grant that does not exist was revoked")
+	}
+
+	// A grant made before the grantee index existed is honoured for the item itself, and in bulk reads
+	// once the indexes are rebuilt
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.GrantCTIAccess(ctx, id, "bob")
+	})
+	var granteeEntry string
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		granteeEntry, err = ctx.GetStub().CreateCompositeKey(granteeIndex, []string{"bob", id})
+		return err
+	})
+	l.Delete(granteeEntry)
+	if key, listed := bobCanRead(); !key || listed {
+		t.Errorf("bob with an unindexed grant read the key %v and the CID %v", key, listed)
+	}
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := cc.RebuildIndexes(ctx, 10, "")
+		return err
+	})
+	if key, listed := bobCanRead(); !key || !listed {
+		t.Errorf("bob after the rebuild read the key %v and the CID %v", key, listed)
+	}
+
+	// Grantee entries left behind by a revoked grant are found by the stale entry sweep
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.RevokeCTIAccess(ctx, id, "bob")
+	})
+	l.Put(granteeEntry, []byte{0x00})
+	var stale []string
+	for bookmark := ""; ; {
+		var page *StaleIndexEntries
+		invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			page, err = cc.FindStaleIndexEntries(ctx, 10, bookmark)
+			return err
+		})
+		stale = append(stale, page.Keys...)
+		if bookmark = page.Bookmark; bookmark == "" {
+			break
+		}
+	}
+	if len(stale) != 1 || stale[0] != granteeEntry {
+		t.Errorf("found stale entries %q, want only the revoked grant", stale)
+	}
+}
+
+func TestSyncAccessibleCTIItemsReturnsRecentEntitledItems(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	add := func(name string, level int) string {
+		var id string
+		invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			id, err = cc.AddCTIItem(ctx, name, 1, testCID, "key", 1, level, "low", "", nil, "", "GREEN", nil, 50)
+			return err
+		})
+		return id
+	}
+	add("old", 1)
+	rechecked := add("old but rechecked", 1)
+	l.Advance(time.Hour)
+	add("restricted", 3)
+	add("new", 1)
+	setUserData(t, cc, l, "bob", 0, 0, 1, 0)
+	// The ledger clock is past sinceTs, so recording availability counts as a modification
+	invoke(t, l, oracle, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.RecordCIDAvailability(ctx, rechecked, true)
+	})
+	since := int64(24*60*60 + 30*60)
+
+	var synced []string
+	bookmark := ""
+	for {
+		var page *CTIItemsPage
+		invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			page, err = cc.SyncAccessibleCTIItems(ctx, since, 2, bookmark)
+			return err
+		})
+		for _, ctiItem := range page.Items {
+			if ctiItem.EncryptKey != "key" {
+				t.Errorf("item %s was synced without its key", ctiItem.ID)
+			}
+			synced = append(synced, ctiItem.Name)
+		}
+		if bookmark = page.Bookmark; bookmark == "" {
+			break
+		}
+	}
+	if got := strings.Join(synced, ","); got != "old but rechecked,new" {
+		t.Errorf("synced %s, want old but rechecked,new", got)
+	}
+}
+
+func TestRebuildIndexesRestoresReviewerEntries(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "phishing kit")
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddReviewData(ctx, id, 4, 4, 4, 4, "")
+	})
+	entries := indexEntries(t, l, reviewerIndex, "bob")
+	if len(entries) != 1 {
+		t.Fatalf("bob has reviewer entries %q, want one", entries)
+	}
+
+	// Lose bob's entry and plant one for a review that does not exist
+	var staleKey string
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		if err := ctx.GetStub().DelState(entries[0]); err != nil {
+			return err
+		}
+		var err error
+		staleKey, err = ctx.GetStub().CreateCompositeKey(reviewerIndex, []string{"carol", id, "Review_9"})
+		if err != nil {
+			return err
+		}
+		return ctx.GetStub().PutState(staleKey, []byte{0x00})
+	})
+
+	for bookmark := ""; ; {
+		invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			bookmark, err = cc.RebuildIndexes(ctx, 10, bookmark)
+			return err
+		})
+		if bookmark == "" {
+			break
+		}
+	}
+	if got := indexEntries(t, l, reviewerIndex, "bob"); len(got) != 1 || got[0] != entries[0] {
+		t.Errorf("after the rebuild bob has reviewer entries %q, want %q", got, entries)
+	}
+
+	var stale []string
+	for bookmark := ""; ; {
+		var page *StaleIndexEntries
+		invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			page, err = cc.FindStaleIndexEntries(ctx, 10, bookmark)
+			return err
+		})
+		stale = append(stale, page.Keys...)
+		if bookmark = page.Bookmark; bookmark == "" {
+			break
+		}
+	}
+	if len(stale) != 1 || stale[0] != staleKey {
+		t.Errorf("found stale entries %q, want only the planted reviewer entry", stale)
+	}
+}
+
+func TestReviewerRewardedOncePerItemWithinMintCap(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	var ids []string
+	for i := 0; i < 4; i++ {
+		ids = append(ids, addItem(t, cc, l, alice, fmt.Sprintf("item %d", i)))
+	}
+	review := func(id string, wantPoints, wantReward int) {
+		t.Helper()
+		added := invokeForEvent(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.AddReviewData(ctx, id, 4, 4, 4, 4, "")
+		})
+		var event ReviewAddedEvent
+		if err := json.Unmarshal(added.Payload, &event); err != nil {
+			t.Fatal(err)
+		}
+		if points := userDataOf(t, l, "bob").Points; points != wantPoints || event.Reward != wantReward {
+			t.Errorf("after reviewing item %s bob has %d points and was rewarded %d, want %d and %d", id, points, event.Reward, wantPoints, wantReward)
+		}
+	}
+
+	review(ids[0], 1, 1)
+	if err := l.Invoke(bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddReviewData(ctx, ids[0], 4, 4, 4, 4, "")
+	}); err == nil {
+		t.Error("a second review of the same item was accepted")
+	}
+
+	if err := l.Invoke(bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.SetRewardMintCap(ctx, 100)
+	}); err == nil {
+		t.Error("a non-admin set the reward mint cap")
+	}
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		if err := cc.SetReviewerReward(ctx, 2); err != nil {
+			return err
+		}
+		return cc.SetRewardMintCap(ctx, 3)
+	})
+
+	// The first reward counts against the day's cap, so only one more fits
+	review(ids[1], 3, 2)
+	review(ids[2], 3, 0)
+
+	l.Advance(24 * time.Hour)
+	review(ids[3], 5, 2)
+}
+
+func TestAdoptCTIItemOnlyAdoptsAnonymizedItems(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	owned := addItem(t, cc, l, alice, "phishing kit")
+	putRawCTIItem(t, l, &CTIData{ID: "2", Name: "orphaned", Uploader: AnonymizedUploader, CID: testCID, Level: 1})
+	lead := &ctitest.Identity{ID: "lead", MSPID: "Org2MSP", Attributes: map[string]string{"cti.role": "orglead"}}
+
+	if err := l.Invoke(bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AdoptCTIItem(ctx, "2")
+	}); err == nil {
+		t.Error("a user without a role adopted an item")
+	}
+	if err := l.Invoke(lead, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AdoptCTIItem(ctx, owned)
+	}); err == nil {
+		t.Error("an item with an uploader was adopted")
+	}
+	invoke(t, l, lead, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AdoptCTIItem(ctx, "2")
+	})
+
+	var ctiItem CTIData
+	if err := json.Unmarshal(l.Get(ctiKey("2")), &ctiItem); err != nil || ctiItem.Uploader != "lead" {
+		t.Errorf("adopted item is %+v, want it uploaded by lead", ctiItem)
+	}
+	if got := len(indexEntries(t, l, uploaderIndex, "lead")); got != 1 {
+		t.Errorf("uploader index has %d entries for lead, want 1", got)
+	}
+}
+
+func TestGetReviewerAgreementMatrix(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "phishing kit")
+	matrix := func() ([]*ReviewerAgreement, error) {
+		var matrix []*ReviewerAgreement
+		err := l.Invoke(bob, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			matrix, err = cc.GetReviewerAgreementMatrix(ctx, id)
+			return err
+		})
+		return matrix, err
+	}
+
+	for _, review := range []struct {
+		reviewer *ctitest.Identity
+		scores   [4]int
+	}{
+		{bob, [4]int{5, 5, 5, 5}},
+		{carol, [4]int{5, 5, 4, 5}},
+		{dave, [4]int{1, 1, 1, 1}},
+	} {
+		if _, err := matrix(); err == nil {
+			t.Error("agreement was computed with fewer than 3 reviews")
+		}
+		invoke(t, l, review.reviewer, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.AddReviewData(ctx, id, review.scores[0], review.scores[1], review.scores[2], review.scores[3], "")
+		})
+	}
+
+	agreements, err := matrix()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]float64{"bob,carol": 0.25, "bob,dave": 4, "carol,dave": 3.75}
+	if len(agreements) != len(want) {
+		t.Fatalf("got %d reviewer pairs, want %d", len(agreements), len(want))
+	}
+	for _, agreement := range agreements {
+		pair := []string{agreement.ReviewerA, agreement.ReviewerB}
+		sort.Strings(pair)
+		if difference, ok := want[strings.Join(pair, ",")]; !ok || agreement.MeanAbsoluteDifference != difference {
+			t.Errorf("%s and %s differ by %v, want %v", agreement.ReviewerA, agreement.ReviewerB, agreement.MeanAbsoluteDifference, difference)
+		}
+	}
+}
+
+func TestPingReportsVersionAndTimestamp(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	var pong string
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		pong, err = cc.Ping(ctx)
+		return err
+	})
+	if want := "cti " + ContractVersion + " 1970-01-02T00:00:00Z"; pong != want {
+		t.Errorf("Ping returned %q, want %q", pong, want)
+	}
+}
+
+func TestMigrateSchemaUpgradesOldRecords(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	addItem(t, cc, l, alice, "current")
+	l.Put(ctiKey("2"), []byte(`{"ID":"2","Name":"old","Uploader":"bob","CID":"`+testCID+`","Level":1}`))
+
+	if err := l.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := cc.MigrateSchema(ctx)
+		return err
+	}); err == nil {
+		t.Error("a non-admin migrated the schema")
+	}
+
+	for _, want := range []int{1, 0} {
+		var migrated int
+		invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			migrated, err = cc.MigrateSchema(ctx)
+			return err
+		})
+		if migrated != want {
+			t.Errorf("migrated %d records, want %d", migrated, want)
+		}
+	}
+
+	var ctiItem CTIData
+	if err := json.Unmarshal(l.Get(ctiKey("2")), &ctiItem); err != nil || ctiItem.SchemaVersion != SchemaVersion || ctiItem.Name != "old" {
+		t.Errorf("migrated record is %+v, want schema version %d", ctiItem, SchemaVersion)
+	}
+
+	var version *VersionInfo
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		version, err = cc.GetContractVersion(ctx)
+		return err
+	})
+	if version.ContractVersion != ContractVersion || version.SchemaVersion != SchemaVersion {
+		t.Errorf("GetContractVersion returned %+v", version)
+	}
+}
+
+func TestGetTagFacetsCountsNormalizedTags(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	for i, tags := range [][]string{
+		{"Phishing", "ransomware"},
+		{"phishing"},
+		{" PHISHING ", "phishing", ""},
+		nil,
+	} {
+		putRawCTIItem(t, l, &CTIData{ID: fmt.Sprint(i + 1), Name: "item", Uploader: "alice", CID: testCID, Level: 1, Tags: tags})
+	}
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := cc.RebuildIndexes(ctx, 10, "")
+		return err
+	})
+
+	var facets map[string]int
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		facets, err = cc.GetTagFacets(ctx)
+		return err
+	})
+	if len(facets) != 2 || facets["phishing"] != 3 || facets["ransomware"] != 1 {
+		t.Errorf("tag facets are %v, want phishing 3 and ransomware 1", facets)
+	}
+}
+
+func TestVoteReviewHelpfulAndSortByHelpfulness(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "phishing kit")
+	for _, reviewer := range []*ctitest.Identity{bob, carol} {
+		invoke(t, l, reviewer, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.AddReviewData(ctx, id, 4, 4, 4, 4, "")
+		})
+	}
+	first, second := reviewIDOf(t, l, "bob", id), reviewIDOf(t, l, "carol", id)
+	vote := func(voter *ctitest.Identity, reviewID string, helpful bool) error {
+		return l.Invoke(voter, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.VoteReviewHelpful(ctx, reviewID, helpful)
+		})
+	}
+	counts := func(reviewID string) (int, int) {
+		var review ReviewData
+		if err := json.Unmarshal(l.Get(reviewKey(reviewID)), &review); err != nil {
+			t.Fatal(err)
+		}
+		return review.HelpfulCount, review.UnhelpfulCount
+	}
+
+	// First votes
+	for _, v := range []struct {
+		voter    *ctitest.Identity
+		reviewID string
+		helpful  bool
+	}{
+		{dave, second, true},
+		{erin, second, true},
+		{dave, first, false},
+	} {
+		if err := vote(v.voter, v.reviewID, v.helpful); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if helpful, unhelpful := counts(second); helpful != 2 || unhelpful != 0 {
+		t.Errorf("carol's review has %d helpful and %d unhelpful votes, want 2 and 0", helpful, unhelpful)
+	}
+	if err := vote(dave, second, true); err == nil {
+		t.Error("a voter voted twice the same way")
+	}
+	if err := vote(bob, first, true); err == nil {
+		t.Error("a reviewer voted on their own review")
+	}
+
+	// A changed vote moves from one count to the other
+	if err := vote(erin, second, false); err != nil {
+		t.Fatal(err)
+	}
+	if helpful, unhelpful := counts(second); helpful != 1 || unhelpful != 1 {
+		t.Errorf("after a changed vote carol's review has %d helpful and %d unhelpful votes, want 1 and 1", helpful, unhelpful)
+	}
+
+	var reviews []*ReviewData
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		reviews, err = cc.GetReviewsByCTISortedByHelpfulness(ctx, id)
+		return err
+	})
+	if len(reviews) != 2 || reviews[0].ID != second || reviews[1].ID != first {
+		t.Errorf("reviews sorted by helpfulness are %+v, want carol's then bob's", reviews)
+	}
+}
+
+func TestGetMyAccessibleCountFollowsSubscription(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	for level := 1; level <= 3; level++ {
+		invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+			_, err := cc.AddCTIItem(ctx, fmt.Sprintf("level %d", level), 1, testCID, "key", 1, level, "low", "", nil, "", "GREEN", nil, 50)
+			return err
+		})
+	}
+	accessibleCount := func() *AccessibleCount {
+		var count *AccessibleCount
+		invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			count, err = cc.GetMyAccessibleCount(ctx)
+			return err
+		})
+		return count
+	}
+
+	setUserData(t, cc, l, "bob", 0, 0, 1, 0)
+	if count := accessibleCount(); count.Accessible != 1 || count.Total != 3 {
+		t.Errorf("at tier 1 bob can access %d of %d items, want 1 of 3", count.Accessible, count.Total)
+	}
+	setUserData(t, cc, l, "bob", 0, 0, 3, 0)
+	if count := accessibleCount(); count.Accessible != 3 || count.Total != 3 {
+		t.Errorf("at tier 3 bob can access %d of %d items, want 3 of 3", count.Accessible, count.Total)
+	}
+}
+
+func TestOrgQuotaLimitsUploads(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	var ids []string
+	upload := func(identity *ctitest.Identity) error {
+		return l.Invoke(identity, func(ctx contractapi.TransactionContextInterface) error {
+			id, err := cc.AddCTIItem(ctx, "item", 1, testCID, "key", 1, 1, "low", "", nil, "", "GREEN", nil, 50)
+			ids = append(ids, id)
+			return err
+		})
+	}
+	usage := func() *OrgQuotaUsage {
+		var usage *OrgQuotaUsage
+		invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			usage, err = cc.GetOrgQuotaUsage(ctx, "Org1MSP")
+			return err
+		})
+		return usage
+	}
+
+	if err := l.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.SetOrgQuota(ctx, "Org1MSP", 10)
+	}); err == nil {
+		t.Error("a non-admin set an org quota")
+	}
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.SetOrgQuota(ctx, "Org1MSP", 2)
+	})
+
+	for i := 0; i < 2; i++ {
+		if err := upload(alice); err != nil {
+			t.Fatalf("upload %d within the quota failed: %v", i+1, err)
+		}
+	}
+	if err := upload(alice); err == nil || !strings.Contains(err.Error(), "org quota exceeded") {
+		t.Errorf("upload past the quota returned %v, want an org quota error", err)
+	}
+	if err := upload(bob); err != nil {
+		t.Errorf("an org without a quota could not upload: %v", err)
+	}
+	if usage := usage(); usage.Used != 2 || usage.MaxItems != 2 {
+		t.Errorf("Org1MSP quota usage is %+v, want 2 of 2", usage)
+	}
+
+	// Deleting an item frees its place in the quota
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.DeleteCTIItemByID(ctx, ids[0])
+	})
+	if usage := usage(); usage.Used != 1 {
+		t.Errorf("after a delete Org1MSP has used %d items, want 1", usage.Used)
+	}
+	if err := upload(alice); err != nil {
+		t.Errorf("upload after a delete failed: %v", err)
+	}
+}
+
+func TestGetReviewStatsByOrg(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "phishing kit")
+	review := func(reviewer *ctitest.Identity, score int) {
+		invoke(t, l, reviewer, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.AddReviewData(ctx, id, score, score, score, score, "")
+		})
+	}
+	statsByOrg := func() []*OrgReviewStats {
+		var stats []*OrgReviewStats
+		invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			stats, err = cc.GetReviewStatsByOrg(ctx, id)
+			return err
+		})
+		return stats
+	}
+
+	// bob and dave are both in Org2MSP
+	review(bob, 5)
+	review(dave, 3)
+	if stats := statsByOrg(); len(stats) != 1 || stats[0].MSPID != "Org2MSP" || stats[0].ReviewCount != 2 || stats[0].AverageComposite != 4 {
+		t.Errorf("stats for a single reviewing org are %+v", stats)
+	}
+
+	review(carol, 2)
+	stats := statsByOrg()
+	if len(stats) != 2 {
+		t.Fatalf("got stats for %d orgs, want 2", len(stats))
+	}
+	if stats[0].MSPID != "Org2MSP" || stats[0].AverageAccuracy != 4 || stats[1].MSPID != "Org3MSP" || stats[1].AverageAccuracy != 2 || stats[1].ReviewCount != 1 {
+		t.Errorf("per-org stats are %+v and %+v", stats[0], stats[1])
+	}
+}
+
+func TestAddTagsToCTIItemsTagsAndIndexesOwnedItems(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	first := addItem(t, cc, l, alice, "phishing kit")
+	second := addItem(t, cc, l, alice, "loader")
+	others := addItem(t, cc, l, bob, "botnet")
+
+	if err := l.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := cc.AddTagsToCTIItems(ctx, []string{first}, []string{"not a tag!"})
+		return err
+	}); err == nil {
+		t.Error("an invalid tag was accepted")
+	}
+
+	var result *TagUpdateResult
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		result, err = cc.AddTagsToCTIItems(ctx, []string{first, second, others, "99"}, []string{"Phishing", "phishing", "apt28"})
+		return err
+	})
+	if strings.Join(result.Tagged, ",") != first+","+second || len(result.Skipped) != 2 || result.Skipped[others] == "" || result.Skipped["99"] == "" {
+		t.Errorf("tag result is %+v, want %s and %s tagged and the rest skipped", result, first, second)
+	}
+
+	// Tagging again only adds tags the item lacks
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := cc.AddTagsToCTIItems(ctx, []string{first}, []string{"apt28", "credential-theft"})
+		return err
+	})
+	var ctiItem CTIData
+	if err := json.Unmarshal(l.Get(ctiKey(first)), &ctiItem); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(ctiItem.Tags, ","); got != "phishing,apt28,credential-theft" {
+		t.Errorf("item %s has tags %s, want phishing,apt28,credential-theft", first, got)
+	}
+	if got := len(indexEntries(t, l, tagIndex, "phishing")); got != 2 {
+		t.Errorf("tag index has %d phishing entries, want 2", got)
+	}
+	if got := len(indexEntries(t, l, tagIndex, "credential-theft")); got != 1 {
+		t.Errorf("tag index has %d credential-theft entries, want 1", got)
+	}
+	if got := len(indexEntries(t, l, uploaderIndex, "alice")); got != 2 {
+		t.Errorf("re-indexing left %d uploader entries for alice, want 2", got)
+	}
+}
+
+func TestGetCTILineageOverSmallGraph(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	ids := make(map[string]string)
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		ids[name] = addItem(t, cc, l, alice, name)
+	}
+	// c supersedes b, which supersedes a; d relates to b and e to d; f is unconnected
+	for _, link := range [][3]string{{"b", "a", "supersedes"}, {"c", "b", "supersedes"}, {"d", "b", "related-to"}, {"e", "d", "related-to"}} {
+		invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.LinkCTIItems(ctx, ids[link[0]], ids[link[1]], link[2])
+		})
+	}
+	if err := l.Invoke(bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.LinkCTIItems(ctx, ids["f"], ids["a"], "related-to")
+	}); err == nil {
+		t.Error("a caller linked an item they do not own")
+	}
+
+	names := make(map[string]string)
+	for name, id := range ids {
+		names[id] = name
+	}
+	lineage := func(maxDepth int) (string, string) {
+		var result *CTILineage
+		invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			result, err = cc.GetCTILineage(ctx, ids["b"], maxDepth)
+			return err
+		})
+		var nodes, edges []string
+		for _, node := range result.Nodes {
+			nodes = append(nodes, names[node.ID])
+		}
+		for _, edge := range result.Edges {
+			edges = append(edges, names[edge.From]+" "+edge.Relationship+" "+names[edge.To])
+		}
+		return strings.Join(nodes, ","), strings.Join(edges, "; ")
+	}
+
+	for _, tc := range []struct {
+		maxDepth     int
+		nodes, edges string
+	}{
+		{0, "b", ""},
+		{1, "b,a,d,c", "b supersedes a; d related-to b; c supersedes b"},
+		{2, "b,a,d,c,e", "b supersedes a; d related-to b; c supersedes b; e related-to d"},
+		{50, "b,a,d,c,e", "b supersedes a; d related-to b; c supersedes b; e related-to d"},
+	} {
+		if nodes, edges := lineage(tc.maxDepth); nodes != tc.nodes || edges != tc.edges {
+			t.Errorf("lineage at depth %d is %s with %s, want %s with %s", tc.maxDepth, nodes, edges, tc.nodes, tc.edges)
+		}
+	}
+
+	// Deleting an item drops its links
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.DeleteCTIItemByID(ctx, ids["d"])
+	})
+	if nodes, edges := lineage(2); nodes != "b,a,c" || edges != "b supersedes a; c supersedes b" {
+		t.Errorf("lineage after delete is %s with %s", nodes, edges)
+	}
+
+	// Unlinking removes the edge, and callers without access get redacted items
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.UnlinkCTIItems(ctx, ids["c"], ids["b"], "supersedes")
+	})
+	if nodes, edges := lineage(2); nodes != "b,a" || edges != "b supersedes a" {
+		t.Errorf("lineage after unlink is %s with %s", nodes, edges)
+	}
+	var redacted *CTILineage
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		redacted, err = cc.GetCTILineage(ctx, ids["b"], 1)
+		return err
+	})
+	for _, node := range redacted.Nodes {
+		if node.CID != "" || node.EncryptKey != "" {
+			t.Errorf("bob got item %s with its CID and key", node.ID)
+		}
+	}
+}
+
+func TestBalanceChangesRefuseNegativeValues(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	for _, values := range [][2]int{{-1, 0}, {0, -5}} {
+		if err := l.Invoke(admin, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.AdminSetUserData(ctx, "bob", 0, values[0], 1, values[1])
+		}); err == nil {
+			t.Errorf("bob was given %d points and a balance of %d", values[0], values[1])
+		}
+	}
+
+	userData := &UserData{ID: "bob", Points: 3, Balance: 10}
+	if err := adjustUserBalances(userData, 0, -11); err == nil || !strings.Contains(err.Error(), "insufficient funds") {
+		t.Errorf("overdrawing the balance returned %v, want an insufficient funds error", err)
+	}
+	if err := adjustUserBalances(userData, -4, 0); err == nil {
+		t.Error("points were driven below zero")
+	}
+	if userData.Points != 3 || userData.Balance != 10 {
+		t.Errorf("a refused change left bob with %d points and a balance of %d", userData.Points, userData.Balance)
+	}
+	if err := adjustUserBalances(userData, -3, -10); err != nil || userData.Points != 0 || userData.Balance != 0 {
+		t.Errorf("spending everything returned %v and left %+v", err, userData)
+	}
+}
+
+func TestGetCTIItemSignedHashIsStable(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "phishing kit")
+	signed := func() *SignedCTIItem {
+		var signed *SignedCTIItem
+		invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			signed, err = cc.GetCTIItemSigned(ctx, id)
+			return err
+		})
+		return signed
+	}
+
+	first, second := signed(), signed()
+	if first.CanonicalHash != second.CanonicalHash {
+		t.Errorf("canonical hash changed between calls: %s then %s", first.CanonicalHash, second.CanonicalHash)
+	}
+	itemJSON, err := json.Marshal(first.Item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum := sha256.Sum256(itemJSON); hex.EncodeToString(sum[:]) != first.CanonicalHash || first.HashAlgorithm != "SHA-256" {
+		t.Errorf("canonical hash %s does not match the SHA-256 of the returned item", first.CanonicalHash)
+	}
+}
+
+func TestGetCTIItemsAboveQualityUsesCachedAggregates(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	strong := addItem(t, cc, l, alice, "phishing kit")
+	weak := addItem(t, cc, l, alice, "botnet")
+	for _, review := range []struct {
+		reviewer *ctitest.Identity
+		id       string
+		score    int
+	}{{bob, strong, 5}, {carol, strong, 4}, {bob, weak, 2}, {carol, weak, 5}} {
+		invoke(t, l, review.reviewer, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.AddReviewData(ctx, review.id, review.score, review.score, review.score, review.score, "")
+		})
+	}
+
+	var items []*CTIData
+	invoke(t, l, dave, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		items, err = cc.GetCTIItemsAboveQuality(ctx, 4, 2)
+		return err
+	})
+	if len(items) != 1 || items[0].ID != strong || items[0].ReviewCount != 2 || items[0].CompositeScore != 4.5 {
+		t.Fatalf("items above quality are %+v, want only %s with 2 reviews averaging 4.5", items, strong)
+	}
+
+	l.Invoke(dave, func(ctx contractapi.TransactionContextInterface) error {
+		if _, err := cc.GetCTIItemsAboveQuality(ctx, 4, 0); err == nil {
+			t.Error("a minimum review count of 0 was accepted")
+		}
+		return nil
+	})
+}
+
+func TestDiffCTIItemAcrossRevisions(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "phishing kit")
+	created := int64(24 * 60 * 60)
+	for _, revision := range []struct {
+		name       string
+		confidence int
+	}{{"phishing kit v2", 50}, {"phishing kit v3", 80}} {
+		l.Advance(time.Hour)
+		invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.UpdateCTIItem(ctx, id, revision.name, 1, testCID, "key", 1, "GREEN", nil, revision.confidence)
+		})
+	}
+	revised := created + 2*60*60
+	diff := func(identity *ctitest.Identity, fromTs, toTs int64) map[string]*FieldChange {
+		changes := make(map[string]*FieldChange)
+		invoke(t, l, identity, func(ctx contractapi.TransactionContextInterface) error {
+			diff, err := cc.DiffCTIItem(ctx, id, fromTs, toTs)
+			if err != nil {
+				return err
+			}
+			if diff.ExistedAtFrom != (fromTs >= created) || !diff.ExistedAtTo {
+				t.Errorf("diff from %d to %d reports existence %t/%t", fromTs, toTs, diff.ExistedAtFrom, diff.ExistedAtTo)
+			}
+			for _, change := range diff.Changes {
+				changes[change.Field] = change
+			}
+			return nil
+		})
+		return changes
+	}
+
+	changes := diff(alice, created, revised)
+	if name := changes["Name"]; name == nil || name.Before != `"phishing kit"` || name.After != `"phishing kit v3"` {
+		t.Errorf("name change across revisions is %+v", name)
+	}
+	if confidence := changes["Confidence"]; confidence == nil || confidence.Before != "50" || confidence.After != "80" {
+		t.Errorf("confidence change across revisions is %+v", confidence)
+	}
+	if changes["CID"] != nil {
+		t.Errorf("unchanged CID reported as %+v", changes["CID"])
+	}
+
+	changes = diff(alice, created+1800, created+5400)
+	if name := changes["Name"]; name == nil || name.Before != `"phishing kit"` || name.After != `"phishing kit v2"` {
+		t.Errorf("name change between the first two revisions is %+v", name)
+	}
+
+	changes = diff(bob, 0, revised)
+	if key := changes["encryptKey"]; key == nil || key.Before != "" || key.After == `"key"` {
+		t.Errorf("encryption key change for an unentitled caller is %+v", key)
+	}
+}
+
+func TestReviewAgainstCustomDimension(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "phishing kit")
+	dimensions := []string{"Accuracy", "Timeliness", "Completeness", "Consistency", "Relevance"}
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.SetReviewDimensions(ctx, dimensions)
+	})
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		configured, err := cc.GetReviewDimensions(ctx)
+		if err == nil && strings.Join(configured, ",") != strings.Join(dimensions, ",") {
+			t.Errorf("configured review dimensions are %v", configured)
+		}
+		return err
+	})
+
+	l.Invoke(bob, func(ctx contractapi.TransactionContextInterface) error {
+		if err := cc.AddReviewData(ctx, id, 4, 4, 4, 4, ""); err == nil {
+			t.Error("a review without the Relevance score was accepted")
+		}
+		return nil
+	})
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddScoredReviewData(ctx, id, map[string]int{"Accuracy": 4, "Timeliness": 4, "Completeness": 4, "Consistency": 4, "Relevance": 1}, "")
+	})
+
+	var review ReviewData
+	if err := json.Unmarshal(l.Get(reviewKey(reviewIDOf(t, l, "bob", id))), &review); err != nil {
+		t.Fatal(err)
+	}
+	if review.Scores["Relevance"] != 1 || review.Accuracy != 4 {
+		t.Errorf("stored review scores are %v with accuracy %d", review.Scores, review.Accuracy)
+	}
+	if score := compositeScore(&review); score != 3.4 {
+		t.Errorf("composite score over five dimensions is %v, want 3.4", score)
+	}
+}
+
+func TestContentChangeFlagsCTIItemForReReview(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "phishing kit")
+	needingReReview := func() []*CTIData {
+		var items []*CTIData
+		invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			items, err = cc.GetCTIItemsNeedingReReview(ctx)
+			return err
+		})
+		return items
+	}
+	update := func(name, cid string) {
+		invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.UpdateCTIItem(ctx, id, name, 1, cid, "key", 1, "GREEN", nil, 50)
+		})
+	}
+
+	// An unreviewed item has nothing to re-review
+	update("phishing kit", "QmT5NvUtoM5nWFfrQdVrFtvGfKFmG7AHE8P34isapyhCxX")
+	if items := needingReReview(); len(items) != 0 {
+		t.Fatalf("unreviewed item flagged for re-review: %+v", items)
+	}
+
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddReviewData(ctx, id, 4, 4, 4, 4, "")
+	})
+	update("phishing kit (renamed)", "QmT5NvUtoM5nWFfrQdVrFtvGfKFmG7AHE8P34isapyhCxX")
+	if items := needingReReview(); len(items) != 0 {
+		t.Fatalf("rename flagged the item for re-review: %+v", items)
+	}
+
+	update("phishing kit (renamed)", "QmYjtig7VJQ6XsnUjqqJvj7QaMcCAwtrgNdahSiFofrE7o")
+	if items := needingReReview(); len(items) != 1 || items[0].ID != id {
+		t.Fatalf("items needing re-review after a content change are %+v", items)
+	}
+
+	invoke(t, l, carol, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddReviewData(ctx, id, 4, 4, 4, 4, "")
+	})
+	if items := needingReReview(); len(items) != 0 {
+		t.Errorf("item still flagged after a fresh review: %+v", items)
+	}
+}
+
+func TestGetMyPermissionsFollowsRoleAttribute(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	moderator := &ctitest.Identity{ID: "moderator", MSPID: "Org2MSP", Attributes: map[string]string{"cti.role": "moderator"}}
+	lead := &ctitest.Identity{ID: "lead", MSPID: "Org2MSP", Attributes: map[string]string{"cti.role": "orglead"}}
+	for _, test := range []struct {
+		identity *ctitest.Identity
+		want     Permissions
+	}{
+		{alice, Permissions{ID: "alice", MSPID: "Org1MSP"}},
+		{admin, Permissions{ID: "admin", MSPID: "Org1MSP", Role: "admin", IsAdmin: true, CanMint: true, CanConfigure: true, CanAdoptItems: true, CanModerate: true, CanExpireItems: true}},
+		{oracle, Permissions{ID: "oracle", MSPID: "Org1MSP", Role: "oracle", IsOracle: true, CanRecordCIDAvailability: true, CanExpireItems: true}},
+		{moderator, Permissions{ID: "moderator", MSPID: "Org2MSP", Role: "moderator", IsModerator: true, CanModerate: true}},
+		{lead, Permissions{ID: "lead", MSPID: "Org2MSP", Role: "orglead", IsOrgLead: true, CanAdoptItems: true}},
+	} {
+		invoke(t, l, test.identity, func(ctx contractapi.TransactionContextInterface) error {
+			permissions, err := cc.GetMyPermissions(ctx)
+			if err == nil && *permissions != test.want {
+				t.Errorf("permissions of %s are %+v, want %+v", test.identity.ID, *permissions, test.want)
+			}
+			return err
+		})
+	}
+
+	// The enforcing methods agree with the reported permissions
+	id := addItem(t, cc, l, alice, "phishing kit")
+	l.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+		if err := cc.RecordCIDAvailability(ctx, id, true); err == nil {
+			t.Error("a caller without the oracle role recorded CID availability")
+		}
+		return nil
+	})
+	invoke(t, l, oracle, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.RecordCIDAvailability(ctx, id, true)
+	})
+}
+
+func TestVerifyReviewIntegrityDetectsTampering(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "phishing kit")
+	for _, reviewer := range []*ctitest.Identity{bob, carol} {
+		invoke(t, l, reviewer, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.AddReviewData(ctx, id, 4, 4, 4, 4, "solid")
+		})
+	}
+	reviewID := reviewIDOf(t, l, "bob", id)
+	verify := func() (*ReviewIntegrity, []*ReviewIntegrity) {
+		var integrity *ReviewIntegrity
+		var unverified []*ReviewIntegrity
+		invoke(t, l, dave, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			if integrity, err = cc.VerifyReviewIntegrity(ctx, reviewID); err != nil {
+				return err
+			}
+			unverified, err = cc.VerifyAllReviewsIntegrity(ctx)
+			return err
+		})
+		return integrity, unverified
+	}
+
+	if integrity, unverified := verify(); !integrity.Hashed || !integrity.Verified || len(unverified) != 0 {
+		t.Fatalf("untouched review verified as %+v with unverified reviews %+v", integrity, unverified)
+	}
+
+	var review ReviewData
+	if err := json.Unmarshal(l.Get(reviewKey(reviewID)), &review); err != nil {
+		t.Fatal(err)
+	}
+	review.Accuracy = 1
+	reviewJSON, err := json.Marshal(review)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Put(reviewKey(reviewID), reviewJSON)
+
+	integrity, unverified := verify()
+	if integrity.Verified || integrity.StoredHash == integrity.ComputedHash {
+		t.Errorf("tampered review verified as %+v", integrity)
+	}
+	if len(unverified) != 1 || unverified[0].ReviewID != reviewID {
+		t.Errorf("unverified reviews are %+v, want only %s", unverified, reviewID)
+	}
+}
+
+func TestGetCTIItemsByUploadersMergesWatchlist(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	for i, uploader := range []*ctitest.Identity{alice, bob, carol, alice, bob, carol} {
+		invoke(t, l, uploader, func(ctx contractapi.TransactionContextInterface) error {
+			_, err := cc.AddCTIItem(ctx, fmt.Sprintf("item %d", i), int64(i+1), testCID, "key", 1, 1, "low", "", nil, "", "GREEN", nil, 50)
+			return err
+		})
+	}
+
+	var items []*CTIData
+	invoke(t, l, dave, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		items, err = cc.GetCTIItemsByUploaders(ctx, []string{"alice", "carol", "alice"})
+		return err
+	})
+	var names []string
+	for _, item := range items {
+		names = append(names, item.Name)
+	}
+	if got := strings.Join(names, ","); got != "item 5,item 3,item 2,item 0" {
+		t.Errorf("watchlist items are %s, want items 5, 3, 2 and 0", got)
+	}
+
+	l.Invoke(dave, func(ctx contractapi.TransactionContextInterface) error {
+		if _, err := cc.GetCTIItemsByUploaders(ctx, nil); err == nil {
+			t.Error("an empty watchlist was accepted")
+		}
+		return nil
+	})
+}
+
+func TestUploadStakeReturnedForfeitedAndRefunded(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	moderator := &ctitest.Identity{ID: "moderator", MSPID: "Org2MSP", Attributes: map[string]string{"cti.role": "moderator"}}
+	setUserData(t, cc, l, "alice", 0, 0, 0, 15)
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.SetUploadStake(ctx, 5)
+	})
+	returned := addItem(t, cc, l, alice, "well reviewed")
+	forfeited := addItem(t, cc, l, alice, "false positive")
+	deleted := addItem(t, cc, l, alice, "withdrawn")
+	if balance := userDataOf(t, l, "alice").Balance; balance != 0 {
+		t.Fatalf("balance after staking three items is %d, want 0", balance)
+	}
+	l.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+		if _, err := cc.AddCTIItem(ctx, "unaffordable", 1, testCID, "key", 1, 1, "low", "", nil, "", "GREEN", nil, 50); err == nil {
+			t.Error("an upload without the balance to stake was accepted")
+		}
+		return nil
+	})
+
+	for _, reviewer := range []*ctitest.Identity{bob, carol} {
+		invoke(t, l, reviewer, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.AddReviewData(ctx, returned, 4, 4, 4, 4, "")
+		})
+	}
+	if balance := userDataOf(t, l, "alice").Balance; balance != 5 {
+		t.Errorf("balance after a well reviewed item returned its stake is %d, want 5", balance)
+	}
+
+	l.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+		if _, err := cc.ForfeitCTIStake(ctx, forfeited); err == nil {
+			t.Error("the uploader forfeited a stake")
+		}
+		return nil
+	})
+	invoke(t, l, moderator, func(ctx contractapi.TransactionContextInterface) error {
+		amount, err := cc.ForfeitCTIStake(ctx, forfeited)
+		if err == nil && amount != 5 {
+			t.Errorf("forfeited %d, want 5", amount)
+		}
+		return err
+	})
+
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.DeleteCTIItemByID(ctx, deleted)
+	})
+	if balance := userDataOf(t, l, "alice").Balance; balance != 10 {
+		t.Errorf("balance after a forfeiture and a deletion refund is %d, want 10", balance)
+	}
+}
+
+func TestGetReviewsByCTIAdvancedValidatesSortDimension(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "phishing kit")
+	l.Invoke(bob, func(ctx contractapi.TransactionContextInterface) error {
+		if _, err := cc.GetReviewsByCTIAdvanced(ctx, id, 10, "", "Relevance", false); err == nil || !strings.Contains(err.Error(), "unknown review dimension") {
+			t.Errorf("sorting on an unconfigured dimension failed with %v", err)
+		}
+		if _, err := cc.GetReviewsByCTIAdvanced(ctx, id, 0, "", "Accuracy", false); err == nil {
+			t.Error("a page size of 0 was accepted")
+		}
+		// The in-memory ledger behaves like LevelDB, which rejects rich queries
+		if _, err := cc.GetReviewsByCTIAdvanced(ctx, id, 10, "", "Accuracy", true); err == nil {
+			t.Error("a rich query succeeded without CouchDB")
+		}
+		return nil
+	})
+
+	if field := reviewSortField("Accuracy"); field != "Accuracy" {
+		t.Errorf("sort field of a default dimension is %s", field)
+	}
+	if field := reviewSortField("Relevance"); field != "Scores.Relevance" {
+		t.Errorf("sort field of a custom dimension is %s", field)
+	}
+}
+
+func TestPayOutstandingRewardsSettlesCappedRewards(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	var ids []string
+	for i := 0; i < 3; i++ {
+		ids = append(ids, addItem(t, cc, l, alice, fmt.Sprintf("item %d", i)))
+	}
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.SetRewardMintCap(ctx, 1)
+	})
+	for _, id := range ids {
+		invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.AddReviewData(ctx, id, 4, 4, 4, 4, "")
+		})
+	}
+
+	var shortfall *RewardShortfall
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		shortfall, err = cc.GetUnpaidReviewRewards(ctx, "bob")
+		return err
+	})
+	if shortfall.Owed != 2 || shortfall.PaidRewards != 1 || len(shortfall.UnpaidIDs) != 2 {
+		t.Fatalf("shortfall over the mint cap is %+v, want 2 owed and 1 paid", shortfall)
+	}
+
+	pay := func() *RewardShortfall {
+		var shortfall *RewardShortfall
+		invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			shortfall, err = cc.PayOutstandingRewards(ctx, "bob")
+			return err
+		})
+		return shortfall
+	}
+
+	// The day's cap is already spent, so nothing more can be paid today
+	if shortfall := pay(); shortfall.Owed != 2 || userDataOf(t, l, "bob").Points != 1 {
+		t.Errorf("payout within a spent cap left %+v with %d points", shortfall, userDataOf(t, l, "bob").Points)
+	}
+
+	l.Advance(24 * time.Hour)
+	if shortfall := pay(); shortfall.Owed != 1 || len(shortfall.UnpaidIDs) != 1 || userDataOf(t, l, "bob").Points != 2 {
+		t.Errorf("payout the next day left %+v with %d points", shortfall, userDataOf(t, l, "bob").Points)
+	}
+	l.Advance(24 * time.Hour)
+	if shortfall := pay(); shortfall.Owed != 0 || len(shortfall.UnpaidIDs) != 0 || userDataOf(t, l, "bob").Points != 3 {
+		t.Errorf("final payout left %+v with %d points", shortfall, userDataOf(t, l, "bob").Points)
+	}
+	if got := userDataOf(t, l, "bob").ReviewPoints; got != 3 {
+		t.Errorf("bob has %d review points after every reward was paid, want 3", got)
+	}
+}
+
+func TestScanBrokenReferencesFlagsDanglers(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	reviewed := addItem(t, cc, l, alice, "phishing kit")
+	linked := addItem(t, cc, l, alice, "botnet")
+	successor := addItem(t, cc, l, alice, "botnet v2")
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddReviewData(ctx, reviewed, 4, 4, 4, 4, "")
+	})
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.LinkCTIItems(ctx, successor, linked, "supersedes")
+	})
+	reviewID := reviewIDOf(t, l, "bob", reviewed)
+	scan := func() *BrokenReferenceReport {
+		var report *BrokenReferenceReport
+		invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			report, err = cc.ScanBrokenReferences(ctx, 10, "")
+			return err
+		})
+		return report
+	}
+
+	if report := scan(); len(report.DanglingReviews)+len(report.DanglingIndexEntries)+len(report.DanglingLinks) != 0 {
+		t.Fatalf("consistent ledger reported %+v", report)
+	}
+
+	// Deleting the reviewed item through the contract leaves its review behind, and removing the
+	// superseded item out of band leaves the link and index entries pointing at nothing
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.DeleteCTIItemByID(ctx, reviewed)
+	})
+	l.Delete(ctiKey(linked))
+
+	report := scan()
+	if len(report.DanglingReviews) != 1 || report.DanglingReviews[0].Key != reviewKey(reviewID) || report.DanglingReviews[0].MissingKey != ctiKey(reviewed) {
+		t.Errorf("dangling reviews are %+v", report.DanglingReviews)
+	}
+	if len(report.DanglingLinks) != 1 || report.DanglingLinks[0].MissingKey != ctiKey(linked) {
+		t.Errorf("dangling links are %+v", report.DanglingLinks)
+	}
+	if len(report.DanglingIndexEntries) == 0 {
+		t.Error("index entries of the removed item were not reported")
+	}
+	for _, entry := range report.DanglingIndexEntries {
+		if entry.MissingKey != ctiKey(linked) {
+			t.Errorf("unexpected dangling index entry %+v", entry)
+		}
+	}
+
+	l.Invoke(bob, func(ctx contractapi.TransactionContextInterface) error {
+		if _, err := cc.ScanBrokenReferences(ctx, 10, ""); err == nil {
+			t.Error("a non-admin scanned for broken references")
+		}
+		return nil
+	})
+}
+
+func TestFollowersNotifiedOfCTIItemUpdates(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "phishing kit")
+	for _, follower := range []*ctitest.Identity{bob, carol} {
+		invoke(t, l, follower, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.FollowCTIItem(ctx, id)
+		})
+	}
+	update := func() *CTIItemUpdatedEvent {
+		event := invokeForEvent(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.UpdateCTIItem(ctx, id, "phishing kit", 1, testCID, "key", 1, "GREEN", nil, 50)
+		})
+		if event == nil || event.Name != "CTIItemUpdated" {
+			t.Fatalf("update emitted %+v", event)
+		}
+		var updated CTIItemUpdatedEvent
+		if err := json.Unmarshal(event.Payload, &updated); err != nil {
+			t.Fatal(err)
+		}
+		return &updated
+	}
+
+	if event := update(); event.ID != id || event.UpdatedBy != "alice" || strings.Join(event.Followers, ",") != "bob,carol" {
+		t.Errorf("update event is %+v", event)
+	}
+
+	var followed []*CTIData
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		followed, err = cc.GetMyFollowedCTIItems(ctx)
+		return err
+	})
+	if len(followed) != 1 || followed[0].ID != id {
+		t.Errorf("bob follows %+v", followed)
+	}
+
+	invoke(t, l, carol, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.UnfollowCTIItem(ctx, id)
+	})
+	if event := update(); strings.Join(event.Followers, ",") != "bob" {
+		t.Errorf("followers after carol unfollowed are %v", event.Followers)
+	}
+}
+
+func TestGetScoreHelpfulnessCorrelation(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "phishing kit")
+	correlation := func() (*ScoreHelpfulnessCorrelation, error) {
+		var correlation *ScoreHelpfulnessCorrelation
+		err := l.Invoke(erin, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			correlation, err = cc.GetScoreHelpfulnessCorrelation(ctx, id)
+			return err
+		})
+		return correlation, err
+	}
+
+	// The harshest review is found the most helpful
+	for i, review := range []struct {
+		reviewer *ctitest.Identity
+		score    int
+		voters   []*ctitest.Identity
+	}{{bob, 2, []*ctitest.Identity{erin, oracle}}, {carol, 3, []*ctitest.Identity{erin}}, {dave, 5, nil}} {
+		if _, err := correlation(); err == nil {
+			t.Errorf("correlation computed over %d reviews", i)
+		}
+		invoke(t, l, review.reviewer, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.AddReviewData(ctx, id, review.score, review.score, review.score, review.score, "")
+		})
+		reviewID := reviewIDOf(t, l, review.reviewer.ID, id)
+		for _, voter := range review.voters {
+			invoke(t, l, voter, func(ctx contractapi.TransactionContextInterface) error {
+				return cc.VoteReviewHelpful(ctx, reviewID, true)
+			})
+		}
+	}
+
+	result, err := correlation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ReviewCount != 3 || result.Correlation > -0.98 || result.Correlation < -0.99 {
+		t.Errorf("correlation is %+v, want about -0.982 over 3 reviews", result)
+	}
+}
+
+func TestResetUserAccountRecordsAuditEntry(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	setUserData(t, cc, l, "bob", 2, 7, 1, 30)
+	if err := l.Invoke(carol, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.ResetUserAccount(ctx, "bob")
+	}); err == nil {
+		t.Error("a non-admin reset an account")
+	}
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.ResetUserAccount(ctx, "bob")
+	})
+
+	if userData := userDataOf(t, l, "bob"); userData.ID != "bob" || userData.Points != 0 || userData.Balance != 0 || userData.UploadCount != 0 || userData.Subscribed != 0 {
+		t.Errorf("reset account is %+v", userData)
+	}
+
+	var log []*AuditEntry
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		log, err = cc.GetAdminAuditLog(ctx)
+		return err
+	})
+	// The first entry is the setup through AdminSetUserData
+	if len(log) != 2 || log[1].Actor != "admin" || log[1].Action != "ResetUserAccount" || log[1].Target != "bob" || !strings.Contains(log[1].Details, "balance 30") {
+		t.Errorf("audit log is %+v", log)
+	}
+}
+
+func TestGetCTIItemsByFreshnessRanksRecentlyReviewedFirst(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	// The uploader-supplied timestamp claims the stale item is new; freshness ignores it
+	var stale string
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		stale, err = cc.AddCTIItem(ctx, "stale", 2000000000, testCID, "key", 1, 1, "low", "", nil, "", "GREEN", nil, 50)
+		return err
+	})
+	l.Advance(60 * 24 * time.Hour)
+	recent := addItem(t, cc, l, alice, "recent")
+	reviewed := addItem(t, cc, l, alice, "recent and reviewed")
+	expired := addItem(t, cc, l, alice, "expired")
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddReviewData(ctx, reviewed, 4, 4, 4, 4, "")
+	})
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.SetCTIItemExpiry(ctx, expired, 24*60*60+60*24*60*60+60)
+	})
+	l.Advance(time.Hour)
+
+	var ranked []*RankedCTIItem
+	invoke(t, l, carol, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		ranked, err = cc.GetCTIItemsByFreshness(ctx, 10)
+		return err
+	})
+	var ids []string
+	for _, item := range ranked {
+		ids = append(ids, item.Item.ID)
+	}
+	if got, want := strings.Join(ids, ","), strings.Join([]string{reviewed, recent, stale}, ","); got != want {
+		t.Errorf("items by freshness are %s, want %s", got, want)
+	}
+
+	l.Invoke(carol, func(ctx contractapi.TransactionContextInterface) error {
+		if _, err := cc.GetCTIItemsByFreshness(ctx, 0); err == nil {
+			t.Error("a limit of 0 was accepted")
+		}
+		return nil
+	})
+}
+
+func TestExpireStaleCTIItemsArchivesInPages(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	var ids []string
+	for i := 0; i < 4; i++ {
+		ids = append(ids, addItem(t, cc, l, alice, fmt.Sprintf("item %d", i)))
+	}
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := cc.AddTagsToCTIItems(ctx, ids, []string{"phishing"})
+		return err
+	})
+	// Every item but the last expires within the hour
+	for _, id := range ids[:3] {
+		invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.SetCTIItemExpiry(ctx, id, 24*60*60+60)
+		})
+	}
+	l.Advance(time.Hour)
+
+	if err := l.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := cc.ExpireStaleCTIItems(ctx, 10, "")
+		return err
+	}); err == nil {
+		t.Error("a caller without the admin or oracle role expired items")
+	}
+
+	var expired []string
+	bookmark := ""
+	for page := 0; page == 0 || bookmark != ""; page++ {
+		event := invokeForEvent(t, l, oracle, func(ctx contractapi.TransactionContextInterface) error {
+			result, err := cc.ExpireStaleCTIItems(ctx, 2, bookmark)
+			if err != nil {
+				return err
+			}
+			bookmark = result.Bookmark
+			return nil
+		})
+		if event != nil && event.Name == "CTIItemsExpired" {
+			var payload CTIItemsExpiredEvent
+			if err := json.Unmarshal(event.Payload, &payload); err != nil {
+				t.Fatal(err)
+			}
+			expired = append(expired, payload.IDs...)
+		}
+	}
+	sort.Strings(expired)
+	if got, want := strings.Join(expired, ","), strings.Join(ids[:3], ","); got != want {
+		t.Errorf("expired items are %s, want %s", got, want)
+	}
+
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		facets, err := cc.GetTagFacets(ctx)
+		if err == nil && facets["phishing"] != 1 {
+			t.Errorf("tag facets after archiving are %v, want one phishing item", facets)
+		}
+		return err
+	})
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		usage, err := cc.GetOrgQuotaUsage(ctx, "Org1MSP")
+		if err == nil && usage.Used != 1 {
+			t.Errorf("org quota usage after archiving three items is %d, want 1", usage.Used)
+		}
+		return err
+	})
+}
+
+func TestInvalidatedReviewsHiddenByDefault(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "phishing kit")
+	for _, reviewer := range []*ctitest.Identity{bob, carol} {
+		invoke(t, l, reviewer, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.AddReviewData(ctx, id, 4, 4, 4, 4, "")
+		})
+	}
+	invalidated, kept := reviewIDOf(t, l, "bob", id), reviewIDOf(t, l, "carol", id)
+	var review ReviewData
+	if err := json.Unmarshal(l.Get(reviewKey(invalidated)), &review); err != nil {
+		t.Fatal(err)
+	}
+	review.Invalidated = true
+	reviewJSON, err := json.Marshal(review)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Put(reviewKey(invalidated), reviewJSON)
+
+	reviewIDs := func(reviews []*ReviewData) string {
+		var ids []string
+		for _, review := range reviews {
+			ids = append(ids, review.ID)
+		}
+		return strings.Join(ids, ",")
+	}
+	invoke(t, l, dave, func(ctx contractapi.TransactionContextInterface) error {
+		all, err := cc.GetAllReviewData(ctx)
+		if err != nil {
+			return err
+		}
+		byItem, err := cc.GetReviewDataByCTIDataID(ctx, id)
+		if err != nil {
+			return err
+		}
+		if reviewIDs(all) != kept || reviewIDs(byItem) != kept {
+			t.Errorf("default getters returned %s and %s, want only %s", reviewIDs(all), reviewIDs(byItem), kept)
+		}
+		if _, err := cc.GetAllReviewDataIncludingInvalidated(ctx); err == nil {
+			t.Error("a caller without the moderator role read invalidated reviews")
+		}
+		return nil
+	})
+
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		all, err := cc.GetAllReviewDataIncludingInvalidated(ctx)
+		if err == nil && reviewIDs(all) != invalidated+","+kept {
+			t.Errorf("inclusive getter returned %s", reviewIDs(all))
+		}
+		return err
+	})
+}
+
+// recordingLogger collects operation log lines by level
+type recordingLogger struct {
+	debug []string
+	info  []string
+}
+
+func (r *recordingLogger) Debugf(format string, args ...interface{}) {
+	r.debug = append(r.debug, fmt.Sprintf(format, args...))
+}
+
+func (r *recordingLogger) Infof(format string, args ...interface{}) {
+	r.info = append(r.info, fmt.Sprintf(format, args...))
+}
+
+func TestOperationsLogStartAndOutcome(t *testing.T) {
+	logs := &recordingLogger{}
+	cc := &SmartContract{Logger: logs}
+	l := ctitest.NewLedger()
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := cc.AddCTIItem(ctx, "botnet c2 list", 1, testCID, "secret-key-material", 1, 1, "low", "", nil, "", "GREEN", nil, 50)
+		return err
+	})
+	err := l.Invoke(bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.DeleteCTIItemByID(ctx, "missing")
+	})
+	if err == nil {
+		t.Fatal("deleting a missing item succeeded")
+	}
+
+	if len(logs.debug) != 2 || !strings.Contains(logs.debug[0], "op=AddCTIItem") || !strings.Contains(logs.debug[0], "outcome=started") {
+		t.Errorf("debug lines are %q", logs.debug)
+	}
+	if len(logs.info) != 2 || !strings.Contains(logs.info[0], "outcome=ok") || !strings.Contains(logs.info[0], `msp="Org1MSP"`) ||
+		!strings.Contains(logs.info[1], "op=DeleteCTIItemByID") || !strings.Contains(logs.info[1], "outcome=error") {
+		t.Errorf("info lines are %q", logs.info)
+	}
+	for _, line := range append(logs.debug, logs.info...) {
+		if strings.Contains(line, "secret-key-material") || strings.Contains(line, "botnet") {
+			t.Errorf("log line leaks arguments: %s", line)
+		}
+	}
+}
+
+func TestGetCTIItemsByRegion(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	ids := map[string]string{}
+	for _, item := range []struct{ name, region string }{{"ua wiper", "ua"}, {"ua phishing", "UA"}, {"de botnet", "DE"}, {"untagged", ""}} {
+		id := addItem(t, cc, l, alice, item.name)
+		ids[item.name] = id
+		if item.region != "" {
+			invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+				return cc.SetCTIItemRegion(ctx, id, item.region)
+			})
+		}
+	}
+
+	err := l.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.SetCTIItemRegion(ctx, ids["untagged"], "XX")
+	})
+	if err == nil {
+		t.Error("an unknown region code was accepted")
+	}
+	err = l.Invoke(bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.SetCTIItemRegion(ctx, ids["untagged"], "FR")
+	})
+	if err == nil {
+		t.Error("a caller other than the uploader set the region")
+	}
+
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		for region, want := range map[string]string{"UA": ids["ua wiper"] + "," + ids["ua phishing"], "de": ids["de botnet"], "FR": ""} {
+			items, err := cc.GetCTIItemsByRegion(ctx, region)
+			if err != nil {
+				return err
+			}
+			if items == nil {
+				t.Errorf("region %s returned a nil slice", region)
+			}
+			var got []string
+			for _, item := range items {
+				got = append(got, item.ID)
+			}
+			sort.Strings(got)
+			wantIDs := strings.Split(want, ",")
+			sort.Strings(wantIDs)
+			if strings.Join(got, ",") != strings.Trim(strings.Join(wantIDs, ","), ",") {
+				t.Errorf("region %s returned %v, want %s", region, got, want)
+			}
+		}
+		if _, err := cc.GetCTIItemsByRegion(ctx, "Narnia"); err == nil {
+			t.Error("an invalid region query succeeded")
+		}
+		return nil
+	})
+}
+
+func TestGetSimilarCTIItemsRanksSharedTagsFirst(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	tagged := func(name string, tags ...string) string {
+		id := addItem(t, cc, l, alice, name)
+		if len(tags) > 0 {
+			invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+				_, err := cc.AddTagsToCTIItems(ctx, []string{id}, tags)
+				return err
+			})
+		}
+		return id
+	}
+	target := tagged("emotet loader", "emotet", "loader", "banking")
+	near := tagged("emotet dropper", "emotet", "loader", "banking")
+	loose := tagged("banking trojan", "banking")
+	archived := tagged("old emotet wave", "emotet", "loader", "banking")
+	tagged("unrelated", "ransomware")
+
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.SetCTIItemExpiry(ctx, archived, 24*60*60+60)
+	})
+	l.Advance(time.Hour)
+	invoke(t, l, oracle, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := cc.ExpireStaleCTIItems(ctx, 10, "")
+		return err
+	})
+
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		ranked, err := cc.GetSimilarCTIItems(ctx, target, 10)
+		if err != nil {
+			return err
+		}
+		var got []string
+		for _, item := range ranked {
+			got = append(got, fmt.Sprintf("%s:%g", item.Item.ID, item.Score))
+		}
+		if want := []string{near + ":3", loose + ":1"}; strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("similar items are %v, want %v", got, want)
+		}
+
+		top, err := cc.GetSimilarCTIItems(ctx, target, 1)
+		if err == nil && (len(top) != 1 || top[0].Item.ID != near) {
+			t.Errorf("limit 1 returned %d items", len(top))
+		}
+		if _, err := cc.GetSimilarCTIItems(ctx, target, maxRankedItems+1); err == nil {
+			t.Error("a limit above the cap was accepted")
+		}
+		return err
+	})
+}
+
+func TestGetMyReviewSummary(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	good := addItem(t, cc, l, alice, "phishing kit")
+	poor := addItem(t, cc, l, alice, "stale domains")
+	addItem(t, cc, l, alice, "unreviewed")
+	other := addItem(t, cc, l, bob, "bob's item")
+	for _, review := range []struct {
+		reviewer *ctitest.Identity
+		id       string
+		score    int
+	}{{bob, good, 5}, {carol, good, 4}, {bob, poor, 2}, {carol, other, 1}} {
+		invoke(t, l, review.reviewer, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.AddReviewData(ctx, review.id, review.score, review.score, review.score, review.score, "")
+		})
+	}
+
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		summary, err := cc.GetMyReviewSummary(ctx)
+		if err != nil {
+			return err
+		}
+		if summary.ItemCount != 3 || summary.ReviewsReceived != 3 {
+			t.Errorf("summary counts %d items and %d reviews, want 3 and 3", summary.ItemCount, summary.ReviewsReceived)
+		}
+		if summary.AverageComposite != 11.0/3 || summary.DimensionAverages["Accuracy"] != 11.0/3 {
+			t.Errorf("summary averages are %g and %v", summary.AverageComposite, summary.DimensionAverages)
+		}
+		if summary.BestItemID != good || summary.BestItemScore != 4.5 || summary.WorstItemID != poor || summary.WorstItemScore != 2 {
+			t.Errorf("best and worst items are %s (%g) and %s (%g)", summary.BestItemID, summary.BestItemScore, summary.WorstItemID, summary.WorstItemScore)
+		}
+		return nil
+	})
+
+	invoke(t, l, dave, func(ctx contractapi.TransactionContextInterface) error {
+		summary, err := cc.GetMyReviewSummary(ctx)
+		if err == nil && (summary.ReviewsReceived != 0 || summary.BestItemID != "" || summary.DimensionAverages == nil) {
+			t.Errorf("summary for a contributor without reviews is %+v", summary)
+		}
+		return err
+	})
+}
+
+func TestCTIChangeLogAccumulates(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "phishing kit")
+	target := addItem(t, cc, l, alice, "phishing kit v0")
+	steps := []func(ctx contractapi.TransactionContextInterface) error{
+		func(ctx contractapi.TransactionContextInterface) error {
+			return cc.UpdateCTIItem(ctx, id, "phishing kit", 1, testCID, "rotated key", 2, "GREEN", nil, 50)
+		},
+		func(ctx contractapi.TransactionContextInterface) error {
+			_, err := cc.AddTagsToCTIItems(ctx, []string{id}, []string{"phishing"})
+			return err
+		},
+		func(ctx contractapi.TransactionContextInterface) error {
+			return cc.LinkCTIItems(ctx, id, target, "supersedes")
+		},
+		func(ctx contractapi.TransactionContextInterface) error {
+			return cc.SetCTIItemExpiry(ctx, id, 24*60*60+60)
+		},
+	}
+	for _, step := range steps {
+		l.Advance(time.Minute)
+		invoke(t, l, alice, step)
+	}
+	l.Advance(time.Hour)
+	invoke(t, l, oracle, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := cc.ExpireStaleCTIItems(ctx, 10, "")
+		return err
+	})
+
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		entries, err := cc.GetCTIChangeLog(ctx, id)
+		if err != nil {
+			return err
+		}
+		var actions []string
+		for _, entry := range entries {
+			actions = append(actions, entry.Action)
+		}
+		if got, want := strings.Join(actions, ","), "created,updated,tagged,linked,expiry-set,archived"; got != want {
+			t.Errorf("changelog actions are %s, want %s", got, want)
+		}
+		if len(entries) < 2 {
+			return nil
+		}
+		update := entries[1]
+		if update.Actor != "alice" || !strings.Contains(update.Summary, "encryption key rotated") || !strings.Contains(update.Summary, "level changed from 1 to 2") {
+			t.Errorf("update entry is %+v", update)
+		}
+		if strings.Contains(update.Summary, "rotated key") || strings.Contains(update.Summary, testCID) {
+			t.Errorf("update entry reveals the key or CID: %s", update.Summary)
+		}
+		if entries[0].Timestamp >= update.Timestamp {
+			t.Errorf("entries are not in time order: %d then %d", entries[0].Timestamp, update.Timestamp)
+		}
+		return nil
+	})
+}
+
+func TestGetActivitySinceLastAck(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "phishing kit")
+	for _, user := range []*ctitest.Identity{alice, bob} {
+		invoke(t, l, user, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.AckActivity(ctx)
+		})
+	}
+
+	l.Advance(time.Hour)
+	addItem(t, cc, l, carol, "botnet c2 list")
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddReviewData(ctx, id, 4, 4, 4, 4, "")
+	})
+
+	activity := func(user *ctitest.Identity) *ActivitySummary {
+		var summary *ActivitySummary
+		invoke(t, l, user, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			summary, err = cc.GetActivitySince(ctx)
+			return err
+		})
+		return summary
+	}
+	if got := activity(alice); got.NewCTIItems != 1 || got.NewReviewsOnItems != 1 || got.PointsChange != UploaderReward {
+		t.Errorf("alice's activity is %+v, want one new item, one new review and the uploader reward", got)
+	}
+	if got := activity(bob); got.NewCTIItems != 1 || got.NewReviewsOnItems != 0 || got.PointsChange != defaultReviewerReward {
+		t.Errorf("bob's activity is %+v, want one new item and the reviewer reward", got)
+	}
+
+	l.Advance(time.Hour)
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AckActivity(ctx)
+	})
+	if got := activity(bob); got.NewCTIItems != 0 || got.PointsChange != 0 {
+		t.Errorf("bob's activity after acknowledging is %+v, want nothing new", got)
+	}
+	if got := activity(dave); got.NewCTIItems != 2 {
+		t.Errorf("a user who never acknowledged sees %d new items, want 2", got.NewCTIItems)
+	}
+}
+
+func TestVerifyContentHashesBatchFlagsMismatches(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	hashOf := func(content string) string {
+		sum := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(sum[:])
+	}
+	intact := addItem(t, cc, l, alice, "intact")
+	tampered := addItem(t, cc, l, alice, "tampered")
+	unhashed := addItem(t, cc, l, alice, "unhashed")
+	for id, content := range map[string]string{intact: "intact content", tampered: "original content"} {
+		invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.SetCTIContentHash(ctx, id, hashOf(content))
+		})
+	}
+
+	report := fmt.Sprintf(`[{"id": %q, "computedHash": %q}, {"id": %q, "computedHash": %q}, {"id": %q, "computedHash": %q}]`,
+		intact, hashOf("intact content"), tampered, hashOf("altered content"), unhashed, hashOf("anything"))
+	verify := func(identity *ctitest.Identity) ([]string, error) {
+		var mismatched []string
+		err := l.Invoke(identity, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			mismatched, err = cc.VerifyContentHashesBatch(ctx, report)
+			return err
+		})
+		return mismatched, err
+	}
+	if _, err := verify(alice); err == nil {
+		t.Error("a caller without the oracle role verified content hashes")
+	}
+	mismatched, err := verify(oracle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mismatched) != 1 || mismatched[0] != tampered {
+		t.Errorf("mismatched items are %v, want only %s", mismatched, tampered)
+	}
+	for id, want := range map[string]bool{intact: false, tampered: true, unhashed: false} {
+		if got := ctiItemOf(t, l, id).TamperSuspected; got != want {
+			t.Errorf("item %s TamperSuspected is %v, want %v", id, got, want)
+		}
+	}
+}
+
+func TestContentHashChangeFlagsReviewedItemForReReview(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "phishing kit")
+	setHash := func(hash string) {
+		invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.SetCTIContentHash(ctx, id, hash)
+		})
+	}
+	setHash(strings.Repeat("a", 64))
+	if ctiItemOf(t, l, id).NeedsReReview {
+		t.Error("hashing an unreviewed item flagged it for re-review")
+	}
+
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddReviewData(ctx, id, 4, 4, 4, 4, "")
+	})
+	setHash(strings.Repeat("a", 64))
+	if ctiItemOf(t, l, id).NeedsReReview {
+		t.Error("setting the same hash again flagged the item for re-review")
+	}
+	setHash(strings.Repeat("b", 64))
+	if !ctiItemOf(t, l, id).NeedsReReview {
+		t.Error("changing the hash of a reviewed item did not flag it for re-review")
+	}
+
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		entries, err := cc.GetCTIChangeLog(ctx, id)
+		if err != nil {
+			return err
+		}
+		var hashEntries []string
+		for _, entry := range entries {
+			if entry.Action == "content-hash-set" {
+				hashEntries = append(hashEntries, entry.Summary)
+			}
+		}
+		if len(hashEntries) != 2 || !strings.HasSuffix(hashEntries[1], strings.Repeat("b", 64)) {
+			t.Errorf("content hash changelog entries are %v", hashEntries)
+		}
+		return nil
+	})
+}
+
+func TestGetTrendingCTIItemsRanksRecentAccesses(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	classic := addItem(t, cc, l, alice, "all-time favourite")
+	fresh := addItem(t, cc, l, alice, "new campaign")
+	steady := addItem(t, cc, l, alice, "steady seller")
+	archived := addItem(t, cc, l, alice, "expiring item")
+	access := func(id string, times int) {
+		for i := 0; i < times; i++ {
+			invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+				return cc.RecordCTIAccess(ctx, id)
+			})
+		}
+	}
+	if err := l.Invoke(bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.RecordCTIAccess(ctx, fresh)
+	}); err == nil {
+		t.Error("a caller not entitled to the item recorded an access")
+	}
+
+	access(classic, 5)
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.SetCTIItemExpiry(ctx, archived, 3*24*60*60+60)
+	})
+	l.Advance(2 * 24 * time.Hour)
+	access(fresh, 3)
+	access(steady, 1)
+	access(archived, 4)
+	l.Advance(2 * time.Hour)
+	invoke(t, l, oracle, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := cc.ExpireStaleCTIItems(ctx, 10, "")
+		return err
+	})
+
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		ranked, err := cc.GetTrendingCTIItems(ctx, 24*60*60, 10)
+		if err != nil {
+			return err
+		}
+		var got []string
+		for _, item := range ranked {
+			got = append(got, fmt.Sprintf("%s:%g", item.Item.ID, item.Score))
+		}
+		if want := []string{fresh + ":3", steady + ":1"}; strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("trending items are %v, want %v", got, want)
+		}
+
+		for _, bad := range [][2]int{{0, 10}, {maxTrendingWindow + 1, 10}, {60, 0}, {60, maxRankedItems + 1}} {
+			if _, err := cc.GetTrendingCTIItems(ctx, int64(bad[0]), bad[1]); err == nil {
+				t.Errorf("window %d and limit %d were accepted", bad[0], bad[1])
+			}
+		}
+		return nil
+	})
+}
+
+func TestAddCTIItemSeverityRequirements(t *testing.T) {
+	const indicators = `[{"Type": "domain", "Value": "evil.example"}]`
+	tags := []string{"phishing"}
+	for _, tc := range []struct {
+		severity    string
+		description string
+		tags        []string
+		indicators  string
+		wantErr     string
+	}{
+		{"", "", nil, "", ""},
+		{"low", "", nil, "", ""},
+		{"Medium", "", nil, "", ""},
+		{"high", "credential phishing kit", tags, indicators, ""},
+		{"high", "credential phishing kit", nil, indicators, "tags are required"},
+		{"high", "credential phishing kit", tags, "", "at least one indicator is required"},
+		{"high", " ", tags, indicators, "a description is required"},
+		{"critical", "credential phishing kit", tags, indicators, ""},
+		{"critical", "", tags, indicators, "a description is required"},
+		{"critical", "credential phishing kit", tags, `[{"Type": "domain"}]`, "must have a value"},
+		{"urgent", "", nil, "", "invalid severity"},
+	} {
+		cc := &SmartContract{}
+		l := ctitest.NewLedger()
+		var id string
+		err := l.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			id, err = cc.AddCTIItem(ctx, "item", 1, testCID, "key", 1, 1, tc.severity, tc.description, tc.tags, tc.indicators, "GREEN", nil, 50)
+			return err
+		})
+		switch {
+		case tc.wantErr == "" && err != nil:
+			t.Errorf("severity %q: unexpected error %v", tc.severity, err)
+		case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+			t.Errorf("severity %q: got error %v, want one containing %q", tc.severity, err, tc.wantErr)
+		case err == nil && ctiItemOf(t, l, id).Severity != strings.ToLower(tc.severity):
+			t.Errorf("severity %q was stored as %q", tc.severity, ctiItemOf(t, l, id).Severity)
+		}
+	}
+}
+
+func TestGetReviewParticipation(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	for user, subscribed := range map[*ctitest.Identity]int{bob: 2, carol: 3, dave: 1} {
+		setUserData(t, cc, l, user.ID, 0, 0, subscribed, 0)
+	}
+	var ids []string
+	for _, level := range []int{2, 5} {
+		invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+			id, err := cc.AddCTIItem(ctx, fmt.Sprintf("level %d", level), 1, testCID, "key", 1, level, "low", "", nil, "", "GREEN", nil, 50)
+			ids = append(ids, id)
+			return err
+		})
+	}
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddReviewData(ctx, ids[0], 4, 4, 4, 4, "")
+	})
+
+	invoke(t, l, erin, func(ctx contractapi.TransactionContextInterface) error {
+		participation, err := cc.GetReviewParticipation(ctx, ids[0])
+		if err != nil {
+			return err
+		}
+		if participation.ReviewCount != 1 || participation.Reviewers != 1 || participation.EntitledUsers != 2 || participation.Rate != 0.5 {
+			t.Errorf("participation in the level 2 item is %+v, want 1 of 2 entitled users", participation)
+		}
+
+		participation, err = cc.GetReviewParticipation(ctx, ids[1])
+		if err == nil && (participation.EntitledUsers != 0 || participation.Rate != 0) {
+			t.Errorf("participation in an item no one is entitled to is %+v", participation)
+		}
+		return err
+	})
+}
+
+func TestUpdateCTICIDSwapsCIDAndHashTogether(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "phishing kit")
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddReviewData(ctx, id, 4, 4, 4, 4, "")
+	})
+	const newCID = "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o"
+	newHash := strings.Repeat("c", 64)
+
+	for _, tc := range []struct {
+		caller *ctitest.Identity
+		cid    string
+		hash   string
+	}{{alice, newCID, ""}, {alice, "not-a-cid", newHash}, {bob, newCID, newHash}} {
+		if err := l.Invoke(tc.caller, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.UpdateCTICID(ctx, id, tc.cid, tc.hash)
+		}); err == nil {
+			t.Errorf("UpdateCTICID by %s with CID %q and hash %q succeeded", tc.caller.ID, tc.cid, tc.hash)
+		}
+	}
+	if ctiItem := ctiItemOf(t, l, id); ctiItem.CID != testCID || ctiItem.ContentHash != "" || ctiItem.NeedsReReview {
+		t.Errorf("rejected updates changed the item: %+v", ctiItem)
+	}
+
+	event := invokeForEvent(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.UpdateCTICID(ctx, id, newCID, strings.ToUpper(newHash))
+	})
+	ctiItem := ctiItemOf(t, l, id)
+	if ctiItem.CID != newCID || ctiItem.ContentHash != newHash || !ctiItem.NeedsReReview {
+		t.Errorf("after UpdateCTICID the item has CID %s, hash %s and NeedsReReview %v", ctiItem.CID, ctiItem.ContentHash, ctiItem.NeedsReReview)
+	}
+	if got := indexEntries(t, l, cidIndex, newCID); len(got) != 1 {
+		t.Errorf("new CID index entries are %v", got)
+	}
+	if got := indexEntries(t, l, cidIndex, testCID); len(got) != 0 {
+		t.Errorf("old CID index entries are %v", got)
+	}
+	var updated CTICIDUpdatedEvent
+	if event == nil || event.Name != "CTICIDUpdated" {
+		t.Fatalf("UpdateCTICID emitted %+v", event)
+	}
+	if err := json.Unmarshal(event.Payload, &updated); err != nil {
+		t.Fatal(err)
+	}
+	if updated.ID != id || updated.ContentHash != newHash || updated.UpdatedBy != "alice" {
+		t.Errorf("CTICIDUpdated event is %+v", updated)
+	}
+}
+
+func TestGetCTIItemsByReviewVelocityFavoursRecentBursts(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	established := addItem(t, cc, l, alice, "long-reviewed item")
+	bursting := addItem(t, cc, l, alice, "new campaign")
+	quiet := addItem(t, cc, l, alice, "quiet item")
+	review := func(id string, reviewers ...*ctitest.Identity) {
+		for _, reviewer := range reviewers {
+			invoke(t, l, reviewer, func(ctx contractapi.TransactionContextInterface) error {
+				return cc.AddReviewData(ctx, id, 4, 4, 4, 4, "")
+			})
+		}
+	}
+	review(established, bob, carol, dave, erin)
+	l.Advance(2 * 24 * time.Hour)
+	review(quiet, bob)
+	review(bursting, bob, carol, dave)
+
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		ranked, err := cc.GetCTIItemsByReviewVelocity(ctx, 24*60*60, 10)
+		if err != nil {
+			return err
+		}
+		var got []string
+		for _, item := range ranked {
+			got = append(got, fmt.Sprintf("%s:%g", item.Item.ID, item.Score))
+		}
+		if want := []string{bursting + ":3", quiet + ":1"}; strings.Join(got, ",") != strings.Join(want, ",") {
+			t.Errorf("items by review velocity are %v, want %v", got, want)
+		}
+
+		for _, bad := range [][2]int{{0, 10}, {maxTrendingWindow + 1, 10}, {60, 0}, {60, maxRankedItems + 1}} {
+			if _, err := cc.GetCTIItemsByReviewVelocity(ctx, int64(bad[0]), bad[1]); err == nil {
+				t.Errorf("window %d and limit %d were accepted", bad[0], bad[1])
+			}
+		}
+		return nil
+	})
+}
+
+func TestNotificationsDeliveredAndMarkedRead(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "phishing kit")
+	for _, reviewer := range []*ctitest.Identity{bob, carol} {
+		invoke(t, l, reviewer, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.AddReviewData(ctx, id, 4, 4, 4, 4, "")
+		})
+	}
+
+	inbox := func(identity *ctitest.Identity, unreadOnly bool) []*Notification {
+		var notifications []*Notification
+		invoke(t, l, identity, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			notifications, err = cc.GetMyNotifications(ctx, unreadOnly)
+			return err
+		})
+		return notifications
+	}
+	notifications := inbox(alice, false)
+	if len(notifications) != 2 {
+		t.Fatalf("alice has %d notifications, want 2", len(notifications))
+	}
+	for _, notification := range notifications {
+		if notification.Recipient != "alice" || notification.Type != "review-received" || notification.RefID != id || notification.Read {
+			t.Errorf("notification is %+v", notification)
+		}
+	}
+	if got := inbox(bob, false); len(got) != 0 {
+		t.Errorf("bob received %d notifications about alice's item", len(got))
+	}
+
+	if err := l.Invoke(bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.MarkNotificationRead(ctx, notifications[0].ID)
+	}); err == nil {
+		t.Error("bob marked one of alice's notifications read")
+	}
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.MarkNotificationRead(ctx, notifications[0].ID)
+	})
+	if all := inbox(alice, false); len(all) != 2 || !all[0].Read || all[1].Read {
+		t.Errorf("after marking the first read the inbox is %+v, %+v", all[0], all[1])
+	}
+	if unread := inbox(alice, true); len(unread) != 1 || unread[0].ID != notifications[1].ID {
+		t.Errorf("unread notifications are %+v, want only %s", unread, notifications[1].ID)
+	}
+}
+
+func TestNotificationInboxCapWithinOneTransaction(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	for round := 0; round < 2; round++ {
+		invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+			var notifications []*Notification
+			for i := 0; i < maxNotificationsPerUser+10; i++ {
+				notifications = append(notifications, &Notification{Recipient: "bob", Type: "test", RefID: fmt.Sprint(round, "-", i), Message: "message"})
+			}
+			return notifyUsers(ctx, notifications)
+		})
+
+		var notifications []*Notification
+		invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			notifications, err = cc.GetMyNotifications(ctx, false)
+			return err
+		})
+		if len(notifications) != maxNotificationsPerUser {
+			t.Fatalf("round %d: inbox holds %d notifications, want %d", round, len(notifications), maxNotificationsPerUser)
+		}
+		if last := notifications[len(notifications)-1].RefID; last != fmt.Sprint(round, "-", maxNotificationsPerUser+9) {
+			t.Errorf("round %d: newest notification is %s", round, last)
+		}
+	}
+}
+
+func TestExpiryNotificationsRespectInboxCap(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	var ids []string
+	for i := 0; i < maxNotificationsPerUser+5; i++ {
+		ids = append(ids, addItem(t, cc, l, alice, fmt.Sprintf("item %d", i)))
+	}
+	for _, id := range ids {
+		invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.SetCTIItemExpiry(ctx, id, 24*60*60+60)
+		})
+	}
+	l.Advance(time.Hour)
+	invoke(t, l, oracle, func(ctx contractapi.TransactionContextInterface) error {
+		result, err := cc.ExpireStaleCTIItems(ctx, int32(len(ids)), "")
+		if err == nil && result.Expired != len(ids) {
+			t.Errorf("expired %d items, want %d", result.Expired, len(ids))
+		}
+		return err
+	})
+
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		notifications, err := cc.GetMyNotifications(ctx, true)
+		if err == nil && len(notifications) != maxNotificationsPerUser {
+			t.Errorf("alice has %d notifications after one sweep, want %d", len(notifications), maxNotificationsPerUser)
+		}
+		return err
+	})
+}
+
+func TestExportForPartnerFiltersAndStripsItems(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	good := addItem(t, cc, l, alice, "well reviewed")
+	poor := addItem(t, cc, l, alice, "poorly reviewed")
+	own := addItem(t, cc, l, bob, "partner's own item")
+	var amber string
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		amber, err = cc.AddCTIItem(ctx, "not for redistribution", 1, testCID, "key", 1, 1, "low", "", nil, "", "AMBER", nil, 50)
+		return err
+	})
+	for id, score := range map[string]int{good: 5, poor: 1, own: 5, amber: 5} {
+		invoke(t, l, carol, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.AddReviewData(ctx, id, score, score, score, score, "")
+		})
+	}
+
+	export := func(identity *ctitest.Identity, partnerMSP string, minQuality float64, pageSize int32) (*CTIItemsPage, error) {
+		var page *CTIItemsPage
+		err := l.Invoke(identity, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			page, err = cc.ExportForPartner(ctx, partnerMSP, minQuality, pageSize, "")
+			return err
+		})
+		return page, err
+	}
+	if _, err := export(alice, "Org2MSP", 3, 10); err == nil {
+		t.Error("a caller without the admin role exported a partner feed")
+	}
+	for _, bad := range []struct {
+		partnerMSP string
+		minQuality float64
+		pageSize   int32
+	}{{"", 3, 10}, {"Org2MSP", -1, 10}, {"Org2MSP", 3, 0}} {
+		if _, err := export(admin, bad.partnerMSP, bad.minQuality, bad.pageSize); err == nil {
+			t.Errorf("partner %q, quality %g and page size %d were accepted", bad.partnerMSP, bad.minQuality, bad.pageSize)
+		}
+	}
+
+	page, err := export(admin, "Org2MSP", 3, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 1 || page.Items[0].ID != good {
+		t.Fatalf("partner feed holds %d items, want only %s", len(page.Items), good)
+	}
+	item := page.Items[0]
+	if item.CID != "" || item.EncryptKey != "" || item.Uploader != "" || item.Level != 0 || item.Name != "well reviewed" {
+		t.Errorf("partner item is not stripped: %+v", item)
+	}
+}
+
+func TestReconcileAllUserStatsRepairsDriftedCounters(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	first := addItem(t, cc, l, alice, "first")
+	addItem(t, cc, l, alice, "second")
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddReviewData(ctx, first, 4, 4, 4, 4, "")
+	})
+	want := userDataOf(t, l, bob.ID)
+
+	drifted := userDataOf(t, l, alice.ID)
+	drifted.UploadCount = 7
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return putUserData(ctx, drifted)
+	})
+	drifted = userDataOf(t, l, bob.ID)
+	drifted.ReviewsAuthored = 0
+	drifted.ReviewPoints = 0
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return putUserData(ctx, drifted)
+	})
+
+	reconcile := func(identity *ctitest.Identity, bookmark string) (*ReconcileResult, error) {
+		var result *ReconcileResult
+		err := l.Invoke(identity, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			result, err = cc.ReconcileAllUserStats(ctx, 1, bookmark)
+			return err
+		})
+		return result, err
+	}
+	if _, err := reconcile(alice, ""); err == nil {
+		t.Error("a caller without the admin role reconciled user stats")
+	}
+
+	corrected := 0
+	bookmark := ""
+	for pages := 0; ; pages++ {
+		if pages > 10 {
+			t.Fatal("reconciliation never finished")
+		}
+		result, err := reconcile(admin, bookmark)
+		if err != nil {
+			t.Fatal(err)
+		}
+		corrected += result.Corrected
+		if bookmark = result.Bookmark; bookmark == "" {
+			break
+		}
+	}
+	if corrected != 2 {
+		t.Errorf("corrected %d users, want 2", corrected)
+	}
+	if got := userDataOf(t, l, alice.ID).UploadCount; got != 2 {
+		t.Errorf("alice's upload count is %d, want 2", got)
+	}
+	if got := userDataOf(t, l, bob.ID); got.ReviewsAuthored != 1 || got.ReviewPoints != want.ReviewPoints {
+		t.Errorf("bob authored %d reviews for %d points, want 1 for %d", got.ReviewsAuthored, got.ReviewPoints, want.ReviewPoints)
+	}
+}
+
+func TestGetCTIItemConditionalHonoursETag(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "conditional")
+
+	get := func(identity *ctitest.Identity, knownETag string) *ConditionalCTIItem {
+		var result *ConditionalCTIItem
+		invoke(t, l, identity, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			result, err = cc.GetCTIItemConditional(ctx, id, knownETag)
+			return err
+		})
+		return result
+	}
+
+	redacted := get(bob, "")
+	if redacted.NotModified || redacted.Item == nil || redacted.Item.CID != "" {
+		t.Fatalf("unentitled caller got %+v, want the redacted item", redacted)
+	}
+	if cached := get(bob, redacted.ETag); !cached.NotModified || cached.Item != nil {
+		t.Errorf("matching ETag returned %+v, want not modified without a body", cached)
+	}
+	if full := get(alice, redacted.ETag); full.NotModified || full.Item.CID != testCID {
+		t.Errorf("uploader got NotModified=%v and CID %q for the redacted ETag", full.NotModified, full.Item.CID)
+	}
+
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.SetCTIItemRegion(ctx, id, "DE")
+	})
+	changed := get(bob, redacted.ETag)
+	if changed.NotModified || changed.Item == nil || changed.Item.Region != "DE" {
+		t.Errorf("changed item returned %+v, want the updated item", changed)
+	}
+}
+
+func TestGetReviewerImpactComparesEarlyReviewsWithConsensus(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	frank := &ctitest.Identity{ID: "frank", MSPID: "Org1MSP"}
+	review := func(identity *ctitest.Identity, id string, score int) {
+		l.Advance(time.Minute)
+		invoke(t, l, identity, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.AddReviewData(ctx, id, score, score, score, score, "")
+		})
+	}
+
+	// bob calls the first item's consensus early, misses the second's and reviews the third too late
+	matched := addItem(t, cc, l, alice, "matched")
+	review(bob, matched, 4)
+	for _, reviewer := range []*ctitest.Identity{carol, dave, erin, frank} {
+		review(reviewer, matched, 4)
+	}
+	missed := addItem(t, cc, l, alice, "missed")
+	review(carol, missed, 5)
+	review(bob, missed, 1)
+	for _, reviewer := range []*ctitest.Identity{dave, erin, frank} {
+		review(reviewer, missed, 5)
+	}
+	late := addItem(t, cc, l, alice, "late")
+	for _, reviewer := range []*ctitest.Identity{carol, dave, erin, frank} {
+		review(reviewer, late, 3)
+	}
+	review(bob, late, 3)
+
+	var impact *ReviewerImpact
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		impact, err = cc.GetReviewerImpact(ctx, "bob")
+		return err
+	})
+	if impact.Samples != 2 || impact.Matches != 1 || impact.ImpactScore != 0.5 {
+		t.Errorf("bob has %d samples, %d matches and impact %g, want 2, 1 and 0.5", impact.Samples, impact.Matches, impact.ImpactScore)
+	}
+}
+
+func TestCTIItemsOrderedAcrossDigitBoundaries(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	for _, id := range []string{"1000000", "9", "999999", "10", "100", "99"} {
+		putLegacyItem(t, l, ctiKey(id), id)
+	}
+
+	var ids []string
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		ctiItems, err := cc.GetAllCTIItems(ctx)
+		for _, ctiItem := range ctiItems {
+			ids = append(ids, ctiItem.ID)
+		}
+		return err
+	})
+	if got, want := strings.Join(ids, ","), "9,10,99,100,999999,1000000"; got != want {
+		t.Errorf("GetAllCTIItems returned %s, want %s", got, want)
+	}
+
+	// Non-canonical numerals do not alias the item with the same value
+	if ctiItem := ctiItemOf(t, l, "009"); ctiItem != nil {
+		t.Errorf("ID 009 resolved to item %s", ctiItem.ID)
+	}
+}
+
+func TestMigrateCTIKeysPadsNumericKeys(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	putLegacyItem(t, l, "CTI_42", "42")
+	putLegacyItem(t, l, "CTI_7", "7")
+
+	if err := l.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := cc.MigrateCTIKeys(ctx, 1, "")
+		return err
+	}); err == nil {
+		t.Error("a caller without the admin role migrated CTI keys")
+	}
+
+	bookmark := ""
+	for {
+		invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			bookmark, err = cc.MigrateCTIKeys(ctx, 1, bookmark)
+			return err
+		})
+		if bookmark == "" {
+			break
+		}
+	}
+
+	for _, key := range []string{"CTI_42", "CTI_7"} {
+		if l.Get(key) != nil {
+			t.Errorf("un-padded key %s survived the migration", key)
+		}
+	}
+	for _, id := range []string{"42", "7"} {
+		if ctiItem := ctiItemOf(t, l, id); ctiItem == nil || ctiItem.Name != "legacy "+id {
+			t.Errorf("item %s is missing after the migration", id)
+		}
+	}
+}
+
+func TestGetCTIItemsPageHasNoGapsOrDuplicates(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	for i := 0; i < 250; i++ {
+		addItem(t, cc, l, alice, fmt.Sprintf("item %d", i))
+	}
+
+	seen := make(map[string]bool)
+	var fetched []int32
+	bookmark := ""
+	for {
+		var page *CTIItemsPage
+		invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			page, err = cc.GetCTIItemsPage(ctx, 100, bookmark)
+			return err
+		})
+		fetched = append(fetched, page.FetchedCount)
+		for _, ctiItem := range page.Items {
+			if seen[ctiItem.ID] {
+				t.Errorf("item %s returned twice", ctiItem.ID)
+			}
+			seen[ctiItem.ID] = true
+		}
+		bookmark = page.Bookmark
+		if bookmark == "" {
+			break
+		}
+	}
+
+	if len(seen) != 250 {
+		t.Errorf("paged through %d items, want 250", len(seen))
+	}
+	if fmt.Sprint(fetched) != "[100 100 50]" {
+		t.Errorf("fetched counts %v, want [100 100 50]", fetched)
+	}
+}
+
+func TestGetCTIItemTakesTheAssignedID(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	for i := 0; i < 10; i++ {
+		addItem(t, cc, l, alice, fmt.Sprintf("filler %d", i))
+	}
+	id := addItem(t, cc, l, alice, "round trip")
+
+	var ctiItem *CTIData
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		ctiItem, err = cc.GetCTIItem(ctx, id)
+		return err
+	})
+	if ctiItem.ID != id || ctiItem.Name != "round trip" {
+		t.Errorf("GetCTIItem(%q) returned item %s named %q", id, ctiItem.ID, ctiItem.Name)
+	}
+
+	err := l.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := cc.GetCTIItem(ctx, "0"+id)
+		return err
+	})
+	if err == nil {
+		t.Errorf("GetCTIItem(\"0%s\") returned item %s", id, id)
+	}
+}
+
+func TestAddCTIItemReturnsDistinctIDs(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	first := addItem(t, cc, l, alice, "first")
+	second := addItem(t, cc, l, bob, "second")
+
+	if first == "" || first == second {
+		t.Fatalf("uploads were assigned IDs %q and %q", first, second)
+	}
+	for id, name := range map[string]string{first: "first", second: "second"} {
+		if ctiItem := ctiItemOf(t, l, id); ctiItem == nil || ctiItem.Name != name {
+			t.Errorf("ID %s does not resolve to the item just added", id)
+		}
+	}
+}
+
+func TestOnlyUploaderOrAdminUpdatesAndDeletes(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "owned")
+	update := func(identity *ctitest.Identity, name string) error {
+		return l.Invoke(identity, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.UpdateCTIItem(ctx, id, name, 1, testCID, "key", 1, "GREEN", nil, 50)
+		})
+	}
+	remove := func(identity *ctitest.Identity) error {
+		return l.Invoke(identity, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.DeleteCTIItemByID(ctx, id)
+		})
+	}
+
+	if err := update(bob, "hijacked"); err == nil {
+		t.Error("a caller other than the uploader updated the item")
+	}
+	if err := remove(bob); err == nil {
+		t.Error("a caller other than the uploader deleted the item")
+	}
+	if err := update(alice, "renamed"); err != nil {
+		t.Fatalf("the uploader could not update the item: %v", err)
+	}
+	if err := update(admin, "moderated"); err != nil {
+		t.Fatalf("an admin could not update the item: %v", err)
+	}
+	if ctiItem := ctiItemOf(t, l, id); ctiItem.Name != "moderated" || ctiItem.Uploader != "alice" {
+		t.Errorf("item is %q uploaded by %s, want \"moderated\" still uploaded by alice", ctiItem.Name, ctiItem.Uploader)
+	}
+
+	if err := remove(admin); err != nil {
+		t.Fatalf("an admin could not delete the item: %v", err)
+	}
+	if ctiItem := ctiItemOf(t, l, id); ctiItem != nil {
+		t.Error("the item survived its deletion")
+	}
+	own := addItem(t, cc, l, bob, "bob's own")
+	if err := remove(bob); err == nil {
+		t.Error("an uploader hard-deleted an item")
+	}
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.SoftDeleteCTIItem(ctx, own)
+	})
+}
+
+func TestConcurrentUploadsAndReviewsBothCommit(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	ids := make([]string, 2)
+	upload := func(i int) func(ctx contractapi.TransactionContextInterface) error {
+		return func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			ids[i], err = cc.AddCTIItem(ctx, fmt.Sprintf("item %d", i), 1, testCID, "key", 1, 1, "low", "", nil, "", "GREEN", nil, 50)
+			return err
+		}
+	}
+	if errs := l.Concurrent(ctitest.Call(alice, upload(0)), ctitest.Call(bob, upload(1))); errs[0] != nil || errs[1] != nil {
+		t.Fatalf("concurrent uploads failed with %v", errs)
+	}
+	if ids[0] == ids[1] || ctiItemOf(t, l, ids[0]) == nil || ctiItemOf(t, l, ids[1]) == nil {
+		t.Errorf("concurrent uploads were stored as %v", ids)
+	}
+
+	// Reviews of different items share no key, so they no longer conflict over a review counter
+	errs := l.Concurrent(
+		ctitest.Call(carol, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.AddReviewData(ctx, ids[0], 4, 4, 4, 4, "")
+		}),
+		ctitest.Call(dave, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.AddReviewData(ctx, ids[1], 2, 2, 2, 2, "")
+		}),
+	)
+	if errs[0] != nil || errs[1] != nil {
+		t.Fatalf("concurrent reviews of different items failed with %v", errs)
+	}
+	if reviewIDOf(t, l, "carol", ids[0]) == reviewIDOf(t, l, "dave", ids[1]) {
+		t.Error("concurrent reviews were given the same ID")
+	}
+}
+
+func TestCTIItemLifecycleEvents(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	var id string
+	event := invokeForEvent(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		id, err = cc.AddCTIItem(ctx, "phishing kit", 42, testCID, "key", 1, 2, "low", "", nil, "", "GREEN", nil, 50)
+		return err
+	})
+	want := CTIItemEvent{ID: id, Uploader: "alice", Level: 2, Timestamp: 42}
+	var added CTIItemEvent
+	if event == nil || event.Name != "CTIItemAdded" {
+		t.Fatalf("upload set event %+v, want CTIItemAdded", event)
+	}
+	if err := json.Unmarshal(event.Payload, &added); err != nil || added != want {
+		t.Errorf("CTIItemAdded payload is %+v, want %+v", added, want)
+	}
+
+	event = invokeForEvent(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.UpdateCTIItem(ctx, id, "phishing kit", 43, testCID, "key", 3, "GREEN", nil, 50)
+	})
+	var updated CTIItemUpdatedEvent
+	if event == nil || event.Name != "CTIItemUpdated" {
+		t.Fatalf("update set event %+v, want CTIItemUpdated", event)
+	}
+	want.Level, want.Timestamp = 3, 43
+	if err := json.Unmarshal(event.Payload, &updated); err != nil || updated.CTIItemEvent != want || updated.UpdatedBy != "alice" {
+		t.Errorf("CTIItemUpdated payload is %+v, want %+v updated by alice", updated, want)
+	}
+
+	event = invokeForEvent(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.DeleteCTIItemByID(ctx, id)
+	})
+	var deleted CTIItemEvent
+	if event == nil || event.Name != "CTIItemDeleted" {
+		t.Fatalf("delete set event %+v, want CTIItemDeleted", event)
+	}
+	if err := json.Unmarshal(event.Payload, &deleted); err != nil || deleted != want {
+		t.Errorf("CTIItemDeleted payload is %+v, want %+v", deleted, want)
+	}
+
+	// A failed delete sets no event
+	tx := l.NewTransaction(alice)
+	if err := cc.DeleteCTIItemByID(tx.Context(), id); err == nil {
+		t.Fatal("deleting a missing item succeeded")
+	}
+	if event := tx.Event(); event != nil {
+		t.Errorf("failed delete set event %s", event.Name)
+	}
+}
+
+func TestGetCTIItemHistoryCoversCreateUpdateDelete(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "phishing kit")
+	l.Advance(time.Minute)
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.UpdateCTIItem(ctx, id, "phishing kit v2", 1, testCID, "key", 1, "GREEN", nil, 50)
+	})
+	l.Advance(time.Minute)
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.DeleteCTIItemByID(ctx, id)
+	})
+
+	history := func(identity *ctitest.Identity, id string) []*CTIHistoryRecord {
+		var history []*CTIHistoryRecord
+		invoke(t, l, identity, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			history, err = cc.GetCTIItemHistory(ctx, id)
+			return err
+		})
+		return history
+	}
+
+	versions := history(alice, id)
+	if len(versions) != 3 {
+		t.Fatalf("history has %d versions, want 3", len(versions))
+	}
+	for i, want := range []struct {
+		name      string
+		changedBy string
+		isDelete  bool
+	}{{"phishing kit", "alice", false}, {"phishing kit v2", "admin", false}, {"", "admin", true}} {
+		version := versions[i]
+		if version.IsDelete != want.isDelete || version.ChangedBy != want.changedBy || version.TxID == "" {
+			t.Errorf("version %d is %+v, want delete %v by %s", i, version, want.isDelete, want.changedBy)
+		}
+		if !want.isDelete && (version.Value == nil || version.Value.Name != want.name || version.Value.CID != testCID) {
+			t.Errorf("version %d holds %+v, want %q with its CID", i, version.Value, want.name)
+		}
+	}
+	if versions[0].Timestamp >= versions[2].Timestamp {
+		t.Errorf("history is not oldest first: %d then %d", versions[0].Timestamp, versions[2].Timestamp)
+	}
+
+	// Callers not entitled to the item see its history without CIDs or keys
+	for _, version := range history(bob, id) {
+		if version.Value != nil && (version.Value.CID != "" || version.Value.EncryptKey != "") {
+			t.Errorf("unentitled caller saw CID %q and key %q", version.Value.CID, version.Value.EncryptKey)
+		}
+	}
+
+	if versions := history(alice, "missing"); versions == nil || len(versions) != 0 {
+		t.Errorf("history of a missing item is %v, want an empty slice", versions)
+	}
+}
+
+func TestBuildCTIQuerySelectors(t *testing.T) {
+	minLevel, maxLevel := 1, 3
+	from, to := int64(100), int64(200)
+	for _, tc := range []struct {
+		filter  CTIQueryFilter
+		want    string
+		wantErr bool
+	}{
+		{CTIQueryFilter{}, `{"selector":{"CID":{"$exists":true}}}`, false},
+		{CTIQueryFilter{Uploader: "alice"}, `{"selector":{"CID":{"$exists":true},"Uploader":"alice"}}`, false},
+		{CTIQueryFilter{MinLevel: &minLevel, MaxLevel: &maxLevel}, `{"selector":{"CID":{"$exists":true},"Level":{"$gte":1,"$lte":3}}}`, false},
+		{CTIQueryFilter{FromTimestamp: &from, ToTimestamp: &to}, `{"selector":{"CID":{"$exists":true},"Timestamp":{"$gte":100,"$lte":200}}}`, false},
+		{CTIQueryFilter{MinLevel: &maxLevel, MaxLevel: &minLevel}, "", true},
+		{CTIQueryFilter{FromTimestamp: &to, ToTimestamp: &from}, "", true},
+	} {
+		query, err := BuildCTIQuery(tc.filter)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("inverted filter %+v built %s", tc.filter, query)
+			}
+			continue
+		}
+		if err != nil || query != tc.want {
+			t.Errorf("filter %+v built %s, %v, want %s", tc.filter, query, err, tc.want)
+		}
+	}
+}
+
+// richQueryStub answers rich queries with fixed results, as a CouchDB peer would, and passes every
+// other call to the in-memory ledger
+type richQueryStub struct {
+	shim.ChaincodeStubInterface
+	results []*queryresult.KV
+}
+
+func (s *richQueryStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	return &kvIterator{results: s.results}, nil
+}
+
+// kvIterator iterates over fixed query results
+type kvIterator struct {
+	results []*queryresult.KV
+}
+
+func (i *kvIterator) HasNext() bool {
+	return len(i.results) > 0
+}
+
+func (i *kvIterator) Next() (*queryresult.KV, error) {
+	result := i.results[0]
+	i.results = i.results[1:]
+	return result, nil
+}
+
+func (i *kvIterator) Close() error {
+	return nil
+}
+
+func TestQueryCTIItemsReadsQueryResults(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	ctiItemJSON, err := json.Marshal(&CTIData{ID: "a", Name: "matched", Uploader: "alice", CID: testCID, Level: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := l.NewTransaction(alice)
+	ctx := &contractapi.TransactionContext{}
+	ctx.SetStub(&richQueryStub{ChaincodeStubInterface: tx.Context().GetStub(), results: []*queryresult.KV{
+		{Key: ctiKey("a"), Value: ctiItemJSON},
+		{Key: "UserData_alice", Value: []byte(`{"ID":"alice","CID":"not an item"}`)},
+	}})
+	ctx.SetClientIdentity(tx.Context().GetClientIdentity())
+
+	ctiItems, err := cc.QueryCTIItems(ctx, `{"selector":{"Uploader":"alice"}}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ctiItems) != 1 || ctiItems[0].Name != "matched" {
+		t.Errorf("query returned %+v, want only the matched CTI item", ctiItems)
+	}
+	if _, err := cc.QueryCTIItems(ctx, " "); err == nil {
+		t.Error("an empty query was run")
+	}
+
+	// The in-memory ledger behaves like LevelDB, which rejects rich queries
+	if err := l.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := cc.QueryCTIItems(ctx, `{"selector":{}}`)
+		return err
+	}); err == nil {
+		t.Error("a rich query succeeded without CouchDB")
+	}
+}
+
+func TestGetCTIItemsByUploaderFollowsUpdatesAndDeletes(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	kept := addItem(t, cc, l, alice, "kept")
+	deleted := addItem(t, cc, l, alice, "deleted")
+	addItem(t, cc, l, bob, "bob's")
+	byUploader := func(uploader string) string {
+		var ctiItems []*CTIData
+		invoke(t, l, carol, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			ctiItems, err = cc.GetCTIItemsByUploader(ctx, uploader)
+			return err
+		})
+		var names []string
+		for _, ctiItem := range ctiItems {
+			names = append(names, ctiItem.Name)
+		}
+		sort.Strings(names)
+		return strings.Join(names, ",")
+	}
+
+	if got := byUploader("alice"); got != "deleted,kept" {
+		t.Errorf("alice's items are %s, want deleted,kept", got)
+	}
+
+	// An admin's update leaves the item with its uploader, and a deleted item leaves the index
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.UpdateCTIItem(ctx, kept, "kept and updated", 1, testCID, "key", 1, "GREEN", nil, 50)
+	})
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.DeleteCTIItemByID(ctx, deleted)
+	})
+	if got := byUploader("alice"); got != "kept and updated" {
+		t.Errorf("after an update and a delete alice's items are %s, want kept and updated", got)
+	}
+	if got := byUploader("admin"); got != "" {
+		t.Errorf("the updating admin was indexed as the uploader of %s", got)
+	}
+	if got := len(indexEntries(t, l, uploaderIndex, "alice")); got != 1 {
+		t.Errorf("alice has %d uploader index entries, want 1", got)
+	}
+	if got := byUploader("bob"); got != "bob's" {
+		t.Errorf("bob's items are %s", got)
+	}
+
+	if err := l.Invoke(carol, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := cc.GetCTIItemsByUploader(ctx, "")
+		return err
+	}); err == nil {
+		t.Error("an empty uploader was accepted")
+	}
+}
+
+func TestValidateCID(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	for _, tc := range []struct {
+		cid   string
+		valid bool
+	}{
+		{testCID, true},
+		{"bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi", true},
+		{"", false},
+		{testCID[:30], false},
+		{"Qm" + strings.Repeat("0", 44), false},
+		{"not a cid", false},
+	} {
+		invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+			valid, err := cc.ValidateCID(ctx, tc.cid)
+			if valid != tc.valid || (err == nil) != tc.valid {
+				t.Errorf("ValidateCID(%q) = %v, %v, want valid %v", tc.cid, valid, err, tc.valid)
+			}
+			return nil
+		})
+
+		err := l.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+			_, err := cc.AddCTIItem(ctx, "item", 1, tc.cid, "key", 1, 1, "low", "", nil, "", "GREEN", nil, 50)
+			return err
+		})
+		if (err == nil) != tc.valid {
+			t.Errorf("uploading CID %q returned %v, want valid %v", tc.cid, err, tc.valid)
+		}
+	}
+
+	id := addItem(t, cc, l, alice, "phishing kit")
+	if err := l.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.UpdateCTIItem(ctx, id, "phishing kit", 1, "", "key", 1, "GREEN", nil, 50)
+	}); err == nil {
+		t.Error("an update cleared the CID")
+	}
+}
+
+func TestTLPMarkingsValidatedAndFiltered(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	upload := func(name, tlp string) (string, error) {
+		var id string
+		err := l.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			id, err = cc.AddCTIItem(ctx, name, 1, testCID, "key", 1, 1, "low", "", nil, "", tlp, nil, 50)
+			return err
+		})
+		return id, err
+	}
+	for _, tlp := range []string{"", "PURPLE", "TLP:RED"} {
+		if _, err := upload("invalid", tlp); err == nil {
+			t.Errorf("TLP marking %q was accepted", tlp)
+		}
+	}
+	red, err := upload("red", "red")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := upload("green", "GREEN"); err != nil {
+		t.Fatal(err)
+	}
+	if tlp := ctiItemOf(t, l, red).TLP; tlp != "RED" {
+		t.Errorf("TLP marking \"red\" was stored as %q", tlp)
+	}
+
+	// Items recorded before TLP markings existed read back unmarked
+	l.Put(ctiKey("legacy"), []byte(`{"ID":"legacy","Name":"legacy","Uploader":"alice","CID":"`+testCID+`","Level":1}`))
+	if ctiItem := ctiItemOf(t, l, "legacy"); ctiItem == nil || ctiItem.TLP != "" {
+		t.Errorf("legacy item read back as %+v", ctiItem)
+	}
+
+	byTLP := func(tlp string) []string {
+		var names []string
+		invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+			ctiItems, err := cc.GetCTIItemsByTLP(ctx, tlp)
+			for _, ctiItem := range ctiItems {
+				names = append(names, ctiItem.Name)
+			}
+			return err
+		})
+		return names
+	}
+	if names := byTLP("Red"); len(names) != 1 || names[0] != "red" {
+		t.Errorf("RED items are %v, want only red", names)
+	}
+
+	// Re-marking an item moves it between filters
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.UpdateCTIItem(ctx, red, "red", 1, testCID, "key", 1, "amber", nil, 50)
+	})
+	if names := byTLP("RED"); len(names) != 0 {
+		t.Errorf("re-marked item still filtered as RED: %v", names)
+	}
+	if names := byTLP("AMBER"); len(names) != 1 || names[0] != "red" {
+		t.Errorf("AMBER items are %v, want only red", names)
+	}
+	if err := l.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.UpdateCTIItem(ctx, red, "red", 1, testCID, "key", 1, "PURPLE", nil, 50)
+	}); err == nil {
+		t.Error("an update to an invalid TLP marking was accepted")
+	}
+	if err := l.Invoke(bob, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := cc.GetCTIItemsByTLP(ctx, "PURPLE")
+		return err
+	}); err == nil {
+		t.Error("filtering by an invalid TLP marking was accepted")
+	}
+}
+
+func TestTechniquesValidatedAndQueriedByParent(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	upload := func(name string, techniques ...string) (string, error) {
+		var id string
+		err := l.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			id, err = cc.AddCTIItem(ctx, name, 1, testCID, "key", 1, 1, "low", "", nil, "", "GREEN", techniques, 50)
+			return err
+		})
+		return id, err
+	}
+	for _, technique := range []string{"T10", "T1059.1", "X1059", "T1059."} {
+		if _, err := upload("invalid", technique); err == nil {
+			t.Errorf("technique %q was accepted", technique)
+		}
+	}
+	parent, err := upload("parent", "t1059", "T1059 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := upload("sub", "T1059.001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := upload("other", "T1566"); err != nil {
+		t.Fatal(err)
+	}
+	if techniques := ctiItemOf(t, l, parent).Techniques; len(techniques) != 1 || techniques[0] != "T1059" {
+		t.Errorf("techniques were stored as %v, want [T1059]", techniques)
+	}
+
+	byTechnique := func(technique string) []string {
+		var names []string
+		invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+			ctiItems, err := cc.GetCTIItemsByTechnique(ctx, technique)
+			for _, ctiItem := range ctiItems {
+				names = append(names, ctiItem.Name)
+			}
+			return err
+		})
+		sort.Strings(names)
+		return names
+	}
+	if names := byTechnique("T1059"); len(names) != 2 || names[0] != "parent" || names[1] != "sub" {
+		t.Errorf("T1059 items are %v, want [parent sub]", names)
+	}
+	if names := byTechnique("t1059.001"); len(names) != 1 || names[0] != "sub" {
+		t.Errorf("T1059.001 items are %v, want [sub]", names)
+	}
+
+	// Retagging an item moves it out of its old technique's index
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.UpdateCTIItem(ctx, sub, "sub", 1, testCID, "key", 1, "GREEN", []string{"T1566.002"}, 50)
+	})
+	if names := byTechnique("T1059"); len(names) != 1 || names[0] != "parent" {
+		t.Errorf("T1059 items after retagging are %v, want [parent]", names)
+	}
+	if names := byTechnique("T1566"); len(names) != 2 {
+		t.Errorf("T1566 items after retagging are %v, want [other sub]", names)
+	}
+}
+
+func TestIndicatorsTypeCheckedAndIndexedByValue(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	upload := func(name, indicatorsJSON string) (string, error) {
+		var id string
+		err := l.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			id, err = cc.AddCTIItem(ctx, name, 1, testCID, "key", 1, 1, "low", "", nil, indicatorsJSON, "GREEN", nil, 50)
+			return err
+		})
+		return id, err
+	}
+	for _, indicators := range []string{
+		`[{"Type": "ipv4", "Value": "300.1.2.3"}]`,
+		`[{"Type": "ipv4", "Value": "::1"}]`,
+		`[{"Type": "domain", "Value": "not a domain"}]`,
+		`[{"Type": "md5", "Value": "abc123"}]`,
+		`[{"Type": "sha256", "Value": "d41d8cd98f00b204e9800998ecf8427e"}]`,
+		`[{"Type": "url", "Value": "evil.example/path"}]`,
+		`[{"Type": "email", "Value": "a@evil.example"}]`,
+	} {
+		if _, err := upload("invalid", indicators); err == nil {
+			t.Errorf("indicators %s were accepted", indicators)
+		}
+	}
+
+	first, err := upload("first", `[{"Type": "MD5", "Value": "D41D8CD98F00B204E9800998ECF8427E"}, {"Type": "domain", "Value": "Evil.Example."}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := upload("second", `[{"Type": "domain", "Value": "evil.example"}, {"Type": "url", "Value": "https://evil.example/login"}]`); err != nil {
+		t.Fatal(err)
+	}
+	if indicators := ctiItemOf(t, l, first).Indicators; indicators[0].Type != "md5" || indicators[0].Value != "d41d8cd98f00b204e9800998ecf8427e" || indicators[1].Value != "evil.example" {
+		t.Errorf("indicators were stored as %+v", indicators)
+	}
+
+	byValue := func(value string) []string {
+		var names []string
+		invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+			ctiItems, err := cc.GetCTIItemsByIndicatorValue(ctx, value)
+			for _, ctiItem := range ctiItems {
+				names = append(names, ctiItem.Name)
+			}
+			return err
+		})
+		sort.Strings(names)
+		return names
+	}
+	if names := byValue("EVIL.example"); len(names) != 2 || names[0] != "first" || names[1] != "second" {
+		t.Errorf("items with evil.example are %v, want [first second]", names)
+	}
+	if names := byValue("D41D8CD98F00B204E9800998ECF8427E"); len(names) != 1 || names[0] != "first" {
+		t.Errorf("items with the md5 are %v, want [first]", names)
+	}
+	if names := byValue("https://evil.example/login"); len(names) != 1 || names[0] != "second" {
+		t.Errorf("items with the url are %v, want [second]", names)
+	}
+}
+
+func TestConfidenceValidatedAndFiltered(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	upload := func(name string, confidence int) (string, error) {
+		var id string
+		err := l.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			id, err = cc.AddCTIItem(ctx, name, 1, testCID, "key", 1, 1, "low", "", nil, "", "GREEN", nil, confidence)
+			return err
+		})
+		return id, err
+	}
+	for _, confidence := range []int{-1, 101} {
+		if _, err := upload("invalid", confidence); err == nil {
+			t.Errorf("confidence %d was accepted", confidence)
+		}
+	}
+	for name, confidence := range map[string]int{"zero": 0, "low": 40, "high": 90, "certain": 100} {
+		if _, err := upload(name, confidence); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Items recorded before confidence existed read back as unset, not as zero
+	l.Put(ctiKey("legacy"), []byte(`{"ID":"legacy","Name":"legacy","Uploader":"alice","CID":"`+testCID+`","Level":1}`))
+	if ctiItem := ctiItemOf(t, l, "legacy"); ctiItem == nil || ctiItem.Confidence != unsetConfidence {
+		t.Errorf("legacy item read back as %+v", ctiItem)
+	}
+
+	aboveConfidence := func(threshold int) []string {
+		var names []string
+		invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+			ctiItems, err := cc.GetCTIItemsAboveConfidence(ctx, threshold)
+			for _, ctiItem := range ctiItems {
+				names = append(names, ctiItem.Name)
+			}
+			return err
+		})
+		sort.Strings(names)
+		return names
+	}
+	if names := aboveConfidence(90); len(names) != 2 || names[0] != "certain" || names[1] != "high" {
+		t.Errorf("items at or above 90 are %v, want [certain high]", names)
+	}
+	if names := aboveConfidence(0); len(names) != 4 {
+		t.Errorf("items at or above 0 are %v, want every item but legacy", names)
+	}
+	if err := l.Invoke(bob, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := cc.GetCTIItemsAboveConfidence(ctx, 101)
+		return err
+	}); err == nil {
+		t.Error("a threshold above 100 was accepted")
+	}
+}
+
+func TestSoftDeleteKeepsReviewsAndHidesItem(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	setUserData(t, cc, l, "alice", 0, 0, 0, 10)
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.SetUploadStake(ctx, 5)
+	})
+	withdrawn := addItem(t, cc, l, alice, "withdrawn")
+	moderated := addItem(t, cc, l, alice, "moderated")
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddReviewData(ctx, withdrawn, 3, 3, 3, 3, "")
+	})
+
+	softDelete := func(identity *ctitest.Identity, id string) error {
+		return l.Invoke(identity, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.SoftDeleteCTIItem(ctx, id)
+		})
+	}
+	if err := softDelete(bob, withdrawn); err == nil {
+		t.Error("a caller other than the uploader soft-deleted the item")
+	}
+	l.Advance(time.Minute)
+	if err := softDelete(alice, withdrawn); err != nil {
+		t.Fatal(err)
+	}
+	if err := softDelete(alice, withdrawn); err == nil {
+		t.Error("an item was soft-deleted twice")
+	}
+	ctiItem := ctiItemOf(t, l, withdrawn)
+	if ctiItem == nil || !ctiItem.Deleted || ctiItem.DeletedBy != "alice" || ctiItem.DeletedAt != 86460 {
+		t.Fatalf("soft-deleted item is %+v, want it kept and marked deleted by alice", ctiItem)
+	}
+	if indexed := indexEntries(t, l, uploaderIndex); len(indexed) != 1 {
+		t.Errorf("uploader index after a soft delete is %v, want only the other item", indexed)
+	}
+
+	// Reviews of the deleted item still resolve, but it takes no new reviews or updates
+	invoke(t, l, carol, func(ctx contractapi.TransactionContextInterface) error {
+		reviews, err := cc.GetReviewDataByCTIDataID(ctx, withdrawn)
+		if err == nil && len(reviews) != 1 {
+			t.Errorf("reviews of the deleted item are %v, want bob's", reviews)
+		}
+		return err
+	})
+	if err := l.Invoke(carol, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddReviewData(ctx, withdrawn, 3, 3, 3, 3, "")
+	}); err == nil {
+		t.Error("a soft-deleted item was reviewed")
+	}
+	if err := l.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.UpdateCTIItem(ctx, withdrawn, "revived", 1, testCID, "key", 1, "GREEN", nil, 50)
+	}); err == nil {
+		t.Error("a soft-deleted item was updated")
+	}
+
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		visible, err := cc.GetAllCTIItems(ctx)
+		if err != nil {
+			return err
+		}
+		all, err := cc.GetAllCTIItemsIncludingDeleted(ctx)
+		if err != nil {
+			return err
+		}
+		if len(visible) != 1 || visible[0].ID != moderated || len(all) != 2 {
+			t.Errorf("got %d visible and %d total items, want only the live item visible of 2", len(visible), len(all))
+		}
+		page, err := cc.SyncAccessibleCTIItems(ctx, 0, 10, "")
+		if err != nil {
+			return err
+		}
+		for _, synced := range page.Items {
+			if synced.ID == withdrawn && (!synced.Deleted || synced.CID != "") {
+				t.Errorf("sync returned the deleted item as %+v, want it redacted", synced)
+			}
+		}
+		return nil
+	})
+
+	// The uploader withdrawing an item forfeits its stake; an admin deleting one refunds it
+	if alice := userDataOf(t, l, "alice"); alice.Balance != 0 || alice.UploadCount != 1 {
+		t.Errorf("alice has balance %d and %d uploads after withdrawing an item, want 0 and 1", alice.Balance, alice.UploadCount)
+	}
+	if err := softDelete(admin, moderated); err != nil {
+		t.Fatal(err)
+	}
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.DeleteCTIItemByID(ctx, moderated)
+	})
+	if alice := userDataOf(t, l, "alice"); alice.Balance != 5 || alice.UploadCount != 0 {
+		t.Errorf("alice has balance %d and %d uploads after an admin deletion, want 5 and 0", alice.Balance, alice.UploadCount)
+	}
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		notifications, err := cc.GetMyNotifications(ctx, false)
+		var types []string
+		for _, notification := range notifications {
+			types = append(types, notification.Type)
+		}
+		sort.Strings(types)
+		if got := strings.Join(types, ","); !strings.Contains(got, "stake-forfeited") || !strings.Contains(got, "stake-returned") {
+			t.Errorf("alice's notifications are %s, want a forfeiture and a return", got)
+		}
+		return err
+	})
+}
+
+func TestReviewsStoredUnderSingleReviewPrefix(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "phishing kit")
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddReviewData(ctx, id, 4, 4, 4, 4, "")
+	})
+	reviewID := reviewIDOf(t, l, "bob", id)
+	if strings.HasPrefix(reviewID, "Review_") || l.Get("Review_"+reviewID) == nil {
+		t.Fatalf("review %s is not stored under a single Review_ prefix", reviewID)
+	}
+
+	// Reviews written with prefixed IDs before bare IDs still fall inside the review range
+	legacy, err := json.Marshal(&ReviewData{ID: "Review_7", UserDataID: "carol", CTIDataID: id, Accuracy: 2, Timeliness: 2, Completeness: 2, Consistency: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Put(reviewKey("Review_7"), legacy)
+	invoke(t, l, carol, func(ctx contractapi.TransactionContextInterface) error {
+		reviews, err := cc.GetAllReviewData(ctx)
+		var ids []string
+		for _, review := range reviews {
+			ids = append(ids, review.ID)
+		}
+		sort.Strings(ids)
+		if len(ids) != 2 || ids[0] != "Review_7" || ids[1] != reviewID {
+			t.Errorf("reviews are %v, want [Review_7 %s]", ids, reviewID)
+		}
+		return err
+	})
+}
+
+func TestDeleteReviewDataTakesBackTheRewardPaid(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "phishing kit")
+	other := addItem(t, cc, l, alice, "stealer")
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.SetReviewerReward(ctx, 3)
+	})
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddReviewData(ctx, id, 4, 4, 4, 4, "")
+	})
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddReviewData(ctx, other, 2, 2, 2, 2, "")
+	})
+	invoke(t, l, carol, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddReviewData(ctx, id, 2, 2, 2, 2, "")
+	})
+	// A later change to the reward does not change what is taken back
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.SetReviewerReward(ctx, 7)
+	})
+
+	deleteReview := func(identity *ctitest.Identity, reviewID string) error {
+		return l.Invoke(identity, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.DeleteReviewData(ctx, reviewID)
+		})
+	}
+	bobsReview := reviewIDOf(t, l, "bob", id)
+	if err := deleteReview(carol, bobsReview); err == nil {
+		t.Error("a caller other than the reviewer deleted the review")
+	}
+	if err := deleteReview(bob, "missing"); err == nil {
+		t.Error("deleting a nonexistent review succeeded")
+	}
+	if err := deleteReview(bob, bobsReview); err != nil {
+		t.Fatal(err)
+	}
+	if l.Get(reviewKey(bobsReview)) != nil || len(indexEntries(t, l, reviewerIndex)) != 2 {
+		t.Error("the deleted review or its index entry survived")
+	}
+	if bob := userDataOf(t, l, "bob"); bob.Points != 3 || bob.ReviewPoints != 3 || bob.ReviewsAuthored != 1 {
+		t.Errorf("bob has %d points, %d review points and %d reviews, want 3, 3 and 1", bob.Points, bob.ReviewPoints, bob.ReviewsAuthored)
+	}
+	if ctiItem := ctiItemOf(t, l, id); ctiItem.ReviewCount != 1 || ctiItem.CompositeScore != 2 {
+		t.Errorf("item has %d reviews scoring %v, want carol's review alone", ctiItem.ReviewCount, ctiItem.CompositeScore)
+	}
+	if alice := userDataOf(t, l, "alice"); alice.Reputation != 2 {
+		t.Errorf("alice's reputation is %v, want 2 from the reviews left", alice.Reputation)
+	}
+
+	// A reward the reviewer has since spent cannot be taken back, so the review stays
+	spent := userDataOf(t, l, "carol")
+	spent.Points = 0
+	spentJSON, err := json.Marshal(spent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Put("UserData_carol", spentJSON)
+	if err := deleteReview(carol, reviewIDOf(t, l, "carol", id)); err == nil {
+		t.Error("a review whose reward was spent was deleted")
+	}
+	if err := deleteReview(admin, reviewIDOf(t, l, "bob", other)); err != nil {
+		t.Errorf("an admin could not delete a review: %v", err)
+	}
+}
+
+func TestGetCTIItemScoreWeightsDimensions(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "phishing kit")
+	score := func() *CTIItemScore {
+		var score *CTIItemScore
+		invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			score, err = cc.GetCTIItemScore(ctx, id)
+			return err
+		})
+		return score
+	}
+	if unreviewed := score(); unreviewed.ReviewCount != 0 || unreviewed.Overall != 0 || unreviewed.Dimensions["Accuracy"] != 0 {
+		t.Errorf("score of an unreviewed item is %+v, want zero", unreviewed)
+	}
+
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddReviewData(ctx, id, 5, 1, 3, 3, "")
+	})
+	invoke(t, l, carol, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddReviewData(ctx, id, 3, 3, 3, 1, "")
+	})
+	got := score()
+	if got.ReviewCount != 2 || got.Accuracy != 4 || got.Timeliness != 2 || got.Completeness != 3 || got.Consistency != 2 || got.Dimensions["Accuracy"] != 4 {
+		t.Errorf("score is %+v, want means 4, 2, 3 and 2 over 2 reviews", got)
+	}
+	// By default accuracy weighs double the other dimensions
+	if want := 4*0.4 + 2*0.2 + 3*0.2 + 2*0.2; math.Abs(got.Overall-want) > 1e-9 {
+		t.Errorf("overall score is %v, want %v", got.Overall, want)
+	}
+
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.SetReviewDimensionWeights(ctx, map[string]float64{"Accuracy": 1, "Timeliness": 0, "Completeness": 0, "Consistency": 1})
+	})
+	if got := score(); math.Abs(got.Overall-3) > 1e-9 {
+		t.Errorf("overall score with reweighted dimensions is %v, want 3", got.Overall)
+	}
+}
+
+func TestDuplicateReviewsRejectedAndUpsertRevisesInPlace(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "phishing kit")
+	upsert := func(score int) {
+		t.Helper()
+		invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.UpsertReviewData(ctx, id, score, score, score, score, fmt.Sprintf("scored %d", score))
+		})
+	}
+	reviewOf := func(reviewer string) *ReviewData {
+		var review *ReviewData
+		invoke(t, l, carol, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			review, err = cc.GetReviewByReviewerAndCTI(ctx, reviewer, id)
+			return err
+		})
+		return review
+	}
+
+	// Without a review to revise, an upsert adds one
+	upsert(2)
+	first := reviewOf("bob")
+	if err := l.Invoke(bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddReviewData(ctx, id, 4, 4, 4, 4, "")
+	}); err == nil {
+		t.Error("a second review of the same item was accepted")
+	}
+
+	l.Advance(time.Minute)
+	upsert(4)
+	revised := reviewOf("bob")
+	if revised.ID != first.ID || revised.Accuracy != 4 || revised.ReviewText != "scored 4" || revised.Timestamp <= first.Timestamp || revised.RewardAmount != first.RewardAmount {
+		t.Errorf("revised review is %+v, want %s rescored to 4 with its reward kept", revised, first.ID)
+	}
+	if ctiItem := ctiItemOf(t, l, id); ctiItem.ReviewCount != 1 || ctiItem.CompositeScore != 4 {
+		t.Errorf("item has %d reviews scoring %v after the revision, want 1 scoring 4", ctiItem.ReviewCount, ctiItem.CompositeScore)
+	}
+	if bob := userDataOf(t, l, "bob"); bob.ReviewsAuthored != 1 || bob.Points != 1 {
+		t.Errorf("bob has %d reviews and %d points after revising, want 1 and 1", bob.ReviewsAuthored, bob.Points)
+	}
+	if alice := userDataOf(t, l, "alice"); alice.Reputation != 4 {
+		t.Errorf("alice's reputation is %v after the revision, want 4", alice.Reputation)
+	}
+
+	if err := l.Invoke(carol, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := cc.GetReviewByReviewerAndCTI(ctx, "carol", id)
+		return err
+	}); err == nil {
+		t.Error("looking up a review that was never written succeeded")
+	}
+
+	// Once other dimensions are configured, reviews are revised with a score for each
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.SetReviewDimensions(ctx, []string{"Accuracy", "Relevance"})
+	})
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.UpsertScoredReviewData(ctx, id, map[string]int{"Accuracy": 3, "Relevance": 5}, "")
+	})
+	if revised := reviewOf("bob"); revised.ID != first.ID || revised.Scores["Relevance"] != 5 || revised.Accuracy != 3 {
+		t.Errorf("review revised with configured dimensions is %+v", revised)
+	}
+}
+
+func TestReviewScoresBoundedToScale(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "phishing kit")
+	for _, tc := range []struct {
+		scores    [4]int
+		dimension string
+	}{
+		{[4]int{0, 3, 3, 3}, "Accuracy"},
+		{[4]int{3, 6, 3, 3}, "Timeliness"},
+		{[4]int{3, 3, -1, 3}, "Completeness"},
+		{[4]int{3, 3, 3, 10}, "Consistency"},
+	} {
+		err := l.Invoke(bob, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.AddReviewData(ctx, id, tc.scores[0], tc.scores[1], tc.scores[2], tc.scores[3], "")
+		})
+		if err == nil || !strings.Contains(err.Error(), tc.dimension+" score") {
+			t.Errorf("scores %v failed with %v, want an error naming %s", tc.scores, err, tc.dimension)
+		}
+	}
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddReviewData(ctx, id, MinReviewScore, MaxReviewScore, MinReviewScore, MaxReviewScore, "")
+	})
+}
+
+func TestAllowSelfReviewLiftsTheCheckWithoutRewards(t *testing.T) {
+	l := ctitest.NewLedger()
+	strict := &SmartContract{}
+	id := addItem(t, strict, l, alice, "phishing kit")
+	if err := l.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+		return strict.AddReviewData(ctx, id, 4, 4, 4, 4, "")
+	}); err == nil {
+		t.Fatal("an uploader reviewed their own item by default")
+	}
+
+	testNetwork := &SmartContract{AllowSelfReview: true}
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		return testNetwork.AddReviewData(ctx, id, 4, 4, 4, 4, "")
+	})
+	// Both the reviewer's and the uploader's updates land on the one record
+	alice := userDataOf(t, l, "alice")
+	if alice.ReviewsAuthored != 1 || alice.ReviewsReceived != 1 || alice.Reputation != 4 {
+		t.Errorf("alice has %d reviews authored and %d received at reputation %v, want 1, 1 and 4", alice.ReviewsAuthored, alice.ReviewsReceived, alice.Reputation)
+	}
+	if alice.Points != 0 || alice.ReviewPoints != 0 {
+		t.Errorf("a self-review earned %d points and %d review points", alice.Points, alice.ReviewPoints)
+	}
+	var review ReviewData
+	if err := json.Unmarshal(l.Get(reviewKey(reviewIDOf(t, l, "alice", id))), &review); err != nil {
+		t.Fatal(err)
+	}
+	if review.RewardAmount != 0 {
+		t.Errorf("self-review recorded a reward of %d", review.RewardAmount)
+	}
+}
+
+func TestUploaderRewardedOncePerPositiveReview(t *testing.T) {
+	cc := &SmartContract{AllowSelfReview: true}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "phishing kit")
+	review := func(identity *ctitest.Identity, upsert bool, score int) {
+		invoke(t, l, identity, func(ctx contractapi.TransactionContextInterface) error {
+			if upsert {
+				return cc.UpsertReviewData(ctx, id, score, score, score, score, "")
+			}
+			return cc.AddReviewData(ctx, id, score, score, score, score, "")
+		})
+	}
+	uploaderPoints := func() (int, int) {
+		return userDataOf(t, l, "alice").Points, ctiItemOf(t, l, id).Points
+	}
+	basePoints, baseItemPoints := uploaderPoints()
+
+	// A positive review credits the uploader and the item; a poor one does not
+	review(bob, false, 4)
+	review(carol, false, 2)
+	if points, itemPoints := uploaderPoints(); points != basePoints+UploaderReward || itemPoints != baseItemPoints+UploaderReward {
+		t.Errorf("uploader and item have %d and %d points, want one uploader reward each", points-basePoints, itemPoints-baseItemPoints)
+	}
+
+	// Revising a review to positive pays out once, however often it is revised
+	review(carol, true, 5)
+	review(carol, true, 2)
+	review(carol, true, 5)
+	if points, itemPoints := uploaderPoints(); points != basePoints+2*UploaderReward || itemPoints != baseItemPoints+2*UploaderReward {
+		t.Errorf("uploader and item have %d and %d points, want two uploader rewards each", points-basePoints, itemPoints-baseItemPoints)
+	}
+
+	// A self-review earns the uploader nothing, even when revised
+	review(alice, false, 5)
+	review(alice, true, 5)
+	if points, itemPoints := uploaderPoints(); points != basePoints+2*UploaderReward || itemPoints != baseItemPoints+2*UploaderReward {
+		t.Errorf("self-review changed the uploader's points by %d and the item's by %d", points-basePoints-2*UploaderReward, itemPoints-baseItemPoints-2*UploaderReward)
+	}
+
+	// Updating the item keeps the points it earned
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.UpdateCTIItem(ctx, id, "phishing kit v2", 1, testCID, "key", 1, "GREEN", nil, 50)
+	})
+	if _, itemPoints := uploaderPoints(); itemPoints != baseItemPoints+2*UploaderReward {
+		t.Errorf("item has %d points after an update, want two uploader rewards", itemPoints-baseItemPoints)
+	}
+
+	// Deleting a review takes back the uploader reward it earned
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.DeleteReviewData(ctx, reviewIDOf(t, l, "bob", id))
+	})
+	if points, itemPoints := uploaderPoints(); points != basePoints+UploaderReward || itemPoints != baseItemPoints+UploaderReward {
+		t.Errorf("uploader and item have %d and %d points after the delete, want one uploader reward each", points-basePoints, itemPoints-baseItemPoints)
+	}
+}
+
+func TestTransferBalanceMovesFundsAtomically(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	setUserData(t, cc, l, "alice", 0, 0, 0, 15)
+	setUserData(t, cc, l, "bob", 0, 0, 0, 0)
+	transfer := func(to string, amount int) error {
+		return l.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.TransferBalance(ctx, to, amount)
+		})
+	}
+	balances := func() (int, int) {
+		return userDataOf(t, l, "alice").Balance, userDataOf(t, l, "bob").Balance
+	}
+
+	if err := transfer("bob", 10); err != nil {
+		t.Fatal(err)
+	}
+	if from, to := balances(); from != 5 || to != 10 {
+		t.Errorf("balances after a transfer of 10 are %d and %d, want 5 and 10", from, to)
+	}
+
+	if err := transfer("bob", 6); err == nil {
+		t.Error("a transfer overdrawing the sender's balance succeeded")
+	}
+	if err := transfer("alice", 1); err == nil {
+		t.Error("a self-transfer succeeded")
+	}
+	for _, amount := range []int{0, -1} {
+		if err := transfer("bob", amount); err == nil {
+			t.Errorf("a transfer of %d succeeded", amount)
+		}
+	}
+	if err := transfer("carol", 1); err == nil {
+		t.Error("a transfer to a user without a record succeeded")
+	}
+	if from, to := balances(); from != 5 || to != 10 {
+		t.Errorf("rejected transfers left balances of %d and %d, want 5 and 10", from, to)
+	}
+}
+
+func TestPurchaseSubscriptionUnlocksPaidLevels(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	for _, level := range []int{0, 2, 3} {
+		invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+			_, err := cc.AddCTIItem(ctx, fmt.Sprintf("level %d", level), 1, testCID, "key", 1, level, "low", "", nil, "", "GREEN", nil, 50)
+			return err
+		})
+	}
+	setUserData(t, cc, l, "bob", 0, 0, 0, 35)
+	purchase := func(level int) error {
+		return l.Invoke(bob, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.PurchaseSubscription(ctx, level)
+		})
+	}
+	visibleLevels := func() []int {
+		var levels []int
+		invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+			items, err := cc.GetCTIItemsFilteredBySubscriptionLevel(ctx)
+			for _, item := range items {
+				levels = append(levels, item.Level)
+			}
+			return err
+		})
+		sort.Ints(levels)
+		return levels
+	}
+	if levels := visibleLevels(); fmt.Sprint(levels) != "[0]" {
+		t.Errorf("levels visible before a purchase are %v, want [0]", levels)
+	}
+
+	if err := purchase(2); err != nil {
+		t.Fatal(err)
+	}
+	if bob := userDataOf(t, l, "bob"); bob.Subscribed != 2 || bob.Balance != 35-2*subscriptionPricePerLevel {
+		t.Errorf("bob has subscription %d and balance %d after buying level 2", bob.Subscribed, bob.Balance)
+	}
+	if levels := visibleLevels(); fmt.Sprint(levels) != "[0 2]" {
+		t.Errorf("levels visible after buying level 2 are %v, want [0 2]", levels)
+	}
+
+	if err := purchase(4); err == nil {
+		t.Error("an unaffordable upgrade succeeded")
+	}
+	for _, level := range []int{1, 2} {
+		if err := purchase(level); err == nil {
+			t.Errorf("a purchase of level %d from level 2 succeeded", level)
+		}
+	}
+	if err := purchase(3); err != nil {
+		t.Fatal(err)
+	}
+	if bob := userDataOf(t, l, "bob"); bob.Subscribed != 3 || bob.Balance != 35-3*subscriptionPricePerLevel {
+		t.Errorf("bob has subscription %d and balance %d after upgrading to level 3", bob.Subscribed, bob.Balance)
+	}
+	if levels := visibleLevels(); fmt.Sprint(levels) != "[0 2 3]" {
+		t.Errorf("levels visible after upgrading to level 3 are %v, want [0 2 3]", levels)
+	}
+}
+
+func TestUsersCannotSetTheirOwnBalanceOrSubscription(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddUserData(ctx)
+	})
+	if bob := userDataOf(t, l, "bob"); bob.Balance != 0 || bob.Subscribed != 0 || bob.Points != 0 || bob.UploadCount != 0 {
+		t.Errorf("a newly registered user has %+v, want an empty record", bob)
+	}
+	if err := l.Invoke(bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddUserData(ctx)
+	}); err == nil {
+		t.Error("a user registered twice")
+	}
+	if err := l.Invoke(bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AdminSetUserData(ctx, "bob", 0, 100, 5, 1000)
+	}); err == nil {
+		t.Error("a non-admin set their own balance and subscription")
+	}
+	if bob := userDataOf(t, l, "bob"); bob.Balance != 0 || bob.Subscribed != 0 || bob.Points != 0 {
+		t.Errorf("bob's record was inflated to %+v", bob)
+	}
+
+	setUserData(t, cc, l, "bob", 1, 2, 3, 4)
+	if bob := userDataOf(t, l, "bob"); bob.UploadCount != 1 || bob.Points != 2 || bob.Subscribed != 3 || bob.Balance != 4 {
+		t.Errorf("an admin migration left bob with %+v", bob)
+	}
+}
+
+func TestUserLevelFollowsUploadsAndPoints(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	setUserData(t, cc, l, "alice", 4, 0, 0, 0)
+	if level := userDataOf(t, l, "alice").UserLevel; level != 0 {
+		t.Errorf("level with 4 uploads is %d, want 0", level)
+	}
+	id := addItem(t, cc, l, alice, "phishing kit")
+	if level := userDataOf(t, l, "alice").UserLevel; level != 1 {
+		t.Errorf("level after a fifth upload is %d, want 1", level)
+	}
+
+	// A reviewer reward carrying points over a threshold promotes the reviewer
+	setUserData(t, cc, l, "bob", 0, 50-defaultReviewerReward, 0, 0)
+	if level := userDataOf(t, l, "bob").UserLevel; level != 1 {
+		t.Errorf("level just below 50 points is %d, want 1", level)
+	}
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddReviewData(ctx, id, 4, 4, 4, 4, "")
+	})
+	if level := userDataOf(t, l, "bob").UserLevel; level != 2 {
+		t.Errorf("level after reaching 50 points is %d, want 2", level)
+	}
+
+	// A record written before levels were derived is brought up to date on request
+	legacy, err := json.Marshal(&UserData{ID: "carol", UploadCount: 50, SchemaVersion: SchemaVersion})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Put("UserData_carol", legacy)
+	invoke(t, l, carol, func(ctx contractapi.TransactionContextInterface) error {
+		level, err := cc.RecalculateUserLevel(ctx)
+		if err == nil && level != 3 {
+			t.Errorf("recalculated level is %d, want 3", level)
+		}
+		return err
+	})
+	if level := userDataOf(t, l, "carol").UserLevel; level != 3 {
+		t.Errorf("stored level after recalculating is %d, want 3", level)
+	}
+}
+
+func TestDepositBalanceIsAdminOnlyAndAudited(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddUserData(ctx)
+	})
+	deposit := func(identity *ctitest.Identity, userID string, amount int) error {
+		return l.Invoke(identity, func(ctx contractapi.TransactionContextInterface) error {
+			return cc.DepositBalance(ctx, userID, amount)
+		})
+	}
+
+	deposited := invokeForEvent(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.DepositBalance(ctx, "bob", 25)
+	})
+	var event BalanceDepositedEvent
+	if err := json.Unmarshal(deposited.Payload, &event); err != nil {
+		t.Fatal(err)
+	}
+	if deposited.Name != "BalanceDeposited" || event.UserID != "bob" || event.Amount != 25 || event.Balance != 25 || event.DepositedBy != "admin" {
+		t.Errorf("deposit event is %s %+v", deposited.Name, event)
+	}
+	if balance := userDataOf(t, l, "bob").Balance; balance != 25 {
+		t.Errorf("balance after a deposit of 25 is %d", balance)
+	}
+
+	if err := deposit(bob, "bob", 10); err == nil {
+		t.Error("a non-admin deposited into their own balance")
+	}
+	for _, amount := range []int{0, -5} {
+		if err := deposit(admin, "bob", amount); err == nil {
+			t.Errorf("a deposit of %d succeeded", amount)
+		}
+	}
+	if err := deposit(admin, "carol", 10); err == nil {
+		t.Error("a deposit to a user without a record succeeded")
+	}
+	if balance := userDataOf(t, l, "bob").Balance; balance != 25 {
+		t.Errorf("rejected deposits left a balance of %d, want 25", balance)
+	}
+
+	var log []*AuditEntry
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		log, err = cc.GetAdminAuditLog(ctx)
+		return err
+	})
+	if len(log) != 1 || log[0].Action != "DepositBalance" || log[0].Target != "bob" {
+		t.Errorf("audit log is %+v, want the one deposit", log)
+	}
+}
+
+// failingStub is a stub whose state reads all fail
+type failingStub struct {
+	shim.ChaincodeStubInterface
+}
+
+func (s *failingStub) GetState(key string) ([]byte, error) {
+	return nil, fmt.Errorf("ledger unavailable reading %s", key)
+}
+
+func TestAddCTIItemSurfacesLedgerReadErrors(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	tx := l.NewTransaction(alice)
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(&failingStub{tx})
+	ctx.SetClientIdentity(alice)
+
+	if _, err := cc.AddCTIItem(ctx, "phishing kit", 1, testCID, "key", 1, 1, "low", "", nil, "", "GREEN", nil, 50); err == nil || !strings.Contains(err.Error(), "ledger unavailable") {
+		t.Errorf("AddCTIItem over a failing ledger returned %v, want the read error", err)
+	}
+	// Commit anyway to show nothing was written before the read failed
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if entries := indexEntries(t, l, uploaderIndex); len(entries) != 0 {
+		t.Errorf("a failed upload left %d index entries", len(entries))
+	}
+}
+
+func TestUpdateKeepsUploaderAndCreationTime(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "phishing kit")
+	created := ctiItemOf(t, l, id).CreatedAt
+
+	l.Advance(time.Hour)
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.UpdateCTIItem(ctx, id, "phishing kit v2", 999, testCID, "key", 1, "GREEN", nil, 50)
+	})
+	ctiItem := ctiItemOf(t, l, id)
+	if ctiItem.Uploader != "alice" || ctiItem.CreatedAt != created {
+		t.Errorf("after an admin edit the item has uploader %q and creation time %d, want alice and %d", ctiItem.Uploader, ctiItem.CreatedAt, created)
+	}
+	if ctiItem.LastModifiedBy != "admin" || ctiItem.UpdatedAt != created+60*60 {
+		t.Errorf("modification metadata is %q at %d, want admin an hour after creation", ctiItem.LastModifiedBy, ctiItem.UpdatedAt)
+	}
+
+	l.Advance(time.Hour)
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.UpdateCTIItem(ctx, id, "phishing kit v3", 1, testCID, "key", 1, "GREEN", nil, 50)
+	})
+	if ctiItem := ctiItemOf(t, l, id); ctiItem.LastModifiedBy != "alice" || ctiItem.CreatedAt != created || ctiItem.UpdatedAt != created+2*60*60 {
+		t.Errorf("after the uploader's edit the item was created at %d and last modified by %q at %d", ctiItem.CreatedAt, ctiItem.LastModifiedBy, ctiItem.UpdatedAt)
+	}
+}
+
+// brokenCouchStub is a stub whose rich queries fail for a reason other than being unsupported
+type brokenCouchStub struct {
+	shim.ChaincodeStubInterface
+}
+
+func (s *brokenCouchStub) GetQueryResult(query string) (shim.StateQueryIteratorInterface, error) {
+	return nil, fmt.Errorf("couchdb connection refused")
+}
+
+func TestGetCTIItemsByTimestampRangeIsInclusive(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	for _, timestamp := range []int64{100, 200, 300, 400} {
+		invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+			_, err := cc.AddCTIItem(ctx, fmt.Sprintf("seen at %d", timestamp), timestamp, testCID, "key", 1, 1, "low", "", nil, "", "GREEN", nil, 50)
+			return err
+		})
+	}
+	inRange := func(startTs, endTs int64) []string {
+		var names []string
+		invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+			ctiItems, err := cc.GetCTIItemsByTimestampRange(ctx, startTs, endTs)
+			for _, ctiItem := range ctiItems {
+				names = append(names, ctiItem.Name)
+			}
+			return err
+		})
+		sort.Strings(names)
+		return names
+	}
+
+	if names := inRange(200, 300); strings.Join(names, ",") != "seen at 200,seen at 300" {
+		t.Errorf("items in [200, 300] are %v, want both boundaries", names)
+	}
+	if names := inRange(300, 300); strings.Join(names, ",") != "seen at 300" {
+		t.Errorf("items in [300, 300] are %v", names)
+	}
+	if names := inRange(201, 299); len(names) != 0 {
+		t.Errorf("items in an empty window are %v", names)
+	}
+	if err := l.Invoke(bob, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := cc.GetCTIItemsByTimestampRange(ctx, 300, 200)
+		return err
+	}); err == nil {
+		t.Error("a range ending before it starts was accepted")
+	}
+
+	// A CouchDB failure is returned rather than hidden behind a scan
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(&brokenCouchStub{l.NewTransaction(bob)})
+	ctx.SetClientIdentity(bob)
+	if _, err := cc.GetCTIItemsByTimestampRange(ctx, 0, 1000); err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("a failing rich query returned %v, want the query error", err)
+	}
+}
+
+func TestMillisecondTimestampsBeyondInt32RoundTrip(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	// Milliseconds since the epoch in 2100, well past the int32 range
+	const timestamp = int64(4102444800123)
+	var id string
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		id, err = cc.AddCTIItem(ctx, "phishing kit", timestamp, testCID, "key", 1, 1, "low", "", nil, "", "GREEN", nil, 50)
+		return err
+	})
+	if !strings.Contains(string(l.Get(ctiKey(id))), `"Timestamp":4102444800123`) {
+		t.Errorf("stored item %s does not encode the timestamp as a plain JSON number", l.Get(ctiKey(id)))
+	}
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		ctiItem, err := cc.GetCTIItem(ctx, id)
+		if err == nil && ctiItem.Timestamp != timestamp {
+			t.Errorf("timestamp read back is %d, want %d", ctiItem.Timestamp, timestamp)
+		}
+		return err
+	})
+
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.UpdateCTIItem(ctx, id, "phishing kit", timestamp+1, testCID, "key", 1, "GREEN", nil, 50)
+	})
+	if got := ctiItemOf(t, l, id).Timestamp; got != timestamp+1 {
+		t.Errorf("updated timestamp is %d, want %d", got, timestamp+1)
+	}
+}
+
+func TestSearchCTIItemsByNameIgnoresCase(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	for _, name := range []string{"Emotet Loader", "emotet c2 list", "QakBot"} {
+		addItem(t, cc, l, alice, name)
+	}
+	removed := addItem(t, cc, l, alice, "EMOTET retired")
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.SoftDeleteCTIItem(ctx, removed)
+	})
+	search := func(query string) string {
+		var names []string
+		invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+			ctiItems, err := cc.SearchCTIItemsByName(ctx, query)
+			for _, ctiItem := range ctiItems {
+				names = append(names, ctiItem.Name)
+			}
+			return err
+		})
+		sort.Strings(names)
+		return strings.Join(names, ",")
+	}
+
+	if names := search("  EMOTET "); names != "Emotet Loader,emotet c2 list" {
+		t.Errorf("search for emotet found %q", names)
+	}
+	if names := search("akb"); names != "QakBot" {
+		t.Errorf("partial search found %q", names)
+	}
+	for _, query := range []string{"", "   "} {
+		if names := search(query); names != "" {
+			t.Errorf("empty query %q found %q", query, names)
+		}
+	}
+	if names := search("trickbot"); names != "" {
+		t.Errorf("search for an unknown name found %q", names)
+	}
+}
+
+func TestAddCTIItemsBatch(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	batch := func(inputs []CTIItemInput) ([]string, error) {
+		itemsJSON, err := json.Marshal(inputs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ids []string
+		err = l.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			ids, err = cc.AddCTIItemsBatch(ctx, string(itemsJSON))
+			return err
+		})
+		return ids, err
+	}
+	input := func(name string) CTIItemInput {
+		return CTIItemInput{Name: name, Timestamp: 1, CID: testCID, Level: 1, TLP: "GREEN", Confidence: 50}
+	}
+	invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddUserData(ctx)
+	})
+
+	// A 100-item batch is numbered within its transaction, in input order on the ledger
+	var inputs []CTIItemInput
+	for i := 0; i < 100; i++ {
+		inputs = append(inputs, input(fmt.Sprintf("item %d", i)))
+	}
+	ids, err := batch(inputs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored []*CTIData
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		stored, err = cc.GetAllCTIItems(ctx)
+		return err
+	})
+	if len(ids) != 100 || len(stored) != 100 {
+		t.Fatalf("batch returned %d IDs and stored %d items, want 100", len(ids), len(stored))
+	}
+	for i, ctiItem := range stored {
+		if ctiItem.ID != ids[i] || ctiItem.Name != inputs[i].Name || ctiItem.Uploader != "alice" {
+			t.Fatalf("item %d is %s %q, want %s %q", i, ctiItem.ID, ctiItem.Name, ids[i], inputs[i].Name)
+		}
+	}
+	if ids[0] != "tx000002-000" || ids[99] != "tx000002-099" {
+		t.Errorf("batch IDs run from %s to %s, want tx000002-000 to tx000002-099", ids[0], ids[99])
+	}
+	if count := userDataOf(t, l, "alice").UploadCount; count != 100 {
+		t.Errorf("alice's upload count is %d, want 100", count)
+	}
+
+	// An empty batch is rejected
+	if _, err := batch(nil); err == nil {
+		t.Error("an empty batch was accepted")
+	}
+
+	// One invalid item fails the whole batch and nothing is written
+	invalid := []CTIItemInput{input("first"), input("second"), input("third")}
+	invalid[1].CID = "not a CID"
+	if _, err := batch(invalid); err == nil || !strings.Contains(err.Error(), "CTI item 1") {
+		t.Errorf("batch with an invalid item returned %v", err)
+	}
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		var err error
+		stored, err = cc.GetAllCTIItems(ctx)
+		return err
+	})
+	if len(stored) != 100 {
+		t.Errorf("failed batch left %d items, want 100", len(stored))
+	}
+}
+
+func TestCTIExistsAndReviewExists(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	id := addItem(t, cc, l, alice, "phishing kit")
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.AddReviewData(ctx, id, 4, 4, 4, 4, "")
+	})
+	reviewID := reviewIDOf(t, l, "bob", id)
+
+	invoke(t, l, carol, func(ctx contractapi.TransactionContextInterface) error {
+		for _, probe := range []struct {
+			name   string
+			exists func() (bool, error)
+			want   bool
+		}{
+			{"present item", func() (bool, error) { return cc.CTIExists(ctx, id) }, true},
+			{"absent item", func() (bool, error) { return cc.CTIExists(ctx, "missing") }, false},
+			{"present review", func() (bool, error) { return cc.ReviewExists(ctx, reviewID) }, true},
+			{"absent review", func() (bool, error) { return cc.ReviewExists(ctx, "missing") }, false},
+		} {
+			if exists, err := probe.exists(); err != nil || exists != probe.want {
+				t.Errorf("%s: exists is %t with error %v, want %t", probe.name, exists, err, probe.want)
+			}
+		}
+		return nil
+	})
+
+	// A ledger read error is returned, not reported as absence
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(&failingStub{l.NewTransaction(carol)})
+	ctx.SetClientIdentity(carol)
+	if _, err := cc.CTIExists(ctx, id); err == nil {
+		t.Error("CTIExists hid a ledger read error")
+	}
+	if _, err := cc.ReviewExists(ctx, reviewID); err == nil {
+		t.Error("ReviewExists hid a ledger read error")
+	}
+}
+
+// writeCountingStub is a stub that counts its state writes
+type writeCountingStub struct {
+	shim.ChaincodeStubInterface
+	writes int
+}
+
+func (s *writeCountingStub) PutState(key string, value []byte) error {
+	s.writes++
+	return s.ChaincodeStubInterface.PutState(key, value)
+}
+
+func TestUserDataReadsNeverWrite(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	setUserData(t, cc, l, "alice", 1, 2, 0, 3)
+	stub := &writeCountingStub{ChaincodeStubInterface: l.NewTransaction(bob)}
+	ctx := new(contractapi.TransactionContext)
+	ctx.SetStub(stub)
+	ctx.SetClientIdentity(bob)
+
+	if userData, err := cc.GetUserDataByID(ctx, "alice"); err != nil || userData.Points != 2 {
+		t.Errorf("alice's record read as %+v with error %v", userData, err)
+	}
+	if _, err := cc.GetUserDataByID(ctx, "carol"); err == nil {
+		t.Error("reading a user without a record succeeded")
+	}
+	if _, err := cc.GetUserData(ctx); err == nil {
+		t.Error("reading the caller's missing record succeeded")
+	}
+	if _, err := cc.GetCTIItemsFilteredBySubscriptionLevel(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if stub.writes != 0 {
+		t.Errorf("the read path wrote %d times", stub.writes)
+	}
+
+	// Only EnsureUserData creates the missing record
+	invoke(t, l, bob, func(ctx contractapi.TransactionContextInterface) error {
+		userData, err := cc.EnsureUserData(ctx)
+		if err == nil && userData.ID != "bob" {
+			t.Errorf("ensured record is %+v", userData)
+		}
+		return err
+	})
+	if l.Get("UserData_bob") == nil {
+		t.Error("EnsureUserData did not store a record")
+	}
+}
+
+func TestExportCTIItemsAsSTIX(t *testing.T) {
+	cc := &SmartContract{}
+	l := ctitest.NewLedger()
+	add := func(name string) string {
+		var id string
+		invoke(t, l, alice, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			id, err = cc.AddCTIItem(ctx, name, 1, testCID, "secret-key", 1, 2, "low", "", []string{"phishing"},
+				`[{"Type": "domain", "Value": "evil.example"}]`, "GREEN", []string{"T1566"}, 80)
+			return err
+		})
+		return id
+	}
+	first := add("phishing kit")
+	second := add("phishing kit v2")
+
+	type object map[string]interface{}
+	export := func(caller *ctitest.Identity, fn func(ctx contractapi.TransactionContextInterface) (string, error)) (object, []object) {
+		var bundleJSON string
+		invoke(t, l, caller, func(ctx contractapi.TransactionContextInterface) error {
+			var err error
+			bundleJSON, err = fn(ctx)
+			return err
+		})
+		if strings.Contains(bundleJSON, "secret-key") {
+			t.Error("the encryption key was exported")
+		}
+		var bundle object
+		if err := json.Unmarshal([]byte(bundleJSON), &bundle); err != nil {
+			t.Fatal(err)
+		}
+		var objects []object
+		for _, o := range bundle["objects"].([]interface{}) {
+			objects = append(objects, o.(map[string]interface{}))
+		}
+		return bundle, objects
+	}
+	exportItem := func(caller *ctitest.Identity, id string) (object, []object) {
+		return export(caller, func(ctx contractapi.TransactionContextInterface) (string, error) {
+			return cc.ExportCTIItemAsSTIX(ctx, id)
+		})
+	}
+
+	bundle, objects := exportItem(alice, first)
+	if bundle["type"] != "bundle" || !strings.HasPrefix(fmt.Sprint(bundle["id"]), "bundle--") {
+		t.Errorf("bundle header is %v", bundle)
+	}
+	byType := make(map[string]object)
+	ids := make(map[string]bool)
+	for _, o := range objects {
+		for _, field := range []string{"type", "spec_version", "id", "created", "modified"} {
+			if o[field] == nil || o[field] == "" {
+				t.Errorf("%v object lacks %s", o["type"], field)
+			}
+		}
+		if o["spec_version"] != "2.1" || !strings.HasPrefix(fmt.Sprint(o["id"]), fmt.Sprint(o["type"], "--")) {
+			t.Errorf("object has spec version %v and ID %v", o["spec_version"], o["id"])
+		}
+		byType[fmt.Sprint(o["type"])] = o
+		ids[fmt.Sprint(o["id"])] = true
+	}
+	if len(objects) != 4 || len(byType) != 4 {
+		t.Fatalf("exported objects %v, want a report, identity, indicator and attack pattern", objects)
+	}
+	report := byType["report"]
+	if report["name"] != "phishing kit" || report["x_cti_cid"] != testCID || report["confidence"] != float64(80) {
+		t.Errorf("report is %v", report)
+	}
+	for _, ref := range report["object_refs"].([]interface{}) {
+		if !ids[fmt.Sprint(ref)] {
+			t.Errorf("report references %v, which is not in the bundle", ref)
+		}
+	}
+	if pattern := byType["indicator"]["pattern"]; pattern != "[domain-name:value = 'evil.example']" {
+		t.Errorf("indicator pattern is %v", pattern)
+	}
+	// UUIDv5 of "technique:T1566" in the STIX namespace
+	if id := byType["attack-pattern"]["id"]; id != "attack-pattern--b64e4d2f-b7de-5a5d-a9ec-6363cbdece49" {
+		t.Errorf("attack pattern ID is %v", id)
+	}
+
+	// IDs are stable across exports, and a caller not entitled to the item gets no CID
+	again, againObjects := exportItem(bob, first)
+	if again["id"] != bundle["id"] {
+		t.Errorf("bundle ID changed from %v to %v", bundle["id"], again["id"])
+	}
+	for i, o := range againObjects {
+		if o["id"] != objects[i]["id"] {
+			t.Errorf("object ID changed from %v to %v", objects[i]["id"], o["id"])
+		}
+		if o["x_cti_cid"] != nil {
+			t.Errorf("bob was exported the CID %v", o["x_cti_cid"])
+		}
+	}
+
+	// The full bundle holds a report per item, sharing the identity and attack pattern
+	_, all := export(alice, cc.ExportAllCTIAsSTIXBundle)
+	counts := make(map[string]int)
+	for _, o := range all {
+		counts[fmt.Sprint(o["type"])]++
+	}
+	if want := map[string]int{"report": 2, "identity": 1, "indicator": 2, "attack-pattern": 1}; fmt.Sprint(counts) != fmt.Sprint(want) {
+		t.Errorf("full bundle holds %v, want %v", counts, want)
+	}
+
+	invoke(t, l, admin, func(ctx contractapi.TransactionContextInterface) error {
+		return cc.SoftDeleteCTIItem(ctx, second)
+	})
+	if err := l.Invoke(alice, func(ctx contractapi.TransactionContextInterface) error {
+		_, err := cc.ExportCTIItemAsSTIX(ctx, second)
+		return err
+	}); err == nil {
+		t.Error("a deleted CTI item was exported")
+	}
+}